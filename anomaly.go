@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// failOnWarning, set with --fail-on-warning, turns warnings into a failure
+// at the end of the run, for CI pipelines.
+var failOnWarning bool
+
+// warnings counts the warnings of the run.
+var warnings atomic.Int64
+
+func countWarnings(n int) {
+	warnings.Add(int64(n))
+}
+
+// checkWarnings fails, with --fail-on-warning, if there were warnings.
+func checkWarnings() error {
+	if n := warnings.Load(); failOnWarning && n > 0 {
+		return fmt.Errorf("%d warnings, failing because of --fail-on-warning", n)
+	}
+	return nil
+}
+
+// warnAnomalies warns about what looks wrong with entry, given the first
+// bytes of its main file and of its WAL, as inspect would report it.
+func warnAnomalies(entry *dbEntry, main, wal []byte) {
+	for _, warning := range newDBReport(entry, main, wal).warnings {
+		warn("database %s: %s", entry.name, warning)
+	}
+}
+
+// checkExtracted warns about what looks wrong with entry, extracted as file
+// in dir.
+func checkExtracted(dir, file string, entry *dbEntry) {
+	main, err := fileHead(filepath.Join(dir, file), dbHeaderSize)
+	if err != nil {
+		return
+	}
+	wal, err := fileHead(filepath.Join(dir, file+"-wal"), walHeaderSize)
+	if err != nil {
+		return
+	}
+	warnAnomalies(entry, main, wal)
+}
+
+// fileHead returns the first n bytes of the file at path, or all of them if
+// it is shorter.
+func fileHead(path string, n int) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	head := make([]byte, n)
+	read, err := io.ReadFull(file, head)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	return head[:read], err
+}
+
+// headWriter keeps the first bytes written to it, up to its capacity.
+type headWriter struct {
+	head []byte
+}
+
+func newHeadWriter(n int) *headWriter {
+	return &headWriter{head: make([]byte, 0, n)}
+}
+
+func (h *headWriter) Write(p []byte) (int, error) {
+	if room := cap(h.head) - len(h.head); room > 0 {
+		h.head = append(h.head, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
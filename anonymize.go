@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// anonymizeKey keys the hashes values are replaced with: drawn anew for
+// each run, so that short values can't be recovered by hashing guesses.
+var anonymizeKey = rand.Text()
+
+func init() {
+	sql.Register("sqlite3_anonymize", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("anonymize", anonymizeValue, true)
+		},
+	})
+}
+
+// anonymizeValue replaces text with a placeholder made of its hash, and
+// blobs with as many bytes derived from their hash, leaving numbers and
+// NULLs alone. Equal values give equal results, keeping joins working.
+func anonymizeValue(v any) any {
+	switch v := v.(type) {
+	case string:
+		sum := anonymizeHash([]byte(v))
+		return "anon-" + hex.EncodeToString(sum[:8])
+	case []byte:
+		sum := anonymizeHash(v)
+		out := make([]byte, len(v))
+		for i := 0; i < len(out); i += len(sum) {
+			copy(out[i:], sum)
+		}
+		return out
+	}
+	return v
+}
+
+func anonymizeHash(b []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(anonymizeKey))
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+// extractShareable unpacks the snapshot at path to a temporary directory
+// and writes each database into the current directory with the same
+// schema, but with only a sample of its rows with --sample, and with its
+// text and blob values scrubbed with --anonymize, or the columns matched by
+// the --redact rules.
+func extractShareable(path string, want func(name string) bool) ([]string, error) {
+	dir, names, cleanup, err := extractTemp(path, want)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	fmt.Printf("Database count: %d\n", len(names))
+	for _, name := range names {
+		if err := shareInto(filepath.Join(dir, name), name); err != nil {
+			return nil, err
+		}
+	}
+	redaction.warnUnused()
+	fmt.Printf("Done!\n\n")
+	return names, nil
+}
+
+// shareInto writes the database at src to dst as extractShareable does.
+func shareInto(src, dst string) error {
+	if sampleSize > 0 {
+		fmt.Printf("Sampling database %s...\n", dst)
+		sample := dst + ".sample"
+		defer os.Remove(sample)
+		rows, err := sampleInto(src, sample)
+		if err != nil {
+			return fmt.Errorf("couldn't sample %s: %w", dst, err)
+		}
+		fmt.Printf("Sampled %d rows\n", rows)
+		src = sample
+	}
+	if !anonymize && redaction == nil {
+		if err := vacuumInto(src, dst); err != nil {
+			return fmt.Errorf("couldn't copy %s: %w", dst, err)
+		}
+		return nil
+	}
+	fmt.Printf("Anonymizing database %s...\n", dst)
+	rows, err := anonymizeInto(src, dst)
+	if err != nil {
+		return fmt.Errorf("couldn't anonymize %s: %w", dst, err)
+	}
+	fmt.Printf("Anonymized %d rows\n", rows)
+	return nil
+}
+
+// anonymizeInto creates a new database at dst with the schema of the
+// database at src and its rows, each column going through the action of the
+// redaction rules applying to it, returning the number of rows copied.
+// Building a new database, rather than updating a copy, leaves no trace of
+// the original values in free pages and fires no trigger.
+func anonymizeInto(src, dst string) (int64, error) {
+	return rebuildInto(src, dst, func(tx *sql.Tx, table string, columns []tableColumn) (int64, error) {
+		var names, values []string
+		for _, column := range columns {
+			name := quoteIdent(column.Name)
+			names = append(names, name)
+			values = append(values, redactExpr(name, redaction.action(table, column.Name), column.NotNull))
+		}
+		result, err := tx.Exec(fmt.Sprintf("INSERT INTO main.%s(%s) SELECT %s FROM src.%s",
+			quoteIdent(table), strings.Join(names, ", "), strings.Join(values, ", "), quoteIdent(table)))
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected()
+	})
+}
+
+// tableColumn is a column rebuildInto copies.
+type tableColumn struct {
+	Name    string
+	NotNull bool
+}
+
+// rebuildInto creates a new database at dst with the schema of the database
+// at src, attached as src, and has fill copy the rows of each table, given
+// its columns but for generated ones and, for virtual tables, hidden ones.
+// Tables are filled after those they reference through foreign keys, and
+// before indexes, triggers and views are created. It returns the number of
+// rows copied.
+func rebuildInto(src, dst string, fill func(tx *sql.Tx, table string, columns []tableColumn) (int64, error)) (int64, error) {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if err := os.Remove(dst + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, err
+		}
+	}
+
+	db, err := sql.Open("sqlite3_anonymize", "file:"+(&url.URL{Path: dst}).EscapedPath())
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	uri := "file:" + (&url.URL{Path: src}).EscapedPath() + "?mode=ro"
+	if _, err := db.Exec("ATTACH DATABASE ? AS src", uri); err != nil {
+		return 0, err
+	}
+	objects, err := readSchemaOf(db, "src")
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Virtual tables go first, through their module, which creates their
+	// shadow tables: copying those would break the module.
+	var total int64
+	var virtual, tables []string
+	for _, o := range objects {
+		if o.Type != "table" || !isVirtual(o) {
+			continue
+		}
+		virtual = append(virtual, o.Name)
+		if _, err := tx.Exec(o.SQL); err != nil {
+			warn("couldn't create virtual table %s in %s, it's left out: %v", o.Name, dst, err)
+			continue
+		}
+		columns, err := copiedColumns(tx, o.Name, "hidden = 0")
+		if err == nil {
+			var n int64
+			n, err = fill(tx, o.Name, columns)
+			total += n
+		}
+		if err != nil {
+			warn("couldn't copy virtual table %s into %s, it's left empty: %v", o.Name, dst, err)
+		}
+	}
+	for _, o := range objects {
+		// sqlite_sequence follows the rows copied, and statistics, which
+		// hold samples of indexed values, are left out.
+		if o.Type != "table" || strings.HasPrefix(o.Name, "sqlite_") || isVirtual(o) || isShadowTable(o, virtual) {
+			continue
+		}
+		if _, err := tx.Exec(o.SQL); err != nil {
+			return 0, fmt.Errorf("couldn't create table %s: %w", o.Name, err)
+		}
+		tables = append(tables, o.Name)
+	}
+
+	tables, err = foreignKeyOrder(tx, tables)
+	if err != nil {
+		return 0, err
+	}
+	for _, table := range tables {
+		columns, err := copiedColumns(tx, table, "hidden NOT IN (2, 3)")
+		if err != nil {
+			return 0, err
+		}
+		n, err := fill(tx, table, columns)
+		if err != nil {
+			return 0, fmt.Errorf("couldn't copy table %s: %w", table, err)
+		}
+		total += n
+	}
+
+	for _, o := range objects {
+		if o.Type == "table" {
+			continue
+		}
+		if _, err := tx.Exec(o.SQL); err != nil {
+			fmt.Printf("Skipping %s %s: %v\n", o.Type, o.Name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if _, err := db.Exec("DETACH DATABASE src"); err != nil {
+		return 0, err
+	}
+	return total, db.Close()
+}
+
+// isShadowTable tells whether o is one of the tables a module keeps the
+// contents of the virtual tables called virtual in, named after them.
+func isShadowTable(o schemaObject, virtual []string) bool {
+	return slices.ContainsFunc(virtual, func(name string) bool { return strings.HasPrefix(o.Name, name+"_") })
+}
+
+// copiedColumns returns the columns of table in the main database matching
+// the condition on their hidden field of pragma_table_xinfo.
+func copiedColumns(tx *sql.Tx, table, hidden string) ([]tableColumn, error) {
+	rows, err := tx.Query(`SELECT name, "notnull" FROM pragma_table_xinfo(?) WHERE `+hidden, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []tableColumn
+	for rows.Next() {
+		var c tableColumn
+		if err := rows.Scan(&c.Name, &c.NotNull); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
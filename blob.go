@@ -0,0 +1,56 @@
+//go:build !js
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/azureblob"
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/s3blob"
+)
+
+// blobReader reads an object of a bucket of a cloud object storage.
+type blobReader struct {
+	*blob.Reader
+	bucket *blob.Bucket
+}
+
+// openBlob opens the object at a URL such as s3://bucket/key?region=...,
+// where the query configures the bucket. Credentials come from the
+// environment, as with the tools of each provider.
+func openBlob(rawURL string) (*blobReader, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("%s doesn't name a bucket and an object in it", rawURL)
+	}
+
+	ctx := context.Background()
+	bucketURL := url.URL{Scheme: u.Scheme, Host: u.Host, RawQuery: u.RawQuery}
+	bucket, err := blob.OpenBucket(ctx, bucketURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open bucket %s: %w", u.Host, err)
+	}
+	reader, err := bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		bucket.Close()
+		return nil, fmt.Errorf("couldn't open %s: %w", rawURL, err)
+	}
+	return &blobReader{reader, bucket}, nil
+}
+
+func (b *blobReader) Close() error {
+	err := b.Reader.Close()
+	if closeErr := b.bucket.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build js
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// openBlob can't reach cloud object storage from WebAssembly, whose SDKs
+// don't build there.
+func openBlob(rawURL string) (io.ReadCloser, error) {
+	return nil, errors.ErrUnsupported
+}
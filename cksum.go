@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// cksumReservedBytes is the number of reserved bytes the checksum VFS of
+// SQLite claims at the end of each page, where it stores the checksum of the
+// rest of the page. Databases with any other number of reserved bytes don't
+// carry checksums.
+const cksumReservedBytes = 8
+
+// pageChecksum computes the checksum the checksum VFS stores at the end of
+// page: two running sums over the little endian words of the rest of it.
+func pageChecksum(page []byte) []byte {
+	var s1, s2 uint32
+	data := page[:len(page)-cksumReservedBytes]
+	for i := 0; i+8 <= len(data); i += 8 {
+		s1 += binary.LittleEndian.Uint32(data[i:]) + s2
+		s2 += binary.LittleEndian.Uint32(data[i+4:]) + s1
+	}
+	return binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, s1), s2)
+}
+
+// checksumFailures returns the pages of the database read by p failing their
+// checksum, if it was written through the checksum VFS.
+func checksumFailures(p *pager) ([]uint32, error) {
+	if p.header.ReservedBytes != cksumReservedBytes {
+		return nil, nil
+	}
+	var failed []uint32
+	for n := uint32(1); n <= p.pageCount; n++ {
+		data, err := p.page(n)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pageChecksum(data), data[len(data)-cksumReservedBytes:]) {
+			failed = append(failed, n)
+		}
+	}
+	return failed, nil
+}
+
+// checksumProblems returns a problem for each page of the database at path
+// failing its checksum, with the checksum VFS.
+func checksumProblems(path string) ([]string, error) {
+	p, err := openPager(path)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+	failed, err := checksumFailures(p)
+	if err != nil {
+		return nil, err
+	}
+	var problems []string
+	for _, n := range failed {
+		problems = append(problems, fmt.Sprintf("page %d fails its checksum", n))
+	}
+	return problems, nil
+}
@@ -0,0 +1,14 @@
+//go:build !js
+
+package main
+
+import "os"
+
+func main() {
+	err := rootCmd.Execute()
+	stopProfiling()
+	emitEnd(err)
+	if err != nil {
+		os.Exit(1)
+	}
+}
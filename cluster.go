@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// go-dqlite numbers the roles differently than raft does: voter 0,
+// standby 1 and spare 2.
+func nodeRole(role uint8) int {
+	switch role {
+	case raftVoter:
+		return 0
+	case raftStandby:
+		return 1
+	}
+	return 2
+}
+
+// nodeYAML renders server as a go-dqlite NodeInfo, with the given indent on
+// the lines after the first.
+func nodeYAML(server raftServer, indent string) string {
+	return fmt.Sprintf("Address: %s\n%sID: %d\n%sRole: %d\n",
+		strconv.Quote(server.Address), indent, server.ID, indent, nodeRole(server.Role))
+}
+
+// writeRecoveryFiles writes, into dir, the cluster.yaml listing servers that
+// go-dqlite reconfigures a cluster from, and the info.yaml describing the
+// server of the given ID, unless id is 0.
+func writeRecoveryFiles(dir string, servers []raftServer, id uint64) error {
+	var cluster strings.Builder
+	for _, server := range servers {
+		cluster.WriteString("- " + nodeYAML(server, "  "))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cluster.yaml"), []byte(cluster.String()), 0600); err != nil {
+		return err
+	}
+	if id == 0 {
+		return nil
+	}
+	for _, server := range servers {
+		if server.ID == id {
+			return os.WriteFile(filepath.Join(dir, "info.yaml"), []byte(nodeYAML(server, "")), 0600)
+		}
+	}
+	return fmt.Errorf("node %d isn't part of the configuration", id)
+}
+
+// printConfiguration prints the cluster configuration of a .meta file.
+func printConfiguration(meta *snapshotMeta) {
+	fmt.Printf("Cluster configuration (index %d):\n", meta.ConfigurationIndex)
+	for _, server := range meta.Servers {
+		fmt.Printf("  %d at %s, %s\n", server.ID, server.Address, server.roleName())
+	}
+	fmt.Println()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Types of the commands dqlite appends to the raft log, as the payload of
+// its command entries.
+const (
+	commandOpen       = 1
+	commandFrames     = 2
+	commandUndo       = 3
+	commandCheckpoint = 4
+)
+
+const commandFormat = 1
+
+// dqliteCommand is a decoded command. Frames commands carry the pages a
+// transaction wrote to the WAL of a database, a large transaction being
+// split across several of them, the last one committing it.
+type dqliteCommand struct {
+	Type     uint8
+	Filename string
+	TxID     uint64
+	Truncate uint32 // size of the database in pages once committed
+	IsCommit bool
+	PageSize uint16
+	Pages    []uint64 // page numbers, the content of each following in Data
+	Data     []byte
+}
+
+func (c *dqliteCommand) typeName() string {
+	switch c.Type {
+	case commandOpen:
+		return "open"
+	case commandFrames:
+		return "frames"
+	case commandUndo:
+		return "undo"
+	case commandCheckpoint:
+		return "checkpoint"
+	}
+	return fmt.Sprintf("type %d", c.Type)
+}
+
+// page returns the content of the i-th page of a frames command.
+func (c *dqliteCommand) page(i int) []byte {
+	return c.Data[i*int(c.PageSize) : (i+1)*int(c.PageSize)]
+}
+
+// decodeCommand decodes a command: an 8 bytes header with its format and
+// type, then its fields, little endian, strings null-terminated and padded
+// to 8 bytes.
+func decodeCommand(data []byte) (*dqliteCommand, error) {
+	d := &commandDecoder{data: data}
+	header := d.next(8)
+	if d.err != nil {
+		return nil, fmt.Errorf("command too short")
+	}
+	if header[0] != commandFormat {
+		return nil, fmt.Errorf("unknown command format %d", header[0])
+	}
+
+	c := &dqliteCommand{Type: header[1]}
+	switch c.Type {
+	case commandOpen, commandCheckpoint:
+		c.Filename = d.text()
+	case commandUndo:
+		c.TxID = d.uint64()
+	case commandFrames:
+		c.Filename = d.text()
+		c.TxID = d.uint64()
+		fields := d.next(8)
+		if d.err == nil {
+			c.Truncate, c.IsCommit = binary.LittleEndian.Uint32(fields), fields[4] != 0
+		}
+		frames := d.next(8)
+		if d.err != nil {
+			break
+		}
+		n := binary.LittleEndian.Uint32(frames)
+		c.PageSize = binary.LittleEndian.Uint16(frames[4:])
+		if uint64(n)*(8+uint64(c.PageSize)) > uint64(len(d.data)) {
+			return nil, fmt.Errorf("frames command of %d pages of %d bytes, longer than its entry", n, c.PageSize)
+		}
+		c.Pages = make([]uint64, n)
+		for i := range c.Pages {
+			c.Pages[i] = d.uint64()
+		}
+		c.Data = d.next(int(n) * int(c.PageSize))
+	default:
+		return nil, fmt.Errorf("unknown command type %d", c.Type)
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("%s command truncated", c.typeName())
+	}
+	return c, nil
+}
+
+// commandDecoder consumes the fields of a command, remembering whether it
+// ran out of data.
+type commandDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *commandDecoder) next(n int) []byte {
+	if d.err != nil || n > len(d.data) {
+		d.err = fmt.Errorf("truncated")
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *commandDecoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *commandDecoder) text() string {
+	end := bytes.IndexByte(d.data, 0)
+	if end < 0 {
+		d.err = fmt.Errorf("truncated")
+		return ""
+	}
+	s := string(d.data[:end])
+	d.next((end + 8) &^ 7)
+	return s
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var compactCmd = &cobra.Command{
+	Use:   "compact <datadir> <outdir>",
+	Short: "Compact the raft log of a data directory into a later snapshot",
+	Long: `Replays the closed segments of the raft log of a data directory onto its
+newest snapshot, applying the pages of the transactions committed since, and
+writes the result as a new snapshot, along with its .meta file, named after
+the term and index of the last entry applied: the log compaction dqlite does
+itself, done offline, for archival or to shrink the startup time of a node.
+
+Transactions left uncommitted at the end of the log are left out, and
+configuration changes found in the log go to the .meta file.`,
+	Args: cobra.ExactArgs(2),
+	RunE: compact,
+
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(compactCmd)
+}
+
+func compact(cmd *cobra.Command, args []string) error {
+	dir, out := args[0], args[1]
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return fmt.Errorf("no snapshot found in %s", dir)
+	}
+	base := snapshots[0]
+	meta, err := readSnapshotMeta(base.Path + ".meta")
+	if err != nil {
+		return fmt.Errorf("couldn't read %s.meta: %w", base.Path, err)
+	}
+	if err := os.MkdirAll(out, 0700); err != nil {
+		return err
+	}
+	_, err = replayOnto(dir, base, meta, 0, out)
+	return err
+}
+
+// snapshotAtIndex writes into a temporary directory the snapshot of the
+// data directory dir as of raft index: its latest snapshot up to there, with
+// the following entries of the log replayed onto it. It returns the path of
+// the snapshot and a function removing it.
+func snapshotAtIndex(dir string, index uint64) (string, func(), error) {
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	i := slices.IndexFunc(snapshots, func(s raftSnapshot) bool { return s.Index <= index })
+	if i < 0 {
+		return "", nil, fmt.Errorf("no snapshot in %s at or before index %d", dir, index)
+	}
+	base := snapshots[i]
+	if base.Index == index {
+		logger.Info("Using " + base.Path)
+		return base.Path, func() {}, nil
+	}
+	logger.Info(fmt.Sprintf("Using %s, replaying the log up to index %d", base.Path, index))
+
+	out, cleanup, err := makeTempDir()
+	if err != nil {
+		return "", nil, err
+	}
+	var path string
+	err = quietly(func() (err error) {
+		path, err = replayOnto(dir, base, nil, index, out)
+		return err
+	})
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return path, cleanup, nil
+}
+
+// replayOnto replays the log of the data directory dir onto its snapshot
+// base, up to index to or, with to 0, to the end of the closed segments, and
+// writes the result into out as a snapshot named after the last entry
+// replayed, returning its path. Unless meta is nil, the .meta file of the
+// snapshot is written as well, from meta and the configuration changes of
+// the log.
+func replayOnto(dir string, base raftSnapshot, meta *snapshotMeta, to uint64, out string) (string, error) {
+	unpacked, _, cleanup, err := extractTemp(base.Path, nil)
+	if err != nil {
+		return "", fmt.Errorf("couldn't extract %s: %w", base.Path, err)
+	}
+	defer cleanup()
+	m, err := readManifest(unpacked)
+	if err != nil {
+		return "", err
+	}
+	compress, err := outputCompression(m.Compression, "")
+	if err != nil {
+		return "", err
+	}
+	work, cleanupWork, err := makeTempDir()
+	if err != nil {
+		return "", err
+	}
+	defer cleanupWork()
+	r, err := newReplayer(unpacked, m, work)
+	if err != nil {
+		return "", err
+	}
+
+	term, index := base.Term, base.Index
+	err = walkLog(dir, base.Index, to, to > 0, func(i uint64, entry raftEntry) error {
+		term, index = entry.Term, i
+		switch entry.Type {
+		case raftCommand:
+			c, err := decodeCommand(entry.Data)
+			if err != nil {
+				return fmt.Errorf("entry %d: %w", i, err)
+			}
+			if err := r.apply(c); err != nil {
+				return fmt.Errorf("entry %d: %w", i, err)
+			}
+		case raftChange:
+			servers, err := decodeConfiguration(entry.Data)
+			if err != nil {
+				return fmt.Errorf("entry %d: %w", i, err)
+			}
+			if meta != nil {
+				meta = &snapshotMeta{ConfigurationIndex: i, Servers: servers}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't replay the log: %w", err)
+	}
+	if index == base.Index {
+		return "", fmt.Errorf("no entries after the snapshot at index %d to replay", base.Index)
+	}
+	if n := len(r.pending); n > 0 {
+		warn("%d transactions left uncommitted at index %d are left out", n, index)
+	}
+
+	sources, err := r.sources()
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("snapshot-%d-%d-%d", term, index, time.Now().UnixMilli())
+	snapshot := filepath.Join(out, name)
+	if err := writeSnapshot(snapshot, sources, compress); err != nil {
+		return "", fmt.Errorf("couldn't write %s: %w", snapshot, err)
+	}
+	if meta != nil {
+		if err := writeSnapshotMeta(snapshot+".meta", meta); err != nil {
+			return "", fmt.Errorf("couldn't write %s.meta: %w", snapshot, err)
+		}
+	}
+	fmt.Printf("Replayed %s (%d transactions) onto %s into %s\n",
+		entryRange(base.Index+1, index), r.transactions, filepath.Base(base.Path), snapshot)
+	return snapshot, nil
+}
+
+// errWalkDone stops walkLog once past the last index to walk.
+var errWalkDone = errors.New("done")
+
+// walkLog calls fn with the entries of the log in dir that follow index
+// after, up to index to or, with to 0, to the end, failing if any of them
+// is missing. Open segments, whose entries may not be committed yet, are
+// only walked with open set.
+func walkLog(dir string, after, to uint64, open bool, fn func(index uint64, entry raftEntry) error) error {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+	next := after + 1
+	// Open segments follow on from the closed ones, walked or not.
+	start := next
+	for _, segment := range segments {
+		if segment.Open && !open {
+			continue
+		}
+		if !segment.Open && segment.Last < next {
+			start = segment.Last + 1
+			continue
+		}
+		if segment.Open {
+			segment.First = start
+		} else if segment.First > next {
+			return fmt.Errorf("%s missing", entryRange(next, segment.First-1))
+		}
+		index := segment.First
+		var fnErr error
+		err := readSegment(filepath.Join(dir, segment.Name), func(_ int64, entries []raftEntry) error {
+			for _, entry := range entries {
+				if to > 0 && index > to {
+					return errWalkDone
+				}
+				if index >= next {
+					if fnErr = fn(index, entry); fnErr != nil {
+						return fnErr
+					}
+				}
+				index++
+			}
+			return nil
+		})
+		switch {
+		case err == errWalkDone:
+			return nil
+		case err != nil && err == fnErr:
+			return fmt.Errorf("%s: %w", segment.Name, err)
+		case err != nil:
+			// A damaged batch: stop there rather than go on with
+			// entries that can't be trusted.
+			return fmt.Errorf("%s: %w, %s", segment.Name, err, lastGood(segment.First, index-segment.First))
+		}
+		if !segment.Open && index != segment.Last+1 {
+			return fmt.Errorf("%s: holds %d entries, %d going by its name", segment.Name, index-segment.First, segment.Last-segment.First+1)
+		}
+		next, start = max(next, index), index
+	}
+	if to > 0 && next <= to {
+		return fmt.Errorf("the log ends at index %d, before %d", next-1, to)
+	}
+	return nil
+}
+
+// replayer applies frames commands to the databases of a snapshot, unpacked
+// and checkpointed, writing the pages of each transaction straight into the
+// main file once it commits. Checkpointed copies and new databases go to
+// its work directory.
+type replayer struct {
+	work      string
+	databases []*packSource
+	// pending holds, by database, the frames commands of the transaction
+	// not committed yet.
+	pending      map[string][]*dqliteCommand
+	transactions int
+}
+
+func newReplayer(dir string, m *manifest, work string) (*replayer, error) {
+	r := &replayer{work: work, pending: make(map[string][]*dqliteCommand)}
+	for i, db := range m.Databases {
+		source, err := unpackedSource(dir, db)
+		if err != nil {
+			return nil, err
+		}
+		if source, err = checkpointCopy(source, filepath.Join(work, fmt.Sprintf("%d.db", i))); err != nil {
+			return nil, fmt.Errorf("couldn't checkpoint %s: %w", db.Name, err)
+		}
+		r.databases = append(r.databases, source)
+	}
+	return r, nil
+}
+
+func (r *replayer) apply(c *dqliteCommand) error {
+	switch c.Type {
+	case commandOpen:
+		_, err := r.database(c.Filename)
+		return err
+	case commandFrames:
+		r.pending[c.Filename] = append(r.pending[c.Filename], c)
+		if !c.IsCommit {
+			return nil
+		}
+		commands := r.pending[c.Filename]
+		delete(r.pending, c.Filename)
+		return r.commit(c.Filename, commands)
+	case commandUndo:
+		for name, commands := range r.pending {
+			if commands[0].TxID == c.TxID {
+				delete(r.pending, name)
+			}
+		}
+	}
+	return nil
+}
+
+// commit writes the pages of a transaction into the database name.
+func (r *replayer) commit(name string, commands []*dqliteCommand) error {
+	db, err := r.database(name)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(db.main, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, c := range commands {
+		for i, page := range c.Pages {
+			if page == 0 {
+				return fmt.Errorf("frames command writing page 0 of %s", name)
+			}
+			if _, err := file.WriteAt(c.page(i), int64(page-1)*int64(c.PageSize)); err != nil {
+				return err
+			}
+		}
+	}
+	if last := commands[len(commands)-1]; last.Truncate > 0 {
+		if err := file.Truncate(int64(last.Truncate) * int64(last.PageSize)); err != nil {
+			return err
+		}
+	}
+	r.transactions++
+	return file.Close()
+}
+
+// database returns the database called name, creating it empty if the log
+// opens a new one.
+func (r *replayer) database(name string) (*packSource, error) {
+	for _, db := range r.databases {
+		if db.entry.name == name {
+			return db, nil
+		}
+	}
+	db := &packSource{entry: dbEntry{name: name}, main: filepath.Join(r.work, fmt.Sprintf("%d.db", len(r.databases)))}
+	if err := os.WriteFile(db.main, nil, 0600); err != nil {
+		return nil, err
+	}
+	r.databases = append(r.databases, db)
+	return db, nil
+}
+
+// sources returns the databases to pack, sized as they are now.
+func (r *replayer) sources() ([]*packSource, error) {
+	for _, db := range r.databases {
+		info, err := os.Stat(db.main)
+		if err != nil {
+			return nil, err
+		}
+		db.entry.mainSize = uint64(info.Size())
+	}
+	return r.databases, nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var compareCmd = &cobra.Command{
+	Use:   "compare <snapshot> <datadir>",
+	Short: "Compare a snapshot against a live data directory",
+	Long: `Compares a snapshot with the newest snapshot of a dqlite data directory,
+reporting the schema changes and row count deltas of each database, and how
+many raft entries the data directory is ahead, to decide whether the snapshot
+is still safe to restore.
+
+The raft index of the snapshot comes from its file name; entries committed to
+the raft log since the newest snapshot of the data directory are counted, but
+not applied to its databases.`,
+	Args: cobra.ExactArgs(2),
+	RunE: compare,
+
+	SilenceUsage: true,
+}
+
+var compareJSON bool
+
+func init() {
+	compareCmd.Flags().BoolVar(&compareJSON, "json", false, "print the comparison as JSON")
+	rootCmd.AddCommand(compareCmd)
+}
+
+// snapshotComparison holds how a data directory diverged from a snapshot.
+type snapshotComparison struct {
+	Snapshot      string               `json:"snapshot"`
+	Live          string               `json:"live_snapshot"`
+	SnapshotIndex uint64               `json:"snapshot_index,omitempty"`
+	LiveIndex     uint64               `json:"live_index"`
+	LogIndex      uint64               `json:"log_index,omitempty"`
+	OpenSegments  int                  `json:"open_segments,omitempty"`
+	Added         []string             `json:"added_databases"`
+	Removed       []string             `json:"removed_databases"`
+	Databases     []databaseComparison `json:"databases"`
+	Diverged      bool                 `json:"diverged"`
+}
+
+// databaseComparison holds the differences of a database found in both the
+// snapshot and the data directory.
+type databaseComparison struct {
+	Name   string         `json:"name"`
+	Schema []schemaChange `json:"schema,omitempty"`
+	Tables []tableCounts  `json:"tables,omitempty"`
+}
+
+// tableCounts counts the rows of a table in the snapshot and in the data
+// directory, -1 standing for a table missing on that side.
+type tableCounts struct {
+	Table    string `json:"table"`
+	Snapshot int64  `json:"snapshot"`
+	Live     int64  `json:"live"`
+}
+
+func compare(cmd *cobra.Command, args []string) error {
+	live, err := newestSnapshot(args[1])
+	if err != nil {
+		return err
+	}
+	logger.Info("Using " + live)
+	c := snapshotComparison{Snapshot: args[0], Live: live}
+	_, c.LiveIndex, _, _ = parseSnapshotName(filepath.Base(live))
+	if _, index, _, ok := parseSnapshotName(filepath.Base(args[0])); ok {
+		c.SnapshotIndex = index
+	}
+	if c.LogIndex, c.OpenSegments, err = lastLogIndex(args[1]); err != nil {
+		return fmt.Errorf("couldn't read the raft log of %s: %w", args[1], err)
+	}
+
+	dirA, namesA, cleanupA, err := extractTemp(args[0], nil)
+	if err != nil {
+		return fmt.Errorf("couldn't extract %s: %w", args[0], err)
+	}
+	defer cleanupA()
+
+	dirB, namesB, cleanupB, err := extractTemp(live, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't extract %s: %w", live, err)
+	}
+	defer cleanupB()
+
+	for _, name := range namesA {
+		if !slices.Contains(namesB, name) {
+			c.Removed = append(c.Removed, name)
+		}
+	}
+	for _, name := range namesB {
+		if !slices.Contains(namesA, name) {
+			c.Added = append(c.Added, name)
+			continue
+		}
+		d, err := compareDatabases(name, filepath.Join(dirA, name), filepath.Join(dirB, name))
+		if err != nil {
+			return fmt.Errorf("couldn't compare %s: %w", name, err)
+		}
+		if len(d.Schema) > 0 || len(d.Tables) > 0 {
+			c.Diverged = true
+		}
+		c.Databases = append(c.Databases, d)
+	}
+	if len(c.Added) > 0 || len(c.Removed) > 0 || c.gap() > 0 {
+		c.Diverged = true
+	}
+
+	if compareJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(c)
+	}
+	printComparison(&c)
+	return nil
+}
+
+// gap returns how many raft entries the data directory is ahead of the
+// snapshot, or 0 if the index of the snapshot isn't known.
+func (c *snapshotComparison) gap() uint64 {
+	latest := max(c.LiveIndex, c.LogIndex)
+	if c.SnapshotIndex == 0 || latest <= c.SnapshotIndex {
+		return 0
+	}
+	return latest - c.SnapshotIndex
+}
+
+// compareDatabases compares the schema and row counts of the database at
+// pathA, from the snapshot, with the one at pathB, from the data directory.
+func compareDatabases(name, pathA, pathB string) (databaseComparison, error) {
+	d := databaseComparison{Name: name}
+	err := withDatabase(pathA, func(db *sql.DB) error {
+		uri := "file:" + (&url.URL{Path: pathB}).EscapedPath() + "?mode=ro"
+		if _, err := db.Exec("ATTACH DATABASE ? AS b", uri); err != nil {
+			return err
+		}
+		defer db.Exec("DETACH DATABASE b")
+
+		schemaA, err := readSchemaOf(db, "main")
+		if err != nil {
+			return err
+		}
+		schemaB, err := readSchemaOf(db, "b")
+		if err != nil {
+			return err
+		}
+		d.Schema = diffSchemas(schemaA, schemaB)
+
+		count := func(schema string, objects []schemaObject, table string) (int64, error) {
+			if !slices.ContainsFunc(objects, func(o schemaObject) bool { return o.Type == "table" && o.Name == table }) {
+				return -1, nil
+			}
+			var n int64
+			err := db.QueryRow("SELECT count(*) FROM " + quoteIdent(schema) + "." + quoteIdent(table)).Scan(&n)
+			return n, err
+		}
+		var tables []string
+		for _, o := range append(slices.Clone(schemaA), schemaB...) {
+			if o.Type == "table" && !strings.HasPrefix(o.Name, "sqlite_") && !isVirtual(o) && !slices.Contains(tables, o.Name) {
+				tables = append(tables, o.Name)
+			}
+		}
+		for _, table := range tables {
+			t := tableCounts{Table: table}
+			if t.Snapshot, err = count("main", schemaA, table); err != nil {
+				return fmt.Errorf("table %s: %w", table, err)
+			}
+			if t.Live, err = count("b", schemaB, table); err != nil {
+				return fmt.Errorf("table %s: %w", table, err)
+			}
+			if t.Snapshot != t.Live {
+				d.Tables = append(d.Tables, t)
+			}
+		}
+		return nil
+	})
+	return d, err
+}
+
+// lastLogIndex returns the last raft index of the closed segments in dir,
+// which dqlite names <first-index>-<last-index>, and how many open segments,
+// whose entries aren't counted, follow them.
+func lastLogIndex(dir string) (uint64, int, error) {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var last uint64
+	open := 0
+	for _, segment := range segments {
+		if segment.Open {
+			open++
+		} else {
+			last = max(last, segment.Last)
+		}
+	}
+	return last, open, nil
+}
+
+func printComparison(c *snapshotComparison) {
+	switch {
+	case c.SnapshotIndex == 0:
+		fmt.Printf("Raft index: unknown for %s, data directory at %d\n", c.Snapshot, max(c.LiveIndex, c.LogIndex))
+	case c.gap() == 0:
+		fmt.Printf("Raft index: %d, data directory not ahead\n", c.SnapshotIndex)
+	default:
+		fmt.Printf("Raft index: %d, data directory %d entries ahead (snapshot at %d", c.SnapshotIndex, c.gap(), c.LiveIndex)
+		if c.LogIndex > c.LiveIndex {
+			fmt.Printf(", log at %d", c.LogIndex)
+		}
+		fmt.Println(")")
+	}
+	if c.OpenSegments > 0 {
+		fmt.Printf("  %d open segments not counted\n", c.OpenSegments)
+	}
+
+	for _, name := range c.Removed {
+		fmt.Printf("- database %s\n", name)
+	}
+	for _, name := range c.Added {
+		fmt.Printf("+ database %s\n", name)
+	}
+	for _, db := range c.Databases {
+		if len(db.Schema) == 0 && len(db.Tables) == 0 {
+			fmt.Printf("= database %s\n", db.Name)
+			continue
+		}
+		fmt.Printf("~ database %s\n", db.Name)
+		printSchemaChanges(db.Schema, "    ")
+		for _, t := range db.Tables {
+			switch {
+			case t.Snapshot < 0:
+				fmt.Printf("    table %s: only live, %d rows\n", t.Table, t.Live)
+			case t.Live < 0:
+				fmt.Printf("    table %s: only in snapshot, %d rows\n", t.Table, t.Snapshot)
+			default:
+				fmt.Printf("    table %s: %d rows, %+d live\n", t.Table, t.Snapshot, t.Live-t.Snapshot)
+			}
+		}
+	}
+
+	if c.Diverged {
+		fmt.Println("Diverged: restoring the snapshot would lose the changes above")
+	} else {
+		fmt.Println("Not diverged: the snapshot matches the data directory")
+	}
+}
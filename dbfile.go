@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	dbHeaderSize  = 100
+	dbHeaderMagic = "SQLite format 3\x00"
+)
+
+// dbHeader is the 100 bytes header found at the start of a database file.
+type dbHeader struct {
+	PageSize          uint32
+	WriteVersion      uint8
+	ReadVersion       uint8
+	ReservedBytes     uint8
+	ChangeCounter     uint32
+	PageCount         uint32
+	FreelistTrunk     uint32
+	FreelistCount     uint32
+	SchemaCookie      uint32
+	SchemaFormat      uint32
+	DefaultCacheSize  uint32
+	LargestRootPage   uint32
+	TextEncoding      uint32
+	UserVersion       uint32
+	IncrementalVacuum uint32
+	ApplicationID     uint32
+	VersionValidFor   uint32
+	SQLiteVersion     uint32
+}
+
+func parseDBHeader(b []byte) (dbHeader, error) {
+	if len(b) < dbHeaderSize {
+		return dbHeader{}, errors.New("database header too short")
+	}
+	if string(b[:16]) != dbHeaderMagic {
+		return dbHeader{}, errors.New("not an SQLite database")
+	}
+
+	h := dbHeader{
+		PageSize:          uint32(binary.BigEndian.Uint16(b[16:])),
+		WriteVersion:      b[18],
+		ReadVersion:       b[19],
+		ReservedBytes:     b[20],
+		ChangeCounter:     binary.BigEndian.Uint32(b[24:]),
+		PageCount:         binary.BigEndian.Uint32(b[28:]),
+		FreelistTrunk:     binary.BigEndian.Uint32(b[32:]),
+		FreelistCount:     binary.BigEndian.Uint32(b[36:]),
+		SchemaCookie:      binary.BigEndian.Uint32(b[40:]),
+		SchemaFormat:      binary.BigEndian.Uint32(b[44:]),
+		DefaultCacheSize:  binary.BigEndian.Uint32(b[48:]),
+		LargestRootPage:   binary.BigEndian.Uint32(b[52:]),
+		TextEncoding:      binary.BigEndian.Uint32(b[56:]),
+		UserVersion:       binary.BigEndian.Uint32(b[60:]),
+		IncrementalVacuum: binary.BigEndian.Uint32(b[64:]),
+		ApplicationID:     binary.BigEndian.Uint32(b[68:]),
+		VersionValidFor:   binary.BigEndian.Uint32(b[92:]),
+		SQLiteVersion:     binary.BigEndian.Uint32(b[96:]),
+	}
+	// A page size of 1 stands for 65536, which doesn't fit in two bytes.
+	if h.PageSize == 1 {
+		h.PageSize = 65536
+	}
+	if h.PageSize < 512 || h.PageSize&(h.PageSize-1) != 0 {
+		return dbHeader{}, fmt.Errorf("invalid page size: %d", h.PageSize)
+	}
+	return h, nil
+}
+
+// autoVacuum returns the auto_vacuum mode of the database: none, full or
+// incremental.
+func (h *dbHeader) autoVacuum() string {
+	switch {
+	case h.LargestRootPage == 0:
+		return "none"
+	case h.IncrementalVacuum != 0:
+		return "incremental"
+	}
+	return "full"
+}
+
+// ptrmapPages returns how many of the pageCount pages of the database are
+// pointer map pages: with auto_vacuum, the one at page 2 and then one after
+// each run of usable/5 pages it describes.
+func (h *dbHeader) ptrmapPages(pageCount uint32) uint32 {
+	if h.LargestRootPage == 0 || pageCount < 2 {
+		return 0
+	}
+	step := (h.PageSize-uint32(h.ReservedBytes))/5 + 1
+	return (pageCount-2)/step + 1
+}
+
+// pager reads the pages of an extracted database as a reader would see them:
+// the committed frames of the WAL take precedence over the main file.
+type pager struct {
+	main      io.ReaderAt
+	wal       io.ReaderAt
+	files     []*os.File       // closed along with the pager
+	frames    map[uint32]int64 // page number to offset of its latest WAL frame
+	pageSize  int
+	pageCount uint32
+	header    dbHeader
+}
+
+// openPager opens the database at path along with its WAL, if any.
+func openPager(path string) (*pager, error) {
+	main, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []*os.File{main}
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	stat, err := main.Stat()
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	var wal io.ReaderAt
+	var walSize int64
+	if f, err := os.Open(path + "-wal"); err == nil {
+		files = append(files, f)
+		info, err := f.Stat()
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		wal, walSize = f, info.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		closeAll()
+		return nil, fmt.Errorf("couldn't read WAL: %w", err)
+	}
+
+	p, err := newPager(main, stat.Size(), wal, walSize)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	p.files = files
+	return p, nil
+}
+
+// newMemPager reads the database whose main file and WAL are held in memory,
+// such as read straight out of a snapshot.
+func newMemPager(main, wal []byte) (*pager, error) {
+	return newPager(bytes.NewReader(main), int64(len(main)), bytes.NewReader(wal), int64(len(wal)))
+}
+
+// readPager reads the database of entry out of snapshot into memory, without
+// copying it when the snapshot is mapped.
+func readPager(snapshot *snapshotReader, entry *dbEntry) (*pager, error) {
+	read := func(size uint64) ([]byte, error) {
+		if snapshot.mapped != nil {
+			if data := snapshot.take(int64(size)); uint64(len(data)) == size {
+				return data, nil
+			}
+			return nil, io.ErrUnexpectedEOF
+		}
+		data := make([]byte, size)
+		_, err := io.ReadFull(snapshot, data)
+		return data, err
+	}
+
+	main, err := read(entry.mainSize)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read %s: %w", entry.name, err)
+	}
+	wal, err := read(entry.walSize)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read %s-wal: %w", entry.name, err)
+	}
+	return newMemPager(main, wal)
+}
+
+// newPager reads the database made of main, of mainSize bytes, and its WAL,
+// of walSize bytes, nil or empty without one.
+func newPager(main io.ReaderAt, mainSize int64, wal io.ReaderAt, walSize int64) (*pager, error) {
+	p := &pager{main: main, frames: make(map[uint32]int64)}
+
+	if wal != nil && walSize > 0 {
+		info, err := scanWAL(bufio.NewReader(io.NewSectionReader(wal, 0, walSize)), walSize)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read WAL: %w", err)
+		}
+		if info.Committed > 0 {
+			p.wal = wal
+			for _, frame := range info.Frames[:info.Committed] {
+				p.frames[frame.Page] = frame.Offset
+			}
+			p.pageSize = int(info.Header.PageSize)
+			p.pageCount = info.Frames[info.Committed-1].Commit
+		}
+	}
+
+	if p.pageSize == 0 {
+		var raw [dbHeaderSize]byte
+		if _, err := main.ReadAt(raw[:], 0); err != nil {
+			return nil, fmt.Errorf("couldn't read database header: %w", err)
+		}
+		h, err := parseDBHeader(raw[:])
+		if err != nil {
+			return nil, err
+		}
+		p.pageSize = int(h.PageSize)
+		p.pageCount = uint32(mainSize / int64(h.PageSize))
+	}
+
+	first, err := p.page(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.header, err = parseDBHeader(first); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// page returns the content of page number n.
+func (p *pager) page(n uint32) ([]byte, error) {
+	if n < 1 || n > p.pageCount {
+		return nil, fmt.Errorf("page %d out of range (1-%d)", n, p.pageCount)
+	}
+
+	buf := make([]byte, p.pageSize)
+	var err error
+	if offset, ok := p.frames[n]; ok {
+		_, err = p.wal.ReadAt(buf, offset)
+	} else {
+		_, err = p.main.ReadAt(buf, int64(n-1)*int64(p.pageSize))
+		// Pages past the end of the main file that no WAL frame holds yet
+		// read as zeroes, like SQLite does.
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read page %d: %w", n, err)
+	}
+	return buf, nil
+}
+
+// usableSize is the page size minus the reserved bytes at the end of each page.
+func (p *pager) usableSize() int {
+	return p.pageSize - int(p.header.ReservedBytes)
+}
+
+// image returns the whole database as a reader sees it, the committed WAL
+// frames applied, as a single file in rollback journal mode.
+func (p *pager) image() ([]byte, error) {
+	image := make([]byte, 0, int64(p.pageCount)*int64(p.pageSize))
+	for n := uint32(1); n <= p.pageCount; n++ {
+		page, err := p.page(n)
+		if err != nil {
+			return nil, err
+		}
+		image = append(image, page...)
+	}
+	// The file format versions: 2 stands for WAL, which a database without
+	// its WAL can't be opened in.
+	image[18], image[19] = 1, 1
+	return image, nil
+}
+
+func (p *pager) Close() error {
+	var err error
+	for _, f := range p.files {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// B-tree page types.
+const (
+	pageIndexInterior = 2
+	pageTableInterior = 5
+	pageIndexLeaf     = 10
+	pageTableLeaf     = 13
+)
+
+// btreePage is a decoded b-tree page header along with its cell offsets.
+type btreePage struct {
+	Type       byte
+	FreeBlock  uint16
+	Fragmented uint8
+	RightChild uint32
+	Cells      []int // offsets of the cells within the page
+	CellsEnd   int   // end of the cell pointer array
+	ContentTop int   // start of the cell content area
+}
+
+// parseBtreePage decodes the b-tree page header of page number n, whose
+// content is data.
+func parseBtreePage(n uint32, data []byte) (*btreePage, error) {
+	offset := 0
+	if n == 1 {
+		offset = dbHeaderSize
+	}
+	if len(data) < offset+8 {
+		return nil, errors.New("page too short")
+	}
+
+	h := data[offset:]
+	bp := &btreePage{
+		Type:       h[0],
+		FreeBlock:  binary.BigEndian.Uint16(h[1:]),
+		Fragmented: h[7],
+		ContentTop: int(binary.BigEndian.Uint16(h[5:])),
+	}
+	if bp.ContentTop == 0 {
+		bp.ContentTop = 65536
+	}
+
+	headerSize := 8
+	switch bp.Type {
+	case pageIndexInterior, pageTableInterior:
+		headerSize = 12
+		bp.RightChild = binary.BigEndian.Uint32(h[8:])
+	case pageIndexLeaf, pageTableLeaf:
+	default:
+		return nil, fmt.Errorf("invalid b-tree page type %d", bp.Type)
+	}
+
+	count := int(binary.BigEndian.Uint16(h[3:]))
+	pointers := offset + headerSize
+	if pointers+2*count > len(data) {
+		return nil, fmt.Errorf("cell pointer array overflows the page (%d cells)", count)
+	}
+	bp.Cells = make([]int, count)
+	bp.CellsEnd = pointers + 2*count
+	for i := range bp.Cells {
+		cell := int(binary.BigEndian.Uint16(data[pointers+2*i:]))
+		if cell < pointers+2*count || cell >= len(data) {
+			return nil, fmt.Errorf("cell %d has an invalid offset %d", i, cell)
+		}
+		bp.Cells[i] = cell
+	}
+	return bp, nil
+}
+
+// IsLeaf reports whether bp is a leaf page.
+func (bp *btreePage) IsLeaf() bool {
+	return bp.Type == pageIndexLeaf || bp.Type == pageTableLeaf
+}
+
+// unused returns the bytes of the page data holds no cell in: the gap
+// between the cell pointers and the cell content, the free blocks within the
+// content and the fragments too small to be free blocks.
+func (bp *btreePage) unused(data []byte) int {
+	n := max(min(bp.ContentTop, len(data))-bp.CellsEnd, 0) + int(bp.Fragmented)
+	// Free blocks come in increasing offsets, each starting with the next
+	// one and its size.
+	for block := int(bp.FreeBlock); block > 0 && block+4 <= len(data); {
+		n += int(binary.BigEndian.Uint16(data[block+2:]))
+		next := int(binary.BigEndian.Uint16(data[block:]))
+		if next <= block {
+			break
+		}
+		block = next
+	}
+	return n
+}
+
+// btreeCell is a decoded b-tree cell.
+type btreeCell struct {
+	LeftChild uint32 // child page for interior cells
+	Rowid     int64  // rowid for table cells
+	Size      int64  // total payload size
+	Local     []byte // payload stored on the page itself
+	Overflow  uint32 // first overflow page, 0 if none
+}
+
+// parseCell decodes the cell at offset in a page of type pageType, given the
+// usable size of pages.
+func parseCell(data []byte, offset int, pageType byte, usable int) (btreeCell, error) {
+	var c btreeCell
+	truncated := fmt.Errorf("cell at offset %d is truncated", offset)
+	if offset < 0 || offset >= len(data) {
+		return c, truncated
+	}
+	b := data[offset:]
+
+	if pageType == pageIndexInterior || pageType == pageTableInterior {
+		if len(b) < 4 {
+			return c, truncated
+		}
+		c.LeftChild = binary.BigEndian.Uint32(b)
+		b = b[4:]
+	}
+	if pageType == pageTableInterior {
+		rowid, n := readVarint(b)
+		if n == 0 {
+			return c, truncated
+		}
+		c.Rowid = int64(rowid)
+		return c, nil
+	}
+
+	// Sizes past MaxInt64 would turn negative, and no page holds them.
+	size, n := readVarint(b)
+	if n == 0 || size > math.MaxInt64 {
+		return c, truncated
+	}
+	b = b[n:]
+	c.Size = int64(size)
+	if pageType == pageTableLeaf {
+		rowid, n := readVarint(b)
+		if n == 0 {
+			return c, truncated
+		}
+		c.Rowid = int64(rowid)
+		b = b[n:]
+	}
+
+	local := localPayload(c.Size, pageType, usable)
+	if int64(len(b)) < local {
+		return c, truncated
+	}
+	c.Local = b[:local]
+	if local < c.Size {
+		if int64(len(b)) < local+4 {
+			return c, truncated
+		}
+		c.Overflow = binary.BigEndian.Uint32(b[local:])
+	}
+	return c, nil
+}
+
+// localPayload returns how many bytes of a payload of the given size are
+// stored on a b-tree page of type pageType, the rest spilling to overflow
+// pages.
+func localPayload(size int64, pageType byte, usable int) int64 {
+	u := int64(usable)
+	maxLocal := u - 35
+	if pageType != pageTableLeaf {
+		maxLocal = (u-12)*64/255 - 23
+	}
+	if size <= maxLocal {
+		return size
+	}
+
+	minLocal := (u-12)*32/255 - 23
+	k := minLocal + (size-minLocal)%(u-4)
+	if k <= maxLocal {
+		return k
+	}
+	return minLocal
+}
+
+// readPayload returns the full payload of c, following its overflow chain.
+// Each overflow page visited is passed to visit, if not nil.
+func (p *pager) readPayload(c btreeCell, visit func(page uint32) error) ([]byte, error) {
+	payload := append([]byte(nil), c.Local...)
+	next := c.Overflow
+	for next != 0 && int64(len(payload)) < c.Size {
+		if visit != nil {
+			if err := visit(next); err != nil {
+				return nil, err
+			}
+		}
+		data, err := p.page(next)
+		if err != nil {
+			return nil, err
+		}
+		chunk := data[4:p.usableSize()]
+		if rest := c.Size - int64(len(payload)); int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		payload = append(payload, chunk...)
+		next = binary.BigEndian.Uint32(data)
+	}
+	if int64(len(payload)) < c.Size {
+		return nil, fmt.Errorf("overflow chain ends after %d of %d bytes", len(payload), c.Size)
+	}
+	return payload, nil
+}
+
+// readVarint decodes an SQLite varint, returning its value and length, or a
+// zero length if b is too short.
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
+
+// parseRecord decodes an SQLite record into its values, which are nil,
+// int64, float64, string or []byte.
+func parseRecord(payload []byte) ([]any, error) {
+	headerSize, n := readVarint(payload)
+	if n == 0 || headerSize < uint64(n) || headerSize > uint64(len(payload)) {
+		return nil, errors.New("invalid record header")
+	}
+
+	var values []any
+	header := payload[n:headerSize]
+	body := payload[headerSize:]
+	for len(header) > 0 {
+		serial, n := readVarint(header)
+		if n == 0 {
+			return nil, errors.New("invalid record header")
+		}
+		header = header[n:]
+
+		size := serialSize(serial)
+		if uint64(len(body)) < size {
+			return nil, errors.New("record body too short")
+		}
+		field := body[:size]
+		body = body[size:]
+
+		switch {
+		case serial == 0:
+			values = append(values, nil)
+		case serial <= 6:
+			v := int64(int8(field[0]))
+			for _, b := range field[1:] {
+				v = v<<8 | int64(b)
+			}
+			values = append(values, v)
+		case serial == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(field)))
+		case serial == 8:
+			values = append(values, int64(0))
+		case serial == 9:
+			values = append(values, int64(1))
+		case serial >= 12 && serial%2 == 0:
+			values = append(values, append([]byte(nil), field...))
+		case serial >= 13:
+			values = append(values, string(field))
+		default:
+			return nil, fmt.Errorf("invalid serial type %d", serial)
+		}
+	}
+	return values, nil
+}
+
+// serialSize returns the size of the content of a record field with the given
+// serial type.
+func serialSize(serial uint64) uint64 {
+	switch {
+	case serial <= 4:
+		return serial
+	case serial == 5:
+		return 6
+	case serial == 6 || serial == 7:
+		return 8
+	case serial < 12:
+		return 0
+	default:
+		return (serial - 12) / 2
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// dedupStore, set with --dedup-store, sends the extracted files to a content
+// addressed store instead of the current directory, cut into chunks of
+// dedupChunkSize bytes.
+var (
+	dedupStore     string
+	dedupChunkSize = 64 << 10
+)
+
+// A store holds each distinct chunk once, as objects/<2 hex digits>/<sha256>,
+// and describes each snapshot extracted into it with snapshots/<name>.json.
+const (
+	storeObjects   = "objects"
+	storeSnapshots = "snapshots"
+)
+
+// storeManifest lists the files a snapshot was extracted into, made of the
+// chunks of the store.
+type storeManifest struct {
+	Snapshot  string      `json:"snapshot"`
+	Created   time.Time   `json:"created"`
+	ChunkSize int         `json:"chunk_size"`
+	Files     []storeFile `json:"files"`
+}
+
+// storeFile is a file of a storeManifest, with the hashes of its chunks in
+// order.
+type storeFile struct {
+	Path   string   `json:"path"`
+	Size   int64    `json:"size"`
+	Chunks []string `json:"chunks"`
+}
+
+// dedupSink writes the files of an extraction into a store. Chunks already
+// in the store aren't written again; the manifest only goes in once every
+// file is complete.
+type dedupSink struct {
+	dir      string
+	manifest storeManifest
+	chunks   int
+	written  int
+	bytes    int64
+}
+
+func newDedupSink(dir, snapshot string) (*dedupSink, error) {
+	for _, sub := range []string{storeObjects, storeSnapshots} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &dedupSink{
+		dir:      dir,
+		manifest: storeManifest{Snapshot: filepath.Base(snapshot), Created: time.Now().UTC(), ChunkSize: dedupChunkSize},
+	}, nil
+}
+
+func (s *dedupSink) add(name string, size int64, r io.Reader) error {
+	f := storeFile{Path: name, Size: size, Chunks: []string{}}
+	buf := getBuffer(s.manifest.ChunkSize)
+	defer putBuffer(buf)
+	for remaining := size; remaining > 0; {
+		chunk := buf[:min(remaining, int64(len(buf)))]
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return err
+		}
+		remaining -= int64(len(chunk))
+
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+		written, err := s.writeObject(hash, chunk)
+		if err != nil {
+			return err
+		}
+		f.Chunks = append(f.Chunks, hash)
+		s.chunks++
+		if written {
+			s.written++
+			s.bytes += int64(len(chunk))
+		}
+	}
+	s.manifest.Files = append(s.manifest.Files, f)
+	return nil
+}
+
+// writeObject stores chunk under hash, unless the store already has it,
+// returning whether it was written.
+func (s *dedupSink) writeObject(hash string, chunk []byte) (bool, error) {
+	path := objectPath(s.dir, hash)
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	// Written aside and renamed into place, so that the store never holds
+	// a partial chunk, even with concurrent extractions.
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".tmp")
+	if err != nil {
+		return false, err
+	}
+	_, err = tmp.Write(chunk)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), archiveFileMode())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *dedupSink) finish(err error) error {
+	// Chunks of a failed extraction are left for store-prune to collect.
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(&s.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, storeSnapshots, s.manifest.Snapshot+".json")
+	if err := os.WriteFile(path+".tmp", append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	fmt.Fprintf(progressOutput(), "Stored %d chunks into %s, %d of them new (%s)\n",
+		s.chunks, s.dir, s.written, formatBytes(s.bytes))
+	return nil
+}
+
+func objectPath(dir, hash string) string {
+	return filepath.Join(dir, storeObjects, hash[:2], hash)
+}
+
+// readStoreManifests returns the manifests of the store in dir, oldest first.
+func readStoreManifests(dir string) ([]*storeManifest, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, storeSnapshots))
+	if err != nil {
+		return nil, err
+	}
+	var manifests []*storeManifest
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, storeSnapshots, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := &storeManifest{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, fmt.Errorf("couldn't parse %s: %w", entry.Name(), err)
+		}
+		manifests = append(manifests, m)
+	}
+	slices.SortFunc(manifests, func(a, b *storeManifest) int { return a.Created.Compare(b.Created) })
+	return manifests, nil
+}
+
+var storeCheckoutCmd = &cobra.Command{
+	Use:   "store-checkout <store> [<snapshot> [<dir>]]",
+	Short: "Write the files of a snapshot kept in a deduplicated store",
+	Long: `Puts back together the files a snapshot was extracted into with
+--dedup-store, into dir or the current directory, checking the hash of every
+chunk. Without a snapshot, lists the snapshots the store holds.`,
+	Args: cobra.RangeArgs(1, 3),
+	RunE: storeCheckout,
+
+	SilenceUsage: true,
+}
+
+var storePruneCmd = &cobra.Command{
+	Use:   "store-prune <store>",
+	Short: "Drop old snapshots from a deduplicated store",
+	Long: `Removes all but the newest --keep snapshots from a store written with
+--dedup-store, then the chunks no remaining snapshot uses, including those
+left behind by failed extractions.`,
+	Args: cobra.ExactArgs(1),
+	RunE: storePrune,
+
+	SilenceUsage: true,
+}
+
+var storeKeep int
+
+func init() {
+	storePruneCmd.Flags().IntVar(&storeKeep, "keep", 0, "number of snapshots to keep, the newest ones (default all, only collecting unused chunks)")
+	rootCmd.AddCommand(storeCheckoutCmd)
+	rootCmd.AddCommand(storePruneCmd)
+}
+
+func storeCheckout(cmd *cobra.Command, args []string) error {
+	manifests, err := readStoreManifests(args[0])
+	if err != nil {
+		return fmt.Errorf("couldn't read the store: %w", err)
+	}
+	if len(args) == 1 {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "SNAPSHOT\tCREATED\tFILES\tSIZE")
+		for _, m := range manifests {
+			var size int64
+			for _, f := range m.Files {
+				size += f.Size
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", m.Snapshot, m.Created.Format(time.RFC3339), len(m.Files), formatBytes(size))
+		}
+		return tw.Flush()
+	}
+
+	i := slices.IndexFunc(manifests, func(m *storeManifest) bool { return m.Snapshot == args[1] })
+	if i < 0 {
+		return fmt.Errorf("snapshot %q not found in the store", args[1])
+	}
+	dir := "."
+	if len(args) == 3 {
+		dir = args[2]
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range manifests[i].Files {
+		fmt.Printf("Writing %s...\n", f.Path)
+		if err := checkoutFile(args[0], f, filepath.Join(dir, f.Path)); err != nil {
+			return fmt.Errorf("couldn't write %s: %w", f.Path, err)
+		}
+	}
+	fmt.Printf("Done!\n")
+	return nil
+}
+
+// checkoutFile writes f, read from the store in dir, to path.
+func checkoutFile(dir string, f storeFile, path string) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var written int64
+	for _, hash := range f.Chunks {
+		chunk, err := os.ReadFile(objectPath(dir, hash))
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if sum := sha256.Sum256(chunk); hex.EncodeToString(sum[:]) != hash {
+			out.Close()
+			return fmt.Errorf("chunk %s is corrupt", hash)
+		}
+		if _, err := out.Write(chunk); err != nil {
+			out.Close()
+			return err
+		}
+		written += int64(len(chunk))
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if written != f.Size {
+		return fmt.Errorf("chunks hold %d bytes, expected %d", written, f.Size)
+	}
+	return nil
+}
+
+func storePrune(cmd *cobra.Command, args []string) error {
+	dir := args[0]
+	if storeKeep < 0 {
+		return fmt.Errorf("--keep can't be negative")
+	}
+	manifests, err := readStoreManifests(dir)
+	if err != nil {
+		return fmt.Errorf("couldn't read the store: %w", err)
+	}
+	if storeKeep > 0 && len(manifests) > storeKeep {
+		for _, m := range manifests[:len(manifests)-storeKeep] {
+			fmt.Printf("Dropping snapshot %s...\n", m.Snapshot)
+			if err := os.Remove(filepath.Join(dir, storeSnapshots, m.Snapshot+".json")); err != nil {
+				return err
+			}
+		}
+		manifests = manifests[len(manifests)-storeKeep:]
+	}
+
+	used := make(map[string]bool)
+	for _, m := range manifests {
+		for _, f := range m.Files {
+			for _, hash := range f.Chunks {
+				used[hash] = true
+			}
+		}
+	}
+	var removed int
+	var freed int64
+	err = filepath.WalkDir(filepath.Join(dir, storeObjects), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || used[d.Name()] {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Kept %d snapshots, removed %d unused chunks (%s)\n", len(manifests), removed, formatBytes(freed))
+	return nil
+}
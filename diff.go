@@ -0,0 +1,357 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <snapshot-a> <snapshot-b>",
+	Short: "Compare the databases of two snapshots",
+	Long: `Compares two snapshots database by database, reporting which databases
+appeared or disappeared, how their schemas changed and which rows were added or
+removed from each table.
+
+With --pages, the databases are compared page by page instead, without
+decoding anything but their freelist: how many pages changed, were added at
+the end, freed onto the freelist or truncated away, which tells where the
+growth of a database between two snapshots went.`,
+	Args: cobra.ExactArgs(2),
+	RunE: diff,
+
+	SilenceUsage: true,
+}
+
+var (
+	diffJSON      bool
+	diffMaxRows   int
+	diffPagesOnly bool
+)
+
+func init() {
+	diffCmd.Flags().BoolVar(&diffJSON, "json", false, "print the differences as JSON")
+	diffCmd.Flags().IntVar(&diffMaxRows, "max-rows", 10, "maximum number of differing rows to show per table and direction")
+	diffCmd.Flags().BoolVar(&diffPagesOnly, "pages", false, "compare the databases page by page instead of row by row")
+	rootCmd.AddCommand(diffCmd)
+}
+
+// snapshotDiff holds the differences between two snapshots.
+type snapshotDiff struct {
+	Added     []string       `json:"added_databases"`
+	Removed   []string       `json:"removed_databases"`
+	Databases []databaseDiff `json:"databases"`
+}
+
+// databaseDiff holds the differences of a database found in both snapshots.
+type databaseDiff struct {
+	Name   string         `json:"name"`
+	Schema []schemaChange `json:"schema,omitempty"`
+	Tables []tableDiff    `json:"tables,omitempty"`
+}
+
+// schemaChange is an object of the schema that was added, removed or changed.
+type schemaChange struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Change string `json:"change"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// tableDiff counts the rows only found on either side of a table, with a
+// sample of them. Changed rows show up as both removed and added.
+type tableDiff struct {
+	Table       string  `json:"table"`
+	Removed     int64   `json:"removed"`
+	Added       int64   `json:"added"`
+	RemovedRows [][]any `json:"removed_rows,omitempty"`
+	AddedRows   [][]any `json:"added_rows,omitempty"`
+	Skipped     string  `json:"skipped,omitempty"`
+}
+
+func diff(cmd *cobra.Command, args []string) error {
+	dirA, namesA, cleanupA, err := extractTemp(args[0], nil)
+	if err != nil {
+		return fmt.Errorf("couldn't extract %s: %w", args[0], err)
+	}
+	defer cleanupA()
+
+	dirB, namesB, cleanupB, err := extractTemp(args[1], nil)
+	if err != nil {
+		return fmt.Errorf("couldn't extract %s: %w", args[1], err)
+	}
+	defer cleanupB()
+
+	if diffPagesOnly {
+		return diffSnapshotPages(dirA, namesA, dirB, namesB)
+	}
+
+	var result snapshotDiff
+	for _, name := range namesA {
+		if !slices.Contains(namesB, name) {
+			result.Removed = append(result.Removed, name)
+		}
+	}
+	for _, name := range namesB {
+		if !slices.Contains(namesA, name) {
+			result.Added = append(result.Added, name)
+			continue
+		}
+		d, err := diffDatabases(name, filepath.Join(dirA, name), filepath.Join(dirB, name))
+		if err != nil {
+			return fmt.Errorf("couldn't compare %s: %w", name, err)
+		}
+		result.Databases = append(result.Databases, d)
+	}
+
+	if diffJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	printSnapshotDiff(&result)
+	return nil
+}
+
+// diffDatabases compares the database at pathA with the one at pathB, which
+// gets attached to the same connection as "b".
+func diffDatabases(name, pathA, pathB string) (databaseDiff, error) {
+	d := databaseDiff{Name: name}
+	err := withDatabase(pathA, func(db *sql.DB) error {
+		uri := "file:" + (&url.URL{Path: pathB}).EscapedPath() + "?mode=ro"
+		if _, err := db.Exec("ATTACH DATABASE ? AS b", uri); err != nil {
+			return err
+		}
+		defer db.Exec("DETACH DATABASE b")
+
+		schemaA, err := readSchemaOf(db, "main")
+		if err != nil {
+			return err
+		}
+		schemaB, err := readSchemaOf(db, "b")
+		if err != nil {
+			return err
+		}
+		d.Schema = diffSchemas(schemaA, schemaB)
+
+		for _, o := range schemaB {
+			if o.Type != "table" || strings.HasPrefix(o.Name, "sqlite_") || isVirtual(o) {
+				continue
+			}
+			i := slices.IndexFunc(schemaA, func(a schemaObject) bool { return a.Type == "table" && a.Name == o.Name })
+			if i < 0 {
+				continue
+			}
+			t, err := diffTable(db, o.Name)
+			if err != nil {
+				return fmt.Errorf("table %s: %w", o.Name, err)
+			}
+			if t.Added > 0 || t.Removed > 0 || t.Skipped != "" {
+				d.Tables = append(d.Tables, t)
+			}
+		}
+		return nil
+	})
+	return d, err
+}
+
+// readSchemaOf is readSchema for the database attached as schema.
+func readSchemaOf(db *sql.DB, schema string) ([]schemaObject, error) {
+	rows, err := db.Query(`SELECT type, name, tbl_name, sql FROM ` + quoteIdent(schema) + `.sqlite_schema
+		WHERE sql NOT NULL
+		ORDER BY type != 'table', tbl_name = 'sqlite_sequence', rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []schemaObject
+	for rows.Next() {
+		var o schemaObject
+		if err := rows.Scan(&o.Type, &o.Name, &o.TblName, &o.SQL); err != nil {
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+	return objects, rows.Err()
+}
+
+// diffSchemas lists the objects that were removed from a, added to b or whose
+// definition changed, in that order.
+func diffSchemas(a, b []schemaObject) []schemaChange {
+	find := func(objects []schemaObject, o schemaObject) int {
+		return slices.IndexFunc(objects, func(x schemaObject) bool { return x.Type == o.Type && x.Name == o.Name })
+	}
+
+	var changes []schemaChange
+	for _, o := range a {
+		if find(b, o) < 0 {
+			changes = append(changes, schemaChange{Type: o.Type, Name: o.Name, Change: "removed", Old: o.SQL})
+		}
+	}
+	for _, o := range b {
+		i := find(a, o)
+		switch {
+		case i < 0:
+			changes = append(changes, schemaChange{Type: o.Type, Name: o.Name, Change: "added", New: o.SQL})
+		case a[i].SQL != o.SQL:
+			changes = append(changes, schemaChange{Type: o.Type, Name: o.Name, Change: "changed", Old: a[i].SQL, New: o.SQL})
+		}
+	}
+	return changes
+}
+
+// diffTable compares the contents of table in the main and b databases.
+func diffTable(db *sql.DB, table string) (tableDiff, error) {
+	t := tableDiff{Table: table}
+
+	columnsA, err := columnsOf(db, "main", table)
+	if err != nil {
+		return t, err
+	}
+	columnsB, err := columnsOf(db, "b", table)
+	if err != nil {
+		return t, err
+	}
+	if !slices.Equal(columnsA, columnsB) {
+		t.Skipped = "columns changed"
+		return t, nil
+	}
+
+	quoted := make([]string, len(columnsA))
+	for i, column := range columnsA {
+		quoted[i] = quoteIdent(column)
+	}
+	list := strings.Join(quoted, ", ")
+	except := func(from, minus string) string {
+		return "SELECT " + list + " FROM " + from + "." + quoteIdent(table) +
+			" EXCEPT SELECT " + list + " FROM " + minus + "." + quoteIdent(table)
+	}
+
+	if t.Removed, t.RemovedRows, err = sampleRows(db, except("main", "b")); err != nil {
+		return t, err
+	}
+	if t.Added, t.AddedRows, err = sampleRows(db, except("b", "main")); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// sampleRows counts the rows returned by query, keeping the first
+// --max-rows of them.
+func sampleRows(db *sql.DB, query string) (int64, [][]any, error) {
+	var count int64
+	if err := db.QueryRow("SELECT count(*) FROM (" + query + ")").Scan(&count); err != nil {
+		return 0, nil, err
+	}
+	if count == 0 || diffMaxRows <= 0 {
+		return count, nil, nil
+	}
+
+	rows, err := db.Query(fmt.Sprintf("%s LIMIT %d", query, diffMaxRows))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var sample [][]any
+	err = scanRows(rows, func(values []any) error {
+		sample = append(sample, slices.Clone(values))
+		return nil
+	})
+	return count, sample, err
+}
+
+// columnsOf returns the stored columns of table in the given schema.
+func columnsOf(db *sql.DB, schema, table string) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?, ?)", table, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// isVirtual reports whether o is a virtual table.
+func isVirtual(o schemaObject) bool {
+	return strings.HasPrefix(strings.ToUpper(o.SQL), "CREATE VIRTUAL TABLE")
+}
+
+func printSnapshotDiff(d *snapshotDiff) {
+	for _, name := range d.Removed {
+		fmt.Printf("- database %s\n", name)
+	}
+	for _, name := range d.Added {
+		fmt.Printf("+ database %s\n", name)
+	}
+
+	for _, db := range d.Databases {
+		if len(db.Schema) == 0 && len(db.Tables) == 0 {
+			fmt.Printf("= database %s\n", db.Name)
+			continue
+		}
+		fmt.Printf("~ database %s\n", db.Name)
+		printSchemaChanges(db.Schema, "    ")
+		for _, t := range db.Tables {
+			if t.Skipped != "" {
+				fmt.Printf("    table %s: rows not compared, %s\n", t.Table, t.Skipped)
+				continue
+			}
+			fmt.Printf("    table %s: %d rows removed, %d rows added\n", t.Table, t.Removed, t.Added)
+			for _, row := range t.RemovedRows {
+				fmt.Printf("      - %s\n", formatRow(row))
+			}
+			for _, row := range t.AddedRows {
+				fmt.Printf("      + %s\n", formatRow(row))
+			}
+		}
+	}
+}
+
+func printSchemaChanges(changes []schemaChange, indent string) {
+	for _, c := range changes {
+		switch c.Change {
+		case "removed":
+			fmt.Printf("%s- %s %s: %s\n", indent, c.Type, c.Name, c.Old)
+		case "added":
+			fmt.Printf("%s+ %s %s: %s\n", indent, c.Type, c.Name, c.New)
+		default:
+			fmt.Printf("%s~ %s %s:\n%s    was: %s\n%s    now: %s\n", indent, c.Type, c.Name, indent, c.Old, indent, c.New)
+		}
+	}
+}
+
+// formatRow renders row values in SQL literal syntax.
+func formatRow(row []any) string {
+	values := make([]string, len(row))
+	for i, value := range row {
+		switch v := value.(type) {
+		case nil:
+			values[i] = "NULL"
+		case string:
+			values[i] = quoteString(v)
+		case []byte:
+			values[i] = "X'" + strings.ToUpper(formatValue(v)) + "'"
+		default:
+			values[i] = formatValue(v)
+		}
+	}
+	return "(" + strings.Join(values, ", ") + ")"
+}
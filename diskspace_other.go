@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "errors"
+
+func availableSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor <datadir>",
+	Short: "Check a whole dqlite data directory for problems",
+	Long: `Checks a dqlite data directory as a whole: that metadata1 and metadata2 are
+sane, that the newest snapshot and its .meta file are readable and pass their
+checksum, that the segments of the raft log pass theirs and follow on from
+the snapshot without gaps, and that its databases pass PRAGMA integrity_check.
+The problems found are listed with the most severe first.`,
+	Args: cobra.ExactArgs(1),
+	RunE: doctor,
+
+	SilenceUsage: true,
+}
+
+var doctorJSON bool
+
+func init() {
+	doctorCmd.Flags().BoolVar(&doctorJSON, "json", false, "print the problems as JSON")
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// Severities of the problems doctor finds, the most severe first.
+const (
+	severityCritical = iota // the node won't start, or will lose data
+	severityError           // something is broken, though the node may cope
+	severityWarning         // something looks off
+)
+
+var severityNames = []string{"critical", "error", "warning"}
+
+// problem is something doctor found wrong with a file of the data directory.
+type problem struct {
+	Severity string `json:"severity"`
+	File     string `json:"file"`
+	Message  string `json:"message"`
+	level    int
+}
+
+type doctorReport struct {
+	dir      string
+	problems []problem
+}
+
+func (d *doctorReport) add(level int, file, format string, args ...any) {
+	d.problems = append(d.problems, problem{severityNames[level], file, fmt.Sprintf(format, args...), level})
+}
+
+func doctor(cmd *cobra.Command, args []string) error {
+	d := &doctorReport{dir: args[0]}
+	if _, err := os.ReadDir(d.dir); err != nil {
+		return err
+	}
+
+	metadata := d.checkMetadata()
+	snapshot := d.checkSnapshots()
+	var snapshotIndex uint64
+	if snapshot != nil {
+		snapshotIndex = snapshot.Index
+		if metadata != nil && snapshot.Term > metadata.Term {
+			d.add(severityError, filepath.Base(snapshot.Path), "at term %d, later than the current term %d", snapshot.Term, metadata.Term)
+		}
+	}
+	d.checkSegments(snapshotIndex, metadata)
+	if snapshot != nil {
+		d.checkDatabases(snapshot.Path)
+	}
+
+	sort.SliceStable(d.problems, func(i, j int) bool { return d.problems[i].level < d.problems[j].level })
+	if doctorJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(struct {
+			Problems []problem `json:"problems"`
+		}{append([]problem{}, d.problems...)}); err != nil {
+			return err
+		}
+	} else {
+		d.print()
+	}
+
+	var counts [3]int
+	for _, p := range d.problems {
+		counts[p.level]++
+	}
+	if serious := counts[severityCritical] + counts[severityError]; serious > 0 {
+		return fmt.Errorf("%d critical problems and %d errors found", counts[severityCritical], counts[severityError])
+	}
+	return nil
+}
+
+// checkMetadata checks metadata1 and metadata2, returning the current one.
+func (d *doctorReport) checkMetadata() *raftMetadata {
+	var current *raftMetadata
+	versions := map[uint64]bool{}
+	for _, name := range []string{"metadata1", "metadata2"} {
+		m, err := readRaftMetadata(filepath.Join(d.dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			d.add(severityError, name, "unreadable: %v", err)
+			continue
+		}
+		if versions[m.Version] {
+			d.add(severityError, name, "same version %d as metadata1, raft can't tell which is current", m.Version)
+		}
+		versions[m.Version] = true
+		if current == nil || m.Version > current.Version {
+			current = m
+		}
+	}
+
+	switch {
+	case current == nil:
+		d.add(severityCritical, "metadata1", "neither metadata1 nor metadata2 is usable, the term and vote of the node are lost")
+	case current.Term == 0:
+		d.add(severityWarning, "metadata1", "the current term is 0, as if the node never took part in an election")
+	}
+	return current
+}
+
+// checkSnapshots checks the .meta files of the snapshots, returning the
+// newest snapshot, or nil if there is none.
+func (d *doctorReport) checkSnapshots() *raftSnapshot {
+	snapshots, err := listSnapshots(d.dir)
+	if err != nil {
+		d.add(severityCritical, ".", "couldn't list the snapshots: %v", err)
+		return nil
+	}
+	for i, s := range snapshots {
+		// Raft only loads the newest snapshot, older ones are leftovers.
+		level := severityWarning
+		if i == 0 {
+			level = severityCritical
+		}
+		name := filepath.Base(s.Path) + ".meta"
+		meta, err := readSnapshotMeta(s.Path + ".meta")
+		if err != nil {
+			d.add(level, name, "unreadable: %v", err)
+			continue
+		}
+		if i > 0 {
+			continue
+		}
+		voters := 0
+		for _, server := range meta.Servers {
+			if server.Role == raftVoter {
+				voters++
+			}
+		}
+		if voters == 0 {
+			d.add(severityCritical, name, "the configuration has no voter, the cluster can't elect a leader")
+		}
+		if meta.ConfigurationIndex > s.Index {
+			d.add(severityError, name, "the configuration is at index %d, after the snapshot at %d", meta.ConfigurationIndex, s.Index)
+		}
+	}
+
+	entries, err := os.ReadDir(d.dir)
+	if err == nil {
+		for _, entry := range entries {
+			data, ok := strings.CutSuffix(entry.Name(), ".meta")
+			if _, _, _, valid := parseSnapshotName(data); ok && valid {
+				if _, err := os.Stat(filepath.Join(d.dir, data)); errors.Is(err, fs.ErrNotExist) {
+					d.add(severityWarning, entry.Name(), "no snapshot goes with it")
+				}
+			}
+		}
+	}
+
+	if len(snapshots) == 0 {
+		return nil
+	}
+	return &snapshots[0]
+}
+
+// checkSegments checks the checksums of the segments and that they hold the
+// entries following snapshotIndex without gaps. Segments before the snapshot
+// are only left for followers catching up, so their problems matter less.
+func (d *doctorReport) checkSegments(snapshotIndex uint64, metadata *raftMetadata) {
+	segments, err := listSegments(d.dir)
+	if err != nil {
+		d.add(severityCritical, ".", "couldn't list the segments: %v", err)
+		return
+	}
+
+	var term uint64
+	read := func(segment raftSegment) (uint64, error) {
+		var count uint64
+		err := readSegment(filepath.Join(d.dir, segment.Name), func(_ int64, entries []raftEntry) error {
+			for _, entry := range entries {
+				term = max(term, entry.Term)
+			}
+			count += uint64(len(entries))
+			return nil
+		})
+		return count, err
+	}
+
+	// last is the index of the last entry of the log so far.
+	var last uint64
+	var previous *raftSegment
+	for i, segment := range segments {
+		if segment.Open {
+			count, err := read(segment)
+			if count > 0 && last == 0 && snapshotIndex > 0 {
+				last = snapshotIndex
+			}
+			if err != nil {
+				d.add(severityError, segment.Name, "%v, %s, raft drops the entries from there on", err, lastGood(last+1, count))
+			}
+			last += count
+			continue
+		}
+
+		level := severityWarning
+		if segment.Last > snapshotIndex {
+			level = severityCritical
+		}
+		switch {
+		case segment.First > segment.Last:
+			d.add(severityError, segment.Name, "first index %d after last index %d", segment.First, segment.Last)
+			continue
+		case previous != nil && segment.First <= previous.Last:
+			d.add(severityError, segment.Name, "overlaps %s", previous.Name)
+		case previous != nil && segment.First > previous.Last+1:
+			d.add(level, segment.Name, "%s, before it, missing", entryRange(previous.Last+1, segment.First-1))
+		case previous == nil && segment.First > snapshotIndex+1:
+			d.add(severityCritical, segment.Name, "%s, between the snapshot and the log, missing", entryRange(snapshotIndex+1, segment.First-1))
+		}
+		count, err := read(segment)
+		if err != nil {
+			d.add(level, segment.Name, "%v, %s", err, lastGood(segment.First, count))
+		} else if want := segment.Last - segment.First + 1; count != want {
+			d.add(level, segment.Name, "holds %d entries, %d going by its name", count, want)
+		}
+		previous, last = &segments[i], segment.Last
+	}
+
+	if metadata != nil && term > metadata.Term {
+		d.add(severityError, "metadata1", "the log has entries of term %d, later than the current term %d", term, metadata.Term)
+	}
+	if last > 0 && last < snapshotIndex {
+		d.add(severityWarning, ".", "the log ends at index %d, before the snapshot at %d", last, snapshotIndex)
+	}
+}
+
+// entryRange describes the log entries from first to last.
+func entryRange(first, last uint64) string {
+	if first == last {
+		return fmt.Sprintf("entry %d", first)
+	}
+	return fmt.Sprintf("entries %d to %d", first, last)
+}
+
+// checkDatabases extracts the snapshot at path and checks its databases.
+func (d *doctorReport) checkDatabases(path string) {
+	name := filepath.Base(path)
+	dir, names, cleanup, err := extractTemp(path, nil)
+	if err != nil {
+		d.add(severityCritical, name, "unreadable: %v", err)
+		return
+	}
+	defer cleanup()
+
+	for _, db := range names {
+		problems, err := integrityCheck(context.Background(), filepath.Join(dir, db), false)
+		switch {
+		case err != nil:
+			d.add(severityCritical, name, "database %s can't be opened: %v", db, err)
+		case len(problems) > 0:
+			d.add(severityCritical, name, "database %s fails integrity_check: %s", db, strings.Join(problems, "; "))
+		}
+	}
+}
+
+func (d *doctorReport) print() {
+	if len(d.problems) == 0 {
+		fmt.Printf("No problems found in %s\n", d.dir)
+		return
+	}
+	for _, p := range d.problems {
+		fmt.Printf("%-9s %s: %s\n", strings.ToUpper(p.Severity), p.File, p.Message)
+	}
+}
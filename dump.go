@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var dumpCmd = &cobra.Command{
+	Use:   "dump <snapshot>",
+	Short: "Dump the databases in a snapshot as SQL text",
+	Long: `Dumps the schema and contents of the databases in a snapshot as SQL text,
+equivalent to the .dump command of the sqlite3 cli, so that snapshots can be
+diffed, grepped and archived as plain text.`,
+	Args: snapshotArg,
+	RunE: dump,
+
+	SilenceUsage: true,
+}
+
+var dumpDB string
+
+func init() {
+	dumpCmd.Flags().StringVar(&dumpDB, "db", "", "only dump the named database")
+	rootCmd.AddCommand(dumpCmd)
+}
+
+func dump(cmd *cobra.Command, args []string) error {
+	path, err := snapshotPath(args)
+	if err != nil {
+		return err
+	}
+	dir, names, cleanup, err := extractTemp(path, onlyDatabase(dumpDB))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if dumpDB != "" && len(names) == 0 {
+		return fmt.Errorf("database %q not found in snapshot", dumpDB)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	for _, name := range names {
+		fmt.Fprintf(out, "-- Database: %s\n", name)
+		if err := dumpDatabase(out, filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("couldn't dump %s: %w", name, err)
+		}
+	}
+	return out.Flush()
+}
+
+// schemaObject is a row of sqlite_schema.
+type schemaObject struct {
+	Type    string
+	Name    string
+	TblName string
+	SQL     string
+}
+
+// readSchema returns the objects in the schema of db that have SQL text,
+// tables first, in the order the sqlite3 cli dumps them.
+func readSchema(db *sql.DB) ([]schemaObject, error) {
+	return readSchemaOf(db, "main")
+}
+
+// dumpDatabase writes the schema and contents of the database at path to w as
+// a single SQL transaction.
+func dumpDatabase(w io.Writer, path string) error {
+	return withDatabase(path, func(db *sql.DB) error {
+		objects, err := readSchema(db)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w, "PRAGMA foreign_keys=OFF;")
+		fmt.Fprintln(w, "BEGIN TRANSACTION;")
+
+		writableSchema := false
+		for _, o := range objects {
+			if o.Type != "table" {
+				fmt.Fprintf(w, "%s;\n", o.SQL)
+				continue
+			}
+
+			switch {
+			case o.Name == "sqlite_sequence":
+				fmt.Fprintln(w, "DELETE FROM sqlite_sequence;")
+			case strings.HasPrefix(o.Name, "sqlite_stat"):
+				fmt.Fprintln(w, "ANALYZE sqlite_schema;")
+			case strings.HasPrefix(o.Name, "sqlite_"):
+				continue
+			case strings.HasPrefix(strings.ToUpper(o.SQL), "CREATE VIRTUAL TABLE"):
+				// Virtual tables create their shadow tables on the fly, which
+				// would then clash with the dumped ones: insert the definition
+				// directly instead, as the sqlite3 cli does.
+				if !writableSchema {
+					fmt.Fprintln(w, "PRAGMA writable_schema=ON;")
+					writableSchema = true
+				}
+				fmt.Fprintf(w, "INSERT INTO sqlite_schema(type,name,tbl_name,rootpage,sql)VALUES('table',%s,%s,0,%s);\n",
+					quoteString(o.Name), quoteString(o.TblName), quoteString(o.SQL))
+				continue
+			default:
+				fmt.Fprintf(w, "%s;\n", o.SQL)
+			}
+
+			if err := dumpRows(w, db, o.Name); err != nil {
+				return fmt.Errorf("table %s: %w", o.Name, err)
+			}
+		}
+
+		if writableSchema {
+			fmt.Fprintln(w, "PRAGMA writable_schema=OFF;")
+		}
+		fmt.Fprintln(w, "COMMIT;")
+		return nil
+	})
+}
+
+// dumpRows writes an INSERT statement for every row of table to w.
+func dumpRows(w io.Writer, db *sql.DB, table string) error {
+	columns, generated, err := tableColumns(db, table)
+	if err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = "quote(" + quoteIdent(column) + ")"
+	}
+
+	// Generated columns can't be inserted into, so the target columns have to
+	// be spelled out whenever the table has any.
+	target := quoteIdent(table)
+	if generated {
+		names := make([]string, len(columns))
+		for i, column := range columns {
+			names[i] = quoteIdent(column)
+		}
+		target += "(" + strings.Join(names, ",") + ")"
+	}
+
+	rows, err := db.Query("SELECT " + strings.Join(quoted, ",") + " FROM " + quoteIdent(table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]string, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "INSERT INTO %s VALUES(%s);\n", target, strings.Join(values, ","))
+	}
+	return rows.Err()
+}
+
+// tableColumns returns the names of the stored columns of table, and whether
+// the table also has generated columns.
+func tableColumns(db queryer, table string) ([]string, bool, error) {
+	rows, err := db.Query("SELECT name, hidden FROM pragma_table_xinfo(?)", table)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	generated := false
+	for rows.Next() {
+		var name string
+		var hidden int
+		if err := rows.Scan(&name, &hidden); err != nil {
+			return nil, false, err
+		}
+		// Hidden values 2 and 3 mark virtual and stored generated columns.
+		if hidden == 2 || hidden == 3 {
+			generated = true
+			continue
+		}
+		columns = append(columns, name)
+	}
+	return columns, generated, rows.Err()
+}
+
+// quoteIdent quotes an SQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteString quotes an SQL string literal.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var editCmd = &cobra.Command{
+	Use:   "edit <snapshot> <outfile>",
+	Short: "Write a modified copy of a snapshot",
+	Long: `Writes a new snapshot identical to the given one except for the requested
+changes, e.g. without a corrupt database that has to be excised for recovery
+or with databases renamed for an application expecting other names. The
+content of the databases is copied over as it is.`,
+	Args: cobra.ExactArgs(2),
+	RunE: edit,
+
+	SilenceUsage: true,
+}
+
+var (
+	editDrop        []string
+	editRename      []string
+	editCompression string
+)
+
+func init() {
+	editCmd.Flags().StringSliceVar(&editDrop, "drop", nil, "databases to remove from the snapshot")
+	editCmd.Flags().StringSliceVar(&editRename, "rename", nil, "databases to rename, as old=new")
+	editCmd.Flags().StringVar(&editCompression, "compression", "", "compression of the new snapshot, none or lz4 (default as the original)")
+	editCmd.Flags().BoolVar(&verifyRoundtrip, "verify-roundtrip", false, "read the snapshot back and compare the hashes of its databases")
+	rootCmd.AddCommand(editCmd)
+}
+
+func edit(cmd *cobra.Command, args []string) error {
+	if len(editDrop) == 0 && len(editRename) == 0 {
+		return fmt.Errorf("nothing to edit, see --drop and --rename")
+	}
+	renames, err := parseRenames(editRename)
+	if err != nil {
+		return err
+	}
+	if same, err := sameFile(args[0], args[1]); err != nil {
+		return err
+	} else if same {
+		return fmt.Errorf("the new snapshot can't overwrite the original")
+	}
+
+	entries, layers, err := readEntries(args[0])
+	if err != nil {
+		return err
+	}
+	for _, name := range slices.Concat(editDrop, slices.Collect(maps.Keys(renames))) {
+		if !slices.ContainsFunc(entries, func(e *dbEntry) bool { return e.name == name }) {
+			return fmt.Errorf("database %q not found in snapshot", name)
+		}
+	}
+
+	// Remember where each database comes from, as the original is read
+	// again, in order, while writing the new snapshot.
+	var kept []*dbEntry
+	var indexes []uint64
+	for i, entry := range entries {
+		if slices.Contains(editDrop, entry.name) {
+			fmt.Printf("Dropping database %s\n", entry.name)
+			continue
+		}
+		if name, ok := renames[entry.name]; ok {
+			fmt.Printf("Renaming database %s to %s\n", entry.name, name)
+			entry = &dbEntry{name: name, mainSize: entry.mainSize, walSize: entry.walSize}
+		}
+		if slices.ContainsFunc(kept, func(e *dbEntry) bool { return e.name == entry.name }) {
+			return fmt.Errorf("there would be two databases named %q", entry.name)
+		}
+		kept = append(kept, entry)
+		indexes = append(indexes, uint64(i))
+	}
+
+	compress, err := outputCompression(layers, editCompression)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := openSnapshot(args[0])
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	err = createSnapshot(args[1], kept, compress, func(w io.Writer, i int) error {
+		return copyEntry(w, snapshot, indexes[i])
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't write %s: %w", args[1], err)
+	}
+	fmt.Printf("Wrote %d databases into %s\n", len(kept), args[1])
+	return nil
+}
+
+// copyEntry writes to w the content of the database at index in snapshot,
+// skipping the ones before it that weren't read yet.
+func copyEntry(w io.Writer, snapshot *snapshotReader, index uint64) error {
+	for {
+		entry, err := snapshot.next()
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return fmt.Errorf("database %d not found in snapshot", index)
+		}
+		if snapshot.read == index+1 {
+			_, err := io.CopyN(w, snapshot, int64(entry.mainSize+entry.walSize))
+			return err
+		}
+		if err := snapshot.skip(entry); err != nil {
+			return fmt.Errorf("couldn't skip database %s: %w", entry.name, err)
+		}
+	}
+}
+
+// parseRenames parses the old=new pairs of --rename.
+func parseRenames(pairs []string) (map[string]string, error) {
+	renames := make(map[string]string)
+	for _, pair := range pairs {
+		old, name, ok := strings.Cut(pair, "=")
+		if !ok || old == "" || name == "" || strings.ContainsRune(name, 0) {
+			return nil, fmt.Errorf("invalid --rename %q, expected old=new", pair)
+		}
+		if _, ok := renames[old]; ok {
+			return nil, fmt.Errorf("database %q renamed twice", old)
+		}
+		renames[old] = name
+	}
+	return renames, nil
+}
+
+// readEntries goes through the snapshot at path, returning the headers of
+// its databases and its compression layers.
+func readEntries(path string) ([]*dbEntry, []string, error) {
+	snapshot, err := openSnapshot(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer snapshot.Close()
+
+	var entries []*dbEntry
+	for {
+		entry, err := snapshot.next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if entry == nil {
+			break
+		}
+		if err := snapshot.skip(entry); err != nil {
+			return nil, nil, fmt.Errorf("couldn't skip database %s: %w", entry.name, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := snapshot.checkEOF(); err != nil {
+		return nil, nil, err
+	}
+	return entries, snapshot.compression(), nil
+}
+
+// sameFile tells whether a and b are the same file, b possibly not existing.
+func sameFile(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return os.SameFile(infoA, infoB), nil
+}
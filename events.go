@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// eventsFormat, set with --events, has the progress of the extraction
+// reported as structured events on the standard output, for wrappers to
+// follow. Everything else then goes to the standard error.
+var eventsFormat string
+
+var events struct {
+	mu        sync.Mutex
+	out       *os.File // nil unless --events is set
+	start     time.Time
+	databases int
+}
+
+func configureEvents() error {
+	switch eventsFormat {
+	case "":
+		return nil
+	case "jsonl":
+	default:
+		return fmt.Errorf("unknown --events format %q, expected jsonl", eventsFormat)
+	}
+	events.out, events.start = os.Stdout, time.Now()
+	// From here on, whatever is printed for humans lands on the standard
+	// error, leaving the standard output to the events alone.
+	os.Stdout = os.Stderr
+	return nil
+}
+
+// emit writes, with --events, an event of the given kind, made of fields,
+// as a JSON object on a line of its own: snapshot_opened, db_started,
+// db_done, warning, error or done.
+func emit(kind string, fields map[string]any) {
+	if events.out == nil {
+		return
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		data = []byte("{}")
+	}
+	// The kind and time come first, whatever the order of fields.
+	line := fmt.Sprintf(`{"event":%q,"time":%q`, kind, time.Now().UTC().Format(time.RFC3339Nano))
+	if len(data) > 2 {
+		line += "," + string(data[1:])
+	} else {
+		line += "}"
+	}
+
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if kind == "db_done" {
+		events.databases++
+	}
+	events.out.WriteString(line + "\n")
+}
+
+// warn logs a warning, emits it as an event and records it in the summary.
+func warn(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	logger.Warn(message)
+	countWarnings(1)
+	emit("warning", map[string]any{"message": message})
+	summarize(func(s *runSummary) { s.Warnings = append(s.Warnings, message) })
+}
+
+// emitEnd emits the final event of the run, done or, if err is not nil,
+// error.
+func emitEnd(err error) {
+	if err != nil {
+		emit("error", map[string]any{"message": err.Error()})
+		return
+	}
+	events.mu.Lock()
+	databases := events.databases
+	events.mu.Unlock()
+	emit("done", map[string]any{"databases": databases, "elapsed": time.Since(events.start).Seconds()})
+}
+
+func emitDBStarted(entry *dbEntry) {
+	emit("db_started", map[string]any{"name": entry.name, "main_size": entry.mainSize, "wal_size": entry.walSize})
+}
+
+// emitDBDone reports a database written out or, with resumed set, found
+// already extracted by a previous run.
+func emitDBDone(db manifestEntry, resumed bool) {
+	fields := map[string]any{"name": db.Name, "main_size": db.MainSize, "wal_size": db.WALSize, "files": db.Files}
+	if resumed {
+		fields["resumed"] = true
+	}
+	emit("db_done", fields)
+}
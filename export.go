@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <snapshot>",
+	Short: "Export tables of a snapshot as CSV or JSON",
+	Long: `Exports the tables of the databases in a snapshot as CSV or JSON files,
+one file per table named <database>.<table>.<format>, without the need for the
+sqlite3 cli. The databases are read into memory: nothing but the exported files
+is written to disk.`,
+	Args: snapshotArg,
+	RunE: export,
+
+	SilenceUsage: true,
+}
+
+var (
+	exportDB     string
+	exportTables []string
+	exportWhere  string
+	exportLimit  int
+	exportFormat string
+	exportDir    string
+)
+
+func init() {
+	exportCmd.Flags().StringVar(&exportDB, "db", "", "only export tables of the named database")
+	exportCmd.Flags().StringSliceVar(&exportTables, "table", nil, "tables to export (default all)")
+	exportCmd.Flags().StringVar(&exportWhere, "where", "", "only export rows matching this SQL expression")
+	exportCmd.Flags().IntVar(&exportLimit, "limit", 0, "export at most this many rows per table (0 means no limit)")
+	exportCmd.Flags().StringVar(&exportFormat, "format", "csv", "output format, either csv or json")
+	exportCmd.Flags().StringVar(&exportDir, "out-dir", ".", "directory to write the exported files to")
+	rootCmd.AddCommand(exportCmd)
+}
+
+func export(cmd *cobra.Command, args []string) error {
+	var write func(w io.Writer, rows *sql.Rows) error
+	switch exportFormat {
+	case "csv":
+		write = writeCSV
+	case "json":
+		write = writeJSON
+	default:
+		return fmt.Errorf("unknown export format %q", exportFormat)
+	}
+
+	path, err := snapshotPath(args)
+	if err != nil {
+		return err
+	}
+	snapshot, err := openSnapshot(path)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	var total uint64
+	found := false
+	for {
+		entry, err := snapshot.next()
+		if err != nil {
+			return err
+		} else if entry == nil {
+			break
+		}
+		if exportDB != "" && entry.name != exportDB {
+			if err := snapshot.skip(entry); err != nil {
+				return fmt.Errorf("couldn't skip %s: %w", entry.name, err)
+			}
+			continue
+		}
+		found = true
+
+		if !validName(entry.name) {
+			return fmt.Errorf("database name %q can't be used as a file name", entry.name)
+		}
+		if err := checkSize(entry, &total); err != nil {
+			return err
+		}
+		if err := exportDatabase(snapshot, entry, write); err != nil {
+			return fmt.Errorf("couldn't export %s: %w", entry.name, err)
+		}
+	}
+
+	if exportDB != "" && !found {
+		return fmt.Errorf("database %q not found in snapshot", exportDB)
+	}
+	return snapshot.checkEOF()
+}
+
+// exportDatabase exports the tables of the database of entry, read into
+// memory out of snapshot.
+func exportDatabase(snapshot *snapshotReader, entry *dbEntry, write func(io.Writer, *sql.Rows) error) error {
+	if entry.mainSize == 0 && entry.walSize == 0 {
+		// An empty database, without any table.
+		return nil
+	}
+	p, err := readPager(snapshot, entry)
+	if err != nil {
+		return err
+	}
+	image, err := p.image()
+	if err != nil {
+		return err
+	}
+	db, err := openImage(image)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tables, err := exportedTables(db)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		// Table names come from the snapshot too: keep them from naming
+		// files outside of --out-dir.
+		if !validName(table) {
+			warn("table %q of %s can't be used in a file name, skipping it", table, entry.name)
+			continue
+		}
+		path := filepath.Join(exportDir, entry.name+"."+table+"."+exportFormat)
+		if filepath.Dir(path) != filepath.Clean(exportDir) {
+			return fmt.Errorf("table %s would be exported outside of %s", table, exportDir)
+		}
+
+		fmt.Printf("Exporting %s.%s to %s...\n", entry.name, table, path)
+		if err := exportTable(db, table, path, write); err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// exportedTables returns the tables of db selected with --table, or all the
+// user tables when none was given.
+func exportedTables(db *sql.DB) ([]string, error) {
+	objects, err := readSchema(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []string
+	for _, o := range objects {
+		if o.Type != "table" || strings.HasPrefix(o.Name, "sqlite_") {
+			continue
+		}
+		if len(exportTables) > 0 && !slices.Contains(exportTables, o.Name) {
+			continue
+		}
+		tables = append(tables, o.Name)
+	}
+
+	for _, table := range exportTables {
+		if !slices.Contains(tables, table) {
+			return nil, fmt.Errorf("table %q not found", table)
+		}
+	}
+	return tables, nil
+}
+
+func exportTable(db *sql.DB, table, path string, write func(io.Writer, *sql.Rows) error) error {
+	query := "SELECT * FROM " + quoteIdent(table)
+	if exportWhere != "" {
+		query += " WHERE " + exportWhere
+	}
+	if exportLimit > 0 {
+		query += " LIMIT " + strconv.Itoa(exportLimit)
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := write(w, rows); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// scanRows calls fn with the values of every row in rows.
+func scanRows(rows *sql.Rows, fn func(values []any) error) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// writeCSV writes rows as CSV with a header line. NULLs become empty fields
+// and blobs are hex encoded.
+func writeCSV(w io.Writer, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+
+	record := make([]string, len(columns))
+	err = scanRows(rows, func(values []any) error {
+		for i, value := range values {
+			record[i] = formatValue(value)
+		}
+		return cw.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSON writes rows as a JSON array of objects, keeping the keys in
+// column order. Blobs are base64 encoded.
+func writeJSON(w io.Writer, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	keys := make([][]byte, len(columns))
+	for i, column := range columns {
+		if keys[i], err = json.Marshal(column); err != nil {
+			return err
+		}
+	}
+
+	io.WriteString(w, "[")
+	first := true
+	err = scanRows(rows, func(values []any) error {
+		if !first {
+			io.WriteString(w, ",")
+		}
+		first = false
+
+		io.WriteString(w, "\n  {")
+		for i, value := range values {
+			if i > 0 {
+				io.WriteString(w, ", ")
+			}
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			w.Write(keys[i])
+			io.WriteString(w, ": ")
+			w.Write(encoded)
+		}
+		_, err := io.WriteString(w, "}")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n]\n")
+	return err
+}
+
+// formatValue renders a value scanned from SQLite as text.
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return hex.EncodeToString(v)
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch <address>",
+	Short: "Unpack databases dumped by a running dqlite node",
+	Long: `Connects to the dqlite node at address with the dqlite client protocol,
+finds the leader, has it dump the given databases and unpacks them like a
+snapshot, without any access to the filesystem of the node. With --output the
+snapshot assembled from the dump is kept.
+
+With --flavor, run on a node, the address, the TLS certificate and the
+database default to those of the local node.`,
+	Example: `  dqlite-snapshot-unpack fetch 10.0.0.1:9000 --db k8s --tls-cert cluster.crt --tls-key cluster.key`,
+	Args:    cobra.RangeArgs(0, 1),
+	RunE:    fetch,
+
+	SilenceUsage: true,
+}
+
+var (
+	fetchDBs     []string
+	fetchOutput  string
+	fetchCert    string
+	fetchKey     string
+	fetchCA      string
+	fetchTimeout time.Duration
+)
+
+func init() {
+	fetchCmd.Flags().StringArrayVar(&fetchDBs, "db", nil, "database to dump (repeatable)")
+	fetchCmd.Flags().StringVar(&fetchOutput, "output", "", "keep the snapshot in this file")
+	fetchCmd.Flags().StringVar(&fetchCert, "tls-cert", "", "certificate to connect with TLS, e.g. the cluster.crt of the node")
+	fetchCmd.Flags().StringVar(&fetchKey, "tls-key", "", "key of the certificate given with --tls-cert")
+	fetchCmd.Flags().StringVar(&fetchCA, "tls-ca", "", "CA to verify the node with (default the certificate itself, as in dqlite clusters)")
+	fetchCmd.Flags().DurationVar(&fetchTimeout, "timeout", time.Minute, "give up on a node not answering within this time")
+	fetchCmd.MarkFlagsRequiredTogether("tls-cert", "tls-key")
+	rootCmd.AddCommand(fetchCmd)
+}
+
+func fetch(cmd *cobra.Command, args []string) error {
+	if err := flavorFetchDefaults(); err != nil {
+		return err
+	}
+	var address string
+	switch {
+	case len(args) > 0:
+		address = args[0]
+	case flavor != "":
+		var err error
+		if address, err = flavorAddress(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("the address of a node is required")
+	}
+	if len(fetchDBs) == 0 {
+		return fmt.Errorf("--db is required")
+	}
+
+	tlsConfig, err := fetchTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	client, err := connectLeader(address, tlsConfig)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var entries []*dbEntry
+	var files [][2][]byte
+	for _, name := range fetchDBs {
+		fmt.Printf("Dumping database %s...\n", name)
+		main, wal, err := client.dump(name)
+		if err != nil {
+			return fmt.Errorf("couldn't dump database %s: %w", name, err)
+		}
+		entries = append(entries, &dbEntry{name: name, mainSize: uint64(len(main)), walSize: uint64(len(wal))})
+		files = append(files, [2][]byte{main, wal})
+	}
+
+	path := fetchOutput
+	if path == "" {
+		var cleanup func()
+		if path, cleanup, err = makeTempFile(); err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+	err = createSnapshot(path, entries, false, func(w io.Writer, i int) error {
+		if _, err := w.Write(files[i][0]); err != nil {
+			return err
+		}
+		_, err := w.Write(files[i][1])
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't write %s: %w", path, err)
+	}
+	fmt.Println()
+
+	return unpack(cmd, []string{path})
+}
+
+// flavorFetchDefaults fills in, with --flavor, the database and the TLS
+// certificate of the local node.
+func flavorFetchDefaults() error {
+	if flavor == "" {
+		return nil
+	}
+	db, ok := flavorDBs[flavor]
+	if !ok {
+		return fmt.Errorf("%s doesn't expose dqlite to clients", flavor)
+	}
+	if len(fetchDBs) == 0 {
+		fetchDBs = []string{db}
+	}
+	if fetchCert == "" {
+		dir := flavorDirs[flavor]
+		fetchCert, fetchKey = filepath.Join(dir, "cluster.crt"), filepath.Join(dir, "cluster.key")
+	}
+	return nil
+}
+
+func fetchTLSConfig() (*tls.Config, error) {
+	if fetchCert == "" {
+		if fetchCA != "" {
+			return nil, fmt.Errorf("--tls-ca requires --tls-cert and --tls-key")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(fetchCert, fetchKey)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load the TLS certificate: %w", err)
+	}
+	caFile := fetchCA
+	if caFile == "" {
+		caFile = fetchCert
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+
+	// dqlite clusters share a self-signed certificate, whose name has
+	// nothing to do with the addresses of the nodes.
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool, MinVersion: tls.VersionTLS12}
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil && len(leaf.DNSNames) > 0 {
+		config.ServerName = leaf.DNSNames[0]
+	}
+	return config, nil
+}
+
+// The parts of the dqlite client protocol (version 1) used to dump databases.
+const (
+	dqliteProtocolVersion = 0x86104dd760433fe5
+
+	dqliteRequestLeader = 0
+	dqliteRequestDump   = 15
+
+	dqliteResponseFailure = 0
+	dqliteResponseNode    = 1
+	dqliteResponseFiles   = 9
+)
+
+// dqliteClient is a connection to a dqlite node. Messages are made of an
+// 8 bytes header (size of the body in 8 bytes words, type, schema and two
+// unused bytes) followed by the body, padded to 8 bytes.
+type dqliteClient struct {
+	conn net.Conn
+}
+
+func dialDqlite(address string, tlsConfig *tls.Config) (*dqliteClient, error) {
+	dialer := &net.Dialer{Timeout: fetchTimeout}
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to %s: %w", address, err)
+	}
+
+	c := &dqliteClient{conn: conn}
+	if err := c.send(binary.LittleEndian.AppendUint64(nil, dqliteProtocolVersion)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("couldn't connect to %s: %w", address, err)
+	}
+	return c, nil
+}
+
+// connectLeader connects to the node at address, then to the leader of its
+// cluster if it is another one.
+func connectLeader(address string, tlsConfig *tls.Config) (*dqliteClient, error) {
+	c, err := dialDqlite(address, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.request(dqliteRequestLeader, dqliteResponseNode, make([]byte, 8))
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("couldn't find the leader through %s: %w", address, err)
+	}
+	if len(body) < 8 {
+		c.Close()
+		return nil, fmt.Errorf("couldn't find the leader through %s: short response", address)
+	}
+	leader, err := readPaddedString(bytes.NewReader(body[8:]))
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("couldn't find the leader through %s: %w", address, err)
+	}
+
+	switch leader {
+	case "":
+		c.Close()
+		return nil, fmt.Errorf("the cluster of %s has no leader", address)
+	case address:
+		return c, nil
+	}
+	c.Close()
+	fmt.Printf("Connecting to the leader, %s...\n", leader)
+	return dialDqlite(leader, tlsConfig)
+}
+
+// dump returns the main file and the WAL of database name.
+func (c *dqliteClient) dump(name string) ([]byte, []byte, error) {
+	var request bytes.Buffer
+	writePaddedString(&request, name)
+	body, err := c.request(dqliteRequestDump, dqliteResponseFiles, request.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := bytes.NewReader(body)
+	count, err := readUint64(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var main, wal []byte
+	for range count {
+		file, err := readPaddedString(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		size, err := readUint64(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		if size > uint64(r.Len()) {
+			return nil, nil, fmt.Errorf("file %s of %d bytes is cut short", file, size)
+		}
+		data := make([]byte, size)
+		r.Read(data)
+		switch file {
+		case name:
+			main = data
+		case name + "-wal":
+			wal = data
+		default:
+			return nil, nil, fmt.Errorf("unexpected file %s in the dump", file)
+		}
+	}
+	return main, wal, nil
+}
+
+// request sends a request of type kind and returns the body of the response,
+// which must be of type expected.
+func (c *dqliteClient) request(kind, expected byte, body []byte) ([]byte, error) {
+	c.conn.SetDeadline(time.Now().Add(fetchTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	body = append(body, make([]byte, -len(body)&7)...)
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header, uint32(len(body)/8))
+	header[4] = kind
+	if err := c.send(append(header, body...)); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, err
+	}
+	response := make([]byte, int64(binary.LittleEndian.Uint32(header))*8)
+	if _, err := io.ReadFull(c.conn, response); err != nil {
+		return nil, err
+	}
+
+	switch header[4] {
+	case expected:
+		return response, nil
+	case dqliteResponseFailure:
+		r := bytes.NewReader(response)
+		code, _ := readUint64(r)
+		message, _ := readPaddedString(r)
+		return nil, fmt.Errorf("%s (code %d)", message, code)
+	default:
+		return nil, fmt.Errorf("unexpected response of type %d", header[4])
+	}
+}
+
+func (c *dqliteClient) send(data []byte) error {
+	_, err := c.conn.Write(data)
+	return err
+}
+
+func (c *dqliteClient) Close() error {
+	return c.conn.Close()
+}
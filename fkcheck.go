@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+)
+
+// fkViolations is the number of rows of a table referencing missing rows of
+// its parent table.
+type fkViolations struct {
+	table, parent string
+	rows          int64
+}
+
+// checkForeignKeys runs PRAGMA foreign_key_check on each of the databases
+// extracted into dir, reporting the violations per table. dqlite applications
+// often run without enforcing foreign keys, so violations are warned about
+// rather than failing the extraction.
+func checkForeignKeys(dir string, names []string) error {
+	for _, name := range names {
+		fmt.Printf("Checking the foreign keys of database %s...\n", name)
+
+		violations, err := foreignKeyCheck(filepath.Join(dir, name))
+		switch {
+		case err != nil:
+			fmt.Printf("FAILED: %v\n\n", err)
+			summarizeCheck(name, fmt.Sprintf("FAILED: %v", err), func(db *summaryDatabase) *string { return &db.FKCheck })
+			return fmt.Errorf("couldn't check the foreign keys of %s: %w", name, err)
+		case len(violations) > 0:
+			var rows int64
+			for _, v := range violations {
+				fmt.Printf("  %s: %d rows referencing missing rows of %s\n", v.table, v.rows, v.parent)
+				rows += v.rows
+			}
+			fmt.Printf("%d violations found\n\n", rows)
+			summarizeCheck(name, fmt.Sprintf("%d violations", rows), func(db *summaryDatabase) *string { return &db.FKCheck })
+			warn("database %s has %d rows violating foreign keys", name, rows)
+		default:
+			fmt.Printf("OK\n\n")
+			summarizeCheck(name, "OK", func(db *summaryDatabase) *string { return &db.FKCheck })
+		}
+	}
+	return nil
+}
+
+// foreignKeyCheck returns the foreign key violations of the database at path,
+// by table and parent table.
+func foreignKeyCheck(path string) ([]fkViolations, error) {
+	var violations []fkViolations
+	err := withDatabase(path, func(db *sql.DB) error {
+		rows, err := db.Query(`SELECT "table", parent, count(*) FROM pragma_foreign_key_check GROUP BY 1, 2 ORDER BY 1, 2`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var v fkViolations
+			if err := rows.Scan(&v.table, &v.parent, &v.rows); err != nil {
+				return err
+			}
+			violations = append(violations, v)
+		}
+		return rows.Err()
+	})
+	return violations, err
+}
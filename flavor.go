@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// flavor, set with --flavor, names a product embedding dqlite, whose data
+// directory then stands in for the snapshot argument.
+var flavor string
+
+// flavorDirs are the data directories of the products embedding dqlite.
+var flavorDirs = map[string]string{
+	"microk8s":   "/var/snap/microk8s/current/var/kubernetes/backend",
+	"k8s-dqlite": "/var/snap/k8s/common/var/lib/k8s-dqlite",
+	"lxd":        "/var/snap/lxd/common/lxd/database/global",
+	"incus":      "/var/lib/incus/database/global",
+}
+
+// flavorDBs are the databases the products keep their state in, for those
+// that can be fetched from (LXD and Incus only expose dqlite through their
+// own API).
+var flavorDBs = map[string]string{
+	"microk8s":   "k8s",
+	"k8s-dqlite": "k8s",
+}
+
+// flavorLocalDBs are plain SQLite databases, outside of dqlite, where the
+// products keep the state of the node alone.
+var flavorLocalDBs = map[string]string{
+	"lxd":   "/var/snap/lxd/common/lxd/database/local.db",
+	"incus": "/var/lib/incus/database/local.db",
+}
+
+func checkFlavor() error {
+	if _, ok := flavorDirs[flavor]; flavor != "" && !ok {
+		return fmt.Errorf("unknown flavor %q, expected microk8s, k8s-dqlite, lxd or incus", flavor)
+	}
+	return nil
+}
+
+// extractLocal writes, with a --flavor having one, a copy of the local
+// database to dir, returning its name, and reports it to out. It goes
+// through VACUUM INTO, which is safe while the product is running, or is
+// sampled and anonymized as extractShareable does.
+func extractLocal(dir string, out io.Writer) (string, error) {
+	path, ok := flavorLocalDBs[flavor]
+	if !ok {
+		return "", nil
+	}
+	name := filepath.Base(path)
+	fmt.Fprintf(out, "Copying the local database of %s to %s...\n", flavor, name)
+	if sampleSize > 0 || anonymize || redaction != nil {
+		if err := shareInto(path, filepath.Join(dir, name)); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	if err := vacuumInto(path, filepath.Join(dir, name)); err != nil {
+		return "", fmt.Errorf("couldn't copy %s: %w", path, err)
+	}
+	return name, nil
+}
+
+// snapshotArg accepts a single snapshot argument, which may be left out with
+// --flavor.
+func snapshotArg(cmd *cobra.Command, args []string) error {
+	if flavor != "" && len(args) == 0 {
+		return nil
+	}
+	return cobra.ExactArgs(1)(cmd, args)
+}
+
+// snapshotPath returns the snapshot given in args, defaulting to the data
+// directory of --flavor. A directory stands for the newest snapshot in it.
+func snapshotPath(args []string) (string, error) {
+	path, err := argPath(args)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return path, nil
+	}
+	newest, err := newestSnapshot(path)
+	if err != nil {
+		return "", err
+	}
+	logger.Info("Using " + newest)
+	return newest, nil
+}
+
+// argPath returns the snapshot or data directory given in args, defaulting
+// to the data directory of --flavor.
+func argPath(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	path := flavorDirs[flavor]
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("couldn't find the data directory of %s: %w", flavor, err)
+	}
+	return path, nil
+}
+
+// newestSnapshot returns the snapshot of the latest raft index in dir, where
+// dqlite names them snapshot-<term>-<index>-<timestamp>, next to their .meta
+// files.
+func newestSnapshot(dir string) (string, error) {
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(snapshots) == 0 {
+		return "", fmt.Errorf("no snapshot found in %s", dir)
+	}
+	return snapshots[0].Path, nil
+}
+
+// parseSnapshotName parses the name dqlite gives a snapshot file,
+// snapshot-<term>-<index>-<timestamp>.
+func parseSnapshotName(name string) (term, index, timestamp uint64, ok bool) {
+	parts := strings.Split(name, "-")
+	if len(parts) != 4 || parts[0] != "snapshot" {
+		return 0, 0, 0, false
+	}
+	var numbers [3]uint64
+	for i, part := range parts[1:] {
+		var err error
+		if numbers[i], err = strconv.ParseUint(part, 10, 64); err != nil {
+			return 0, 0, 0, false
+		}
+	}
+	return numbers[0], numbers[1], numbers[2], true
+}
+
+// flavorAddress returns the address of the node of --flavor, from the
+// info.yaml in its data directory.
+func flavorAddress() (string, error) {
+	path := filepath.Join(flavorDirs[flavor], "info.yaml")
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), ":"); ok && strings.TrimSpace(key) == "Address" {
+			return strings.Trim(strings.TrimSpace(value), `"'`), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no address found in %s", path)
+}
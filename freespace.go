@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var freeSpaceCmd = &cobra.Command{
+	Use:   "free-space <snapshot>",
+	Short: "Report the free space and fragmentation of the databases in a snapshot",
+	Long: `Walks the pages of each database in the snapshot (with the committed WAL
+frames applied) and reports the space a VACUUM would give back: the pages on
+the freelist and the bytes left unused within the pages of each table and
+index, along with how much of their payload they hold and how fragmented
+they are, that is the share of their leaf pages not following on from the
+previous one.`,
+	Args: snapshotArg,
+	RunE: freeSpace,
+
+	SilenceUsage: true,
+}
+
+var (
+	freeSpaceDB   string
+	freeSpaceJSON bool
+)
+
+func init() {
+	freeSpaceCmd.Flags().StringVar(&freeSpaceDB, "db", "", "only report on the named database")
+	freeSpaceCmd.Flags().BoolVar(&freeSpaceJSON, "json", false, "print the report as JSON")
+	rootCmd.AddCommand(freeSpaceCmd)
+}
+
+// freeSpaceReport is the free space of a database.
+type freeSpaceReport struct {
+	Database      string        `json:"database"`
+	PageSize      int           `json:"page_size"`
+	Pages         uint32        `json:"pages"`
+	FreelistPages int           `json:"freelist_pages"`
+	UnusedBytes   int64         `json:"unused_bytes"`
+	WastedBytes   int64         `json:"wasted_bytes"`
+	Objects       []objectSpace `json:"objects"`
+}
+
+// objectSpace is the space taken by a table or index.
+type objectSpace struct {
+	Name          string  `json:"name"`
+	Type          string  `json:"type"`
+	Pages         int     `json:"pages"`
+	Payload       int64   `json:"payload_bytes"`
+	Unused        int64   `json:"unused_bytes"`
+	Fragmentation float64 `json:"fragmentation"`
+}
+
+func freeSpace(cmd *cobra.Command, args []string) error {
+	path, err := snapshotPath(args)
+	if err != nil {
+		return err
+	}
+	dir, names, cleanup, err := extractTemp(path, onlyDatabase(freeSpaceDB))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if freeSpaceDB != "" && len(names) == 0 {
+		return fmt.Errorf("database %q not found in snapshot", freeSpaceDB)
+	}
+
+	reports := []*freeSpaceReport{}
+	for _, name := range names {
+		layout, err := analyzePages(filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Errorf("couldn't inspect %s: %w", name, err)
+		}
+		reports = append(reports, newFreeSpaceReport(name, layout))
+	}
+
+	if freeSpaceJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reports)
+	}
+	for _, r := range reports {
+		printFreeSpace(r)
+	}
+	return nil
+}
+
+func newFreeSpaceReport(name string, layout *pageLayout) *freeSpaceReport {
+	r := &freeSpaceReport{
+		Database:      name,
+		PageSize:      layout.PageSize,
+		Pages:         layout.PageCount,
+		FreelistPages: layout.Kinds[pageKindFreelistTrunk] + layout.Kinds[pageKindFreelistLeaf],
+		Objects:       []objectSpace{},
+	}
+	for _, o := range layout.Objects {
+		space := objectSpace{Name: o.Name, Type: o.Type, Pages: o.Pages, Payload: o.Payload, Unused: o.Unused}
+		if o.Leaves > 1 {
+			space.Fragmentation = float64(o.Scattered) / float64(o.Leaves-1)
+		}
+		r.UnusedBytes += o.Unused
+		r.Objects = append(r.Objects, space)
+	}
+	r.WastedBytes = int64(r.FreelistPages)*int64(r.PageSize) + r.UnusedBytes
+	return r
+}
+
+func printFreeSpace(r *freeSpaceReport) {
+	size := int64(r.Pages) * int64(r.PageSize)
+	fmt.Printf("Database %s: %d pages of %d bytes (%s)\n", r.Database, r.Pages, r.PageSize, formatBytes(size))
+	fmt.Printf("  Freelist: %d pages (%s)\n", r.FreelistPages, formatBytes(int64(r.FreelistPages)*int64(r.PageSize)))
+	fmt.Printf("  Unused within pages: %s\n", formatBytes(r.UnusedBytes))
+	if size > 0 {
+		fmt.Printf("  Wasted: %s, %.1f%% of the file\n", formatBytes(r.WastedBytes), 100*float64(r.WastedBytes)/float64(size))
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "  OBJECT\tTYPE\tPAGES\tPAYLOAD\tUNUSED\tFRAGMENTATION")
+	for _, o := range r.Objects {
+		fmt.Fprintf(tw, "  %s\t%s\t%d\t%s\t%s\t%.1f%%\n",
+			o.Name, o.Type, o.Pages, formatBytes(o.Payload), formatBytes(o.Unused), 100*o.Fragmentation)
+	}
+	tw.Flush()
+	fmt.Println()
+}
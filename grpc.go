@@ -0,0 +1,295 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative snapshot.proto
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// queryBatchSize is about how many bytes of rows Query sends per message,
+// well below the 4 MB gRPC limits messages to by default.
+const queryBatchSize = 1 << 20
+
+// grpcServer implements SnapshotService over the snapshots of the REST API.
+type grpcServer struct {
+	UnimplementedSnapshotServiceServer
+	snapshots *snapshotServer
+}
+
+func newGRPCServer(snapshots *snapshotServer) *grpc.Server {
+	server := grpc.NewServer()
+	RegisterSnapshotServiceServer(server, &grpcServer{snapshots: snapshots})
+	return server
+}
+
+func (g *grpcServer) Inspect(stream SnapshotService_InspectServer) error {
+	source, upload, err := receiveSource(stream.Recv)
+	if err != nil {
+		return err
+	}
+	p := startProcessing("inspect")
+	var snapshot *snapshotReader
+	if source != "" {
+		snapshot, err = openSnapshot(source)
+	} else {
+		snapshot, err = newSnapshotReader(upload)
+	}
+	if err != nil {
+		p.done(0, 0, err)
+		return grpcError(http.StatusUnprocessableEntity, err)
+	}
+	defer snapshot.Close()
+
+	response, err := inspectMessage(snapshot)
+	p.done(snapshot.compressedRead(), snapshot.decoded, err)
+	if err != nil {
+		return grpcError(http.StatusUnprocessableEntity, err)
+	}
+	return stream.SendAndClose(response)
+}
+
+// inspectMessage reads through snapshot, reporting on its databases.
+func inspectMessage(snapshot *snapshotReader) (*InspectResponse, error) {
+	response := &InspectResponse{Compression: snapshot.compression()}
+	for {
+		entry, err := snapshot.next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		report, err := inspectDatabase(snapshot, entry)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't inspect %s: %w", entry.name, err)
+		}
+		response.Databases = append(response.Databases, reportMessage(report))
+	}
+	return response, snapshot.checkEOF()
+}
+
+func (g *grpcServer) Unpack(stream SnapshotService_UnpackServer) error {
+	source, upload, err := receiveSource(stream.Recv)
+	if err != nil {
+		return err
+	}
+	id, code, err := g.snapshots.unpack(source, upload, -1)
+	if err != nil {
+		return grpcError(code, err)
+	}
+	m, code, err := g.snapshots.lookup(id)
+	if err != nil {
+		return grpcError(code, err)
+	}
+	return stream.SendAndClose(snapshotMessage(id, m))
+}
+
+func (g *grpcServer) Query(request *QueryRequest, stream SnapshotService_QueryServer) error {
+	m, code, err := g.snapshots.lookup(request.Id)
+	if err != nil {
+		return grpcError(code, err)
+	}
+	file, err := databaseFile(m, request.Db)
+	if err != nil {
+		return grpcError(http.StatusBadRequest, err)
+	}
+
+	return withDatabase(filepath.Join(g.snapshots.dir, request.Id, file), func(db *sql.DB) error {
+		rows, err := db.QueryContext(stream.Context(), request.Sql)
+		if err != nil {
+			return grpcError(http.StatusBadRequest, err)
+		}
+		defer rows.Close()
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+
+		// Rows go in batches, the first one with the columns, and at least
+		// that one even without rows.
+		response := &QueryResponse{Columns: columns}
+		sent, size := false, 0
+		err = scanRows(rows, func(values []any) error {
+			row := &Row{Values: make([]*Value, len(values))}
+			for i, value := range values {
+				row.Values[i] = valueMessage(value)
+			}
+			response.Rows = append(response.Rows, row)
+			if size += proto.Size(row); size < queryBatchSize {
+				return nil
+			}
+			err := stream.Send(response)
+			response, sent, size = &QueryResponse{}, true, 0
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(response.Rows) > 0 || !sent {
+			return stream.Send(response)
+		}
+		return nil
+	})
+}
+
+// receiveSource reads the first message of a stream of SnapshotSource,
+// returning the URL it gives or else a reader of the uploaded content.
+func receiveSource(recv func() (*SnapshotSource, error)) (string, io.Reader, error) {
+	first, err := recv()
+	if err == io.EOF {
+		return "", nil, grpcError(http.StatusBadRequest, fmt.Errorf("no snapshot given"))
+	} else if err != nil {
+		return "", nil, err
+	}
+	if url, ok := first.Source.(*SnapshotSource_Url); ok {
+		if !isRemoteURL(url.Url) {
+			return "", nil, grpcError(http.StatusBadRequest, fmt.Errorf("url must be an http(s), s3, gs or azblob URL"))
+		}
+		return url.Url, nil, nil
+	}
+	return "", &chunkReader{recv: recv, chunk: first.GetChunk()}, nil
+}
+
+// chunkReader reads the content of a snapshot uploaded in SnapshotSource
+// messages.
+type chunkReader struct {
+	recv  func() (*SnapshotSource, error)
+	chunk []byte
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		message, err := c.recv()
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := message.Source.(*SnapshotSource_Url); ok {
+			return 0, fmt.Errorf("a url can't follow uploaded content")
+		}
+		c.chunk = message.GetChunk()
+	}
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+// databaseFile returns the main file of database name in m, defaulting to
+// the only database of the snapshot.
+func databaseFile(m *manifest, name string) (string, error) {
+	var names []string
+	for _, db := range m.Databases {
+		if db.Name == name || name == "" && len(m.Databases) == 1 {
+			return db.Files[0].Path, nil
+		}
+		names = append(names, db.Name)
+	}
+	switch {
+	case name != "":
+		return "", fmt.Errorf("database %q not found in snapshot", name)
+	case len(names) == 0:
+		return "", fmt.Errorf("snapshot contains no databases")
+	default:
+		return "", fmt.Errorf("snapshot contains %d databases, select one with db: %s",
+			len(names), strings.Join(names, ", "))
+	}
+}
+
+// grpcError turns err, with the HTTP status the REST API would answer it
+// with, into a gRPC error, counting it in the metrics.
+func grpcError(httpStatus int, err error) error {
+	countError(httpStatus)
+	code := codes.InvalidArgument
+	switch {
+	case httpStatus == http.StatusNotFound:
+		code = codes.NotFound
+	case httpStatus >= 500:
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+func reportMessage(r *dbReport) *DatabaseReport {
+	message := &DatabaseReport{
+		Name:     r.entry.name,
+		MainSize: r.entry.mainSize,
+		WalSize:  r.entry.walSize,
+		Warnings: r.warnings,
+	}
+	if h := r.header; h != nil {
+		message.Header = &DatabaseHeader{
+			PageSize:      h.PageSize,
+			PageCount:     h.PageCount,
+			TextEncoding:  h.TextEncoding,
+			SchemaCookie:  h.SchemaCookie,
+			ChangeCounter: h.ChangeCounter,
+			ApplicationId: h.ApplicationID,
+			UserVersion:   h.UserVersion,
+		}
+	}
+	if w := r.wal; w != nil {
+		message.Wal = &WALHeader{
+			PageSize:      w.PageSize,
+			Frames:        (r.entry.walSize - walHeaderSize) / uint64(walFrameHeaderSize+w.PageSize),
+			CheckpointSeq: w.CheckpointSeq,
+			Salt1:         w.Salt1,
+			Salt2:         w.Salt2,
+		}
+	}
+	return message
+}
+
+func snapshotMessage(id string, m *manifest) *Snapshot {
+	message := &Snapshot{
+		Id:           id,
+		Snapshot:     m.Snapshot,
+		SnapshotSize: m.SnapshotSize,
+		Complete:     m.Complete,
+		Compression:  m.Compression,
+	}
+	for _, db := range m.Databases {
+		database := &Database{Name: db.Name, MainSize: db.MainSize, WalSize: db.WALSize}
+		for _, f := range db.Files {
+			database.Files = append(database.Files, &File{Path: f.Path, Offset: f.Offset, Size: f.Size, Sha256: f.SHA256})
+		}
+		message.Databases = append(message.Databases, database)
+	}
+	return message
+}
+
+// valueMessage converts a value scanned from SQLite. Values the driver
+// turns into other types, for columns declared as such, go back to what
+// SQLite stores them as.
+func valueMessage(value any) *Value {
+	switch v := value.(type) {
+	case nil:
+		return &Value{}
+	case int64:
+		return &Value{Value: &Value_Integer{v}}
+	case float64:
+		return &Value{Value: &Value_Real{v}}
+	case string:
+		return &Value{Value: &Value_Text{v}}
+	case []byte:
+		return &Value{Value: &Value_Blob{v}}
+	case bool:
+		if v {
+			return &Value{Value: &Value_Integer{1}}
+		}
+		return &Value{Value: &Value_Integer{0}}
+	case time.Time:
+		return &Value{Value: &Value_Text{v.Format(time.RFC3339Nano)}}
+	default:
+		return &Value{Value: &Value_Text{fmt.Sprint(v)}}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history <dir>",
+	Short: "Report the sizes of the snapshots of a directory over time",
+	Long: `Reads through every snapshot in a directory, such as an archive of daily
+backups, and reports the size of each along with its decoded size and
+compression ratio, then how each database grew from the oldest snapshot to the
+newest. Snapshots are ordered by the timestamp in their dqlite name,
+snapshot-<term>-<index>-<timestamp>, or by modification time for the others.
+With --format csv or json, the time series itself is printed instead, a row
+per snapshot and database, for capacity planning.`,
+	Args: cobra.ExactArgs(1),
+	RunE: history,
+
+	SilenceUsage: true,
+}
+
+var historyFormat string
+
+func init() {
+	historyCmd.Flags().StringVar(&historyFormat, "format", "table", "output format, one of table, csv or json")
+	rootCmd.AddCommand(historyCmd)
+}
+
+// historySnapshot is a snapshot of the directory history reports on.
+type historySnapshot struct {
+	Snapshot    string            `json:"snapshot"`
+	Time        time.Time         `json:"time"`
+	Term        uint64            `json:"term,omitempty"`
+	Index       uint64            `json:"index,omitempty"`
+	FileSize    int64             `json:"file_size"`
+	DecodedSize int64             `json:"decoded_size"`
+	Ratio       float64           `json:"compression_ratio"`
+	Databases   []historyDatabase `json:"databases"`
+}
+
+// historyDatabase is the size of a database in a snapshot.
+type historyDatabase struct {
+	Name     string `json:"name"`
+	MainSize uint64 `json:"main_size"`
+	WALSize  uint64 `json:"wal_size"`
+}
+
+func history(cmd *cobra.Command, args []string) error {
+	switch historyFormat {
+	case "table", "csv", "json":
+	default:
+		return fmt.Errorf("unknown output format %q", historyFormat)
+	}
+
+	entries, err := os.ReadDir(args[0])
+	if err != nil {
+		return err
+	}
+	snapshots := []historySnapshot{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasSuffix(entry.Name(), ".meta") {
+			continue
+		}
+		s, err := readHistory(filepath.Join(args[0], entry.Name()))
+		if err != nil {
+			warn("skipping %s: %v", entry.Name(), err)
+			continue
+		}
+		snapshots = append(snapshots, s)
+	}
+	slices.SortStableFunc(snapshots, func(a, b historySnapshot) int { return a.Time.Compare(b.Time) })
+
+	switch historyFormat {
+	case "csv":
+		return writeHistoryCSV(snapshots)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(snapshots)
+	}
+	printHistory(snapshots)
+	return nil
+}
+
+// readHistory reads through the snapshot at path, adding up the sizes of its
+// databases.
+func readHistory(path string) (historySnapshot, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return historySnapshot{}, err
+	}
+	s := historySnapshot{Snapshot: filepath.Base(path), Time: info.ModTime().UTC(), FileSize: info.Size(), Databases: []historyDatabase{}}
+	if term, index, timestamp, ok := parseSnapshotName(s.Snapshot); ok {
+		s.Term, s.Index, s.Time = term, index, time.UnixMilli(int64(timestamp)).UTC()
+	}
+
+	snapshot, err := openSnapshot(path)
+	if err != nil {
+		return s, err
+	}
+	defer snapshot.Close()
+	for {
+		entry, err := snapshot.next()
+		if err != nil {
+			return s, err
+		}
+		if entry == nil {
+			break
+		}
+		if err := snapshot.skip(entry); err != nil {
+			return s, fmt.Errorf("couldn't read database %s: %w", entry.name, err)
+		}
+		s.Databases = append(s.Databases, historyDatabase{Name: entry.name, MainSize: entry.mainSize, WALSize: entry.walSize})
+	}
+	if err := snapshot.checkEOF(); err != nil {
+		return s, err
+	}
+	s.DecodedSize = snapshot.decoded
+	if s.FileSize > 0 {
+		s.Ratio = float64(s.DecodedSize) / float64(s.FileSize)
+	}
+	return s, nil
+}
+
+// writeHistoryCSV writes a row per snapshot and database.
+func writeHistoryCSV(snapshots []historySnapshot) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"snapshot", "time", "term", "index", "file_size", "decoded_size", "compression_ratio", "database", "main_size", "wal_size"})
+	for _, s := range snapshots {
+		for _, db := range s.Databases {
+			w.Write([]string{
+				s.Snapshot, s.Time.Format(time.RFC3339),
+				strconv.FormatUint(s.Term, 10), strconv.FormatUint(s.Index, 10),
+				strconv.FormatInt(s.FileSize, 10), strconv.FormatInt(s.DecodedSize, 10),
+				strconv.FormatFloat(s.Ratio, 'f', 2, 64),
+				db.Name, strconv.FormatUint(db.MainSize, 10), strconv.FormatUint(db.WALSize, 10),
+			})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func printHistory(snapshots []historySnapshot) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SNAPSHOT\tTIME\tFILE\tDECODED\tRATIO\tDATABASES")
+	for _, s := range snapshots {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.1fx\t%d\n", s.Snapshot, s.Time.Format(time.RFC3339),
+			formatBytes(s.FileSize), formatBytes(s.DecodedSize), s.Ratio, len(s.Databases))
+	}
+	tw.Flush()
+	if len(snapshots) < 2 {
+		return
+	}
+
+	// Growth of each database between the first and the last snapshot
+	// holding it.
+	type growth struct {
+		first, last time.Time
+		from, to    int64
+	}
+	var names []string
+	growths := make(map[string]*growth)
+	for _, s := range snapshots {
+		for _, db := range s.Databases {
+			size := int64(db.MainSize + db.WALSize)
+			g, ok := growths[db.Name]
+			if !ok {
+				names = append(names, db.Name)
+				growths[db.Name] = &growth{first: s.Time, last: s.Time, from: size, to: size}
+				continue
+			}
+			g.last, g.to = s.Time, size
+		}
+	}
+	fmt.Println()
+	tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATABASE\tFIRST\tLAST\tGROWTH\tPER DAY")
+	for _, name := range names {
+		g := growths[name]
+		perDay := "-"
+		if days := g.last.Sub(g.first).Hours() / 24; days > 0 {
+			perDay = formatGrowth(int64(float64(g.to-g.from) / days))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", name, formatBytes(g.from), formatBytes(g.to), formatGrowth(g.to-g.from), perDay)
+	}
+	tw.Flush()
+}
+
+// formatGrowth formats a size difference with its sign.
+func formatGrowth(n int64) string {
+	if n < 0 {
+		return "-" + formatBytes(-n)
+	}
+	return "+" + formatBytes(n)
+}
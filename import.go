@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <outfile>",
+	Short: "Build a snapshot from plain SQLite databases",
+	Long: `Writes a snapshot holding ordinary SQLite databases produced elsewhere, to
+bootstrap a dqlite node with them. Each database is first copied through
+VACUUM INTO and switched to WAL mode, as dqlite expects, with its WAL
+checkpointed so that the snapshot carries an empty one. The original files are
+left untouched.`,
+	Example: `  dqlite-snapshot-unpack import snapshot --lz4 --db app=./app.db`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    importDatabases,
+
+	SilenceUsage: true,
+}
+
+var (
+	importDBs []string
+	importLZ4 bool
+)
+
+// dqlitePageSize is the page size dqlite uses unless configured otherwise.
+const dqlitePageSize = 4096
+
+func init() {
+	importCmd.Flags().StringArrayVar(&importDBs, "db", nil, "database to import, as name=file.db (repeatable)")
+	importCmd.Flags().BoolVar(&importLZ4, "lz4", false, "compress the snapshot with LZ4, as dqlite does")
+	importCmd.MarkFlagRequired("db")
+	importCmd.Flags().BoolVar(&verifyRoundtrip, "verify-roundtrip", false, "read the snapshot back and compare the hashes of its databases")
+	rootCmd.AddCommand(importCmd)
+}
+
+func importDatabases(cmd *cobra.Command, args []string) error {
+	dir, cleanup, err := makeTempDir()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	var sources []*packSource
+	for _, spec := range importDBs {
+		name, path, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || path == "" || strings.ContainsRune(name, 0) {
+			return fmt.Errorf("invalid --db %q, expected name=file.db", spec)
+		}
+		for _, source := range sources {
+			if source.entry.name == name {
+				return fmt.Errorf("database %q given twice", name)
+			}
+		}
+
+		fmt.Printf("Importing database %s from %s...\n", name, path)
+		// Database names may contain anything but nulls, file names can't.
+		dst := filepath.Join(dir, fmt.Sprintf("%d.db", len(sources)))
+		source, err := importDatabase(dst, name, path)
+		if err != nil {
+			return fmt.Errorf("couldn't import %s: %w", path, err)
+		}
+		sources = append(sources, source)
+	}
+
+	if err := writeSnapshot(args[0], sources, importLZ4); err != nil {
+		return fmt.Errorf("couldn't write %s: %w", args[0], err)
+	}
+	fmt.Printf("Imported %d databases into %s\n", len(sources), args[0])
+	return nil
+}
+
+// importDatabase prepares at dst a WAL mode copy of the database at path, to
+// be packed as name.
+func importDatabase(dst, name, path string) (*packSource, error) {
+	if err := vacuumInto(path, dst); err != nil {
+		return nil, err
+	}
+
+	db, err := openDatabase(dst, false)
+	if err != nil {
+		return nil, err
+	}
+	var mode string
+	err = db.QueryRow("PRAGMA journal_mode=wal").Scan(&mode)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if mode != "wal" {
+		return nil, fmt.Errorf("couldn't switch to WAL mode: still in %s mode", mode)
+	}
+
+	head := make([]byte, dbHeaderSize)
+	file, err := os.Open(dst)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := io.ReadFull(file, head); err != nil {
+		return nil, err
+	}
+	header, err := parseDBHeader(head)
+	if err != nil {
+		return nil, err
+	}
+	if header.PageSize != dqlitePageSize {
+		warn("%s has pages of %d bytes, while dqlite uses %d unless configured otherwise",
+			path, header.PageSize, dqlitePageSize)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	source := &packSource{entry: dbEntry{name: name, mainSize: uint64(info.Size())}, main: dst}
+
+	// SQLite removes the WAL once checkpointed on close, but should it be
+	// left behind, it's part of the database.
+	if info, err := os.Stat(dst + "-wal"); err == nil {
+		source.wal = dst + "-wal"
+		source.entry.walSize = uint64(info.Size())
+	}
+	return source, nil
+}
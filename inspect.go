@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <snapshot>",
+	Short: "Report the contents of a snapshot without extracting it",
+	Long: `Reads through a snapshot and reports, for every database, the sizes of its
+files along with the information in the SQLite and WAL headers, flagging
+anything inconsistent. Nothing is written to disk.`,
+	Args: snapshotArg,
+	RunE: inspect,
+
+	SilenceUsage: true,
+}
+
+var (
+	recoveryDir    string
+	recoveryNodeID uint64
+)
+
+func init() {
+	inspectCmd.Flags().StringVar(&recoveryDir, "recovery-files", "", "write the cluster.yaml and info.yaml of the configuration in the .meta file into this directory")
+	inspectCmd.Flags().Uint64Var(&recoveryNodeID, "node-id", 0, "node to write the info.yaml of, with --recovery-files (default the only one)")
+	rootCmd.AddCommand(inspectCmd)
+}
+
+// dbReport is what inspect finds out about a database.
+type dbReport struct {
+	entry    dbEntry
+	header   *dbHeader
+	wal      *walHeader
+	warnings []string
+}
+
+func (r *dbReport) warn(format string, args ...any) {
+	r.warnings = append(r.warnings, fmt.Sprintf(format, args...))
+}
+
+func inspect(cmd *cobra.Command, args []string) error {
+	path, err := snapshotPath(args)
+	if err != nil {
+		return err
+	}
+	if err := inspectConfiguration(path); err != nil {
+		return err
+	}
+	snapshot, err := openSnapshot(path)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	fmt.Printf("Database count: %d\n\n", snapshot.count)
+	if snapshot.count == 0 {
+		warn("the snapshot holds no database")
+	}
+	for {
+		entry, err := snapshot.next()
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			break
+		}
+
+		report, err := inspectDatabase(snapshot, entry)
+		if err != nil {
+			return fmt.Errorf("couldn't inspect %s: %w", entry.name, err)
+		}
+		printReport(report)
+		countWarnings(len(report.warnings))
+	}
+	return snapshot.checkEOF()
+}
+
+// inspectConfiguration prints the cluster configuration in the .meta file
+// next to the snapshot at path, if there is one, and writes the files of
+// --recovery-files from it.
+func inspectConfiguration(path string) error {
+	meta, err := readSnapshotMeta(path + ".meta")
+	switch {
+	case err == nil:
+		printConfiguration(meta)
+	case !errors.Is(err, fs.ErrNotExist):
+		warn("couldn't read %s.meta: %v", path, err)
+	}
+	if recoveryDir == "" {
+		return nil
+	}
+	if meta == nil {
+		return fmt.Errorf("no configuration to write the recovery files from, %s.meta can't be read", path)
+	}
+
+	id := recoveryNodeID
+	if id == 0 && len(meta.Servers) == 1 {
+		id = meta.Servers[0].ID
+	}
+	if err := writeRecoveryFiles(recoveryDir, meta.Servers, id); err != nil {
+		return fmt.Errorf("couldn't write the recovery files: %w", err)
+	}
+	if id == 0 {
+		fmt.Printf("Wrote cluster.yaml into %s, pick the node of info.yaml with --node-id\n\n", recoveryDir)
+	} else {
+		fmt.Printf("Wrote cluster.yaml and info.yaml (node %d) into %s\n\n", id, recoveryDir)
+	}
+	return nil
+}
+
+// inspectDatabase reads the content of entry from r, looking at the headers
+// of the main and WAL files only.
+func inspectDatabase(r io.Reader, entry *dbEntry) (*dbReport, error) {
+	main, err := readHead(r, entry.mainSize, dbHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read main: %w", err)
+	}
+	wal, err := readHead(r, entry.walSize, walHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read wal: %w", err)
+	}
+	return newDBReport(entry, main, wal), nil
+}
+
+// newDBReport reports on entry from the first bytes of its main file and of
+// its WAL.
+func newDBReport(entry *dbEntry, main, wal []byte) *dbReport {
+	report := &dbReport{entry: *entry}
+	if entry.mainSize == 0 {
+		report.warn("the main file is empty")
+	} else if h, err := parseDBHeader(main); err != nil {
+		report.warn("invalid database header: %v", err)
+	} else {
+		report.header = &h
+	}
+
+	if entry.walSize > 0 {
+		if h, _, err := parseWALHeader(wal); err != nil {
+			report.warn("invalid WAL header: %v", err)
+		} else {
+			report.wal = &h
+		}
+	}
+
+	checkConsistency(report)
+	return report
+}
+
+// readHead reads a file of the given size from r, returning at most its
+// first n bytes and discarding the rest.
+func readHead(r io.Reader, size uint64, n int) ([]byte, error) {
+	head := make([]byte, min(size, uint64(n)))
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(size)-int64(len(head))); err != nil {
+		return nil, err
+	}
+	return head, nil
+}
+
+// Beyond these, a WAL is suspiciously large: dqlite checkpoints long before.
+const (
+	walSizeRatio = 4
+	walSizeFloor = 64 << 20
+)
+
+// checkConsistency flags the ways in which the sizes and headers of a
+// database contradict each other, or just look suspicious.
+func checkConsistency(r *dbReport) {
+	if !plainName(r.entry.name) {
+		r.warn("the name %q has unusual characters", r.entry.name)
+	}
+	if r.entry.walSize > walSizeFloor && r.entry.walSize/walSizeRatio > r.entry.mainSize {
+		r.warn("the WAL (%s) is much larger than the main file (%s)",
+			formatBytes(int64(r.entry.walSize)), formatBytes(int64(r.entry.mainSize)))
+	}
+
+	if h := r.header; h != nil {
+		pageSize := uint64(h.PageSize)
+		if r.entry.mainSize%pageSize != 0 {
+			r.warn("main file size %d is not a multiple of the page size %d", r.entry.mainSize, pageSize)
+		}
+		if h.VersionValidFor == h.ChangeCounter && uint64(h.PageCount)*pageSize != r.entry.mainSize {
+			r.warn("header declares %d pages but the main file holds %d", h.PageCount, r.entry.mainSize/pageSize)
+		}
+		if h.IncrementalVacuum != 0 && h.LargestRootPage == 0 {
+			r.warn("the header asks for incremental vacuum but auto_vacuum is off")
+		}
+		if r.entry.walSize > 0 && (h.ReadVersion != 2 || h.WriteVersion != 2) {
+			r.warn("the database has a WAL but its header is not in WAL mode")
+		}
+	}
+
+	if w := r.wal; w != nil {
+		frameSize := uint64(walFrameHeaderSize + w.PageSize)
+		if (r.entry.walSize-walHeaderSize)%frameSize != 0 {
+			r.warn("WAL size %d doesn't match a whole number of %d bytes frames", r.entry.walSize, frameSize)
+		}
+		if r.header != nil && w.PageSize != r.header.PageSize {
+			r.warn("WAL page size %d differs from the database page size %d", w.PageSize, r.header.PageSize)
+		}
+	}
+}
+
+// plainName tells whether name is made of letters, digits, dots, dashes and
+// underscores only, as database names normally are.
+func plainName(name string) bool {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("._-", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func textEncodingName(encoding uint32) string {
+	switch encoding {
+	case 0:
+		return "not set"
+	case 1:
+		return "UTF-8"
+	case 2:
+		return "UTF-16le"
+	case 3:
+		return "UTF-16be"
+	default:
+		return fmt.Sprintf("invalid (%d)", encoding)
+	}
+}
+
+func printReport(r *dbReport) {
+	fmt.Printf("Database %s:\n", r.entry.name)
+	fmt.Printf("  Main file:           %d bytes\n", r.entry.mainSize)
+	fmt.Printf("  WAL file:            %d bytes\n", r.entry.walSize)
+
+	if h := r.header; h != nil {
+		fmt.Printf("  Page size:           %d\n", h.PageSize)
+		fmt.Printf("  Text encoding:       %s\n", textEncodingName(h.TextEncoding))
+		fmt.Printf("  Schema cookie:       %d\n", h.SchemaCookie)
+		fmt.Printf("  File change counter: %d\n", h.ChangeCounter)
+		fmt.Printf("  Application ID:      %d\n", h.ApplicationID)
+		fmt.Printf("  User version:        %d\n", h.UserVersion)
+		fmt.Printf("  Auto-vacuum:         %s\n", h.autoVacuum())
+		switch h.ReservedBytes {
+		case 0:
+		case cksumReservedBytes:
+			fmt.Printf("  Reserved bytes:      %d (checksum VFS)\n", h.ReservedBytes)
+		default:
+			fmt.Printf("  Reserved bytes:      %d\n", h.ReservedBytes)
+		}
+		if n := h.ptrmapPages(uint32(r.entry.mainSize / uint64(h.PageSize))); n > 0 {
+			fmt.Printf("  Pointer map:         %d pages (%s)\n", n, formatBytes(int64(n)*int64(h.PageSize)))
+		}
+	}
+	if w := r.wal; w != nil {
+		frames := (r.entry.walSize - walHeaderSize) / uint64(walFrameHeaderSize+w.PageSize)
+		fmt.Printf("  WAL frames:          %d\n", frames)
+		fmt.Printf("  WAL checkpoint seq:  %d\n", w.CheckpointSeq)
+		fmt.Printf("  WAL salts:           %#08x %#08x\n", w.Salt1, w.Salt2)
+	}
+
+	for _, warning := range r.warnings {
+		fmt.Printf("  WARNING: %s\n", warning)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// checkJournalMode makes sure mode is a journal mode the extracted databases
+// can be switched to.
+func checkJournalMode(mode string) error {
+	switch mode {
+	case "wal", "delete", "truncate", "persist":
+		return nil
+	default:
+		return fmt.Errorf("unsupported journal mode %q", mode)
+	}
+}
+
+// setJournalMode switches the databases extracted into dir to the given
+// journal mode. Leaving WAL mode checkpoints the WAL into the main file, so
+// that each database ends up as a single classic file.
+func setJournalMode(dir string, names []string, mode string) error {
+	for _, name := range names {
+		fmt.Printf("Switching database %s to %s journal mode...\n", name, mode)
+
+		db, err := openDatabase(filepath.Join(dir, name), false)
+		if err != nil {
+			return fmt.Errorf("couldn't open %s: %w", name, err)
+		}
+		var result string
+		err = db.QueryRow("PRAGMA journal_mode=" + mode).Scan(&result)
+		if closeErr := db.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't switch %s to %s journal mode: %w", name, mode, err)
+		}
+		if !strings.EqualFold(result, mode) {
+			return fmt.Errorf("couldn't switch %s to %s journal mode: still in %s mode", name, mode, result)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// lockDir can't lock anything without flock: concurrent runs aren't caught.
+func lockDir(dir string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,62 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockDir takes the advisory lock of dir, failing right away if another run
+// holds it. The returned function releases it, removing the lock file.
+// Filesystems without locks are written to unguarded.
+func lockDir(dir string) (func(), error) {
+	path := filepath.Join(dir, lockName)
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create the lock file: %w", err)
+		}
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		switch {
+		case errors.Is(err, syscall.EWOULDBLOCK):
+			holder, _ := io.ReadAll(file)
+			file.Close()
+			if pid := strings.TrimSpace(string(holder)); pid != "" {
+				return nil, fmt.Errorf("another run (pid %s) is extracting into %s", pid, dir)
+			}
+			return nil, fmt.Errorf("another run is extracting into %s", dir)
+		case errors.Is(err, syscall.ENOLCK) || errors.Is(err, syscall.EOPNOTSUPP):
+			file.Close()
+			os.Remove(path)
+			return func() {}, nil
+		case err != nil:
+			file.Close()
+			return nil, fmt.Errorf("couldn't lock %s: %w", path, err)
+		}
+
+		// The previous holder removes the file on its way out: if it did
+		// between our open and our lock, ours is gone, so start over.
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(path); err != nil || !os.SameFile(info, current) {
+			file.Close()
+			continue
+		}
+		file.Truncate(0)
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+		return func() {
+			os.Remove(path)
+			file.Close()
+		}, nil
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var logCmd = &cobra.Command{
+	Use:   "log <datadir>",
+	Short: "Decode the entries of the raft log of a data directory",
+	Long: `Decodes the entries of the raft log of a dqlite data directory, closed and
+open segments alike, one per line: its index and term, and what it holds,
+the database, transaction and pages of frames commands, the other dqlite
+commands, barriers and configuration changes. Changes are told apart from
+the configuration they replace, as servers added, removed, moved to another
+address, promoted or demoted, along with the voters left.
+
+Logs run to gigabytes: --from-index, --to-index and --term narrow the output
+down to the entries of interest, and segments wholly before --from-index
+aren't even read.`,
+	Example: `  dqlite-snapshot-unpack log /var/snap/microk8s/current/var/kubernetes/backend --from-index 1500 --to-index 1600`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    decodeLog,
+
+	SilenceUsage: true,
+}
+
+var (
+	logFromIndex uint64
+	logToIndex   uint64
+	logTerm      uint64
+	logJSON      bool
+)
+
+func init() {
+	logCmd.Flags().Uint64Var(&logFromIndex, "from-index", 0, "first index to decode")
+	logCmd.Flags().Uint64Var(&logToIndex, "to-index", 0, "last index to decode")
+	logCmd.Flags().Uint64Var(&logTerm, "term", 0, "only decode the entries of this term")
+	logCmd.Flags().BoolVar(&logJSON, "json", false, "print the entries as JSON, one per line")
+	rootCmd.AddCommand(logCmd)
+}
+
+// logEntry is an entry as decoded by log.
+type logEntry struct {
+	Index    uint64         `json:"index"`
+	Term     uint64         `json:"term"`
+	Type     string         `json:"type"`
+	Command  string         `json:"command,omitempty"`
+	Database string         `json:"database,omitempty"`
+	TxID     uint64         `json:"tx_id,omitempty"`
+	Pages    []uint64       `json:"pages,omitempty"`
+	PageSize uint16         `json:"page_size,omitempty"`
+	Truncate uint32         `json:"truncate,omitempty"`
+	Commit   bool           `json:"commit,omitempty"`
+	Servers  []raftServer   `json:"servers,omitempty"`
+	Changes  []serverChange `json:"changes,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// serverChange is how a configuration change affected a server: added,
+// removed, promoted or demoted (from PreviousRole to Role), or moved (from
+// PreviousAddress to Address).
+type serverChange struct {
+	Change          string `json:"change"`
+	ID              uint64 `json:"id"`
+	Address         string `json:"address"`
+	PreviousAddress string `json:"previous_address,omitempty"`
+	Role            string `json:"role,omitempty"`
+	PreviousRole    string `json:"previous_role,omitempty"`
+}
+
+func decodeLog(cmd *cobra.Command, args []string) error {
+	dir := args[0]
+	if logToIndex > 0 && logFromIndex > logToIndex {
+		return fmt.Errorf("--from-index %d is after --to-index %d", logFromIndex, logToIndex)
+	}
+	segments, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	// The log starts with the first closed segment or, without any, right
+	// after the snapshot the open segments follow on from.
+	var start uint64
+	if i := slices.IndexFunc(segments, func(s raftSegment) bool { return !s.Open }); i >= 0 {
+		start = segments[i].First - 1
+	} else if len(snapshots) > 0 {
+		start = snapshots[0].Index
+	}
+	after := start
+	if logFromIndex > 0 {
+		after = max(after, logFromIndex-1)
+	}
+
+	// Configuration changes are reported against the configuration they
+	// replace: that of the newest snapshot up to the first entry decoded,
+	// brought up to date with the changes of the log in between. Without
+	// one, the first change only lists its servers.
+	from := after
+	var config []raftServer
+	if i := slices.IndexFunc(snapshots, func(s raftSnapshot) bool { return s.Index <= after && s.Index >= start }); i >= 0 {
+		if meta, err := readSnapshotMeta(snapshots[i].Path + ".meta"); err == nil {
+			from, config = snapshots[i].Index, meta.Servers
+		}
+	}
+
+	filterTerm := cmd.Flags().Changed("term")
+	encoder := json.NewEncoder(os.Stdout)
+	var n int
+	err = walkLog(dir, from, 0, true, func(index uint64, entry raftEntry) error {
+		if logToIndex > 0 && index > logToIndex {
+			return errWalkDone
+		}
+		previous := config
+		if entry.Type == raftChange {
+			if servers, err := decodeConfiguration(entry.Data); err == nil {
+				config = servers
+			}
+		}
+		if index <= after || filterTerm && entry.Term != logTerm {
+			return nil
+		}
+		n++
+		e := decodeEntry(index, entry)
+		if e.Type == "change" && e.Error == "" && previous != nil {
+			e.Changes = diffConfiguration(previous, e.Servers)
+		}
+		if logJSON {
+			return encoder.Encode(e)
+		}
+		printEntry(e)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't decode the log: %w", err)
+	}
+	if n == 0 {
+		warn("no entries in %s match", dir)
+	}
+	return nil
+}
+
+func decodeEntry(index uint64, entry raftEntry) *logEntry {
+	e := &logEntry{Index: index, Term: entry.Term}
+	switch entry.Type {
+	case raftCommand:
+		e.Type = "command"
+		c, err := decodeCommand(entry.Data)
+		if err != nil {
+			e.Error = err.Error()
+			break
+		}
+		e.Command, e.Database, e.TxID = c.typeName(), c.Filename, c.TxID
+		e.Pages, e.PageSize, e.Truncate, e.Commit = c.Pages, c.PageSize, c.Truncate, c.IsCommit
+	case raftBarrier:
+		e.Type = "barrier"
+	case raftChange:
+		e.Type = "change"
+		servers, err := decodeConfiguration(entry.Data)
+		if err != nil {
+			e.Error = err.Error()
+			break
+		}
+		e.Servers = servers
+	default:
+		e.Type = fmt.Sprintf("type %d", entry.Type)
+	}
+	return e
+}
+
+// diffConfiguration returns how the servers of configuration before changed
+// in after: those added or changed in the order of after, then those
+// removed.
+func diffConfiguration(before, after []raftServer) []serverChange {
+	var changes []serverChange
+	for _, server := range after {
+		i := slices.IndexFunc(before, func(s raftServer) bool { return s.ID == server.ID })
+		if i < 0 {
+			changes = append(changes, serverChange{Change: "added", ID: server.ID, Address: server.Address, Role: server.roleName()})
+			continue
+		}
+		old := before[i]
+		if old.Address != server.Address {
+			changes = append(changes, serverChange{Change: "moved", ID: server.ID, Address: server.Address, PreviousAddress: old.Address})
+		}
+		if old.Role != server.Role {
+			change := "demoted"
+			if roleRank(server.Role) > roleRank(old.Role) {
+				change = "promoted"
+			}
+			changes = append(changes, serverChange{Change: change, ID: server.ID, Address: server.Address, Role: server.roleName(), PreviousRole: old.roleName()})
+		}
+	}
+	for _, server := range before {
+		if !slices.ContainsFunc(after, func(s raftServer) bool { return s.ID == server.ID }) {
+			changes = append(changes, serverChange{Change: "removed", ID: server.ID, Address: server.Address, PreviousRole: server.roleName()})
+		}
+	}
+	return changes
+}
+
+// roleRank orders roles by how much a server takes part in the cluster:
+// spares not at all, standbys replicating the log, voters electing leaders
+// and committing entries as well.
+func roleRank(role uint8) int {
+	switch role {
+	case raftSpare:
+		return 0
+	case raftStandby:
+		return 1
+	case raftVoter:
+		return 2
+	}
+	return -1
+}
+
+func printEntry(e *logEntry) {
+	fmt.Printf("%d\tterm %d\t", e.Index, e.Term)
+	switch {
+	case e.Error != "":
+		fmt.Printf("%s: %s\n", e.Type, e.Error)
+	case e.Command == "frames":
+		fmt.Printf("frames %s, tx %d, %d pages", e.Database, e.TxID, len(e.Pages))
+		if e.Commit {
+			fmt.Printf(", commit, %d pages in all", e.Truncate)
+		}
+		fmt.Println()
+	case e.Command == "undo":
+		fmt.Printf("undo tx %d\n", e.TxID)
+	case e.Command != "":
+		fmt.Printf("%s %s\n", e.Command, e.Database)
+	case e.Type == "change" && len(e.Changes) > 0:
+		var changes, voters []string
+		for _, c := range e.Changes {
+			switch c.Change {
+			case "added":
+				changes = append(changes, fmt.Sprintf("added %d at %s as %s", c.ID, c.Address, c.Role))
+			case "removed":
+				changes = append(changes, fmt.Sprintf("removed %d at %s", c.ID, c.Address))
+			case "moved":
+				changes = append(changes, fmt.Sprintf("moved %d from %s to %s", c.ID, c.PreviousAddress, c.Address))
+			default:
+				changes = append(changes, fmt.Sprintf("%s %d from %s to %s", c.Change, c.ID, c.PreviousRole, c.Role))
+			}
+		}
+		for _, server := range e.Servers {
+			if server.Role == raftVoter {
+				voters = append(voters, fmt.Sprint(server.ID))
+			}
+		}
+		fmt.Printf("change: %s; voters %s\n", strings.Join(changes, ", "), strings.Join(voters, ", "))
+	case e.Type == "change":
+		var servers []string
+		for _, server := range e.Servers {
+			servers = append(servers, fmt.Sprintf("%d at %s (%s)", server.ID, server.Address, server.roleName()))
+		}
+		fmt.Printf("change to %s\n", strings.Join(servers, ", "))
+	default:
+		fmt.Println(e.Type)
+	}
+}
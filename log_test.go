@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffConfiguration(t *testing.T) {
+	before := []raftServer{
+		{ID: 1, Address: "a:9000", Role: raftVoter},
+		{ID: 2, Address: "b:9000", Role: raftStandby},
+		{ID: 3, Address: "c:9000", Role: raftSpare},
+		{ID: 4, Address: "d:9000", Role: raftVoter},
+		{ID: 5, Address: "e:9000", Role: raftStandby},
+	}
+
+	for _, tc := range []struct {
+		name  string
+		after []raftServer
+		want  []serverChange
+	}{
+		{"unchanged", before, nil},
+		{
+			"added",
+			append(before[:5:5], raftServer{ID: 6, Address: "f:9000", Role: raftSpare}),
+			[]serverChange{{Change: "added", ID: 6, Address: "f:9000", Role: "spare"}},
+		},
+		{
+			"removed",
+			before[:3],
+			[]serverChange{
+				{Change: "removed", ID: 4, Address: "d:9000", PreviousRole: "voter"},
+				{Change: "removed", ID: 5, Address: "e:9000", PreviousRole: "standby"},
+			},
+		},
+		{
+			"promoted and demoted",
+			[]raftServer{
+				{ID: 1, Address: "a:9000", Role: raftStandby},
+				{ID: 2, Address: "b:9000", Role: raftVoter},
+				{ID: 3, Address: "c:9000", Role: raftStandby},
+				{ID: 4, Address: "d:9000", Role: raftSpare},
+				{ID: 5, Address: "e:9000", Role: raftSpare},
+			},
+			[]serverChange{
+				{Change: "demoted", ID: 1, Address: "a:9000", Role: "standby", PreviousRole: "voter"},
+				{Change: "promoted", ID: 2, Address: "b:9000", Role: "voter", PreviousRole: "standby"},
+				{Change: "promoted", ID: 3, Address: "c:9000", Role: "standby", PreviousRole: "spare"},
+				{Change: "demoted", ID: 4, Address: "d:9000", Role: "spare", PreviousRole: "voter"},
+				{Change: "demoted", ID: 5, Address: "e:9000", Role: "spare", PreviousRole: "standby"},
+			},
+		},
+		{
+			"moved",
+			[]raftServer{{ID: 1, Address: "z:9000", Role: raftSpare}},
+			[]serverChange{
+				{Change: "moved", ID: 1, Address: "z:9000", PreviousAddress: "a:9000"},
+				{Change: "demoted", ID: 1, Address: "z:9000", Role: "spare", PreviousRole: "voter"},
+				{Change: "removed", ID: 2, Address: "b:9000", PreviousRole: "standby"},
+				{Change: "removed", ID: 3, Address: "c:9000", PreviousRole: "spare"},
+				{Change: "removed", ID: 4, Address: "d:9000", PreviousRole: "voter"},
+				{Change: "removed", ID: 5, Address: "e:9000", PreviousRole: "standby"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := diffConfiguration(before, tc.after); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v\nwant %+v", got, tc.want)
+			}
+		})
+	}
+}
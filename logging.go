@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// logger receives the diagnostics of the tool, warnings and notices about
+// what it picked, as opposed to its output. It writes to the standard error,
+// in the format of --log-format, the plain one keeping to the WARNING: lines
+// the tool always printed.
+var logger = slog.New(newPlainHandler(os.Stderr, slog.LevelInfo))
+
+var (
+	logFormat string
+	logLevel  string
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "plain", "format of the diagnostics on the standard error: plain, text or json")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "least severe diagnostics shown: debug, info, warn or error")
+}
+
+// configureLogging sets up logger as the logging flags ask for.
+func configureLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return fmt.Errorf("invalid --log-level %q, expected debug, info, warn or error", logLevel)
+	}
+	options := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "plain":
+		logger = slog.New(newPlainHandler(os.Stderr, level))
+	case "text":
+		logger = slog.New(slog.NewTextHandler(os.Stderr, options))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, options))
+	default:
+		return fmt.Errorf("unknown --log-format %q, expected plain, text or json", logFormat)
+	}
+	return nil
+}
+
+// plainHandler writes records as bare lines: the message, prefixed with
+// the level unless it's info, followed by the attributes as key=value.
+type plainHandler struct {
+	mu    *sync.Mutex
+	out   io.Writer
+	level slog.Leveler
+	attrs string
+}
+
+func newPlainHandler(out io.Writer, level slog.Leveler) *plainHandler {
+	return &plainHandler{mu: &sync.Mutex{}, out: out, level: level}
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var line bytes.Buffer
+	switch {
+	case r.Level >= slog.LevelError:
+		line.WriteString("ERROR: ")
+	case r.Level >= slog.LevelWarn:
+		line.WriteString("WARNING: ")
+	case r.Level < slog.LevelInfo:
+		line.WriteString("DEBUG: ")
+	}
+	line.WriteString(r.Message)
+	line.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		line.WriteString(plainAttr(a))
+		return true
+	})
+	line.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.out.Write(line.Bytes())
+	return err
+}
+
+func (h *plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	for _, a := range attrs {
+		c.attrs += plainAttr(a)
+	}
+	return &c
+}
+
+// WithGroup is a no-op: the plain format has no room for groups.
+func (h *plainHandler) WithGroup(name string) slog.Handler {
+	return h
+}
+
+func plainAttr(a slog.Attr) string {
+	value := a.Value.Resolve().String()
+	if strings.ContainsAny(value, " \t\n\"=") {
+		value = fmt.Sprintf("%q", value)
+	}
+	return " " + a.Key + "=" + value
+}
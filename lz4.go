@@ -1,21 +1,21 @@
+//go:build cgo && !purego
+
 package main
 
 /*
 #cgo LDFLAGS: -llz4
 #include <stdlib.h>
+#include <lz4.h>
 #include <lz4frame.h>
 */
 import "C"
 import (
 	"errors"
+	"fmt"
 	"io"
 	"unsafe"
 )
 
-const (
-	bufferSize = 64 * 1024
-)
-
 // LZ4Reader is a reader that wraps LZ4 interface in a simple way and implements io.Reader.
 // It became necessary as dqlite is using block dependency to yield supposedly better compression,
 // and that is not supported by the usual Go lz4 package (github.com/pierrec/lz4)
@@ -30,7 +30,13 @@ type LZ4Reader struct {
 	outputSize   int    // number of decompressed bytes in outputBuf
 	eof          bool
 	err          error
-	frameStarted bool
+	frameStarted bool // a frame is being decoded and hasn't ended yet
+
+	// liblz4 always enforces the checksums present in the frames; with
+	// verify set, errors also tell where in the input they happened.
+	verify bool
+	frame  int   // number of the frame being decoded
+	offset int64 // compressed bytes consumed so far
 }
 
 type LZ4Error uint64
@@ -52,71 +58,187 @@ func NewLZ4Reader(r io.Reader) (*LZ4Reader, error) {
 	return &LZ4Reader{
 		r:         r,
 		ctx:       ctx,
-		inputBuf:  make([]byte, bufferSize),
-		outputBuf: make([]byte, bufferSize),
+		inputBuf:  getBuffer(bufferSize),
+		outputBuf: getBuffer(bufferSize),
 	}, nil
 }
 
 func (lr *LZ4Reader) Read(p []byte) (int, error) {
-	if lr.err != nil {
-		return 0, lr.err
-	}
-	// Serve leftover output
-	if lr.outputOffset < lr.outputSize {
-		n := copy(p, lr.outputBuf[lr.outputOffset:lr.outputSize])
-		lr.outputOffset += n
-		return n, nil
-	}
-	if lr.eof {
-		return 0, io.EOF
-	}
+	for {
+		if lr.err != nil {
+			return 0, lr.err
+		}
+		// Serve leftover output
+		if lr.outputOffset < lr.outputSize {
+			n := copy(p, lr.outputBuf[lr.outputOffset:lr.outputSize])
+			lr.outputOffset += n
+			return n, nil
+		}
+		if lr.eof {
+			return 0, io.EOF
+		}
+		if len(p) == 0 {
+			return 0, nil
+		}
 
-	// Prepare output
-	lr.outputOffset = 0
-	lr.outputSize = 0
-
-	// Fill input buffer if needed
-	if lr.inputStart == lr.inputEnd {
-		n, err := lr.r.Read(lr.inputBuf)
-		if n == 0 && err != nil {
-			if err == io.EOF {
-				lr.eof = true
-				return 0, io.EOF
+		// Fill input buffer if needed
+		if lr.inputStart == lr.inputEnd {
+			n, err := lr.r.Read(lr.inputBuf)
+			if n == 0 && err != nil {
+				if err == io.EOF && lr.frameStarted {
+					err = io.ErrUnexpectedEOF
+				}
+				if err == io.EOF {
+					lr.eof = true
+					return 0, io.EOF
+				}
+				lr.err = err
+				return 0, err
 			}
-			lr.err = err
-			return 0, err
+			lr.inputStart = 0
+			lr.inputEnd = n
 		}
-		lr.inputStart = 0
-		lr.inputEnd = n
-	}
 
-	// Set up pointers
-	srcPtr := unsafe.Pointer(&lr.inputBuf[lr.inputStart])
-	srcSize := C.size_t(lr.inputEnd - lr.inputStart)
-	dstPtr := unsafe.Pointer(&lr.outputBuf[0])
-	dstSize := C.size_t(len(lr.outputBuf))
-
-	// Decompress
-	res := C.LZ4F_decompress(lr.ctx, dstPtr, &dstSize, srcPtr, &srcSize, nil)
-	if C.LZ4F_isError(res) != 0 {
-		lr.err = LZ4Error(res)
-		return 0, lr.err
-	}
+		// Large reads are decompressed straight into p, sparing a copy;
+		// small ones go through outputBuf.
+		dst := lr.outputBuf
+		direct := len(p) >= len(lr.outputBuf)
+		if direct {
+			dst = p
+		}
 
-	// Update input buffer position
-	lr.inputStart += int(srcSize)
-	// Update output buffer size
-	lr.outputSize = int(dstSize)
-	lr.outputOffset = 0
+		// Set up pointers
+		srcPtr := unsafe.Pointer(&lr.inputBuf[lr.inputStart])
+		srcSize := C.size_t(lr.inputEnd - lr.inputStart)
+		dstPtr := unsafe.Pointer(&dst[0])
+		dstSize := C.size_t(len(dst))
 
-	// Try again to serve data
-	return lr.Read(p)
+		// Decompress. Once a frame is over, liblz4 starts over with whatever
+		// follows, so concatenated and skippable frames are handled as well.
+		if !lr.frameStarted {
+			lr.frame++
+		}
+		res := C.LZ4F_decompress(lr.ctx, dstPtr, &dstSize, srcPtr, &srcSize, nil)
+		if C.LZ4F_isError(res) != 0 {
+			lr.err = LZ4Error(res)
+			if lr.verify {
+				lr.err = fmt.Errorf("lz4: %w (frame %d, around compressed offset %d)", lr.err, lr.frame, lr.offset)
+			}
+			return 0, lr.err
+		}
+		lr.frameStarted = res != 0
+		lr.offset += int64(srcSize)
+
+		// Update input buffer position
+		lr.inputStart += int(srcSize)
+		if direct {
+			if dstSize > 0 {
+				return int(dstSize), nil
+			}
+			continue
+		}
+		// Update output buffer size, to be served on the next iteration
+		lr.outputSize = int(dstSize)
+		lr.outputOffset = 0
+	}
 }
 
 func (lr *LZ4Reader) Close() error {
 	if lr.ctx != nil {
 		C.LZ4F_freeDecompressionContext(lr.ctx)
 		lr.ctx = nil
+		putBuffer(lr.inputBuf)
+		putBuffer(lr.outputBuf)
+		lr.inputBuf, lr.outputBuf = nil, nil
+		lr.err = io.ErrClosedPipe
 	}
 	return nil
 }
+
+// decodeLZ4Block decompresses src, a raw LZ4 block (without frame), which is
+// expected to decompress to at most size bytes.
+func decodeLZ4Block(src []byte, size int) ([]byte, error) {
+	if len(src) == 0 {
+		return nil, errors.New("empty LZ4 block")
+	}
+	dst := make([]byte, size)
+	n := C.LZ4_decompress_safe(
+		(*C.char)(unsafe.Pointer(&src[0])), (*C.char)(unsafe.Pointer(unsafe.SliceData(dst))),
+		C.int(len(src)), C.int(size))
+	if n < 0 {
+		return nil, errors.New("corrupt LZ4 block")
+	}
+	return dst[:n], nil
+}
+
+// lz4Writer compresses what is written to it into an LZ4 frame the way
+// dqlite does: 64KiB linked blocks, with the content size and checksum.
+type lz4Writer struct {
+	w     io.Writer
+	ctx   *C.LZ4F_cctx
+	prefs C.LZ4F_preferences_t
+	buf   []byte
+}
+
+// newLZ4Writer starts a frame of size bytes of content on w.
+func newLZ4Writer(w io.Writer, size int64) (*lz4Writer, error) {
+	lw := &lz4Writer{w: w}
+	if errCode := C.LZ4F_createCompressionContext(&lw.ctx, C.LZ4F_VERSION); C.LZ4F_isError(errCode) != 0 {
+		return nil, errors.New("failed to create LZ4 compression context")
+	}
+	// All the settings are spelled out, so that the output only depends on
+	// the input (and on the version of liblz4).
+	lw.prefs.frameInfo.blockSizeID = C.LZ4F_max64KB
+	lw.prefs.frameInfo.blockMode = C.LZ4F_blockLinked
+	lw.prefs.frameInfo.contentChecksumFlag = C.LZ4F_contentChecksumEnabled
+	lw.prefs.frameInfo.contentSize = C.ulonglong(size)
+	lw.prefs.compressionLevel = 0
+	lw.prefs.autoFlush = 0
+	lw.buf = make([]byte, max(int(C.LZ4F_compressBound(C.size_t(bufferSize), &lw.prefs)), C.LZ4F_HEADER_SIZE_MAX))
+
+	n := C.LZ4F_compressBegin(lw.ctx, unsafe.Pointer(&lw.buf[0]), C.size_t(len(lw.buf)), &lw.prefs)
+	if err := lw.flush(n); err != nil {
+		lw.free()
+		return nil, err
+	}
+	return lw, nil
+}
+
+func (lw *lz4Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), bufferSize)]
+		n := C.LZ4F_compressUpdate(lw.ctx, unsafe.Pointer(&lw.buf[0]), C.size_t(len(lw.buf)),
+			unsafe.Pointer(&chunk[0]), C.size_t(len(chunk)), nil)
+		if err := lw.flush(n); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// Close ends the frame. It doesn't close the underlying writer.
+func (lw *lz4Writer) Close() error {
+	defer lw.free()
+	n := C.LZ4F_compressEnd(lw.ctx, unsafe.Pointer(&lw.buf[0]), C.size_t(len(lw.buf)), nil)
+	return lw.flush(n)
+}
+
+// flush writes out the n bytes liblz4 produced in buf, or returns the error
+// n stands for.
+func (lw *lz4Writer) flush(n C.size_t) error {
+	if C.LZ4F_isError(n) != 0 {
+		return LZ4Error(n)
+	}
+	_, err := lw.w.Write(lw.buf[:n])
+	return err
+}
+
+func (lw *lz4Writer) free() {
+	if lw.ctx != nil {
+		C.LZ4F_freeCompressionContext(lw.ctx)
+		lw.ctx = nil
+	}
+}
@@ -0,0 +1,686 @@
+//go:build !cgo || purego
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	lz4FrameMagic = 0x184D2204
+	// lz4WindowSize is how far back matches can reach, hence how much
+	// history block-linked frames need to keep around.
+	lz4WindowSize = 64 * 1024
+)
+
+var (
+	errLZ4Corrupt  = errors.New("lz4: corrupt block")
+	errLZ4Header   = errors.New("lz4: invalid frame header")
+	errLZ4Checksum = errors.New("lz4: header checksum mismatch")
+
+	errLZ4BlockChecksum   = errors.New("lz4: block checksum mismatch")
+	errLZ4ContentChecksum = errors.New("lz4: content checksum mismatch")
+)
+
+// LZ4Reader is a pure Go decoder of LZ4 frames implementing io.Reader, used
+// when cgo (and so liblz4) is not available. Unlike github.com/pierrec/lz4 it
+// supports the block dependency dqlite compresses its snapshots with.
+type LZ4Reader struct {
+	r *bufio.Reader
+
+	linked      bool // blocks may reference data of previous blocks
+	blockSum    bool // each block is followed by its checksum
+	contentSum  bool // the frame ends with a checksum of its content
+	maxBlock    int
+	started     bool
+	compressed  []byte // holds the current compressed block
+	window      []byte // history followed by the decompressed current block
+	outputStart int    // offset of the next byte to serve from window
+	eof         bool
+	err         error
+
+	// Block and content checksums are always enforced, like liblz4 does;
+	// with verify set, errors also tell where they happened. frame and
+	// block number the current frame and block.
+	verify      bool
+	frame       int
+	block       int
+	contentSize uint64 // declared size of the content, if not 0
+	produced    uint64
+	content     *xxh32
+	endSum      uint32 // content checksum found at the end of the frame
+
+	// With independent blocks, decoding runs in parallel: results holds,
+	// in order, the channels the decoded blocks are delivered on, ahead the
+	// result taken from them to look for the end of the frame.
+	results chan chan lz4Result
+	ahead   *lz4Result
+	done    chan struct{}
+}
+
+// lz4Result is a block decoded in parallel.
+type lz4Result struct {
+	data []byte
+	err  error
+	eof  bool
+}
+
+// NewLZ4Reader wraps an io.Reader that provides compressed LZ4 (frame) data.
+func NewLZ4Reader(r io.Reader) (*LZ4Reader, error) {
+	return &LZ4Reader{r: bufio.NewReaderSize(r, bufferSize)}, nil
+}
+
+func (lr *LZ4Reader) Read(p []byte) (int, error) {
+	for {
+		if lr.err != nil {
+			return 0, lr.err
+		}
+		if lr.outputStart < len(lr.window) {
+			n := copy(p, lr.window[lr.outputStart:])
+			lr.outputStart += n
+			return n, nil
+		}
+		if lr.eof {
+			return 0, io.EOF
+		}
+
+		if !lr.started {
+			lr.err = lr.readFrameHeader()
+			if lr.err == io.EOF {
+				lr.err = nil
+				lr.eof = true
+				continue
+			}
+			lr.started = true
+			if lr.err == nil && !lr.linked {
+				if workers := parallelBlocks(lr.maxBlock); workers > 1 {
+					lr.startParallel(workers)
+				}
+			}
+			continue
+		}
+		if lr.results != nil {
+			lr.nextParallel()
+			continue
+		}
+		lr.err = lr.readBlock()
+	}
+}
+
+// readFrameHeader parses the descriptor of the next frame, skipping
+// skippable frames. It returns io.EOF if the input ends before a new frame.
+func (lr *LZ4Reader) readFrameHeader() error {
+	var header [19]byte
+	for {
+		if _, err := io.ReadFull(lr.r, header[:4]); err != nil {
+			return err
+		}
+		magic := binary.LittleEndian.Uint32(header[:])
+		if magic&0xfffffff0 != lz4SkippableMagic {
+			break
+		}
+		if _, err := io.ReadFull(lr.r, header[4:8]); err != nil {
+			return noEOF(err)
+		}
+		size := int64(binary.LittleEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(io.Discard, lr.r, size); err != nil {
+			return noEOF(err)
+		}
+	}
+	if binary.LittleEndian.Uint32(header[:]) != lz4FrameMagic {
+		return errLZ4Header
+	}
+	if _, err := io.ReadFull(lr.r, header[4:6]); err != nil {
+		return noEOF(err)
+	}
+
+	flg, bd := header[4], header[5]
+	if flg>>6 != 1 || flg&0x02 != 0 || bd&0x8f != 0 {
+		return errLZ4Header
+	}
+	lr.linked = flg&0x20 == 0
+	lr.blockSum = flg&0x10 != 0
+	lr.contentSum = flg&0x04 != 0
+
+	switch bd >> 4 & 0x7 {
+	case 4:
+		lr.maxBlock = 64 * 1024
+	case 5:
+		lr.maxBlock = 256 * 1024
+	case 6:
+		lr.maxBlock = 1024 * 1024
+	case 7:
+		lr.maxBlock = 4 * 1024 * 1024
+	default:
+		return errLZ4Header
+	}
+
+	// The optional content size and dictionary ID come before the header
+	// checksum byte.
+	size := 6
+	if flg&0x08 != 0 {
+		size += 8
+	}
+	if flg&0x01 != 0 {
+		return fmt.Errorf("lz4: dictionaries are not supported")
+	}
+	if _, err := io.ReadFull(lr.r, header[6:size+1]); err != nil {
+		return noEOF(err)
+	}
+	if byte(xxh32Sum(header[4:size])>>8) != header[size] {
+		return errLZ4Checksum
+	}
+
+	lr.frame++
+	lr.block = 0
+	lr.produced = 0
+	lr.contentSize = 0
+	if flg&0x08 != 0 {
+		lr.contentSize = binary.LittleEndian.Uint64(header[6:])
+	}
+	lr.content = nil
+	if lr.contentSum {
+		lr.content = newXXH32(0)
+	}
+
+	putBuffer(lr.compressed)
+	putBuffer(lr.window)
+	lr.compressed = getBuffer(lr.maxBlock)
+	lr.window = getBuffer(lz4WindowSize + lr.maxBlock)[:0]
+	lr.outputStart = 0
+	return nil
+}
+
+// readRawBlock reads the next block of the frame into buf, returning its
+// content and whether it is stored uncompressed. A nil block marks the end of
+// the frame.
+func (lr *LZ4Reader) readRawBlock(buf []byte) ([]byte, bool, error) {
+	var raw [4]byte
+	if _, err := io.ReadFull(lr.r, raw[:]); err != nil {
+		return nil, false, noEOF(err)
+	}
+	size := binary.LittleEndian.Uint32(raw[:])
+	if size == 0 {
+		// End mark: only the content checksum may follow.
+		if lr.contentSum {
+			if _, err := io.ReadFull(lr.r, raw[:]); err != nil {
+				return nil, false, noEOF(err)
+			}
+			lr.endSum = binary.LittleEndian.Uint32(raw[:])
+		}
+		return nil, false, nil
+	}
+	lr.block++
+
+	uncompressed := size&0x80000000 != 0
+	size &= 0x7fffffff
+	if int(size) > lr.maxBlock {
+		return nil, false, lr.blockError(errLZ4Corrupt, lr.block)
+	}
+	block := buf[:size]
+	if _, err := io.ReadFull(lr.r, block); err != nil {
+		return nil, false, noEOF(err)
+	}
+	if lr.blockSum {
+		if _, err := io.ReadFull(lr.r, raw[:]); err != nil {
+			return nil, false, noEOF(err)
+		}
+		if xxh32Sum(block) != binary.LittleEndian.Uint32(raw[:]) {
+			return nil, false, lr.located(errLZ4BlockChecksum, lr.block)
+		}
+	}
+	return block, uncompressed, nil
+}
+
+// blockError tells in which block of which frame err happened.
+func (lr *LZ4Reader) blockError(err error, block int) error {
+	return fmt.Errorf("%w (block %d of frame %d)", err, block, lr.frame)
+}
+
+// located tells, with verify set, where err happened: in which block of the
+// current frame or, for block 0, at its end.
+func (lr *LZ4Reader) located(err error, block int) error {
+	switch {
+	case !lr.verify:
+		return err
+	case block == 0:
+		return fmt.Errorf("%w (frame %d)", err, lr.frame)
+	}
+	return lr.blockError(err, block)
+}
+
+// checkContent hashes the decompressed data going out of the current frame
+// and, once the frame ended, verifies its content checksum and size.
+func (lr *LZ4Reader) checkContent(data []byte, end bool) error {
+	lr.produced += uint64(len(data))
+	if lr.content != nil {
+		lr.content.Write(data)
+	}
+	if !end {
+		return nil
+	}
+	if lr.content != nil && lr.content.Sum32() != lr.endSum {
+		return lr.located(errLZ4ContentChecksum, 0)
+	}
+	if lr.contentSize != 0 && lr.produced != lr.contentSize {
+		return fmt.Errorf("lz4: frame %d declares %d bytes of content but %d were decoded",
+			lr.frame, lr.contentSize, lr.produced)
+	}
+	return nil
+}
+
+// readBlock decompresses the next block of the frame into the window.
+func (lr *LZ4Reader) readBlock() error {
+	block, uncompressed, err := lr.readRawBlock(lr.compressed)
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		// Another frame may follow.
+		lr.started = false
+		return lr.checkContent(nil, true)
+	}
+
+	// Keep the last 64KiB as history for linked blocks, nothing otherwise.
+	history := 0
+	if lr.linked {
+		history = min(len(lr.window), lz4WindowSize)
+	}
+	copy(lr.window[:history], lr.window[len(lr.window)-history:])
+	lr.window = lr.window[:history]
+	lr.outputStart = history
+
+	if uncompressed {
+		lr.window = append(lr.window, block...)
+	} else if lr.window, err = lz4DecodeBlock(lr.window, block, lr.maxBlock); err != nil {
+		return lr.blockError(err, lr.block)
+	}
+	if err := lr.checkContent(lr.window[history:], false); err != nil {
+		return err
+	}
+	return lr.endAhead()
+}
+
+// endAhead ends the frame if its end mark comes next, verifying the content
+// checksum before the last block is served rather than after: by the time
+// the next read finds the end, the file the block went to may be complete
+// and renamed into place.
+func (lr *LZ4Reader) endAhead() error {
+	mark, err := lr.r.Peek(4)
+	if err != nil || binary.LittleEndian.Uint32(mark) != 0 {
+		// Errors are for the next block to report.
+		return nil
+	}
+	if _, _, err := lr.readRawBlock(lr.compressed); err != nil {
+		return err
+	}
+	lr.started = false
+	return lr.checkContent(nil, true)
+}
+
+// startParallel starts decoding the (independent) blocks of the frame with
+// up to workers goroutines. A dispatcher reads the blocks in order and hands
+// each to its own goroutine, queueing the channel its result will be
+// delivered on so that Read can consume the results in order.
+func (lr *LZ4Reader) startParallel(workers int) {
+	lr.results = make(chan chan lz4Result, 2*workers)
+	lr.done = make(chan struct{})
+	sem := make(chan struct{}, workers)
+
+	go func() {
+		defer close(lr.results)
+		for {
+			buf := getBuffer(lr.maxBlock)
+			block, uncompressed, err := lr.readRawBlock(buf)
+
+			result := make(chan lz4Result, 1)
+			select {
+			case lr.results <- result:
+			case <-lr.done:
+				putBuffer(buf)
+				return
+			}
+			if err != nil || block == nil {
+				putBuffer(buf)
+				result <- lz4Result{err: err, eof: block == nil}
+				return
+			}
+
+			sem <- struct{}{}
+			n := lr.block
+			go func() {
+				defer func() { <-sem }()
+				if uncompressed {
+					result <- lz4Result{data: block}
+					return
+				}
+				data, err := lz4DecodeBlock(getBuffer(lr.maxBlock)[:0], block, lr.maxBlock)
+				putBuffer(buf)
+				if err != nil {
+					err = lr.blockError(err, n)
+				}
+				result <- lz4Result{data: data, err: err}
+			}()
+		}
+	}()
+}
+
+// nextParallel moves the next block decoded in parallel to the window.
+func (lr *LZ4Reader) nextParallel() {
+	result, ok := lr.nextResult()
+	if !ok {
+		lr.err = io.ErrUnexpectedEOF
+		return
+	}
+	switch {
+	case result.err != nil:
+		lr.err = result.err
+	case result.eof:
+		lr.endParallel()
+	default:
+		putBuffer(lr.window)
+		lr.window = result.data
+		lr.outputStart = 0
+		if lr.err = lr.checkContent(result.data, false); lr.err != nil {
+			return
+		}
+		// As in endAhead, the end of the frame is checked before its
+		// last block is served.
+		if next, ok := lr.nextResult(); ok && next.eof && next.err == nil {
+			lr.endParallel()
+		} else if ok {
+			lr.ahead = &next
+		}
+	}
+}
+
+// nextResult returns the next block decoded in parallel, waiting for it if
+// needed, or false if the dispatcher stopped short.
+func (lr *LZ4Reader) nextResult() (lz4Result, bool) {
+	if lr.ahead != nil {
+		result := *lr.ahead
+		lr.ahead = nil
+		return result, true
+	}
+	pending, ok := <-lr.results
+	if !ok {
+		return lz4Result{}, false
+	}
+	return <-pending, true
+}
+
+// endParallel ends the frame decoded in parallel: the dispatcher is done
+// with it, and another frame may follow.
+func (lr *LZ4Reader) endParallel() {
+	lr.results = nil
+	lr.done = nil
+	lr.started = false
+	lr.err = lr.checkContent(nil, true)
+}
+
+// lz4DecodeBlock decompresses the LZ4 block src, appending the result to dst,
+// whose content serves as history for matches. At most limit bytes are
+// produced.
+func lz4DecodeBlock(dst, src []byte, limit int) ([]byte, error) {
+	limit += len(dst)
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+
+		literals := int(token >> 4)
+		if literals == 15 {
+			var ok bool
+			if literals, i, ok = lz4Length(src, i, literals); !ok {
+				return nil, errLZ4Corrupt
+			}
+		}
+		if i+literals > len(src) || len(dst)+literals > limit {
+			return nil, errLZ4Corrupt
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+
+		// The last sequence of a block has literals only.
+		if i == len(src) {
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, errLZ4Corrupt
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, errLZ4Corrupt
+		}
+
+		length := int(token & 15)
+		if length == 15 {
+			var ok bool
+			if length, i, ok = lz4Length(src, i, length); !ok {
+				return nil, errLZ4Corrupt
+			}
+		}
+		length += 4
+		if len(dst)+length > limit {
+			return nil, errLZ4Corrupt
+		}
+
+		// Matches may overlap with the bytes they produce, in which case
+		// they have to be copied in chunks no longer than the offset.
+		start := len(dst) - offset
+		for length > 0 {
+			chunk := min(length, offset)
+			dst = append(dst, dst[start:start+chunk]...)
+			start += chunk
+			length -= chunk
+		}
+	}
+	return dst, nil
+}
+
+// decodeLZ4Block decompresses src, a raw LZ4 block (without frame), which is
+// expected to decompress to at most size bytes.
+func decodeLZ4Block(src []byte, size int) ([]byte, error) {
+	if len(src) == 0 {
+		return nil, errors.New("empty LZ4 block")
+	}
+	return lz4DecodeBlock(make([]byte, 0, size), src, size)
+}
+
+// lz4Length decodes the extra bytes of a literal or match length starting at
+// src[i], adding them to n.
+func lz4Length(src []byte, i, n int) (int, int, bool) {
+	for {
+		if i >= len(src) {
+			return 0, 0, false
+		}
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, true
+		}
+	}
+}
+
+// noEOF turns an EOF in the middle of a frame into an unexpected one.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (lr *LZ4Reader) Close() error {
+	if lr.done != nil {
+		close(lr.done)
+		lr.done = nil
+	}
+	putBuffer(lr.compressed)
+	putBuffer(lr.window)
+	lr.compressed, lr.window = nil, nil
+	lr.err = io.ErrClosedPipe
+	return nil
+}
+
+// lz4FrameBlockSize is the size of the blocks lz4Writer compresses.
+const lz4FrameBlockSize = 64 * 1024
+
+// lz4Writer compresses what is written to it into an LZ4 frame the way
+// dqlite does: 64KiB linked blocks, with the content size and checksum.
+type lz4Writer struct {
+	w       io.Writer
+	window  []byte // history followed by the pending content of the block
+	history int
+	out     []byte
+	sum     *xxh32
+}
+
+// newLZ4Writer starts a frame of size bytes of content on w.
+func newLZ4Writer(w io.Writer, size int64) (*lz4Writer, error) {
+	// Version 1, linked blocks, content size and checksum; 64KiB blocks.
+	header := []byte{0x04, 0x22, 0x4d, 0x18, 0x4c, 0x40}
+	header = binary.LittleEndian.AppendUint64(header, uint64(size))
+	header = append(header, byte(xxh32Sum(header[4:])>>8))
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &lz4Writer{
+		w:      w,
+		window: make([]byte, 0, lz4WindowSize+lz4FrameBlockSize),
+		out:    make([]byte, 0, 4+lz4FrameBlockSize),
+		sum:    newXXH32(0),
+	}, nil
+}
+
+func (lw *lz4Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), lw.history+lz4FrameBlockSize-len(lw.window))
+		lw.window = append(lw.window, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(lw.window) == lw.history+lz4FrameBlockSize {
+			if err := lw.writeBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// writeBlock compresses the pending content, keeping the last 64KiB of the
+// window as history for the next block.
+func (lw *lz4Writer) writeBlock() error {
+	block := lw.window[lw.history:]
+	lw.sum.Write(block)
+
+	lw.out = binary.LittleEndian.AppendUint32(lw.out[:0], 0)
+	lw.out = lz4EncodeBlock(lw.out, lw.window, lw.history)
+	if size := len(lw.out) - 4; size < len(block) {
+		binary.LittleEndian.PutUint32(lw.out, uint32(size))
+	} else {
+		lw.out = binary.LittleEndian.AppendUint32(lw.out[:0], uint32(len(block))|0x80000000)
+		lw.out = append(lw.out, block...)
+	}
+	if _, err := lw.w.Write(lw.out); err != nil {
+		return err
+	}
+
+	lw.history = min(len(lw.window), lz4WindowSize)
+	copy(lw.window, lw.window[len(lw.window)-lw.history:])
+	lw.window = lw.window[:lw.history]
+	return nil
+}
+
+// Close ends the frame. It doesn't close the underlying writer.
+func (lw *lz4Writer) Close() error {
+	if len(lw.window) > lw.history {
+		if err := lw.writeBlock(); err != nil {
+			return err
+		}
+	}
+	end := binary.LittleEndian.AppendUint32(make([]byte, 4), lw.sum.Sum32())
+	_, err := lw.w.Write(end)
+	return err
+}
+
+// lz4EncodeBlock compresses src[start:] as an LZ4 block appended to dst,
+// with matches allowed to reach back into src[:start]. It's a greedy
+// compressor looking for matches through a hash table of 4 bytes sequences.
+func lz4EncodeBlock(dst, src []byte, start int) []byte {
+	const (
+		hashLog  = 16
+		minMatch = 4
+		// The last match must start 12 bytes before the end of the block
+		// and the last 5 bytes are always literals.
+		mfLimit   = 12
+		lastLits  = 5
+		maxOffset = 65535
+	)
+	hash := func(i int) uint32 {
+		return binary.LittleEndian.Uint32(src[i:]) * 2654435761 >> (32 - hashLog)
+	}
+
+	var table [1 << hashLog]int32 // positions, plus one
+	for i := max(0, start-maxOffset); i+minMatch <= start; i++ {
+		table[hash(i)] = int32(i + 1)
+	}
+
+	anchor := start
+	for i := start; i+mfLimit < len(src); {
+		h := hash(i)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if ref < 0 || i-ref > maxOffset || binary.LittleEndian.Uint32(src[ref:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+
+		length := minMatch
+		for i+length < len(src)-lastLits && src[ref+length] == src[i+length] {
+			length++
+		}
+		dst = lz4AppendSequence(dst, src[anchor:i], i-ref, length)
+		i += length
+		anchor = i
+	}
+	return lz4AppendSequence(dst, src[anchor:], 0, 0)
+}
+
+// lz4AppendSequence appends to dst the sequence of literals followed by the
+// match at offset of the given length, or by nothing if length is 0.
+func lz4AppendSequence(dst, literals []byte, offset, length int) []byte {
+	token := byte(min(len(literals), 15)) << 4
+	if length > 0 {
+		token |= byte(min(length-4, 15))
+	}
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = lz4AppendLength(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if length == 0 {
+		return dst
+	}
+
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(offset))
+	if length-4 >= 15 {
+		dst = lz4AppendLength(dst, length-4-15)
+	}
+	return dst
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fixtures in testdata/lz4 hold the same 70 KiB, 40 KiB of text followed
+// by 30 KiB of random bytes, so that each frame has a compressed block and an
+// uncompressed one. They were written with lz4 1.9.4:
+//
+//	linked.lz4           lz4 -BD -B4 --content-size, as the cgo lz4Writer does
+//	independent.lz4      lz4 -BI -B4
+//	block-checksums.lz4  lz4 -BX -BI -B4
+//	concatenated.lz4     the first 35 KiB and the rest as frames of their own,
+//	                     with a skippable frame in between
+//
+// purego-writer.lz4 was written by the pure Go lz4Writer.
+const (
+	fixtureSize   = 70 * 1024
+	fixtureSHA256 = "475de78b3ca5259308fe57d312c0ce9976dc8221baa4d43c85fd272542ecf1d0"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "lz4", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func decodeLZ4(compressed []byte) ([]byte, error) {
+	lr, err := NewLZ4Reader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer lr.Close()
+	return io.ReadAll(lr)
+}
+
+// TestLZ4Fixtures decodes frames written by liblz4 and by the pure Go
+// encoder, so that running the tests with and without the purego tag
+// cross-checks both implementations.
+func TestLZ4Fixtures(t *testing.T) {
+	for _, name := range []string{
+		"linked.lz4",
+		"independent.lz4",
+		"block-checksums.lz4",
+		"concatenated.lz4",
+		"purego-writer.lz4",
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := decodeLZ4(readFixture(t, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(data)
+			if len(data) != fixtureSize || hex.EncodeToString(sum[:]) != fixtureSHA256 {
+				t.Errorf("decoded %d bytes with SHA-256 %x, want %d bytes with %s",
+					len(data), sum, fixtureSize, fixtureSHA256)
+			}
+		})
+	}
+}
+
+func TestLZ4Corrupt(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		fixture string
+		offset  int // of the flipped byte, from the end if negative
+	}{
+		{"content checksum", "linked.lz4", -1},
+		{"block checksum", "block-checksums.lz4", 7 + 4 + 30909},
+		{"compressed data", "purego-writer.lz4", 1000},
+		{"uncompressed block", "independent.lz4", -100},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := readFixture(t, tc.fixture)
+			offset := tc.offset
+			if offset < 0 {
+				offset += len(data)
+			}
+			data[offset] ^= 0x55
+
+			lr, err := NewLZ4Reader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer lr.Close()
+			// The frame must fail before its last byte is served, for
+			// the database being extracted to fail with it.
+			if _, err := io.ReadFull(lr, make([]byte, fixtureSize)); err == nil {
+				t.Errorf("corrupt frame decoded without error")
+			}
+		})
+	}
+}
+
+func TestLZ4RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(325, 0))
+	random := make([]byte, 300*1024)
+	for i := range random {
+		random[i] = byte(rng.Uint32())
+	}
+	text := bytes.Repeat([]byte("INSERT INTO t VALUES (1, 'dqlite');\n"), 300*1024/36+1)
+	zeros := make([]byte, 300*1024)
+
+	for _, content := range []struct {
+		name string
+		data []byte
+	}{{"random", random}, {"text", text}, {"zeros", zeros}} {
+		for _, size := range []int{0, 1, 100, 64*1024 - 1, 64 * 1024, 64*1024 + 1, 300 * 1024} {
+			data := content.data[:size]
+			compressed := encodeLZ4(t, data)
+			decoded, err := decodeLZ4(compressed)
+			if err != nil {
+				t.Fatalf("%s, %d bytes: %v", content.name, size, err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Errorf("%s, %d bytes: round trip gave back %d different bytes", content.name, size, len(decoded))
+			}
+		}
+	}
+}
+
+// TestLZ4Frames decodes frames following each other, with a skippable frame
+// in between, as a single stream.
+func TestLZ4Frames(t *testing.T) {
+	first := bytes.Repeat([]byte("first frame "), 10000)
+	second := bytes.Repeat([]byte("second frame "), 10000)
+
+	var stream bytes.Buffer
+	stream.Write(encodeLZ4(t, first))
+	skippable := binary.LittleEndian.AppendUint32(nil, 0x184d2a50)
+	skippable = binary.LittleEndian.AppendUint32(skippable, 4)
+	stream.Write(append(skippable, "skip"...))
+	stream.Write(encodeLZ4(t, second))
+
+	decoded, err := decodeLZ4(stream.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(first, second...); !bytes.Equal(decoded, want) {
+		t.Errorf("decoded %d bytes, want the %d of both frames", len(decoded), len(want))
+	}
+}
+
+func encodeLZ4(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	lw, err := newLZ4Writer(&compressed, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return compressed.Bytes()
+}
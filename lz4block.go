@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxLZ4BlockSize is the largest size of an LZ4 block (LZ4_MAX_INPUT_SIZE).
+const maxLZ4BlockSize = 0x7E000000
+
+// newLZ4BlockReader reads all of r as a single raw LZ4 block, as handed out
+// by tools using the block format without frames, and serves its
+// decompressed content. The decompressed size must be known upfront: it's
+// either given as size or, when size is 0, read from a 4 bytes little-endian
+// prefix as written by e.g. python's lz4.block.
+func newLZ4BlockReader(r io.Reader, size int64) (io.Reader, error) {
+	if size == 0 {
+		var prefix [4]byte
+		if _, err := io.ReadFull(r, prefix[:]); err != nil {
+			return nil, fmt.Errorf("couldn't read the LZ4 block size prefix: %w", err)
+		}
+		size = int64(binary.LittleEndian.Uint32(prefix[:]))
+	}
+	if size <= 0 || size > maxLZ4BlockSize {
+		return nil, fmt.Errorf("invalid LZ4 block decompressed size: %d", size)
+	}
+
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := decodeLZ4Block(src, int(size))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(dst), nil
+}
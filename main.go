@@ -2,11 +2,22 @@ package main
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/binary"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math"
+	"math/rand/v2"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -15,143 +26,858 @@ var rootCmd = &cobra.Command{
 	Use:   "dqlite-snapshot-unpack <snapshot>",
 	Short: "Unpack dqlite snapshots",
 	Long:  `Unpacks dqlite snapshots into readable databases for sqlite3 cli`,
-	Args:  cobra.ExactArgs(1),
+	Args:  snapshotArg,
 	RunE:  unpack,
+
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := configureLogging(); err != nil {
+			return err
+		}
+		if err := configureMemory(); err != nil {
+			return err
+		}
+		if err := checkFlavor(); err != nil {
+			return err
+		}
+		if err := configureEvents(); err != nil {
+			return err
+		}
+		configureRateLimit()
+		if cmd.Annotations[ownSignals] == "" {
+			removeScratchOnSignal()
+		}
+		return startProfiling()
+	},
+	PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+		return checkWarnings()
+	},
+	SilenceUsage: true,
+}
+
+var (
+	verifyMode string
+	schemaOnly bool
+	vacuum     bool
+	anonymize  bool
+	redactPath string
+	redaction  *redactionRules
+	sampleSize int
+	stats      bool
+	recoverDB  bool
+	journal    string
+	onlyDB     string
+	tables     []string
+	resume     bool
+	checkWAL   bool
+	fkCheck    bool
+	atIndex    uint64
+	outputExt  string
+	tagNames   bool
+
+	verifyTimeout time.Duration
+
+	lz4Block          bool
+	lz4BlockSize      int64
+	verifyCompression bool
+	renameDuplicates  bool
+	noMmap            bool
+	jobs              int
+	sparseFiles       bool
+	printPhases       bool
+
+	// Extraction stops before writing databases beyond these sizes, which
+	// most likely come from a corrupt header. 0 disables the checks.
+	maxDBSize    int64 = 64 << 30
+	maxTotalSize int64 = 256 << 30
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&flavor, "flavor", "", "microk8s, k8s-dqlite, lxd or incus: default to the newest snapshot of its data directory")
+	rootCmd.PersistentFlags().BoolVar(&lz4Block, "lz4-block", false, "the snapshot is a raw LZ4 block, without frame")
+	rootCmd.PersistentFlags().Int64Var(&lz4BlockSize, "lz4-block-size", 0, "decompressed size of the raw LZ4 block (default read from a 4 bytes prefix)")
+	rootCmd.PersistentFlags().Var((*byteSize)(&bufferSize), "buffer-size", "size of the chunks read, decompressed and written at a time (e.g. 1M)")
+	rootCmd.PersistentFlags().Var((*byteSize)(&writeBufferSize), "write-buffer", "size of the writes to the extracted files (e.g. 4M)")
+	rootCmd.PersistentFlags().Var((*byteSize64)(&maxMemory), "max-memory", "approximate cap on the memory taken by decompression buffers and, in purego builds, by LZ4 blocks decoded in parallel (e.g. 256M, default no limit)")
+	rootCmd.PersistentFlags().Var((*byteSize64)(&bwLimit), "bwlimit", "cap reads and writes to this many bytes per second each (e.g. 20M), 0 for no limit")
+	rootCmd.PersistentFlags().Var((*byteSize64)(&maxDBSize), "max-db-size", "refuse to extract databases (main and WAL) larger than this, 0 for no limit")
+	rootCmd.PersistentFlags().Var((*byteSize64)(&maxTotalSize), "max-total-size", "refuse to extract more than this in total, 0 for no limit")
+	rootCmd.PersistentFlags().BoolVar(&ignoreSpace, "ignore-space", false, "only warn when the declared sizes exceed the free disk space, instead of failing before extracting")
+	rootCmd.PersistentFlags().BoolVar(&renameDuplicates, "rename-duplicates", false, "extract further databases with an already seen name as <name>.1, <name>.2... instead of failing")
+	rootCmd.PersistentFlags().IntVar(&jobs, "jobs", 1, "extract up to this many databases of uncompressed snapshots at once")
+	rootCmd.PersistentFlags().BoolVar(&sparseFiles, "sparse", false, "leave holes in the extracted files instead of writing blocks of zeros")
+	rootCmd.PersistentFlags().BoolVar(&noMmap, "no-mmap", false, "read uncompressed snapshots with plain reads instead of mapping them in memory")
+	rootCmd.PersistentFlags().BoolVar(&failOnWarning, "fail-on-warning", false, "fail at the end if anything was warned about, such as a suspicious database")
+	rootCmd.PersistentFlags().BoolVar(&traceParsing, "trace", false, "log every field of the snapshot as it is parsed, with its offset, raw bytes and value")
+	rootCmd.PersistentFlags().BoolVar(&verifyCompression, "verify-compression", false, "report in which frame and block the LZ4 checksums, always enforced, fail")
+
+	rootCmd.Flags().StringVar(&verifyMode, "verify-db", "", "check every extracted database with PRAGMA integrity_check (full) or the faster quick_check (quick)")
+	rootCmd.Flags().Lookup("verify-db").NoOptDefVal = "full"
+	rootCmd.Flags().DurationVar(&verifyTimeout, "verify-timeout", 0, "interrupt the check of a database taking longer than this, failing it (default no limit)")
+	rootCmd.Flags().BoolVar(&checkWAL, "check-wal", false, "make sure every extracted WAL belongs with its main file")
+	rootCmd.Flags().BoolVar(&fkCheck, "fk-check", false, "run PRAGMA foreign_key_check on every extracted database, warning about violations")
+	rootCmd.Flags().BoolVar(&schemaOnly, "schema-only", false, "only write the schema of each database to <name>.sql")
+	rootCmd.Flags().BoolVar(&vacuum, "vacuum", false, "produce compact databases without a WAL through VACUUM INTO")
+	rootCmd.Flags().IntVar(&sampleSize, "sample", 0, "only keep the first N rows of each table, picking those whose foreign keys lead to rows kept")
+	rootCmd.Flags().StringVar(&redactPath, "redact", "", "redact the columns listed in this rules file, as table.column keep|hash|mask|drop per line")
+	rootCmd.Flags().BoolVar(&anonymize, "anonymize", false, "replace every text and blob value with a hash, keeping the schema and row counts, for sharing")
+	rootCmd.Flags().BoolVar(&stats, "stats", false, "report row count, size and indexes of every table")
+	rootCmd.Flags().BoolVar(&printPhases, "timings", false, "report the time spent reading, decompressing, writing and hashing, per database")
+	rootCmd.Flags().BoolVar(&recoverDB, "recover", false, "salvage the rows of databases failing verification into <name>.recovered (implies --verify-db)")
+	rootCmd.Flags().StringVar(&journal, "journal-mode", "wal", "journal mode of the extracted databases: wal, delete, truncate or persist")
+	rootCmd.Flags().StringVar(&onlyDB, "db", "", "only extract the named database")
+	rootCmd.Flags().StringSliceVar(&tables, "tables", nil, "only keep these tables of the database selected with --db")
+	rootCmd.Flags().StringVar(&modeFlag, "mode", "", "permissions of the extracted files, e.g. 0600 (default 0666 minus the umask)")
+	rootCmd.Flags().StringVar(&outputExt, "ext", "", "extension of the extracted files, e.g. .db, which the WAL takes too as in name.db-wal")
+	rootCmd.Flags().BoolVar(&tagNames, "tag-names", false, "name the extracted files <name>.<term>-<index>, from the name of the snapshot, so that several snapshots can share a directory")
+	rootCmd.Flags().StringVar(&ownerFlag, "owner", "", "owner of the extracted files, as user[:group] (requires root)")
+	rootCmd.Flags().StringVar(&outputSpec, "output", "", "write the extracted files into an archive instead: tar:<file>, zip:<file> or sqlar:<file>")
+	rootCmd.Flags().StringVar(&dedupStore, "dedup-store", "", "write the extracted files into this content-addressed store instead, sharing the chunks already there")
+	rootCmd.Flags().Var((*byteSize)(&dedupChunkSize), "dedup-chunk-size", "size of the chunks of --dedup-store: smaller ones share more between snapshots, in more files")
+	rootCmd.Flags().StringVar(&eventsFormat, "events", "", "report progress as events on the standard output, moving the rest to the standard error: jsonl")
+	rootCmd.Flags().StringVar(&summaryPath, "summary", "", "also write the summary printed at the end to this file, as JSON")
+	rootCmd.Flags().BoolVar(&force, "force", false, "extract into the current directory even if it holds other files")
+	rootCmd.Flags().BoolVar(&resume, "resume", false, "continue an interrupted extraction, keeping the databases already written")
+	rootCmd.Flags().Uint64Var(&atIndex, "at-index", 0, "extract the databases of a data directory as of this raft index, replaying its log onto a snapshot")
+	rootCmd.MarkFlagsMutuallyExclusive("verify-db", "schema-only")
+	rootCmd.MarkFlagsMutuallyExclusive("recover", "schema-only")
+	rootCmd.MarkFlagsMutuallyExclusive("fk-check", "schema-only")
+	rootCmd.MarkFlagsMutuallyExclusive("vacuum", "schema-only")
+	rootCmd.MarkFlagsMutuallyExclusive("stats", "schema-only")
+	rootCmd.MarkFlagsMutuallyExclusive("tables", "schema-only")
+	rootCmd.MarkFlagsMutuallyExclusive("tables", "vacuum")
+	rootCmd.MarkFlagsMutuallyExclusive("anonymize", "schema-only")
+	rootCmd.MarkFlagsMutuallyExclusive("anonymize", "vacuum")
+	rootCmd.MarkFlagsMutuallyExclusive("anonymize", "tables")
+	rootCmd.MarkFlagsMutuallyExclusive("redact", "schema-only")
+	rootCmd.MarkFlagsMutuallyExclusive("redact", "vacuum")
+	rootCmd.MarkFlagsMutuallyExclusive("redact", "tables")
+	rootCmd.MarkFlagsMutuallyExclusive("sample", "schema-only")
+	rootCmd.MarkFlagsMutuallyExclusive("sample", "vacuum")
+	rootCmd.MarkFlagsMutuallyExclusive("sample", "tables")
+	rootCmd.MarkFlagsMutuallyExclusive("output", "dedup-store")
+	// Archives and stores only take plain extractions, which is all
+	// --resume knows how to continue too.
+	for _, flag := range []string{"schema-only", "vacuum", "anonymize", "redact", "sample", "tables"} {
+		rootCmd.MarkFlagsMutuallyExclusive("resume", flag)
+	}
+	for _, flag := range []string{"resume", "schema-only", "vacuum", "anonymize", "redact", "sample", "tables",
+		"stats", "verify-db", "recover", "check-wal", "fk-check", "journal-mode"} {
+		rootCmd.MarkFlagsMutuallyExclusive("output", flag)
+		rootCmd.MarkFlagsMutuallyExclusive("dedup-store", flag)
+	}
 }
 
 func unpack(cmd *cobra.Command, args []string) error {
-	reader, err := createReader(args[0])
+	startSummary()
+	err := unpackSnapshot(args)
+	if err == nil {
+		err = checkWarnings()
+	}
+	return finishSummary(err)
+}
+
+func unpackSnapshot(args []string) error {
+	if err := checkJournalMode(journal); err != nil {
+		return err
+	}
+	if verifyMode != "" && verifyMode != "quick" && verifyMode != "full" {
+		return fmt.Errorf("invalid --verify-db %q, expected quick or full", verifyMode)
+	}
+	if sampleSize < 0 {
+		return fmt.Errorf("--sample can't be negative")
+	}
+	if len(tables) > 0 && onlyDB == "" {
+		return fmt.Errorf("--tables requires --db")
+	}
+	if outputExt != "" && (!strings.HasPrefix(outputExt, ".") || strings.ContainsRune(outputExt, '/')) {
+		return fmt.Errorf("invalid --ext %q, expected a suffix such as .db", outputExt)
+	}
+	if dedupChunkSize <= 0 {
+		return fmt.Errorf("--dedup-chunk-size must be positive")
+	}
+	if eventsFormat != "" && strings.HasSuffix(outputSpec, ":-") {
+		return fmt.Errorf("--events can't share the standard output with the archive")
+	}
+	if err := parsePermissions(); err != nil {
+		return err
+	}
+	if redactPath != "" {
+		var err error
+		if redaction, err = readRedactionRules(redactPath); err != nil {
+			return fmt.Errorf("couldn't read the redaction rules: %w", err)
+		}
+	}
+	var path string
+	var err error
+	if atIndex > 0 {
+		dir, err := argPath(args)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("--at-index needs a data directory")
+		}
+		var cleanup func()
+		if path, cleanup, err = snapshotAtIndex(dir, atIndex); err != nil {
+			return err
+		}
+		defer cleanup()
+	} else if path, err = snapshotPath(args); err != nil {
+		return err
+	}
+	if tagNames {
+		if nameTag, err = snapshotTag(path); err != nil {
+			return err
+		}
+	}
+	if outputSpec != "" || dedupStore != "" {
+		return unpackArchive(path)
+	}
+
+	unlock, err := lockDir(".")
 	if err != nil {
 		return err
 	}
+	defer unlock()
+	if !force {
+		if err := checkOutputDir(".", path); err != nil {
+			return err
+		}
+	}
+	if schemaOnly {
+		return extractSchemas(path)
+	}
 
-	if format, err := readUint64(reader); err != nil {
-		return fmt.Errorf("couldn't read format number: %w", err)
-	} else if format != 1 {
-		return fmt.Errorf("unexpected format number: %d", format)
+	var names []string
+	switch {
+	case len(tables) > 0:
+		names, err = extractTables(path, onlyDB, tables)
+	case vacuum:
+		names, err = extractVacuumed(path, onlyDatabase(onlyDB))
+	case sampleSize > 0 || anonymize || redaction != nil:
+		names, err = extractShareable(path, onlyDatabase(onlyDB))
+	default:
+		names, err = extract(path, ".", os.Stdout, onlyDatabase(onlyDB))
+	}
+	if err != nil {
+		return err
+	}
+	if onlyDB != "" && len(names) == 0 {
+		return fmt.Errorf("database %q not found in snapshot", onlyDB)
 	}
+	if onlyDB == "" {
+		if local, err := extractLocal(".", os.Stdout); err != nil {
+			return err
+		} else if local != "" {
+			names = append(names, local)
+		}
+	}
+
+	// Before changing the journal mode, which checkpoints the WALs away.
+	if checkWAL {
+		if err := checkWALs(".", names); err != nil {
+			return err
+		}
+	}
+	if journal != "wal" {
+		if err := setJournalMode(".", names, journal); err != nil {
+			return err
+		}
+	}
+	if err := applyPermissions(".", names); err != nil {
+		return err
+	}
+	if fkCheck {
+		if err := checkForeignKeys(".", names); err != nil {
+			return err
+		}
+	}
+	if stats {
+		if err := printTableStats(".", names); err != nil {
+			return err
+		}
+	}
+	if verifyMode != "" || recoverDB {
+		if err := verifyDatabases(".", names, cmp.Or(verifyMode, "full")); err != nil {
+			return err
+		}
+	}
+	if err := writeAttachScript(".", names); err != nil {
+		return fmt.Errorf("couldn't write %s: %w", taggedName(attachScriptName), err)
+	}
+	return printOpenCommands(".", names)
+}
 
-	databases, err := readUint64(reader)
+// extract unpacks the databases in the snapshot at path into dir, along
+// with a manifest describing them, reporting progress to out, and returns
+// the names of the extracted main files. When want is not nil, only the
+// databases it accepts are written out.
+func extract(path, dir string, out io.Writer, want func(name string) bool) ([]string, error) {
+	snapshot, err := openSnapshot(path)
 	if err != nil {
-		return fmt.Errorf("couldn't read database count: %w", err)
+		return nil, err
 	}
+	defer snapshot.Close()
+	// Parallel extractions read the mapping, which goes away on Close.
+	pool := newWorkerPool(jobs)
+	defer pool.wait()
+	var mu sync.Mutex
 
-	fmt.Printf("Database count: %d\n", databases)
+	fmt.Fprintf(out, "Database count: %d\n", snapshot.count)
+	emit("snapshot_opened", map[string]any{"snapshot": path, "databases": snapshot.count, "compression": snapshot.compression()})
+	summarizeSnapshot(path, snapshot)
+	if snapshot.count == 0 {
+		warn("the snapshot holds no database")
+	}
 
-	for range databases {
-		name, err := readPaddedString(reader)
+	// Mapped snapshots declare the sizes of all their databases upfront, as
+	// far as we're concerned; compressed ones only as they go, so each
+	// database is checked before being written.
+	preflight := snapshot.mapped != nil && !resume
+	if preflight {
+		size, err := declaredSize(path, want)
 		if err != nil {
-			return fmt.Errorf("couldn't read the database name: %w", err)
+			return nil, err
+		}
+		if err := checkSpace(dir, size, "the snapshot"); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	var total uint64
+	stats := newCompressionStats()
+	m := &manifest{Snapshot: path, Compression: snapshot.compression()}
+	// Known upfront for local files, for --resume to tell snapshots apart
+	// before the extraction completes.
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		m.SnapshotSize = info.Size()
+	}
+
+	written := make(map[string]bool)
+	var done map[string]manifestEntry
+	if resume {
+		if done, err = resumable(dir, path, m.SnapshotSize); err != nil {
+			return nil, err
 		}
-		fmt.Printf("Decoding database %s...\n", name)
+	}
 
-		mainSize, err := readUint64(reader)
+	for {
+		entry, err := snapshot.next()
 		if err != nil {
-			return fmt.Errorf("couldn't read main size: %w", err)
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		name := entry.name
+		start := snapshot.compressedRead()
+		stats.begin()
+
+		if want != nil && !want(name) {
+			fmt.Fprintf(out, "Skipping database %s...\n\n", name)
+			if err := snapshot.skip(entry); err != nil {
+				return nil, fmt.Errorf("couldn't skip database %s: %w", name, err)
+			}
+			stats.add(name, int64(entry.mainSize+entry.walSize), snapshot.compressedRead()-start)
+			continue
 		}
-		walSize, err := readUint64(reader)
+		if err := checkSize(entry, &total); err != nil {
+			return nil, err
+		}
+		file, err := fileName(name, written)
 		if err != nil {
-			return fmt.Errorf("couldn't read wal size: %w", err)
+			return nil, err
+		}
+
+		if db, ok := done[file]; ok && db.MainSize == entry.mainSize && db.WALSize == entry.walSize {
+			// The stream can't be seeked into, so the database is
+			// decompressed all the same, just not written.
+			fmt.Fprintf(out, "Database %s already extracted, skipping...\n\n", name)
+			if err := snapshot.skip(entry); err != nil {
+				return nil, fmt.Errorf("couldn't skip database %s: %w", name, err)
+			}
+			stats.add(name, int64(entry.mainSize+entry.walSize), snapshot.compressedRead()-start)
+			mu.Lock()
+			m.Databases = append(m.Databases, db)
+			mu.Unlock()
+			names = append(names, file)
+			emitDBDone(db, true)
+			summarizeDatabase(db)
+			continue
 		}
+		if !preflight {
+			if err := checkSpace(dir, entry.mainSize+entry.walSize, "database "+name); err != nil {
+				return nil, err
+			}
+		}
+		// Until extracted, the database is listed without files, which
+		// --resume won't take for done.
+		mu.Lock()
+		m.Databases = append(m.Databases, manifestEntry{Name: name, MainSize: entry.mainSize, WALSize: entry.walSize})
+		i := len(m.Databases) - 1
+		mu.Unlock()
+		names = append(names, file)
 
-		fmt.Printf("Decoding main database file (%d bytes)...\n", mainSize)
-		if err := unpackFile(reader, name, int64(mainSize)); err != nil {
-			return fmt.Errorf("couldn't unpack main: %w", err)
+		// The databases of mapped snapshots can be read independently, and
+		// so extracted in parallel.
+		emitDBStarted(entry)
+		if jobs > 1 && snapshot.mapped != nil {
+			fmt.Fprintf(out, "Decoding database %s...\n", name)
+			offset := snapshot.decoded
+			section := snapshot.section(int64(entry.mainSize + entry.walSize))
+			stats.addParallel(name, int64(entry.mainSize+entry.walSize), snapshot.compressedRead()-start)
+			err := pool.run(func() error {
+				db, err := unpackDatabase(section, offset, dir, file, entry, io.Discard)
+				if err != nil {
+					return fmt.Errorf("couldn't unpack database %s: %w", name, err)
+				}
+				checkExtracted(dir, file, entry)
+				mu.Lock()
+				defer mu.Unlock()
+				m.Databases[i] = db
+				fmt.Fprintf(out, "Database %s done\n", name)
+				emitDBDone(db, false)
+				summarizeDatabase(db)
+				if err := writeManifest(dir, m); err != nil {
+					return fmt.Errorf("couldn't write the manifest: %w", err)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
 		}
 
-		fmt.Printf("Decoding WAL database file (%d bytes)...\n", walSize)
-		if err := unpackFile(reader, name+"-wal", int64(walSize)); err != nil {
-			return fmt.Errorf("couldn't unpack wal: %w", err)
+		fmt.Fprintf(out, "Decoding database %s...\n", name)
+		db, err := unpackDatabase(snapshot, 0, dir, file, entry, out)
+		if err != nil {
+			return nil, err
+		}
+		checkExtracted(dir, file, entry)
+		fmt.Fprintf(out, "Done!\n\n")
+		emitDBDone(db, false)
+		summarizeDatabase(db)
+		stats.add(name, int64(entry.mainSize+entry.walSize), snapshot.compressedRead()-start)
+		m.Databases[i] = db
+		if err := writeManifest(dir, m); err != nil {
+			return nil, fmt.Errorf("couldn't write the manifest: %w", err)
 		}
-		fmt.Println("Done!\n")
 	}
 
-	var extra [1]byte
-	_, err = reader.Read(extra[:])
-	if err == io.EOF {
-		return nil
-	} else if err != nil {
-		return fmt.Errorf("checking for EOF: %w", err)
-	} else {
-		return fmt.Errorf("expected EOF but found extra data")
+	if err := pool.wait(); err != nil {
+		return nil, err
+	}
+	if err := snapshot.checkEOF(); err != nil {
+		return nil, err
+	}
+	m.SnapshotSize = snapshot.compressedRead()
+	m.Complete = true
+	if err := writeManifest(dir, m); err != nil {
+		return nil, fmt.Errorf("couldn't write the manifest: %w", err)
+	}
+	summarizeSizes(snapshot)
+	stats.print(out, snapshot)
+	return names, nil
+}
+
+// fileName returns the name to extract database name as, given those
+// written already, adding it to them.
+func fileName(name string, written map[string]bool) (string, error) {
+	if !validName(name) {
+		return "", fmt.Errorf("database name %q can't be used as a file name", name)
+	}
+
+	// Buggy producers have been seen writing the same database twice, the
+	// second copy overwriting the first.
+	file := name + nameTag + outputExt
+	if written[file] {
+		if !renameDuplicates {
+			return "", fmt.Errorf("database %q appears twice in the snapshot, see --rename-duplicates", name)
+		}
+		file = duplicateName(name, written)
+		warn("database %s appears more than once in the snapshot, extracting this copy as %s", name, file)
 	}
+	written[file] = true
+	return file, nil
 }
 
-func readUint64(r io.Reader) (uint64, error) {
-	var buf [8]byte
-	_, err := io.ReadFull(r, buf[:])
+// validName reports whether name, read from a snapshot, can be used as part
+// of a file name without leaving the output directory.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsRune(name, '/') &&
+		!strings.ContainsRune(name, filepath.Separator)
+}
+
+// duplicateName returns the first of name.1, name.2... not in written,
+// followed by the --tag-names tag and --ext.
+func duplicateName(name string, written map[string]bool) string {
+	for i := 1; ; i++ {
+		if file := fmt.Sprintf("%s.%d%s%s", name, i, nameTag, outputExt); !written[file] {
+			return file
+		}
+	}
+}
+
+// unpackDatabase writes the main file and the WAL of entry, read from
+// snapshot, to file and file-wal in dir, returning how it lists them in the
+// manifest. Offsets there are counted from base.
+//
+// Both files are written to temporary names first and only renamed into
+// place once complete, WAL first, so that an interrupted run never leaves a
+// database that looks valid behind.
+func unpackDatabase(snapshot *snapshotReader, base int64, dir, file string, entry *dbEntry, out io.Writer) (manifestEntry, error) {
+	db := manifestEntry{Name: entry.name, MainSize: entry.mainSize, WALSize: entry.walSize}
+
+	fmt.Fprintf(out, "Decoding main database file (%d bytes)...\n", entry.mainSize)
+	mainFile := manifestFile{Path: file, Offset: base + snapshot.decoded, Size: int64(entry.mainSize)}
+	mainTmp, mainSum, untrackMain, err := unpackFile(snapshot, filepath.Join(dir, file), int64(entry.mainSize))
 	if err != nil {
-		return 0, err
+		return db, fmt.Errorf("couldn't unpack main: %w", err)
+	}
+	// Removes the temporary file unless renamed into place.
+	defer untrackMain()
+
+	fmt.Fprintf(out, "Decoding WAL database file (%d bytes)...\n", entry.walSize)
+	walFile := manifestFile{Path: file + "-wal", Offset: base + snapshot.decoded, Size: int64(entry.walSize)}
+	walTmp, walSum, untrackWAL, err := unpackFile(snapshot, filepath.Join(dir, file+"-wal"), int64(entry.walSize))
+	if err != nil {
+		return db, fmt.Errorf("couldn't unpack wal: %w", err)
+	}
+	defer untrackWAL()
+	mainFile.SHA256, walFile.SHA256 = hex.EncodeToString(mainSum), hex.EncodeToString(walSum)
+
+	if err := os.Rename(walTmp, filepath.Join(dir, file+"-wal")); err != nil {
+		return db, err
+	}
+	if err := os.Rename(mainTmp, filepath.Join(dir, file)); err != nil {
+		return db, err
+	}
+	db.Files = []manifestFile{mainFile, walFile}
+	return db, nil
+}
+
+// checkSize makes sure entry is within --max-db-size and that, added to the
+// total extracted so far, it stays within --max-total-size.
+func checkSize(entry *dbEntry, total *uint64) error {
+	size := entry.mainSize + entry.walSize
+	if maxDBSize > 0 && size > uint64(maxDBSize) {
+		return fmt.Errorf("database %s declares %d bytes, more than --max-db-size (%s): the snapshot is likely corrupt",
+			entry.name, size, formatBytes(maxDBSize))
+	}
+	if *total += size; *total < size || maxTotalSize > 0 && *total > uint64(maxTotalSize) {
+		return fmt.Errorf("extracting database %s would exceed --max-total-size (%s): the snapshot is likely corrupt",
+			entry.name, formatBytes(maxTotalSize))
+	}
+	return nil
+}
+
+// lockName is the file unpack holds an advisory lock on while writing into a
+// directory, so that two runs can't mix up their files there.
+const lockName = ".dqlite-snapshot-unpack.lock"
+
+// force, set with --force, lets unpack write into a directory holding
+// anything but a previous extraction of the same snapshot.
+var force bool
+
+// checkOutputDir makes sure dir is empty, but for the lock file, or holds a
+// previous extraction of the snapshot at path (going by its manifest), so
+// that the files of different snapshots don't get mixed up.
+func checkOutputDir(dir, path string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	entries = slices.DeleteFunc(entries, func(e fs.DirEntry) bool { return e.Name() == lockName })
+	if len(entries) == 0 {
+		return nil
+	}
+	m, err := readManifest(dir)
+	switch {
+	case err == nil && filepath.Base(m.Snapshot) == filepath.Base(path):
+		return nil
+	case err == nil:
+		return fmt.Errorf("%s holds an extraction of %s, use another directory or --force", dir, m.Snapshot)
+	case tagNames && slices.ContainsFunc(entries, isManifest):
+		// The files of other snapshots, which tagged names don't clash
+		// with.
+		return nil
+	}
+	return fmt.Errorf("%s isn't empty (%s...), use an empty directory or --force", dir, entries[0].Name())
+}
+
+// isManifest reports whether e is the manifest of an extraction, tagged or
+// not.
+func isManifest(e fs.DirEntry) bool {
+	matched, _ := filepath.Match("MANIFEST*.json", e.Name())
+	return matched
+}
+
+// nameTag is what --tag-names adds to the names of the extracted files,
+// .<term>-<index>, empty without it.
+var nameTag string
+
+// snapshotTag returns the tag of the snapshot at path, from its name as
+// dqlite gives it, snapshot-<term>-<index>-<timestamp>. The .meta file
+// shares that name, and doesn't record the term itself.
+func snapshotTag(path string) (string, error) {
+	term, index, _, ok := parseSnapshotName(filepath.Base(path))
+	if !ok {
+		return "", fmt.Errorf("--tag-names needs the term and index from the snapshot name, but %s isn't named snapshot-<term>-<index>-<timestamp>", filepath.Base(path))
 	}
-	return binary.LittleEndian.Uint64(buf[:]), nil
+	return fmt.Sprintf(".%d-%d", term, index), nil
 }
 
-// readPaddedString reads a null-terminated string from r,
-// consuming 8-byte blocks, stopping at the first null, and discarding remaining padding.
-func readPaddedString(r io.Reader) (string, error) {
-	var buf bytes.Buffer
-	block := make([]byte, 8)
+// ignoreSpace, set with --ignore-space, turns running short of disk space
+// into a warning.
+var ignoreSpace bool
 
+// checkSpace makes sure the filesystem of dir has room for size more bytes
+// of what, failing before anything is written rather than halfway through,
+// or only warning with --ignore-space. Where the free space can't be told,
+// there is assumed to be enough.
+func checkSpace(dir string, size uint64, what string) error {
+	available, err := availableSpace(dir)
+	if err != nil || size <= available {
+		return nil
+	}
+	message := fmt.Sprintf("%s takes %s but only %s is free in %s",
+		what, formatBytes(int64(size)), formatBytes(int64(available)), dir)
+	if ignoreSpace {
+		warn("%s", message)
+		return nil
+	}
+	return fmt.Errorf("%s, use --ignore-space to try anyway", message)
+}
+
+// declaredSize adds up the sizes the snapshot at path declares for the
+// databases want accepts, only reading their headers, which is cheap for
+// mapped snapshots.
+func declaredSize(path string, want func(name string) bool) (uint64, error) {
+	snapshot, err := openSnapshot(path)
+	if err != nil {
+		return 0, err
+	}
+	defer snapshot.Close()
+
+	var total uint64
 	for {
-		_, err := io.ReadFull(r, block)
+		entry, err := snapshot.next()
 		if err != nil {
-			return "", fmt.Errorf("reading block: %w", err)
+			return 0, err
 		}
-
-		// Efficient null scan
-		i := bytes.IndexByte(block, 0)
-		if i >= 0 {
-			// Null found: write up to it and stop
-			buf.Write(block[:i])
-			break
+		if entry == nil {
+			return total, nil
 		}
+		if want == nil || want(entry.name) {
+			total += entry.mainSize + entry.walSize
+		}
+		if err := snapshot.skip(entry); err != nil {
+			return 0, fmt.Errorf("couldn't skip database %s: %w", entry.name, err)
+		}
+	}
+}
+
+// extractTemp unpacks the snapshot at path into a fresh temporary directory,
+// quietly, for commands that only need the databases as scratch files. The
+// returned cleanup function removes the directory again.
+func extractTemp(path string, want func(name string) bool) (string, []string, func(), error) {
+	dir, cleanup, err := makeTempDir()
+	if err != nil {
+		return "", nil, nil, err
+	}
 
-		// No null: write whole block
-		buf.Write(block)
+	names, err := extract(path, dir, io.Discard, want)
+	if err != nil {
+		cleanup()
+		return "", nil, nil, err
 	}
+	return dir, names, cleanup, nil
+}
 
-	return buf.String(), nil
+// onlyDatabase returns an extract filter accepting just the named database,
+// or nil (accepting all of them) when name is empty.
+func onlyDatabase(name string) func(string) bool {
+	if name == "" {
+		return nil
+	}
+	return func(n string) bool { return n == name }
 }
 
-func unpackFile(reader io.Reader, name string, length int64) error {
-	main, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0766)
+// byteSize is an int flag accepting sizes with a K, M or G (binary) suffix.
+type byteSize int
+
+func (b *byteSize) Set(s string) error {
+	n, err := parseByteSize(s)
 	if err != nil {
 		return err
 	}
-	defer main.Close()
+	if int64(int(n)) != n {
+		return fmt.Errorf("%s is too large", s)
+	}
+	*b = byteSize(n)
+	return nil
+}
 
-	_, err = io.Copy(main, io.LimitReader(reader, int64(length)))
+func (b *byteSize) String() string { return formatByteSize(int64(*b)) }
+func (b *byteSize) Type() string   { return "size" }
+
+// byteSize64 is the int64 flavor of byteSize.
+type byteSize64 int64
+
+func (b *byteSize64) Set(s string) (err error) {
+	n, err := parseByteSize(s)
+	*b = byteSize64(n)
 	return err
 }
 
-func createReader(path string) (io.Reader, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+func (b *byteSize64) String() string { return formatByteSize(int64(*b)) }
+func (b *byteSize64) Type() string   { return "size" }
+
+// formatByteSize formats n the way parseByteSize reads it, with the largest
+// suffix it is a multiple of.
+func formatByteSize(n int64) string {
+	for _, unit := range []struct {
+		suffix string
+		shift  int
+	}{{"G", 30}, {"M", 20}, {"K", 10}} {
+		if n != 0 && n%(1<<unit.shift) == 0 {
+			return strconv.FormatInt(n>>unit.shift, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func parseByteSize(s string) (int64, error) {
+	number := strings.TrimRight(strings.ToUpper(s), "BI")
+	shift := 0
+	switch {
+	case strings.HasSuffix(number, "K"):
+		shift = 10
+	case strings.HasSuffix(number, "M"):
+		shift = 20
+	case strings.HasSuffix(number, "G"):
+		shift = 30
 	}
+	if shift != 0 {
+		number = number[:len(number)-1]
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
 
-	reader := bufio.NewReader(file)
-	compressed, err := isCompressed(reader)
+// unpackFile copies length bytes of reader to a temporary file next to name,
+// called <name>.tmp-XXXXXXXX, and returns its path along with the SHA-256 of
+// its content and the function to call once it is renamed, as createTemp
+// does. The file is removed if fewer bytes could be copied.
+func unpackFile(reader io.Reader, name string, length int64) (string, []byte, func(), error) {
+	main, untrack, err := createTemp(name)
 	if err != nil {
-		return nil, err
+		return "", nil, nil, err
+	}
+	defer main.Close()
+
+	var out io.Writer = main
+	var sparse *sparseWriter
+	if sparseFiles {
+		sparse = &sparseWriter{file: main}
+		out = sparse
+	} else if err := preallocate(main, length); err != nil {
+		main.Close()
+		untrack()
+		return "", nil, nil, fmt.Errorf("couldn't allocate %d bytes: %w", length, err)
+	}
+	out = limitWriter(out)
+
+	hash := sha256.New()
+	var n int64
+	if snapshot, ok := reader.(*snapshotReader); ok && snapshot.mapped != nil && sparse == nil {
+		// The content is copied over by the kernel, while being hashed out
+		// of the mapping.
+		offset := snapshot.origin + snapshot.decoded
+		data := snapshot.take(length)
+		hashed := make(chan struct{})
+		go func() {
+			(&timedWriter{hash, &timings.hash}).Write(data)
+			close(hashed)
+		}()
+		start := time.Now()
+		n, err = copyFromFile(main, snapshot.path, offset, int64(len(data)))
+		timings.write.Add(int64(time.Since(start)))
+		<-hashed
+	} else {
+		// Writing (and hashing) happens in the background, while the next
+		// chunk is being decompressed.
+		buffered := bufio.NewWriterSize(&timedWriter{out, &timings.write}, writeBufferSize)
+		w := newAheadWriter(io.MultiWriter(buffered, &timedWriter{hash, &timings.hash}), bufferSize)
+		buf := getBuffer(bufferSize)
+		n, err = io.CopyBuffer(w, io.LimitReader(reader, int64(length)), buf)
+		putBuffer(buf)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = buffered.Flush()
+		}
+		if sparse != nil && err == nil {
+			err = sparse.finish()
+		}
+	}
+	if err == nil && n < length {
+		err = io.ErrUnexpectedEOF
 	}
-	if compressed {
-		return NewLZ4Reader(reader)
+	if closeErr := main.Close(); err == nil {
+		err = closeErr
 	}
-	return reader, nil
+	if err != nil {
+		untrack()
+		return "", nil, nil, err
+	}
+	return main.Name(), hash.Sum(nil), untrack, nil
 }
 
-func isCompressed(reader *bufio.Reader) (bool, error) {
-	const lz4magic = 0x184D2204
-	lz4Header, err := reader.Peek(4)
+// copyFromFile appends n bytes found at offset in the file at path to dst.
+// Going through os.File.ReadFrom, the copy happens in the kernel where
+// possible, with copy_file_range (which may even share the blocks) or splice
+// on Linux.
+func copyFromFile(dst *os.File, path string, offset, n int64) (int64, error) {
+	src, err := os.Open(path)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
+	defer src.Close()
 
-	compressed := binary.LittleEndian.Uint32(lz4Header) == lz4magic
-	return compressed, nil
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(dst, io.LimitReader(src, n))
 }
 
-func main() {
-	rootCmd.Execute()
+// createTemp creates a new file called <name>.tmp-XXXXXXXX. Unlike
+// os.CreateTemp, it creates the file with the permissions given with --mode
+// and --owner, or as a plain os.Create would. Until the returned function is
+// called, once the file is renamed into place or given up on, an interrupted
+// run removes it; the function removes it too if it is still there.
+func createTemp(name string) (*os.File, func(), error) {
+	for {
+		tmp := fmt.Sprintf("%s.tmp-%08x", name, rand.Uint32())
+		file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, createFileMode())
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		untrack := trackScratch(tmp)
+		if err := setPermissions(file); err != nil {
+			file.Close()
+			untrack()
+			return nil, nil, err
+		}
+		return file, untrack, nil
+	}
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestName is the file unpack describes the extracted snapshot in,
+// tagged like the databases with --tag-names.
+const manifestName = "MANIFEST.json"
+
+// manifest records what an extraction produced, so that the files can be
+// verified later, the snapshot put back together by repack and an
+// interrupted extraction resumed. It is rewritten after every database, with
+// Complete only set once the whole snapshot went through.
+type manifest struct {
+	Snapshot     string          `json:"snapshot"`
+	SnapshotSize int64           `json:"snapshot_size,omitempty"`
+	Complete     bool            `json:"complete"`
+	Compression  []string        `json:"compression,omitempty"`
+	Databases    []manifestEntry `json:"databases"`
+}
+
+// manifestEntry describes an extracted database, in snapshot order.
+type manifestEntry struct {
+	Name     string         `json:"name"`
+	MainSize uint64         `json:"main_size"`
+	WALSize  uint64         `json:"wal_size"`
+	Files    []manifestFile `json:"files"`
+}
+
+// manifestFile is a file extracted from the snapshot, found at Offset in its
+// decompressed content.
+type manifestFile struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func writeManifest(dir string, m *manifest) error {
+	data, err := m.marshal()
+	if err != nil {
+		return err
+	}
+	// Written aside and renamed into place, so that an interrupted run
+	// leaves the previous version behind rather than half a file.
+	path := filepath.Join(dir, taggedName(manifestName))
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (m *manifest) marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	return append(data, '\n'), err
+}
+
+func readManifest(dir string) (*manifest, error) {
+	return readManifestFile(filepath.Join(dir, taggedName(manifestName)))
+}
+
+func readManifestFile(path string) (*manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %w", filepath.Base(path), err)
+	}
+	return m, nil
+}
+
+// taggedName adds the --tag-names tag to name, before its extension, for the
+// files describing an extraction not to clash with those of other snapshots.
+func taggedName(name string) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + nameTag + ext
+}
+
+// resumable returns the databases of the manifest in dir whose files are
+// still there as extracted, going by their SHA-256, keyed by main file. The
+// manifest must describe the same snapshot, at path and of size bytes (0 when
+// unknown). A missing manifest means there's nothing to resume.
+func resumable(dir, path string, size int64) (map[string]manifestEntry, error) {
+	m, err := readManifest(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if m.Complete {
+		return nil, fmt.Errorf("the extraction in %s is already complete", dir)
+	}
+	if m.Snapshot != path || size > 0 && m.SnapshotSize > 0 && m.SnapshotSize != size {
+		return nil, fmt.Errorf("%s holds an extraction of %s (%d bytes), not of %s (%d bytes), and can't be resumed",
+			dir, m.Snapshot, m.SnapshotSize, path, size)
+	}
+
+	done := make(map[string]manifestEntry)
+	for _, db := range m.Databases {
+		intact := len(db.Files) > 0
+		for _, file := range db.Files {
+			sum, err := fileSHA256(filepath.Join(dir, file.Path))
+			if err == nil && sum != file.SHA256 {
+				warn("%s changed since it was extracted, extracting %s again", file.Path, db.Name)
+			}
+			if err != nil || sum != file.SHA256 {
+				intact = false
+				break
+			}
+		}
+		if intact {
+			done[db.Files[0].Path] = db
+		}
+	}
+	return done, nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <outfile> <snapshot>:<db>...",
+	Short: "Combine databases of several snapshots into one",
+	Long: `Writes a snapshot made of databases picked from several snapshots, in the
+given order, e.g. to build test fixtures or to consolidate recovered state.
+The content of the databases is copied over as it is.`,
+	Example: `  dqlite-snapshot-unpack merge out.snap a.snap:dbA b.snap:dbB`,
+	Args:    cobra.MinimumNArgs(2),
+	RunE:    merge,
+
+	SilenceUsage: true,
+}
+
+var mergeLZ4 bool
+
+func init() {
+	mergeCmd.Flags().BoolVar(&mergeLZ4, "lz4", false, "compress the snapshot with LZ4, as dqlite does")
+	mergeCmd.Flags().BoolVar(&verifyRoundtrip, "verify-roundtrip", false, "read the snapshot back and compare the hashes of its databases")
+	rootCmd.AddCommand(mergeCmd)
+}
+
+// mergeSource is a database picked from a snapshot.
+type mergeSource struct {
+	path  string
+	index uint64
+	entry *dbEntry
+}
+
+func merge(cmd *cobra.Command, args []string) error {
+	var sources []*mergeSource
+	var entries []*dbEntry
+	for _, spec := range args[1:] {
+		source, err := findMergeSource(spec)
+		if err != nil {
+			return err
+		}
+		for _, other := range sources {
+			if other.entry.name == source.entry.name {
+				return fmt.Errorf("database %q picked twice", source.entry.name)
+			}
+		}
+		if same, err := sameFile(source.path, args[0]); err != nil {
+			return err
+		} else if same {
+			return fmt.Errorf("the new snapshot can't overwrite %s", source.path)
+		}
+		sources = append(sources, source)
+		entries = append(entries, source.entry)
+	}
+
+	err := createSnapshot(args[0], entries, mergeLZ4, func(w io.Writer, i int) error {
+		snapshot, err := openSnapshot(sources[i].path)
+		if err != nil {
+			return err
+		}
+		defer snapshot.Close()
+
+		fmt.Printf("Copying database %s from %s...\n", sources[i].entry.name, sources[i].path)
+		return copyEntry(w, snapshot, sources[i].index)
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't write %s: %w", args[0], err)
+	}
+	fmt.Printf("Merged %d databases into %s\n", len(sources), args[0])
+	return nil
+}
+
+// findMergeSource locates the database named by a <snapshot>:<db> argument.
+func findMergeSource(spec string) (*mergeSource, error) {
+	i := strings.LastIndexByte(spec, ':')
+	if i <= 0 || i == len(spec)-1 {
+		return nil, fmt.Errorf("invalid argument %q, expected <snapshot>:<db>", spec)
+	}
+	path, name := spec[:i], spec[i+1:]
+
+	entries, _, err := readEntries(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read %s: %w", path, err)
+	}
+	for index, entry := range entries {
+		if entry.name == name {
+			return &mergeSource{path: path, index: uint64(index), entry: entry}, nil
+		}
+	}
+	return nil, fmt.Errorf("database %q not found in %s", name, path)
+}
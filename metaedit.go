@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var metaEditCmd = &cobra.Command{
+	Use:   "meta-edit <snapshot>",
+	Short: "Rewrite the term, index or configuration of a snapshot (last resort)",
+	Long: `Rewrites, for last-resort recovery, the raft metadata of a snapshot in a data
+directory: its term and index, which are part of the names of the snapshot
+and of its .meta file, and the configuration index and cluster configuration
+in the .meta file, whose CRC32 is recomputed. A .meta file failing its
+checksum is rewritten with a valid one.
+
+Mismatched metadata is a common reason for restored nodes to refuse to start,
+but metadata that doesn't match the rest of the cluster can just as well make
+it diverge: the original .meta file is kept as pre-meta-edit-<name>.`,
+	Example: `  dqlite-snapshot-unpack meta-edit snapshot-3-1000-1700000000 --term 4 --server 1=10.0.0.1:19001`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    metaEdit,
+
+	SilenceUsage: true,
+}
+
+var (
+	metaTerm               uint64
+	metaIndex              uint64
+	metaConfigurationIndex uint64
+	metaServers            []string
+)
+
+func init() {
+	metaEditCmd.Flags().Uint64Var(&metaTerm, "term", 0, "new term of the snapshot")
+	metaEditCmd.Flags().Uint64Var(&metaIndex, "index", 0, "new index of the snapshot")
+	metaEditCmd.Flags().Uint64Var(&metaConfigurationIndex, "configuration-index", 0, "new index of the configuration")
+	metaEditCmd.Flags().StringArrayVar(&metaServers, "server", nil, "server of the new configuration, as id=address[,role=voter|standby|spare] (repeatable)")
+	rootCmd.AddCommand(metaEditCmd)
+}
+
+func metaEdit(cmd *cobra.Command, args []string) error {
+	path := args[0]
+	metaPath := path + ".meta"
+	flags := cmd.Flags()
+	if !flags.Changed("term") && !flags.Changed("index") && !flags.Changed("configuration-index") && len(metaServers) == 0 {
+		return fmt.Errorf("nothing to change, give --term, --index, --configuration-index or --server")
+	}
+	term, index, timestamp, ok := parseSnapshotName(filepath.Base(path))
+	if !ok {
+		return fmt.Errorf("%s isn't named snapshot-<term>-<index>-<timestamp>", path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	var servers []raftServer
+	for _, spec := range metaServers {
+		server, err := parseServer(spec)
+		if err != nil {
+			return err
+		}
+		servers = append(servers, server)
+	}
+
+	original, err := os.ReadFile(metaPath)
+	if err != nil {
+		return err
+	}
+	meta, err := loadSnapshotMeta(metaPath)
+	switch {
+	case err != nil && (len(servers) == 0 || !flags.Changed("configuration-index")):
+		return fmt.Errorf("couldn't read %s: %w, give --configuration-index and --server to write it anew", metaPath, err)
+	case err != nil:
+		warn("couldn't read %s: %v, writing it anew", metaPath, err)
+		meta = &snapshotMeta{}
+	case meta.stored != meta.computed:
+		warn("%s fails its checksum (stored %08x, computed %08x), it's rewritten with a valid one", metaPath, meta.stored, meta.computed)
+	}
+
+	warn("meta-edit rewrites raft metadata by hand: a snapshot whose term, index or configuration " +
+		"don't match the rest of the cluster can make nodes refuse to start or diverge")
+	if flags.Changed("term") {
+		fmt.Printf("Term: %d -> %d\n", term, metaTerm)
+		term = metaTerm
+	}
+	if flags.Changed("index") {
+		fmt.Printf("Index: %d -> %d\n", index, metaIndex)
+		index = metaIndex
+	}
+	if flags.Changed("configuration-index") {
+		fmt.Printf("Configuration index: %d -> %d\n", meta.ConfigurationIndex, metaConfigurationIndex)
+		meta.ConfigurationIndex = metaConfigurationIndex
+	}
+	if len(servers) > 0 {
+		fmt.Println("Configuration:")
+		for _, server := range meta.Servers {
+			fmt.Printf("  - %d at %s, %s\n", server.ID, server.Address, server.roleName())
+		}
+		for _, server := range servers {
+			fmt.Printf("  + %d at %s, %s\n", server.ID, server.Address, server.roleName())
+		}
+		meta.Servers = servers
+	}
+	if meta.ConfigurationIndex > index {
+		warn("the configuration index %d is after the index %d of the snapshot", meta.ConfigurationIndex, index)
+	}
+	if !slices.ContainsFunc(meta.Servers, func(s raftServer) bool { return s.Role == raftVoter }) {
+		warn("the configuration has no voter, the cluster won't be able to elect a leader")
+	}
+
+	dir := filepath.Dir(path)
+	backup := filepath.Join(dir, "pre-meta-edit-"+filepath.Base(metaPath))
+	if _, err := os.Stat(backup); errors.Is(err, fs.ErrNotExist) {
+		// Only the first backup holds the original.
+		if err := os.WriteFile(backup, original, 0600); err != nil {
+			return fmt.Errorf("couldn't back up %s: %w", metaPath, err)
+		}
+	}
+
+	newPath := filepath.Join(dir, fmt.Sprintf("snapshot-%d-%d-%d", term, index, timestamp))
+	if newPath != path {
+		if _, err := os.Stat(newPath); err == nil {
+			return fmt.Errorf("%s already exists", newPath)
+		}
+	}
+	if err := writeSnapshotMeta(newPath+".meta", meta); err != nil {
+		return fmt.Errorf("couldn't write %s.meta: %w", newPath, err)
+	}
+	if newPath != path {
+		if err := os.Rename(path, newPath); err != nil {
+			return err
+		}
+		if err := os.Remove(metaPath); err != nil {
+			return err
+		}
+		fmt.Printf("Renamed %s to %s\n", filepath.Base(path), filepath.Base(newPath))
+	}
+	fmt.Printf("Wrote %s.meta, the original is kept as %s\n", newPath, backup)
+	return nil
+}
+
+// parseServer parses a --server flag of meta-edit.
+func parseServer(spec string) (raftServer, error) {
+	invalid := fmt.Errorf("invalid --server %q, expected id=address[,role=voter|standby|spare]", spec)
+	parts := strings.Split(spec, ",")
+	id, address, ok := strings.Cut(parts[0], "=")
+	if !ok || address == "" || strings.ContainsRune(address, 0) {
+		return raftServer{}, invalid
+	}
+	server := raftServer{Address: address, Role: raftVoter}
+	var err error
+	if server.ID, err = strconv.ParseUint(id, 10, 64); err != nil || server.ID == 0 {
+		return raftServer{}, invalid
+	}
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+		if key != "role" {
+			return raftServer{}, invalid
+		}
+		switch value {
+		case "voter":
+			server.Role = raftVoter
+		case "standby":
+			server.Role = raftStandby
+		case "spare":
+			server.Role = raftSpare
+		default:
+			return raftServer{}, invalid
+		}
+	}
+	return server, nil
+}
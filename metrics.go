@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The metrics of serve, exposed at /metrics.
+var (
+	metrics = prometheus.NewRegistry()
+
+	snapshotsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dqlite_snapshot_unpack_snapshots_total",
+		Help: "Snapshots processed, by operation (unpack or inspect) and result (ok or error).",
+	}, []string{"operation", "result"})
+	bytesRead = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dqlite_snapshot_unpack_read_bytes_total",
+		Help: "Bytes of snapshots read, compressed, by successful operations.",
+	})
+	bytesDecompressed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dqlite_snapshot_unpack_decompressed_bytes_total",
+		Help: "Bytes of databases decompressed by successful operations.",
+	})
+	processingSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dqlite_snapshot_unpack_duration_seconds",
+		Help:    "Time taken to process a snapshot, by operation.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"operation"})
+	phaseSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dqlite_snapshot_unpack_phase_duration_seconds",
+		Help:    "Time spent in each phase (read, decompress, write, hash) of a successful extraction, approximate when several run at once.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"phase"})
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dqlite_snapshot_unpack_errors_total",
+		Help: "Failed requests, by class: request, not_found, snapshot or internal.",
+	}, []string{"class"})
+)
+
+func init() {
+	metrics.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		snapshotsProcessed, bytesRead, bytesDecompressed, processingSeconds, phaseSeconds, errorsTotal,
+	)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metrics, promhttp.HandlerOpts{})
+}
+
+// processing measures the processing of a snapshot for the metrics.
+type processing struct {
+	operation string
+	start     time.Time
+	phases    phaseDurations
+}
+
+func startProcessing(operation string) *processing {
+	return &processing{operation: operation, start: time.Now(), phases: timings.durations()}
+}
+
+// done records the end of the processing, which read and decompressed the
+// given bytes if err is nil. The phases are timed for the whole process, so
+// concurrent extractions get each other's time counted in.
+func (p *processing) done(read, decompressed int64, err error) {
+	processingSeconds.WithLabelValues(p.operation).Observe(time.Since(p.start).Seconds())
+	if err != nil {
+		snapshotsProcessed.WithLabelValues(p.operation, "error").Inc()
+		return
+	}
+	snapshotsProcessed.WithLabelValues(p.operation, "ok").Inc()
+	bytesRead.Add(float64(read))
+	bytesDecompressed.Add(float64(decompressed))
+
+	d := timings.durations().sub(p.phases)
+	for phase, duration := range map[string]time.Duration{"read": d.read, "decompress": d.decompress, "write": d.write, "hash": d.hash} {
+		phaseSeconds.WithLabelValues(phase).Observe(max(duration, 0).Seconds())
+	}
+}
+
+// countError counts a failed request in the metrics, by the class its HTTP
+// status stands for.
+func countError(status int) {
+	class := "request"
+	switch {
+	case status == http.StatusNotFound:
+		class = "not_found"
+	case status == http.StatusUnprocessableEntity:
+		class = "snapshot"
+	case status >= 500:
+		class = "internal"
+	}
+	errorsTotal.WithLabelValues(class).Inc()
+}
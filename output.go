@@ -0,0 +1,439 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zlib"
+)
+
+// outputSpec, set with --output, sends the extracted files to an archive
+// instead of the current directory.
+var outputSpec string
+
+// outputSink receives the files of an extraction, in place of a directory.
+type outputSink interface {
+	// add stores a file called name, made of the size bytes read from r.
+	add(name string, size int64, r io.Reader) error
+	// finish completes the output or, if err is not nil, discards it, and
+	// returns the first error.
+	finish(err error) error
+}
+
+// openOutput opens the sink described by an --output value, kind:target.
+func openOutput(spec string) (outputSink, error) {
+	kind, target, _ := strings.Cut(spec, ":")
+	if target == "" {
+		return nil, fmt.Errorf("invalid --output %q, expected tar:<file>, zip:<file> or sqlar:<file>", spec)
+	}
+	switch kind {
+	case "tar":
+		return newTarSink(target)
+	case "zip":
+		return newZipSink(target)
+	case "sqlar":
+		if target == "-" {
+			return nil, fmt.Errorf("an SQLite archive can't be written to the standard output")
+		}
+		return newSqlarSink(target)
+	}
+	return nil, fmt.Errorf("unknown --output kind %q, expected tar, zip or sqlar", kind)
+}
+
+// unpackArchive is unpack with --output or --dedup-store. Writing the archive
+// to the standard output, as with tar:-, it reports progress to the standard
+// error.
+func unpackArchive(path string) error {
+	var sink outputSink
+	var err error
+	if dedupStore != "" {
+		sink, err = newDedupSink(dedupStore, path)
+	} else {
+		sink, err = openOutput(outputSpec)
+	}
+	if err != nil {
+		return err
+	}
+	names, err := extractArchive(path, sink, progressOutput(), onlyDatabase(onlyDB))
+	if err == nil && onlyDB != "" && len(names) == 0 {
+		err = fmt.Errorf("database %q not found in snapshot", onlyDB)
+	}
+	return sink.finish(err)
+}
+
+// progressOutput is where unpack reports progress: the standard output,
+// unless the archive goes there.
+func progressOutput() *os.File {
+	if strings.HasSuffix(outputSpec, ":-") {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// extractArchive streams the databases in the snapshot at path, along with
+// the manifest and, with --flavor, the local database, into sink. It works
+// like extract, without writing anything to disk.
+func extractArchive(path string, sink outputSink, out io.Writer, want func(name string) bool) ([]string, error) {
+	snapshot, err := openSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Close()
+
+	fmt.Fprintf(out, "Database count: %d\n", snapshot.count)
+	emit("snapshot_opened", map[string]any{"snapshot": path, "databases": snapshot.count, "compression": snapshot.compression()})
+	summarizeSnapshot(path, snapshot)
+	if snapshot.count == 0 {
+		warn("the snapshot holds no database")
+	}
+
+	var names []string
+	var total uint64
+	stats := newCompressionStats()
+	m := &manifest{Snapshot: path, Compression: snapshot.compression()}
+	written := make(map[string]bool)
+	for {
+		entry, err := snapshot.next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		name := entry.name
+		start := snapshot.compressedRead()
+		stats.begin()
+
+		if want != nil && !want(name) {
+			fmt.Fprintf(out, "Skipping database %s...\n\n", name)
+			if err := snapshot.skip(entry); err != nil {
+				return nil, fmt.Errorf("couldn't skip database %s: %w", name, err)
+			}
+			stats.add(name, int64(entry.mainSize+entry.walSize), snapshot.compressedRead()-start)
+			continue
+		}
+		if err := checkSize(entry, &total); err != nil {
+			return nil, err
+		}
+		file, err := fileName(name, written)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(out, "Decoding database %s...\n", name)
+		emitDBStarted(entry)
+		db := manifestEntry{Name: name, MainSize: entry.mainSize, WALSize: entry.walSize}
+		heads := []*headWriter{newHeadWriter(dbHeaderSize), newHeadWriter(walHeaderSize)}
+		for i, f := range []manifestFile{{Path: file, Size: int64(entry.mainSize)}, {Path: file + "-wal", Size: int64(entry.walSize)}} {
+			f.Offset = snapshot.decoded
+			hash := sha256.New()
+			w := io.MultiWriter(&timedWriter{hash, &timings.hash}, heads[i])
+			if err := sink.add(f.Path, f.Size, io.TeeReader(snapshot, w)); err != nil {
+				return nil, fmt.Errorf("couldn't unpack %s: %w", f.Path, err)
+			}
+			f.SHA256 = hex.EncodeToString(hash.Sum(nil))
+			db.Files = append(db.Files, f)
+		}
+		warnAnomalies(entry, heads[0].head, heads[1].head)
+		fmt.Fprintf(out, "Done!\n\n")
+		emitDBDone(db, false)
+		summarizeDatabase(db)
+		stats.add(name, int64(entry.mainSize+entry.walSize), snapshot.compressedRead()-start)
+		m.Databases = append(m.Databases, db)
+		names = append(names, file)
+	}
+
+	if err := snapshot.checkEOF(); err != nil {
+		return nil, err
+	}
+	m.SnapshotSize = snapshot.compressedRead()
+	m.Complete = true
+	summarizeSizes(snapshot)
+	data, err := m.marshal()
+	if err != nil {
+		return nil, err
+	}
+	if err := sink.add(manifestName, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("couldn't write the manifest: %w", err)
+	}
+	stats.print(out, snapshot)
+
+	if want == nil {
+		local, err := archiveLocal(sink, out)
+		if err != nil {
+			return nil, err
+		}
+		if local != "" {
+			names = append(names, local)
+		}
+	}
+	return names, nil
+}
+
+// archiveLocal adds the local database of --flavor, if it has one, to sink.
+func archiveLocal(sink outputSink, out io.Writer) (string, error) {
+	if _, ok := flavorLocalDBs[flavor]; !ok {
+		return "", nil
+	}
+	dir, cleanup, err := makeTempDir()
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	name, err := extractLocal(dir, out)
+	if err != nil || name == "" {
+		return "", err
+	}
+	return name, addFile(sink, filepath.Join(dir, name), name)
+}
+
+// addFile adds the file at path to sink as name.
+func addFile(sink outputSink, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return sink.add(name, info.Size(), file)
+}
+
+// archiveFile is where an archive is written: a temporary file, renamed into
+// place once complete, or a stream such as the standard output for "-".
+type archiveFile struct {
+	*bufio.Writer
+	path    string
+	file    *os.File
+	untrack func()
+}
+
+func createArchiveFile(path string) (*archiveFile, error) {
+	if path == "-" {
+		return archiveStream(os.Stdout), nil
+	}
+	file, untrack, err := createTemp(path)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveFile{Writer: bufio.NewWriterSize(limitWriter(file), writeBufferSize), path: path, file: file, untrack: untrack}, nil
+}
+
+func archiveStream(w io.Writer) *archiveFile {
+	return &archiveFile{Writer: bufio.NewWriterSize(limitWriter(w), writeBufferSize)}
+}
+
+// finish puts the archive in place or, if err is not nil, removes it.
+func (a *archiveFile) finish(err error) error {
+	if err == nil {
+		err = a.Flush()
+	}
+	if a.file == nil {
+		return err
+	}
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(a.file.Name(), a.path)
+	}
+	// Removes the file unless renamed into place.
+	a.untrack()
+	return err
+}
+
+// tarSink writes the files to a tar archive.
+type tarSink struct {
+	out     *archiveFile
+	tar     *tar.Writer
+	modTime time.Time
+}
+
+func newTarSink(path string) (*tarSink, error) {
+	out, err := createArchiveFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newTarStream(out), nil
+}
+
+func newTarStream(out *archiveFile) *tarSink {
+	return &tarSink{out: out, tar: tar.NewWriter(out), modTime: time.Now()}
+}
+
+func (t *tarSink) add(name string, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     int64(archiveFileMode()),
+		ModTime:  t.modTime,
+		Format:   tar.FormatPAX,
+	}
+	if err := t.tar.WriteHeader(header); err != nil {
+		return err
+	}
+	return copyExactly(t.tar, r, size)
+}
+
+func (t *tarSink) finish(err error) error {
+	if err == nil {
+		err = t.tar.Close()
+	}
+	return t.out.finish(err)
+}
+
+// zipSink writes the files to a zip archive, deflated.
+type zipSink struct {
+	out     *archiveFile
+	zip     *zip.Writer
+	modTime time.Time
+}
+
+func newZipSink(path string) (*zipSink, error) {
+	out, err := createArchiveFile(path)
+	if err != nil {
+		return nil, err
+	}
+	z := zip.NewWriter(out)
+	// The deflater of klauspost/compress is several times faster than the
+	// one of the standard library, which would be the bottleneck.
+	z.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.BestSpeed)
+	})
+	return &zipSink{out: out, zip: z, modTime: time.Now()}, nil
+}
+
+func (z *zipSink) add(name string, size int64, r io.Reader) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: z.modTime}
+	header.SetMode(archiveFileMode())
+	w, err := z.zip.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	return copyExactly(w, r, size)
+}
+
+func (z *zipSink) finish(err error) error {
+	if err == nil {
+		err = z.zip.Close()
+	}
+	return z.out.finish(err)
+}
+
+// sqlarSink writes the files to an SQLite Archive, the sqlar table of a
+// database, which the sqlite3 shell extracts with -A. As there, files are
+// deflated unless that doesn't make them smaller. SQLite only takes them
+// whole, so each file is held in memory, and limited to 1 GB.
+type sqlarSink struct {
+	path    string
+	tmp     string
+	untrack func()
+	db      *sql.DB
+	tx      *sql.Tx
+	modTime time.Time
+}
+
+func newSqlarSink(path string) (*sqlarSink, error) {
+	file, untrack, err := createTemp(path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	s := &sqlarSink{path: path, tmp: file.Name(), untrack: untrack, modTime: time.Now()}
+
+	if s.db, err = openDatabase(s.tmp, false); err == nil {
+		_, err = s.db.Exec(`CREATE TABLE sqlar(
+			name TEXT PRIMARY KEY,
+			mode INT,
+			mtime INT,
+			sz INT,
+			data BLOB
+		)`)
+	}
+	if err == nil {
+		s.tx, err = s.db.Begin()
+	}
+	if err != nil {
+		return nil, s.finish(fmt.Errorf("couldn't create the archive: %w", err))
+	}
+	return s, nil
+}
+
+func (s *sqlarSink) add(name string, size int64, r io.Reader) error {
+	var raw bytes.Buffer
+	raw.Grow(int(size))
+	if err := copyExactly(&raw, r, size); err != nil {
+		return err
+	}
+
+	var deflated bytes.Buffer
+	w, _ := zlib.NewWriterLevel(&deflated, zlib.BestSpeed)
+	w.Write(raw.Bytes())
+	w.Close()
+	data := raw.Bytes()
+	if deflated.Len() < raw.Len() {
+		data = deflated.Bytes()
+	}
+
+	// The mode is the whole st_mode, with the type of file.
+	mode := 0100000 | int64(archiveFileMode())
+	_, err := s.tx.Exec("INSERT INTO sqlar(name, mode, mtime, sz, data) VALUES (?, ?, ?, ?, ?)",
+		name, mode, s.modTime.Unix(), size, data)
+	return err
+}
+
+func (s *sqlarSink) finish(err error) error {
+	if s.tx != nil {
+		if err == nil {
+			err = s.tx.Commit()
+		} else {
+			s.tx.Rollback()
+		}
+	}
+	if s.db != nil {
+		if closeErr := s.db.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		err = os.Rename(s.tmp, s.path)
+	}
+	s.untrack()
+	return err
+}
+
+// copyExactly copies size bytes of r to w, failing if r has fewer.
+func copyExactly(w io.Writer, r io.Reader, size int64) error {
+	buf := getBuffer(bufferSize)
+	defer putBuffer(buf)
+	n, err := io.CopyBuffer(w, io.LimitReader(r, size), buf)
+	if err == nil && n < size {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// archiveFileMode is the mode of the files in archives: that of --mode, or
+// 0644.
+func archiveFileMode() os.FileMode {
+	if fileMode != 0 {
+		return fileMode
+	}
+	return 0644
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var packCmd = &cobra.Command{
+	Use:   "pack <outfile>",
+	Short: "Build a snapshot from database files",
+	Long: `Writes a format 1 dqlite snapshot holding the given database files, the
+reverse of unpacking, so that a repaired database can be fed back to a
+cluster. With --lz4 the snapshot is compressed the way dqlite does it.`,
+	Example: `  dqlite-snapshot-unpack pack snapshot --lz4 --db db=./db,wal=./db-wal --db k8s=./k8s`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    pack,
+
+	SilenceUsage: true,
+}
+
+var (
+	packDBs []string
+	packLZ4 bool
+
+	// verifyRoundtrip is shared by the commands writing snapshots.
+	verifyRoundtrip bool
+	// checkpointOnPack is shared by pack and repack.
+	checkpointOnPack bool
+)
+
+func init() {
+	packCmd.Flags().StringArrayVar(&packDBs, "db", nil, "database to pack, as name=main.db[,wal=main.db-wal] (repeatable)")
+	packCmd.Flags().BoolVar(&packLZ4, "lz4", false, "compress the snapshot with LZ4, as dqlite does")
+	packCmd.Flags().BoolVar(&checkpointOnPack, "checkpoint-on-pack", false, "apply the WAL to the main file, packing an empty WAL")
+	packCmd.Flags().BoolVar(&verifyRoundtrip, "verify-roundtrip", false, "read the snapshot back and compare the hashes of its databases")
+	packCmd.MarkFlagRequired("db")
+	rootCmd.AddCommand(packCmd)
+}
+
+// packSource is a database to pack, along with the files it is read from.
+type packSource struct {
+	entry dbEntry
+	main  string
+	wal   string
+}
+
+func pack(cmd *cobra.Command, args []string) error {
+	var sources []*packSource
+	seen := make(map[string]bool)
+	for _, spec := range packDBs {
+		source, err := parsePackSource(spec)
+		if err != nil {
+			return err
+		}
+		if seen[source.entry.name] {
+			return fmt.Errorf("database %q given twice", source.entry.name)
+		}
+		seen[source.entry.name] = true
+		sources = append(sources, source)
+	}
+
+	if err := writeSnapshot(args[0], sources, packLZ4); err != nil {
+		return fmt.Errorf("couldn't write %s: %w", args[0], err)
+	}
+	fmt.Printf("Packed %d databases into %s\n", len(sources), args[0])
+	return nil
+}
+
+// parsePackSource parses a --db flag of pack, finding out the sizes of the
+// files it names.
+func parsePackSource(spec string) (*packSource, error) {
+	parts := strings.Split(spec, ",")
+	name, main, ok := strings.Cut(parts[0], "=")
+	if !ok || name == "" || main == "" || strings.ContainsRune(name, 0) {
+		return nil, fmt.Errorf("invalid --db %q, expected name=main.db[,wal=main.db-wal]", spec)
+	}
+	source := &packSource{entry: dbEntry{name: name}, main: main}
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+		if key != "wal" || value == "" {
+			return nil, fmt.Errorf("invalid --db %q, expected name=main.db[,wal=main.db-wal]", spec)
+		}
+		source.wal = value
+	}
+
+	info, err := os.Stat(source.main)
+	if err != nil {
+		return nil, err
+	}
+	source.entry.mainSize = uint64(info.Size())
+
+	if source.wal == "" {
+		if _, err := os.Stat(source.main + "-wal"); err == nil {
+			warn("%s-wal exists but isn't packed, add wal=%s-wal to include it",
+				source.main, source.main)
+		}
+		return source, nil
+	}
+	info, err = os.Stat(source.wal)
+	if err != nil {
+		return nil, err
+	}
+	source.entry.walSize = uint64(info.Size())
+	return source, nil
+}
+
+// writeSnapshot writes the snapshot of sources to path, LZ4-compressed if
+// compress is set. With --checkpoint-on-pack, checkpointed copies of the
+// databases are packed instead.
+func writeSnapshot(path string, sources []*packSource, compress bool) error {
+	if checkpointOnPack {
+		dir, cleanup, err := makeTempDir()
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		checkpointed := make([]*packSource, len(sources))
+		for i, source := range sources {
+			if checkpointed[i], err = checkpointCopy(source, filepath.Join(dir, fmt.Sprintf("%d.db", i))); err != nil {
+				return fmt.Errorf("couldn't checkpoint %s: %w", source.entry.name, err)
+			}
+		}
+		sources = checkpointed
+	}
+
+	entries := make([]*dbEntry, len(sources))
+	for i, source := range sources {
+		entries[i] = &source.entry
+	}
+	return createSnapshot(path, entries, compress, func(w io.Writer, i int) error {
+		if err := copyFile(w, sources[i].main, int64(sources[i].entry.mainSize)); err != nil {
+			return err
+		}
+		return copyFile(w, sources[i].wal, int64(sources[i].entry.walSize))
+	})
+}
+
+// checkpointCopy copies the files of source to dst, applies the WAL to the
+// main file there and returns the copy, with an empty WAL.
+func checkpointCopy(source *packSource, dst string) (*packSource, error) {
+	if source.entry.walSize == 0 {
+		return source, nil
+	}
+	for _, file := range []struct {
+		src, dst string
+		size     uint64
+	}{{source.main, dst, source.entry.mainSize}, {source.wal, dst + "-wal", source.entry.walSize}} {
+		out, err := os.Create(file.dst)
+		if err != nil {
+			return nil, err
+		}
+		err = copyFile(out, file.src, int64(file.size))
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Printf("Checkpointing database %s (%d bytes of WAL)...\n", source.entry.name, source.entry.walSize)
+	db, err := openDatabase(dst, false)
+	if err != nil {
+		return nil, err
+	}
+	var busy, frames, checkpointed int
+	err = db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &frames, &checkpointed)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if busy != 0 {
+		return nil, fmt.Errorf("the checkpoint couldn't complete")
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		return nil, err
+	}
+	return &packSource{entry: dbEntry{name: source.entry.name, mainSize: uint64(info.Size())}, main: dst}, nil
+}
+
+// createSnapshot writes a snapshot of entries to path, LZ4-compressed if
+// compress is set. After the header of each entry, content is called to
+// write the main file and the WAL of the i-th one. The snapshot is written
+// aside and only renamed into place once complete and, with
+// --verify-roundtrip, read back.
+//
+// The output is reproducible for a given build: it only depends on the
+// entries, their content and compress, the padding being made of zeros and
+// the compression settings fixed. liblz4 and the pure Go encoder compress
+// differently though, so cgo and purego builds give different (equally
+// valid) LZ4 snapshots.
+func createSnapshot(path string, entries []*dbEntry, compress bool, content func(w io.Writer, i int) error) error {
+	file, untrack, err := createTemp(path)
+	if err != nil {
+		return err
+	}
+	// Removes the snapshot unless renamed into place, once closed.
+	defer untrack()
+	defer file.Close()
+
+	err = writeSnapshotFile(file, entries, compress, content)
+	if err == nil && verifyRoundtrip {
+		err = checkRoundtrip(file.Name(), path, entries)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	return err
+}
+
+func writeSnapshotFile(file *os.File, entries []*dbEntry, compress bool, content func(w io.Writer, i int) error) error {
+	buffered := bufio.NewWriterSize(limitWriter(file), bufferSize)
+	var w io.Writer = buffered
+	var lz4 *lz4Writer
+	if compress {
+		var err error
+		if lz4, err = newLZ4Writer(buffered, snapshotSize(entries)); err != nil {
+			return err
+		}
+		w = lz4
+	}
+
+	snapshot := &snapshotWriter{w}
+	if err := snapshot.writeHeader(uint64(len(entries))); err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		if err := snapshot.writeEntry(entry); err != nil {
+			return err
+		}
+		if !verifyRoundtrip {
+			if err := content(snapshot, i); err != nil {
+				return err
+			}
+			continue
+		}
+		hash := sha256.New()
+		if err := content(io.MultiWriter(snapshot, hash), i); err != nil {
+			return err
+		}
+		entry.sum = hash.Sum(nil)
+	}
+
+	if lz4 != nil {
+		if err := lz4.Close(); err != nil {
+			return err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// checkRoundtrip reads back the snapshot written to file, to end up at path,
+// making sure it holds entries, with the content they had when written.
+func checkRoundtrip(file, path string, entries []*dbEntry) error {
+	snapshot, err := openSnapshot(file)
+	if err != nil {
+		return fmt.Errorf("couldn't read back %s: %w", path, err)
+	}
+	defer snapshot.Close()
+
+	for _, want := range entries {
+		entry, err := snapshot.next()
+		if err != nil {
+			return fmt.Errorf("couldn't read back %s: %w", path, err)
+		}
+		if entry == nil || entry.name != want.name || entry.mainSize != want.mainSize || entry.walSize != want.walSize {
+			return fmt.Errorf("round trip of %s failed: database %s doesn't match", path, want.name)
+		}
+		hash := sha256.New()
+		if _, err := io.CopyN(hash, snapshot, int64(entry.mainSize+entry.walSize)); err != nil {
+			return fmt.Errorf("couldn't read back %s: %w", path, err)
+		}
+		if !bytes.Equal(hash.Sum(nil), want.sum) {
+			return fmt.Errorf("round trip of %s failed: the content of database %s doesn't match", path, want.name)
+		}
+	}
+	if entry, err := snapshot.next(); err != nil || entry != nil {
+		return fmt.Errorf("round trip of %s failed: unexpected databases at the end", path)
+	}
+	if err := snapshot.checkEOF(); err != nil {
+		return fmt.Errorf("round trip of %s failed: %w", path, err)
+	}
+	fmt.Printf("Round trip of %s verified: %d databases match\n", path, len(entries))
+	return nil
+}
+
+// outputCompression tells whether a snapshot derived from one compressed
+// with layers should be LZ4-compressed: as requested with --compression or,
+// by default, like the original.
+func outputCompression(layers []string, flag string) (bool, error) {
+	switch flag {
+	case "":
+	case "none":
+		return false, nil
+	case "lz4":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown compression %q", flag)
+	}
+
+	compress := len(layers) > 0 && layers[len(layers)-1] == "lz4"
+	if compress {
+		layers = layers[:len(layers)-1]
+	}
+	if len(layers) > 0 {
+		warn("the snapshot was compressed with %s as well, which isn't reproduced",
+			strings.Join(layers, ", "))
+	}
+	return compress, nil
+}
+
+// copyFile writes the first size bytes of the file at path to w, failing if
+// the file got shorter in the meantime.
+func copyFile(w io.Writer, path string, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.CopyN(w, limitReader(file), size); err == io.EOF {
+		return fmt.Errorf("%s changed while being packed", path)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// snapshotPageDiff holds the page level differences between two snapshots.
+type snapshotPageDiff struct {
+	Added     []string   `json:"added_databases"`
+	Removed   []string   `json:"removed_databases"`
+	Databases []pageDiff `json:"databases"`
+}
+
+// pageDiff counts the pages of a database found in both snapshots by how they
+// changed. Pages on the freelist on both sides count as unchanged, whatever
+// they hold.
+type pageDiff struct {
+	Name      string `json:"name"`
+	PageSize  int    `json:"page_size"`
+	PagesA    uint32 `json:"pages_a"`
+	PagesB    uint32 `json:"pages_b"`
+	Unchanged int    `json:"unchanged"`
+	Changed   int    `json:"changed"`
+	Added     int    `json:"added"`
+	Freed     int    `json:"freed"`
+	Truncated int    `json:"truncated"`
+}
+
+// diffSnapshotPages compares the databases extracted into dirA and dirB page
+// by page.
+func diffSnapshotPages(dirA string, namesA []string, dirB string, namesB []string) error {
+	result := snapshotPageDiff{Databases: []pageDiff{}}
+	for _, name := range namesA {
+		if !slices.Contains(namesB, name) {
+			result.Removed = append(result.Removed, name)
+		}
+	}
+	for _, name := range namesB {
+		if !slices.Contains(namesA, name) {
+			result.Added = append(result.Added, name)
+			continue
+		}
+		d, err := diffPages(name, filepath.Join(dirA, name), filepath.Join(dirB, name))
+		if err != nil {
+			return fmt.Errorf("couldn't compare %s: %w", name, err)
+		}
+		result.Databases = append(result.Databases, d)
+	}
+
+	if diffJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	printSnapshotPageDiff(&result)
+	return nil
+}
+
+// diffPages compares the pages of the database at pathA with those of the
+// database at pathB, as readers see them, committed WAL frames included:
+// pages past the end of a are added, those past the end of b truncated, and
+// pages in use in a but on the freelist of b freed.
+func diffPages(name, pathA, pathB string) (pageDiff, error) {
+	d := pageDiff{Name: name}
+	a, err := openPager(pathA)
+	if err != nil {
+		return d, err
+	}
+	defer a.Close()
+	b, err := openPager(pathB)
+	if err != nil {
+		return d, err
+	}
+	defer b.Close()
+
+	d.PageSize, d.PagesA, d.PagesB = b.pageSize, a.pageCount, b.pageCount
+	if a.pageSize != b.pageSize {
+		return d, fmt.Errorf("page size changed from %d to %d", a.pageSize, b.pageSize)
+	}
+	freeA, freeB := freelistPages(a), freelistPages(b)
+	for n := uint32(1); n <= max(a.pageCount, b.pageCount); n++ {
+		switch {
+		case n > a.pageCount:
+			d.Added++
+			continue
+		case n > b.pageCount:
+			d.Truncated++
+			continue
+		case freeB[n] && freeA[n]:
+			d.Unchanged++
+			continue
+		case freeB[n]:
+			d.Freed++
+			continue
+		}
+		pageA, err := a.page(n)
+		if err != nil {
+			return d, err
+		}
+		pageB, err := b.page(n)
+		if err != nil {
+			return d, err
+		}
+		if bytes.Equal(pageA, pageB) {
+			d.Unchanged++
+		} else {
+			d.Changed++
+		}
+	}
+	return d, nil
+}
+
+// freelistPages returns the pages on the freelist of the database read by p,
+// indexed by page number. A damaged freelist is followed as far as it goes.
+func freelistPages(p *pager) []bool {
+	w := newPageWalker(p)
+	w.walkFreelist()
+	free := make([]bool, p.pageCount+1)
+	for n, kind := range w.kinds {
+		free[n] = kind == pageKindFreelistTrunk || kind == pageKindFreelistLeaf
+	}
+	return free
+}
+
+func printSnapshotPageDiff(d *snapshotPageDiff) {
+	for _, name := range d.Removed {
+		fmt.Printf("- database %s\n", name)
+	}
+	for _, name := range d.Added {
+		fmt.Printf("+ database %s\n", name)
+	}
+
+	for _, db := range d.Databases {
+		if db.Changed == 0 && db.Added == 0 && db.Freed == 0 && db.Truncated == 0 {
+			fmt.Printf("= database %s: %d pages of %d bytes\n", db.Name, db.PagesB, db.PageSize)
+			continue
+		}
+		fmt.Printf("~ database %s: %d -> %d pages of %d bytes\n", db.Name, db.PagesA, db.PagesB, db.PageSize)
+		fmt.Printf("    %d changed, %d added, %d freed, %d truncated, %d unchanged\n",
+			db.Changed, db.Added, db.Freed, db.Truncated, db.Unchanged)
+	}
+}
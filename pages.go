@@ -0,0 +1,506 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"unicode/utf16"
+
+	"github.com/spf13/cobra"
+)
+
+var pagesCmd = &cobra.Command{
+	Use:   "pages <snapshot>",
+	Short: "Report the page layout of the databases in a snapshot",
+	Long: `Walks the pages of each database in the snapshot (with the committed WAL
+frames applied) and reports page types, per-object page counts and pages that
+look suspicious, without the need for the sqlite3 cli.`,
+	Args: snapshotArg,
+	RunE: pages,
+
+	SilenceUsage: true,
+}
+
+var pagesDB string
+
+func init() {
+	pagesCmd.Flags().StringVar(&pagesDB, "db", "", "only inspect the named database")
+	rootCmd.AddCommand(pagesCmd)
+}
+
+func pages(cmd *cobra.Command, args []string) error {
+	path, err := snapshotPath(args)
+	if err != nil {
+		return err
+	}
+	dir, names, cleanup, err := extractTemp(path, onlyDatabase(pagesDB))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if pagesDB != "" && len(names) == 0 {
+		return fmt.Errorf("database %q not found in snapshot", pagesDB)
+	}
+
+	for _, name := range names {
+		layout, err := analyzePages(filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Errorf("couldn't inspect %s: %w", name, err)
+		}
+		printPageLayout(name, layout)
+	}
+	return nil
+}
+
+// pageKind classifies the pages of a database file.
+type pageKind int
+
+const (
+	pageUnused pageKind = iota
+	pageKindTableInterior
+	pageKindTableLeaf
+	pageKindIndexInterior
+	pageKindIndexLeaf
+	pageKindOverflow
+	pageKindFreelistTrunk
+	pageKindFreelistLeaf
+	pageKindPtrmap
+	pageKindLockByte
+	pageKindCount
+)
+
+var pageKindNames = [pageKindCount]string{
+	pageUnused:            "unreferenced",
+	pageKindTableInterior: "table interior",
+	pageKindTableLeaf:     "table leaf",
+	pageKindIndexInterior: "index interior",
+	pageKindIndexLeaf:     "index leaf",
+	pageKindOverflow:      "overflow",
+	pageKindFreelistTrunk: "freelist trunk",
+	pageKindFreelistLeaf:  "freelist leaf",
+	pageKindPtrmap:        "pointer map",
+	pageKindLockByte:      "lock byte",
+}
+
+func (k pageKind) String() string {
+	return pageKindNames[k]
+}
+
+// pageObject is a b-tree found in the schema, with the pages it uses.
+type pageObject struct {
+	Name    string
+	Type    string
+	TblName string
+	Root    uint32
+	SQL     string
+	Pages   int
+
+	Leaves    int   // leaf pages
+	Scattered int   // leaf pages not right before the previous one in key order
+	Payload   int64 // bytes of the rows or keys, overflow included
+	Unused    int64 // bytes of the b-tree pages holding no cell
+	BadCells  int64 // cells of leaf pages that couldn't be decoded
+	lastLeaf  uint32
+}
+
+// pageLayout is the result of walking all the pages of a database.
+type pageLayout struct {
+	PageSize   int
+	PageCount  uint32
+	WALFrames  int
+	AutoVacuum string
+	// ReservedBytes at the end of each page, where the checksum VFS
+	// stores its checksums, which ChecksumFailures pages fail.
+	ReservedBytes    int
+	ChecksumFailures int
+	Kinds            [pageKindCount]int
+	Objects          []*pageObject
+	Suspicious       []string
+}
+
+// pageWalker keeps track of which pages have been reached while walking the
+// structures of a database.
+type pageWalker struct {
+	p      *pager
+	layout *pageLayout
+	kinds  []pageKind
+	owners []string
+	// pointers holds what the pointer map of an auto-vacuum database should
+	// say about each page.
+	pointers []ptrmapEntry
+}
+
+// Types of the entries of the pointer map.
+const (
+	ptrmapRootPage  = 1
+	ptrmapFreePage  = 2
+	ptrmapOverflow1 = 3
+	ptrmapOverflow2 = 4
+	ptrmapBtree     = 5
+)
+
+// ptrmapEntry is an entry of the pointer map: the type of a page and the
+// page pointing to it, if any.
+type ptrmapEntry struct {
+	Type   byte
+	Parent uint32
+}
+
+// mark records that page n is of the given kind and belongs to owner. It
+// returns false, after logging why, if the page can't be visited: it is out
+// of range or it was already reached from somewhere else.
+func (w *pageWalker) mark(n uint32, kind pageKind, owner string) bool {
+	if n < 1 || n > w.p.pageCount {
+		w.suspicious("page %d, referenced by %s, is out of range", n, owner)
+		return false
+	}
+	if w.kinds[n] != pageUnused {
+		w.suspicious("page %d is used both as %s of %s and as %s of %s",
+			n, w.kinds[n], w.owners[n], kind, owner)
+		return false
+	}
+	w.kinds[n] = kind
+	w.owners[n] = owner
+	return true
+}
+
+func (w *pageWalker) suspicious(format string, args ...any) {
+	w.layout.Suspicious = append(w.layout.Suspicious, fmt.Sprintf(format, args...))
+}
+
+func newPageWalker(p *pager) *pageWalker {
+	return &pageWalker{
+		p: p,
+		layout: &pageLayout{
+			PageSize:      p.pageSize,
+			PageCount:     p.pageCount,
+			WALFrames:     len(p.frames),
+			AutoVacuum:    p.header.autoVacuum(),
+			ReservedBytes: int(p.header.ReservedBytes),
+		},
+		kinds:    make([]pageKind, p.pageCount+1),
+		owners:   make([]string, p.pageCount+1),
+		pointers: make([]ptrmapEntry, p.pageCount+1),
+	}
+}
+
+// walkSchema marks the pages whose role follows from the header alone (lock
+// byte, pointer map and freelist) and those of the schema table, collecting
+// the b-trees it defines into the layout. The b-trees themselves are left for
+// the caller to walk.
+func (w *pageWalker) walkSchema() {
+	p := w.p
+
+	// The lock-byte page is never used, whatever the database contains.
+	if lockByte := uint32(1<<30/p.pageSize) + 1; lockByte <= p.pageCount {
+		w.mark(lockByte, pageKindLockByte, "the file format")
+	}
+	w.walkPtrmap()
+	w.walkFreelist()
+
+	schema := &pageObject{Name: "sqlite_schema", Type: "table", TblName: "sqlite_schema", Root: 1}
+	w.layout.Objects = append(w.layout.Objects, schema)
+	w.walkBtree(schema, func(c btreeCell) {
+		payload, err := p.readPayload(c, nil)
+		if err != nil {
+			w.suspicious("couldn't read schema row %d: %v", c.Rowid, err)
+			return
+		}
+		values, err := parseRecord(payload)
+		if err != nil || len(values) < 5 {
+			w.suspicious("schema row %d is malformed", c.Rowid)
+			return
+		}
+		root, _ := values[3].(int64)
+		if root <= 0 {
+			return
+		}
+		w.layout.Objects = append(w.layout.Objects, &pageObject{
+			Type:    p.text(values[0]),
+			Name:    p.text(values[1]),
+			TblName: p.text(values[2]),
+			Root:    uint32(root),
+			SQL:     p.text(values[4]),
+		})
+	})
+}
+
+// analyzePages walks every structure of the database at path and classifies
+// its pages.
+func analyzePages(path string) (*pageLayout, error) {
+	p, err := openPager(path)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	w := newPageWalker(p)
+	layout := w.layout
+	w.walkSchema()
+	for _, o := range layout.Objects[1:] {
+		w.walkBtree(o, nil)
+	}
+	w.checkPtrmap()
+	failed, err := checksumFailures(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range failed {
+		w.suspicious("page %d fails its checksum", n)
+	}
+	layout.ChecksumFailures = len(failed)
+
+	for n := uint32(1); n <= p.pageCount; n++ {
+		kind := w.kinds[n]
+		layout.Kinds[kind]++
+		if kind == pageUnused {
+			w.suspicious("page %d is not referenced by anything", n)
+		}
+	}
+	if free := layout.Kinds[pageKindFreelistTrunk] + layout.Kinds[pageKindFreelistLeaf]; uint32(free) != p.header.FreelistCount {
+		w.suspicious("header declares %d freelist pages but %d were found", p.header.FreelistCount, free)
+	}
+	return layout, nil
+}
+
+// walkBtree visits all the pages of the b-tree of o, calling leaf (if not
+// nil) on each cell of its leaf pages.
+func (w *pageWalker) walkBtree(o *pageObject, leaf func(c btreeCell)) {
+	if !w.mark(o.Root, pageUnused, o.Name) {
+		return
+	}
+	w.pointers[o.Root] = ptrmapEntry{ptrmapRootPage, 0}
+
+	stack := []uint32{o.Root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		o.Pages++
+
+		data, err := w.p.page(n)
+		if err != nil {
+			w.suspicious("%v", err)
+			continue
+		}
+		bp, err := parseBtreePage(n, data)
+		if err != nil {
+			w.suspicious("page %d of %s: %v", n, o.Name, err)
+			continue
+		}
+		w.kinds[n] = btreePageKind(bp.Type)
+		o.Unused += int64(bp.unused(data))
+		if bp.IsLeaf() {
+			// Leaves come last to first, as the stack pops the right
+			// child first: in a compact b-tree, each one comes right
+			// before the previous.
+			if o.Leaves > 0 && n+1 != o.lastLeaf {
+				o.Scattered++
+			}
+			o.Leaves++
+			o.lastLeaf = n
+		}
+
+		for _, offset := range bp.Cells {
+			c, err := parseCell(data, offset, bp.Type, w.p.usableSize())
+			if err != nil {
+				w.suspicious("page %d of %s: %v", n, o.Name, err)
+				if bp.IsLeaf() {
+					o.BadCells++
+				}
+				continue
+			}
+			// Cells of index interior pages carry keys too, which
+			// overflow like those of leaves.
+			o.Payload += c.Size
+			if c.Overflow != 0 {
+				w.walkOverflow(o, n, c)
+			}
+			if !bp.IsLeaf() {
+				if w.mark(c.LeftChild, pageUnused, o.Name) {
+					w.pointers[c.LeftChild] = ptrmapEntry{ptrmapBtree, n}
+					stack = append(stack, c.LeftChild)
+				}
+				continue
+			}
+			if leaf != nil {
+				leaf(c)
+			}
+		}
+		if !bp.IsLeaf() && w.mark(bp.RightChild, pageUnused, o.Name) {
+			w.pointers[bp.RightChild] = ptrmapEntry{ptrmapBtree, n}
+			stack = append(stack, bp.RightChild)
+		}
+	}
+}
+
+// walkOverflow marks the overflow chain of c, a cell of page, as belonging
+// to o.
+func (w *pageWalker) walkOverflow(o *pageObject, page uint32, c btreeCell) {
+	entry := ptrmapEntry{ptrmapOverflow1, page}
+	_, err := w.p.readPayload(c, func(n uint32) error {
+		if !w.mark(n, pageKindOverflow, o.Name) {
+			return fmt.Errorf("broken overflow chain")
+		}
+		w.pointers[n] = entry
+		entry = ptrmapEntry{ptrmapOverflow2, n}
+		o.Pages++
+		return nil
+	})
+	if err != nil {
+		w.suspicious("overflow chain of %s row %d: %v", o.Name, c.Rowid, err)
+	}
+}
+
+// walkFreelist marks the trunk and leaf pages of the freelist.
+func (w *pageWalker) walkFreelist() {
+	trunk := w.p.header.FreelistTrunk
+	for trunk != 0 {
+		if !w.mark(trunk, pageKindFreelistTrunk, "the freelist") {
+			return
+		}
+		w.pointers[trunk] = ptrmapEntry{ptrmapFreePage, 0}
+		data, err := w.p.page(trunk)
+		if err != nil {
+			w.suspicious("%v", err)
+			return
+		}
+
+		count := binary.BigEndian.Uint32(data[4:])
+		if int(count) > (w.p.usableSize()-8)/4 {
+			w.suspicious("freelist trunk page %d declares %d leaves", trunk, count)
+			return
+		}
+		for i := range int(count) {
+			if leaf := binary.BigEndian.Uint32(data[8+4*i:]); w.mark(leaf, pageKindFreelistLeaf, "the freelist") {
+				w.pointers[leaf] = ptrmapEntry{ptrmapFreePage, 0}
+			}
+		}
+		trunk = binary.BigEndian.Uint32(data)
+	}
+}
+
+// walkPtrmap marks the pointer map pages of auto-vacuum databases, which
+// checkPtrmap reads once everything else has been walked.
+func (w *pageWalker) walkPtrmap() {
+	if w.p.header.LargestRootPage == 0 {
+		return
+	}
+	// Each pointer map page describes the usable/5 pages following it.
+	step := uint32(w.p.usableSize()/5) + 1
+	for n := uint32(2); n <= w.p.pageCount; n += step {
+		w.mark(n, pageKindPtrmap, "the pointer map")
+	}
+}
+
+// checkPtrmap compares the pointer map of an auto-vacuum database with what
+// the walk found each page to be.
+func (w *pageWalker) checkPtrmap() {
+	if w.p.header.LargestRootPage == 0 {
+		return
+	}
+	step := uint32(w.p.usableSize()/5) + 1
+	for ptrmap := uint32(2); ptrmap <= w.p.pageCount; ptrmap += step {
+		data, err := w.p.page(ptrmap)
+		if err != nil {
+			w.suspicious("%v", err)
+			return
+		}
+		for n := ptrmap + 1; n < ptrmap+step && n <= w.p.pageCount; n++ {
+			want := w.pointers[n]
+			if want.Type == 0 {
+				// Unreferenced and lock-byte pages have no say.
+				continue
+			}
+			raw := data[5*(n-ptrmap-1):]
+			got := ptrmapEntry{raw[0], binary.BigEndian.Uint32(raw[1:])}
+			if got != want {
+				w.suspicious("the pointer map says page %d is %s, it is %s", n, got, want)
+			}
+		}
+	}
+}
+
+func (e ptrmapEntry) String() string {
+	switch e.Type {
+	case ptrmapRootPage:
+		return "a root page"
+	case ptrmapFreePage:
+		return "a free page"
+	case ptrmapOverflow1:
+		return fmt.Sprintf("the first overflow page of a cell of page %d", e.Parent)
+	case ptrmapOverflow2:
+		return fmt.Sprintf("the overflow page following page %d", e.Parent)
+	case ptrmapBtree:
+		return fmt.Sprintf("a b-tree page under page %d", e.Parent)
+	}
+	return fmt.Sprintf("of type %d under page %d", e.Type, e.Parent)
+}
+
+func btreePageKind(t byte) pageKind {
+	switch t {
+	case pageTableInterior:
+		return pageKindTableInterior
+	case pageTableLeaf:
+		return pageKindTableLeaf
+	case pageIndexInterior:
+		return pageKindIndexInterior
+	default:
+		return pageKindIndexLeaf
+	}
+}
+
+// text decodes a text value of a record according to the database encoding.
+func (p *pager) text(v any) string {
+	s, _ := v.(string)
+	if p.header.TextEncoding != 2 && p.header.TextEncoding != 3 {
+		return s
+	}
+
+	units := make([]uint16, len(s)/2)
+	for i := range units {
+		if p.header.TextEncoding == 2 {
+			units[i] = binary.LittleEndian.Uint16([]byte(s[2*i:]))
+		} else {
+			units[i] = binary.BigEndian.Uint16([]byte(s[2*i:]))
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func printPageLayout(name string, layout *pageLayout) {
+	fmt.Printf("Database %s: %d pages of %d bytes (%d pages from the WAL)\n",
+		name, layout.PageCount, layout.PageSize, layout.WALFrames)
+	fmt.Printf("  Auto-vacuum: %s\n", layout.AutoVacuum)
+	switch layout.ReservedBytes {
+	case 0:
+	case cksumReservedBytes:
+		fmt.Printf("  Reserved bytes: %d per page, checksum VFS, %d pages failing their checksum\n",
+			layout.ReservedBytes, layout.ChecksumFailures)
+	default:
+		fmt.Printf("  Reserved bytes: %d per page\n", layout.ReservedBytes)
+	}
+
+	fmt.Printf("  Page types:\n")
+	for kind := pageKindTableInterior; kind < pageKindCount; kind++ {
+		if layout.Kinds[kind] > 0 {
+			fmt.Printf("    %-16s %d\n", kind.String()+":", layout.Kinds[kind])
+		}
+	}
+	if layout.Kinds[pageUnused] > 0 {
+		fmt.Printf("    %-16s %d\n", pageUnused.String()+":", layout.Kinds[pageUnused])
+	}
+
+	fmt.Printf("  Pages per object:\n")
+	for _, o := range layout.Objects {
+		fmt.Printf("    %-6s %-30s %d\n", o.Type, o.Name, o.Pages)
+	}
+
+	if len(layout.Suspicious) > 0 {
+		fmt.Printf("  Suspicious pages:\n")
+		for _, s := range layout.Suspicious {
+			fmt.Printf("    %s\n", s)
+		}
+	}
+	fmt.Println()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	modeFlag  string
+	ownerFlag string
+
+	// fileMode, when not 0, is the exact mode of the extracted files.
+	// Otherwise they are created 0666 minus the umask.
+	fileMode os.FileMode
+	// fileUID and fileGID, when not -1, are the owner of the extracted files.
+	fileUID = -1
+	fileGID = -1
+)
+
+// parsePermissions checks --mode and --owner.
+func parsePermissions() error {
+	if modeFlag != "" {
+		mode, err := strconv.ParseUint(modeFlag, 8, 32)
+		if err != nil || mode == 0 || mode > 0777 {
+			return fmt.Errorf("invalid --mode %q, expected octal permissions such as 0600", modeFlag)
+		}
+		fileMode = os.FileMode(mode)
+	}
+
+	if ownerFlag == "" {
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("--owner requires running as root")
+	}
+	owner, group, _ := strings.Cut(ownerFlag, ":")
+	uid, gid, err := lookupOwner(owner)
+	if err != nil {
+		return err
+	}
+	if group != "" {
+		if gid, err = lookupGroup(group); err != nil {
+			return err
+		}
+	}
+	fileUID, fileGID = uid, gid
+	return nil
+}
+
+// lookupOwner resolves a user name or ID, returning it along with its
+// primary group.
+func lookupOwner(name string) (int, int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return 0, 0, fmt.Errorf("unknown user %q", name)
+		}
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	return uid, gid, nil
+}
+
+func lookupGroup(name string) (int, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		if g, err = user.LookupGroupId(name); err != nil {
+			return 0, fmt.Errorf("unknown group %q", name)
+		}
+	}
+	gid, _ := strconv.Atoi(g.Gid)
+	return gid, nil
+}
+
+// createFileMode is the mode new output files are created with.
+func createFileMode() os.FileMode {
+	if fileMode != 0 {
+		return fileMode
+	}
+	return 0666
+}
+
+// setPermissions applies --mode (regardless of the umask) and --owner to
+// file, if given.
+func setPermissions(file *os.File) error {
+	if fileMode != 0 {
+		if err := file.Chmod(fileMode); err != nil {
+			return err
+		}
+	}
+	if fileUID != -1 {
+		return file.Chown(fileUID, fileGID)
+	}
+	return nil
+}
+
+// applyPermissions applies --mode and --owner to the files of the databases
+// in dir and to the manifest, as some are created by SQLite rather than by
+// extract.
+func applyPermissions(dir string, names []string) error {
+	if fileMode == 0 && fileUID == -1 {
+		return nil
+	}
+
+	paths := []string{filepath.Join(dir, taggedName(manifestName))}
+	for _, name := range names {
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			paths = append(paths, filepath.Join(dir, name+suffix))
+		}
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		err = setPermissions(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("couldn't set the permissions of %s: %w", path, err)
+		}
+	}
+	return nil
+}
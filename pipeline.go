@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// pipelineDepth is how many chunks the stages of the extraction pipeline
+// may run ahead of each other.
+const pipelineDepth = 4
+
+const (
+	minBufferSize = 4 * 1024
+	maxBufferSize = 64 * 1024 * 1024
+
+	// pipelineBuffers is how many buffers of bufferSize the extraction of a
+	// snapshot with a single layer of compression keeps around: those of
+	// the stages reading the input, decompressing and writing, plus the
+	// ones of the decompressor itself and the write buffer, counted as one
+	// as well.
+	pipelineBuffers = 3*(pipelineDepth+1) + 3
+)
+
+var (
+	// bufferSize is the size of the chunks data moves through the pipeline
+	// in, set with --buffer-size.
+	bufferSize = 64 * 1024
+	// writeBufferSize is how much is written to the extracted files at a
+	// time, set with --write-buffer. Larger writes keep disks busier.
+	writeBufferSize = 1024 * 1024
+	// maxMemory, when not 0, caps (approximately) the memory taken by the
+	// buffers of the pipeline and by blocks decoded in parallel.
+	maxMemory int64
+)
+
+// configureMemory checks --buffer-size and --write-buffer, shrinking them as
+// needed to fit in --max-memory.
+func configureMemory() error {
+	if bufferSize < minBufferSize || bufferSize > maxBufferSize {
+		return fmt.Errorf("--buffer-size must be between %s and %s",
+			formatBytes(minBufferSize), formatBytes(maxBufferSize))
+	}
+	if writeBufferSize < minBufferSize || writeBufferSize > maxBufferSize {
+		return fmt.Errorf("--write-buffer must be between %s and %s",
+			formatBytes(minBufferSize), formatBytes(maxBufferSize))
+	}
+	if maxMemory == 0 {
+		return nil
+	}
+
+	size := maxMemory / pipelineBuffers
+	if size < minBufferSize {
+		return fmt.Errorf("--max-memory must be at least %s", formatBytes(minBufferSize*pipelineBuffers))
+	}
+	bufferSize = min(bufferSize, int(size)/minBufferSize*minBufferSize)
+	writeBufferSize = min(writeBufferSize, bufferSize)
+	return nil
+}
+
+// buffers recycles the buffers of the pipeline and of the decoders, so that
+// repeated extractions don't churn the GC. They are pooled by capacity.
+var buffers sync.Map // int to *sync.Pool of *[]byte
+
+// getBuffer returns a buffer of size bytes, recycled if possible. Its
+// content is undefined.
+func getBuffer(size int) []byte {
+	if pool, ok := buffers.Load(size); ok {
+		if b, ok := pool.(*sync.Pool).Get().(*[]byte); ok {
+			return (*b)[:size]
+		}
+	}
+	return make([]byte, size)
+}
+
+// putBuffer hands b over for reuse: it mustn't be used anymore.
+func putBuffer(b []byte) {
+	if cap(b) == 0 {
+		return
+	}
+	pool, _ := buffers.LoadOrStore(cap(b), &sync.Pool{})
+	b = b[:cap(b)]
+	pool.(*sync.Pool).Put(&b)
+}
+
+// parallelBlocks returns how many compressed blocks of up to blockSize bytes
+// may be decoded at the same time, within what --max-memory leaves to them.
+func parallelBlocks(blockSize int) int {
+	workers := runtime.GOMAXPROCS(0)
+	if maxMemory == 0 {
+		return workers
+	}
+
+	// Each block in flight takes a compressed and a decompressed buffer,
+	// and up to twice as many blocks as workers are queued.
+	left := maxMemory - int64(pipelineBuffers*bufferSize)
+	return min(workers, int(left/int64(2*blockSize)-1)/3)
+}
+
+// aheadReader reads its source from a separate goroutine, staying up to
+// pipelineDepth chunks ahead of the consumer. Stacked on each layer of
+// decompression, it lets input reading, decompression and output writing
+// proceed concurrently.
+type aheadReader struct {
+	chunks chan aheadChunk
+	free   chan []byte
+	done   chan struct{}
+	exited chan struct{}
+
+	current aheadChunk
+	offset  int
+}
+
+type aheadChunk struct {
+	data []byte
+	err  error
+}
+
+func newAheadReader(r io.Reader, size int) *aheadReader {
+	a := &aheadReader{
+		chunks: make(chan aheadChunk, pipelineDepth),
+		free:   make(chan []byte, pipelineDepth+1),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	for range pipelineDepth + 1 {
+		a.free <- getBuffer(size)
+	}
+	go a.fill(r)
+	return a
+}
+
+func (a *aheadReader) fill(r io.Reader) {
+	defer close(a.exited)
+	for {
+		var buf []byte
+		select {
+		case buf = <-a.free:
+		case <-a.done:
+			return
+		}
+
+		n, err := r.Read(buf)
+		select {
+		case a.chunks <- aheadChunk{data: buf[:n], err: err}:
+		case <-a.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (a *aheadReader) Read(p []byte) (int, error) {
+	for a.offset == len(a.current.data) {
+		if a.current.err != nil {
+			return 0, a.current.err
+		}
+		if a.current.data != nil {
+			a.free <- a.current.data[:cap(a.current.data)]
+		}
+
+		select {
+		case a.current = <-a.chunks:
+		case <-a.done:
+			return 0, io.ErrClosedPipe
+		}
+		a.offset = 0
+	}
+
+	n := copy(p, a.current.data[a.offset:])
+	a.offset += n
+	return n, nil
+}
+
+// Close stops the reading goroutine and waits for it to be done with the
+// source, which can then be safely closed. The buffers nobody holds anymore
+// are recycled.
+func (a *aheadReader) Close() error {
+	close(a.done)
+	<-a.exited
+	for {
+		select {
+		case buf := <-a.free:
+			putBuffer(buf)
+		case chunk := <-a.chunks:
+			putBuffer(chunk.data)
+		default:
+			return nil
+		}
+	}
+}
+
+// aheadWriter writes to its destination from a separate goroutine, so that
+// the producer can go on while previous chunks are being written.
+type aheadWriter struct {
+	chunks chan []byte
+	free   chan []byte
+	failed chan struct{}
+	exited chan struct{}
+	err    error
+
+	buf []byte
+}
+
+func newAheadWriter(w io.Writer, size int) *aheadWriter {
+	a := &aheadWriter{
+		chunks: make(chan []byte, pipelineDepth),
+		free:   make(chan []byte, pipelineDepth+1),
+		failed: make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	for range pipelineDepth {
+		a.free <- getBuffer(size)[:0]
+	}
+	a.buf = getBuffer(size)[:0]
+	go a.drain(w)
+	return a
+}
+
+func (a *aheadWriter) drain(w io.Writer) {
+	defer close(a.exited)
+	for chunk := range a.chunks {
+		if a.err == nil {
+			if _, err := w.Write(chunk); err != nil {
+				a.err = err
+				close(a.failed)
+			}
+		}
+		a.free <- chunk[:0]
+	}
+}
+
+func (a *aheadWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		select {
+		case <-a.failed:
+			return written, a.err
+		default:
+		}
+
+		n := copy(a.buf[len(a.buf):cap(a.buf)], p)
+		a.buf = a.buf[:len(a.buf)+n]
+		p = p[n:]
+		written += n
+
+		if len(a.buf) == cap(a.buf) {
+			a.chunks <- a.buf
+			a.buf = <-a.free
+		}
+	}
+	return written, nil
+}
+
+// Close writes out whatever is still buffered and waits for the writing
+// goroutine, returning the first error it ran into.
+func (a *aheadWriter) Close() error {
+	if len(a.buf) > 0 {
+		a.chunks <- a.buf
+	} else {
+		putBuffer(a.buf)
+	}
+	a.buf = nil
+	close(a.chunks)
+	<-a.exited
+	for len(a.free) > 0 {
+		putBuffer(<-a.free)
+	}
+	return a.err
+}
+
+// workerPool runs functions on up to a given number of goroutines at a time,
+// keeping the first error they return.
+type workerPool struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	err   error
+}
+
+func newWorkerPool(n int) *workerPool {
+	return &workerPool{slots: make(chan struct{}, max(n, 1))}
+}
+
+// run waits for a free goroutine and starts f on it, unless a function
+// failed already, whose error it returns instead.
+func (p *workerPool) run(f func() error) error {
+	p.slots <- struct{}{}
+	if err := p.failed(); err != nil {
+		<-p.slots
+		return err
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.slots }()
+		if err := f(); err != nil {
+			p.mu.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// wait waits for the running functions, returning the first error.
+func (p *workerPool) wait() error {
+	p.wg.Wait()
+	return p.failed()
+}
+
+func (p *workerPool) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+var (
+	cpuProfile string
+	memProfile string
+	pprofAddr  string
+
+	cpuProfileFile *os.File
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&cpuProfile, "cpuprofile", "", "write a CPU profile of the run to this file")
+	rootCmd.PersistentFlags().StringVar(&memProfile, "memprofile", "", "write a heap profile to this file at the end of the run")
+	rootCmd.PersistentFlags().StringVar(&pprofAddr, "pprof-addr", "", "serve the net/http/pprof endpoints on this address (e.g. localhost:6060)")
+}
+
+// startProfiling sets up what the profiling flags ask for.
+func startProfiling() error {
+	if pprofAddr != "" {
+		listener, err := net.Listen("tcp", pprofAddr)
+		if err != nil {
+			return fmt.Errorf("couldn't serve pprof: %w", err)
+		}
+		logger.Info(fmt.Sprintf("Serving pprof on http://%s/debug/pprof/", listener.Addr()))
+		go http.Serve(listener, nil)
+	}
+
+	if cpuProfile != "" {
+		file, err := os.Create(cpuProfile)
+		if err != nil {
+			return fmt.Errorf("couldn't create the CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return fmt.Errorf("couldn't start the CPU profile: %w", err)
+		}
+		cpuProfileFile = file
+	}
+	return nil
+}
+
+// stopProfiling writes out the profiles, whether the run succeeded or not.
+func stopProfiling() {
+	if cpuProfileFile != nil {
+		pprof.StopCPUProfile()
+		cpuProfileFile.Close()
+	}
+
+	if memProfile != "" {
+		file, err := os.Create(memProfile)
+		if err != nil {
+			warn("couldn't create the heap profile: %v", err)
+			return
+		}
+		defer file.Close()
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(file); err != nil {
+			warn("couldn't write the heap profile: %v", err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var queryCmd = &cobra.Command{
+	Use:   "query <snapshot> <sql>",
+	Short: "Run an SQL query against a database in a snapshot",
+	Long: `Extracts a database of the snapshot to a temporary directory, runs the given
+SQL against it (read-only) and prints the results.`,
+	Args: cobra.ExactArgs(2),
+	RunE: query,
+
+	SilenceUsage: true,
+}
+
+var (
+	queryDB     string
+	queryFormat string
+)
+
+func init() {
+	queryCmd.Flags().StringVar(&queryDB, "db", "", "database to query (required if the snapshot has more than one)")
+	queryCmd.Flags().StringVar(&queryFormat, "format", "table", "output format, one of table, csv or json")
+	rootCmd.AddCommand(queryCmd)
+}
+
+func query(cmd *cobra.Command, args []string) error {
+	var writeResult func(w io.Writer, rows *sql.Rows) error
+	switch queryFormat {
+	case "table":
+		writeResult = writeTable
+	case "csv":
+		writeResult = writeCSV
+	case "json":
+		writeResult = writeJSON
+	default:
+		return fmt.Errorf("unknown output format %q", queryFormat)
+	}
+
+	dir, names, cleanup, err := extractTemp(args[0], onlyDatabase(queryDB))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	name, err := pickDatabase(names, queryDB)
+	if err != nil {
+		return err
+	}
+
+	return withDatabase(filepath.Join(dir, name), func(db *sql.DB) error {
+		rows, err := db.Query(args[1])
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		out := bufio.NewWriter(os.Stdout)
+		if err := writeResult(out, rows); err != nil {
+			return err
+		}
+		return out.Flush()
+	})
+}
+
+// pickDatabase returns the database selected with a --db flag among the
+// extracted ones, defaulting to the only database of the snapshot.
+func pickDatabase(names []string, want string) (string, error) {
+	switch {
+	case want != "" && len(names) == 0:
+		return "", fmt.Errorf("database %q not found in snapshot", want)
+	case want != "":
+		return want, nil
+	case len(names) == 0:
+		return "", fmt.Errorf("snapshot contains no databases")
+	case len(names) > 1:
+		return "", fmt.Errorf("snapshot contains %d databases, select one with --db: %s",
+			len(names), strings.Join(names, ", "))
+	default:
+		return names[0], nil
+	}
+}
+
+// writeTable writes rows as aligned columns under a header line.
+func writeTable(w io.Writer, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+
+	record := make([]string, len(columns))
+	err = scanRows(rows, func(values []any) error {
+		for i, value := range values {
+			if value == nil {
+				record[i] = "NULL"
+			} else {
+				record[i] = formatValue(value)
+			}
+		}
+		_, err := fmt.Fprintln(tw, strings.Join(record, "\t"))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Flush()
+}
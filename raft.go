@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The files raft keeps next to the snapshots in a dqlite data directory, all
+// of them little endian: metadata1 and metadata2, the .meta file of each
+// snapshot and the segments of the log.
+
+const (
+	raftFormat           = 1
+	raftMetadataSize     = 32
+	raftSnapshotMetaSize = 32
+	raftEntryHeaderSize  = 16
+)
+
+// Types of raft log entries.
+const (
+	raftCommand = 1
+	raftBarrier = 2
+	raftChange  = 3
+)
+
+// raftMetadata is the content of metadata1 or metadata2, the one with the
+// higher version being current.
+type raftMetadata struct {
+	Version  uint64 `json:"version"`
+	Term     uint64 `json:"term"`
+	VotedFor uint64 `json:"voted_for"`
+}
+
+func readRaftMetadata(path string) (*raftMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != raftMetadataSize {
+		return nil, fmt.Errorf("%d bytes instead of %d", len(data), raftMetadataSize)
+	}
+	if format := binary.LittleEndian.Uint64(data); format != raftFormat {
+		return nil, fmt.Errorf("unknown format %d", format)
+	}
+	return &raftMetadata{
+		Version:  binary.LittleEndian.Uint64(data[8:]),
+		Term:     binary.LittleEndian.Uint64(data[16:]),
+		VotedFor: binary.LittleEndian.Uint64(data[24:]),
+	}, nil
+}
+
+// raftServer is a member of the cluster configuration.
+type raftServer struct {
+	ID      uint64 `json:"id"`
+	Address string `json:"address"`
+	Role    uint8  `json:"role"`
+}
+
+// Roles of the servers in a configuration.
+const (
+	raftStandby = 0
+	raftVoter   = 1
+	raftSpare   = 2
+)
+
+func (s raftServer) roleName() string {
+	switch s.Role {
+	case raftStandby:
+		return "standby"
+	case raftVoter:
+		return "voter"
+	case raftSpare:
+		return "spare"
+	}
+	return fmt.Sprintf("role %d", s.Role)
+}
+
+// decodeConfiguration decodes a cluster configuration: a format byte, the
+// number of servers, and then the ID, null-terminated address and role of
+// each of them.
+func decodeConfiguration(data []byte) ([]raftServer, error) {
+	if len(data) < 9 {
+		return nil, fmt.Errorf("configuration too short")
+	}
+	if data[0] != raftFormat {
+		return nil, fmt.Errorf("unknown configuration format %d", data[0])
+	}
+	n := binary.LittleEndian.Uint64(data[1:])
+	data = data[9:]
+	var servers []raftServer
+	for i := uint64(0); i < n; i++ {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("configuration truncated at server %d", i)
+		}
+		s := raftServer{ID: binary.LittleEndian.Uint64(data)}
+		end := strings.IndexByte(string(data[8:]), 0)
+		if end < 0 || len(data) < 8+end+2 {
+			return nil, fmt.Errorf("configuration truncated at server %d", i)
+		}
+		s.Address, s.Role = string(data[8:8+end]), data[8+end+1]
+		data = data[8+end+2:]
+		servers = append(servers, s)
+	}
+	return servers, nil
+}
+
+// snapshotMeta is the content of the .meta file of a snapshot.
+type snapshotMeta struct {
+	ConfigurationIndex uint64       `json:"configuration_index"`
+	Servers            []raftServer `json:"servers"`
+
+	// stored and computed are the CRC32 found in the file and the one of
+	// its content.
+	stored, computed uint32
+}
+
+// readSnapshotMeta reads the .meta file at path, checking the CRC32 that
+// covers the configuration and its index.
+func readSnapshotMeta(path string) (*snapshotMeta, error) {
+	meta, err := loadSnapshotMeta(path)
+	if err != nil {
+		return nil, err
+	}
+	if meta.stored != meta.computed {
+		return nil, fmt.Errorf("checksum mismatch: stored %08x, computed %08x", meta.stored, meta.computed)
+	}
+	return meta, nil
+}
+
+// loadSnapshotMeta reads the .meta file at path, whatever its CRC32.
+func loadSnapshotMeta(path string) (*snapshotMeta, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < raftSnapshotMetaSize {
+		return nil, fmt.Errorf("%d bytes, too short", len(data))
+	}
+	if format := binary.LittleEndian.Uint64(data); format != raftFormat {
+		return nil, fmt.Errorf("unknown format %d", format)
+	}
+	size := binary.LittleEndian.Uint64(data[24:])
+	if size > uint64(len(data)-raftSnapshotMetaSize) {
+		return nil, fmt.Errorf("configuration of %d bytes, but only %d follow", size, len(data)-raftSnapshotMetaSize)
+	}
+	configuration := data[raftSnapshotMetaSize : raftSnapshotMetaSize+size]
+
+	meta := &snapshotMeta{
+		ConfigurationIndex: binary.LittleEndian.Uint64(data[16:]),
+		stored:             uint32(binary.LittleEndian.Uint64(data[8:])),
+		computed:           crc32.Update(crc32.ChecksumIEEE(data[16:raftSnapshotMetaSize]), crc32.IEEETable, configuration),
+	}
+	if meta.Servers, err = decodeConfiguration(configuration); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// raftSegment is a file of the raft log. Closed segments are named after
+// the first and last index of their entries; open ones, still being written
+// to or preallocated, after a counter, their entries following those of the
+// closed segments.
+type raftSegment struct {
+	Name    string `json:"name"`
+	Open    bool   `json:"open"`
+	Counter uint64 `json:"counter,omitempty"`
+	First   uint64 `json:"first_index,omitempty"`
+	Last    uint64 `json:"last_index,omitempty"`
+	Size    int64  `json:"size"`
+}
+
+// listSegments returns the segments in dir: the closed ones by index, then
+// the open ones by counter.
+func listSegments(dir string) ([]raftSegment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []raftSegment
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		segment := raftSegment{Name: entry.Name()}
+		if counter, ok := strings.CutPrefix(entry.Name(), "open-"); ok {
+			if segment.Counter, err = strconv.ParseUint(counter, 10, 64); err != nil {
+				continue
+			}
+			segment.Open = true
+		} else {
+			first, last, ok := strings.Cut(entry.Name(), "-")
+			if !ok {
+				continue
+			}
+			if segment.First, err = strconv.ParseUint(first, 10, 64); err != nil {
+				continue
+			}
+			if segment.Last, err = strconv.ParseUint(last, 10, 64); err != nil {
+				continue
+			}
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		segment.Size = info.Size()
+		segments = append(segments, segment)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		a, b := segments[i], segments[j]
+		if a.Open != b.Open {
+			return b.Open
+		}
+		if a.Open {
+			return a.Counter < b.Counter
+		}
+		return a.First < b.First
+	})
+	return segments, nil
+}
+
+// raftEntry is an entry of the raft log.
+type raftEntry struct {
+	Term uint64
+	Type uint8
+	Data []byte
+}
+
+// errSegmentChecksum is returned by readSegment for a batch failing its
+// checksum.
+var errSegmentChecksum = errors.New("checksum mismatch")
+
+// lastGood describes how far a segment whose first entry has index first
+// could be read, count entries in, for the errors of readSegment: the
+// entries up to there passed their checksums, those after can't be trusted.
+func lastGood(first, count uint64) string {
+	if count == 0 {
+		return "no good entry before it"
+	}
+	return fmt.Sprintf("last good entry %d", first+count-1)
+}
+
+// readSegment calls fn with the entries of each batch of the segment at
+// path. A batch starts with the CRC32 of its header and of its data, then
+// the number of entries and the term, type and size of each of them, before
+// their data, padded to 8 bytes each. Open segments end at the first batch
+// left zeroed, or right away if nothing was written to them yet. Errors
+// come with the offset of the batch.
+func readSegment(path string, fn func(offset int64, entries []raftEntry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(file)
+
+	format, err := readUint64(r)
+	if err == io.EOF || err == nil && format == 0 {
+		return nil // never written to
+	} else if err != nil {
+		return fmt.Errorf("couldn't read the format: %w", err)
+	}
+	if format != raftFormat {
+		return fmt.Errorf("unknown format %d", format)
+	}
+
+	offset := int64(8)
+	for {
+		var preamble [16]byte
+		if _, err := io.ReadFull(r, preamble[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("batch at offset %d: %w", offset, err)
+		}
+		count := binary.LittleEndian.Uint64(preamble[8:])
+		if count == 0 {
+			if binary.LittleEndian.Uint64(preamble[:]) == 0 {
+				return nil // the preallocated rest of an open segment
+			}
+			return fmt.Errorf("batch at offset %d: no entries", offset)
+		}
+		if count > uint64(stat.Size()-offset)/raftEntryHeaderSize {
+			return fmt.Errorf("batch at offset %d: %d entries, more than would fit", offset, count)
+		}
+
+		header := make([]byte, 8+count*raftEntryHeaderSize)
+		copy(header, preamble[8:])
+		if _, err := io.ReadFull(r, header[8:]); err != nil {
+			return fmt.Errorf("batch at offset %d: couldn't read the header: %w", offset, err)
+		}
+		if crc32.ChecksumIEEE(header) != binary.LittleEndian.Uint32(preamble[:]) {
+			return fmt.Errorf("batch at offset %d: header %w", offset, errSegmentChecksum)
+		}
+
+		entries := make([]raftEntry, count)
+		var size uint64
+		for i := range entries {
+			h := header[8+i*raftEntryHeaderSize:]
+			entries[i].Term, entries[i].Type = binary.LittleEndian.Uint64(h), h[8]
+			size += (uint64(binary.LittleEndian.Uint32(h[12:])) + 7) &^ 7
+		}
+		if size > uint64(stat.Size()) {
+			return fmt.Errorf("batch at offset %d: %d bytes of data, more than the segment holds", offset, size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("batch at offset %d: couldn't read the data: %w", offset, err)
+		}
+		if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(preamble[4:]) {
+			return fmt.Errorf("batch at offset %d: data %w", offset, errSegmentChecksum)
+		}
+		for i := range entries {
+			length := uint64(binary.LittleEndian.Uint32(header[8+i*raftEntryHeaderSize+12:]))
+			entries[i].Data, data = data[:length], data[(length+7)&^7:]
+		}
+
+		if err := fn(offset, entries); err != nil {
+			return err
+		}
+		offset += 8 + int64(len(header)) + int64(size)
+	}
+}
+
+// raftSnapshot is a snapshot in a data directory, named after the term and
+// index of the last entry it includes.
+type raftSnapshot struct {
+	Path                   string
+	Term, Index, Timestamp uint64
+}
+
+// listSnapshots returns the snapshots in dir, the newest first.
+func listSnapshots(dir string) ([]raftSnapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []raftSnapshot
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if term, index, timestamp, ok := parseSnapshotName(entry.Name()); ok {
+			snapshots = append(snapshots, raftSnapshot{filepath.Join(dir, entry.Name()), term, index, timestamp})
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		a, b := snapshots[i], snapshots[j]
+		if a.Index != b.Index {
+			return a.Index > b.Index
+		}
+		if a.Term != b.Term {
+			return a.Term > b.Term
+		}
+		return a.Timestamp > b.Timestamp
+	})
+	return snapshots, nil
+}
+
+func writeRaftMetadata(path string, m *raftMetadata) error {
+	data := make([]byte, raftMetadataSize)
+	binary.LittleEndian.PutUint64(data, raftFormat)
+	binary.LittleEndian.PutUint64(data[8:], m.Version)
+	binary.LittleEndian.PutUint64(data[16:], m.Term)
+	binary.LittleEndian.PutUint64(data[24:], m.VotedFor)
+	return os.WriteFile(path, data, 0600)
+}
+
+// encodeConfiguration is the reverse of decodeConfiguration, padded to 8
+// bytes.
+func encodeConfiguration(servers []raftServer) []byte {
+	data := []byte{raftFormat}
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(servers)))
+	for _, s := range servers {
+		data = binary.LittleEndian.AppendUint64(data, s.ID)
+		data = append(data, s.Address...)
+		data = append(data, 0, s.Role)
+	}
+	return append(data, make([]byte, -len(data)&7)...)
+}
+
+// writeSnapshotMeta writes the .meta file of a snapshot at path.
+func writeSnapshotMeta(path string, meta *snapshotMeta) error {
+	configuration := encodeConfiguration(meta.Servers)
+	data := make([]byte, raftSnapshotMetaSize, raftSnapshotMetaSize+len(configuration))
+	binary.LittleEndian.PutUint64(data, raftFormat)
+	binary.LittleEndian.PutUint64(data[16:], meta.ConfigurationIndex)
+	binary.LittleEndian.PutUint64(data[24:], uint64(len(configuration)))
+	data = append(data, configuration...)
+	binary.LittleEndian.PutUint64(data[8:], uint64(crc32.ChecksumIEEE(data[16:])))
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testServers = []raftServer{
+	{ID: 1, Address: "10.0.0.1:9000", Role: raftVoter},
+	{ID: 2, Address: "10.0.0.2:9000", Role: raftStandby},
+}
+
+// appendBatch appends a batch of entries to segment, as raft writes them.
+func appendBatch(segment []byte, entries []raftEntry) []byte {
+	header := binary.LittleEndian.AppendUint64(nil, uint64(len(entries)))
+	var data []byte
+	for _, e := range entries {
+		header = binary.LittleEndian.AppendUint64(header, e.Term)
+		header = append(header, e.Type, 0, 0, 0)
+		header = binary.LittleEndian.AppendUint32(header, uint32(len(e.Data)))
+		data = append(data, e.Data...)
+		data = append(data, make([]byte, -len(e.Data)&7)...)
+	}
+	segment = binary.LittleEndian.AppendUint32(segment, crc32.ChecksumIEEE(header))
+	segment = binary.LittleEndian.AppendUint32(segment, crc32.ChecksumIEEE(data))
+	segment = append(segment, header...)
+	return append(segment, data...)
+}
+
+// testSegment returns a segment holding a configuration entry in its first
+// batch and two commands in its second, which starts at second.
+func testSegment() (segment []byte, second int) {
+	segment = binary.LittleEndian.AppendUint64(nil, raftFormat)
+	segment = appendBatch(segment, []raftEntry{{Term: 1, Type: raftChange, Data: encodeConfiguration(testServers)}})
+	second = len(segment)
+	segment = appendBatch(segment, []raftEntry{
+		{Term: 2, Type: raftCommand, Data: []byte("command")},
+		{Term: 2, Type: raftBarrier, Data: make([]byte, 8)},
+	})
+	return segment, second
+}
+
+// readTestSegment writes segment to a file named after the index of its
+// first entry, 10, and reads it back, returning its entries and, on failure,
+// how far it could be read as lastGood reports it.
+func readTestSegment(t *testing.T, segment []byte) ([]raftEntry, []int64, string, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "0000000000000010-0000000000000012")
+	if err := os.WriteFile(path, segment, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var entries []raftEntry
+	var offsets []int64
+	err := readSegment(path, func(offset int64, batch []raftEntry) error {
+		offsets = append(offsets, offset)
+		entries = append(entries, batch...)
+		return nil
+	})
+	return entries, offsets, lastGood(10, uint64(len(entries))), err
+}
+
+func TestReadSegment(t *testing.T) {
+	segment, second := testSegment()
+	entries, offsets, _, err := readTestSegment(t, segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{8, int64(second)}; !reflect.DeepEqual(offsets, want) {
+		t.Errorf("batches at offsets %v, want %v", offsets, want)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("read %d entries, want 3", len(entries))
+	}
+	if e := entries[1]; e.Term != 2 || e.Type != raftCommand || string(e.Data) != "command" {
+		t.Errorf("second entry is %+v", e)
+	}
+	servers, err := decodeConfiguration(entries[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(servers, testServers) {
+		t.Errorf("configuration decoded as %+v, want %+v", servers, testServers)
+	}
+
+	// The preallocated, zeroed rest of an open segment ends it.
+	if entries, _, _, err := readTestSegment(t, append(segment, make([]byte, 64)...)); err != nil || len(entries) != 3 {
+		t.Errorf("open segment: %d entries, error %v", len(entries), err)
+	}
+}
+
+func TestReadSegmentCorrupt(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		corrupt func(segment []byte, second int)
+		what    string
+		batch   int // of the failing batch, 0 for the first
+	}{
+		{"first header", func(s []byte, _ int) { s[8] ^= 1 }, "header", 0},
+		{"second header", func(s []byte, second int) { s[second] ^= 1 }, "header", 1},
+		{"second data", func(s []byte, _ int) { s[len(s)-1] ^= 1 }, "data", 1},
+		{"entry type", func(s []byte, second int) { s[second+8+8+8] = raftChange }, "header", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			segment, second := testSegment()
+			tc.corrupt(segment, second)
+			offset := []int{8, second}[tc.batch]
+			good := []string{"no good entry before it", "last good entry 10"}[tc.batch]
+
+			_, _, last, err := readTestSegment(t, segment)
+			if !errors.Is(err, errSegmentChecksum) {
+				t.Fatalf("got error %v, want a checksum mismatch", err)
+			}
+			if want := fmt.Sprintf("batch at offset %d: %s checksum mismatch", offset, tc.what); err.Error() != want {
+				t.Errorf("got error %q, want %q", err, want)
+			}
+			if last != good {
+				t.Errorf("reported %q, want %q", last, good)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// bwLimit, set with --bwlimit, caps the bytes per second read from the
+// sources and, separately, written to the sinks, 0 for no limit.
+var bwLimit int64
+
+// readLimit and writeLimit are shared by all the readers and writers, so
+// that the limits hold whatever is running concurrently.
+var readLimit, writeLimit *rateLimiter
+
+func configureRateLimit() {
+	if bwLimit > 0 {
+		readLimit, writeLimit = newRateLimiter(bwLimit), newRateLimiter(bwLimit)
+	}
+}
+
+// rateLimiter lets through rate bytes per second, on average. Transfers are
+// split in slices of a tenth of a second at most, to keep it smooth.
+type rateLimiter struct {
+	rate  int64
+	slice int
+
+	mu   sync.Mutex
+	next time.Time // when the bytes allowed so far will have gone through
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate, slice: int(max(rate/10, 1))}
+}
+
+// wait accounts for n bytes, sleeping for as long as they take at the rate.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	until := l.next
+	l.mu.Unlock()
+	time.Sleep(time.Until(until))
+}
+
+// limitReader returns r, slowed down to --bwlimit if set.
+func limitReader(r io.Reader) io.Reader {
+	if readLimit == nil {
+		return r
+	}
+	return &limitedReader{r: r, limit: readLimit}
+}
+
+// limitWriter returns w, slowed down to --bwlimit if set.
+func limitWriter(w io.Writer) io.Writer {
+	if writeLimit == nil {
+		return w
+	}
+	return &limitedWriter{w: w, limit: writeLimit}
+}
+
+type limitedReader struct {
+	r     io.Reader
+	limit *rateLimiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > l.limit.slice {
+		p = p[:l.limit.slice]
+	}
+	n, err := l.r.Read(p)
+	l.limit.wait(n)
+	return n, err
+}
+
+type limitedWriter struct {
+	w     io.Writer
+	limit *rateLimiter
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), l.limit.slice)]
+		l.limit.wait(len(chunk))
+		n, err := l.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// salvagedTable counts the rows copied from a table by salvage.
+type salvagedTable struct {
+	Name   string
+	Rows   int64
+	Failed int64
+}
+
+// salvageReport describes what a salvage pass managed to retrieve, and what
+// it had to give up on.
+type salvageReport struct {
+	Tables   []*salvagedTable
+	Orphans  int64 // rows found on unreferenced pages, saved to lost_and_found
+	Problems []string
+}
+
+// salvage copies every row that can still be decoded from the database at
+// src into a new database at dst, the way the .recover command of the sqlite3
+// cli does: it reads the b-trees directly rather than through SQLite, so it
+// gets past the damage that makes queries fail. Rows of table leaf pages that
+// no b-tree references anymore end up in a lost_and_found table.
+func salvage(src, dst string) (*salvageReport, error) {
+	p, err := openPager(src)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if err := os.Remove(dst + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	out, err := openDatabase(dst, false)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	tx, err := out.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := &salvageReport{}
+	w := newPageWalker(p)
+	w.walkSchema()
+	objects := w.layout.Objects[1:]
+
+	// Create all the tables upfront, so that sqlite_sequence exists too
+	// when AUTOINCREMENT is in use.
+	created := make(map[string]bool)
+	for _, o := range objects {
+		if o.Type != "table" || strings.HasPrefix(o.Name, "sqlite_") {
+			continue
+		}
+		if _, err := tx.Exec(o.SQL); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("couldn't create table %s: %v", o.Name, err))
+			continue
+		}
+		created[o.Name] = true
+	}
+	created["sqlite_sequence"] = true
+
+	for _, o := range objects {
+		if o.Type != "table" || !created[o.Name] {
+			w.walkBtree(o, nil)
+			continue
+		}
+
+		table := &salvagedTable{Name: o.Name}
+		report.Tables = append(report.Tables, table)
+		insert, err := salvageInserter(tx, o.Name)
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("table %s: %v", o.Name, err))
+			w.walkBtree(o, nil)
+			continue
+		}
+		w.walkBtree(o, func(c btreeCell) {
+			values, err := decodeRow(p, c)
+			if err == nil {
+				err = insert(c.Rowid, values)
+			}
+			if err != nil {
+				table.Failed++
+				return
+			}
+			table.Rows++
+		})
+		// Cells too damaged to tell their rowid are lost all the same.
+		table.Failed += o.BadCells
+	}
+
+	if report.Orphans, err = salvageOrphans(tx, w); err != nil {
+		return nil, err
+	}
+
+	for _, o := range objects {
+		if o.Type == "table" || strings.HasPrefix(o.Name, "sqlite_") {
+			continue
+		}
+		if _, err := tx.Exec(o.SQL); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("couldn't create %s %s: %v", o.Type, o.Name, err))
+		}
+	}
+	report.Problems = append(report.Problems, w.layout.Suspicious...)
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, out.Close()
+}
+
+// decodeRow returns the values of the row stored in the table leaf cell c.
+func decodeRow(p *pager, c btreeCell) ([]any, error) {
+	payload, err := p.readPayload(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseRecord(payload)
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if _, ok := value.(string); ok {
+			values[i] = p.text(value)
+		}
+	}
+	return values, nil
+}
+
+// salvageInserter returns a function inserting decoded rows into table,
+// keeping their rowid. Rows are fitted to the columns of the table: records
+// written before an ALTER TABLE ADD COLUMN have fewer values.
+func salvageInserter(tx *sql.Tx, table string) (func(rowid int64, values []any) error, error) {
+	rows, err := tx.Query("SELECT name, upper(type), pk FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	ipk, pks := -1, 0
+	for rows.Next() {
+		var name, typ string
+		var pk int
+		if err := rows.Scan(&name, &typ, &pk); err != nil {
+			return nil, err
+		}
+		if pk > 0 {
+			pks++
+			if typ == "INTEGER" {
+				ipk = len(columns)
+			}
+		}
+		columns = append(columns, quoteIdent(name))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// An INTEGER PRIMARY KEY aliases the rowid and is stored as NULL.
+	if pks != 1 {
+		ipk = -1
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s(rowid, %s) VALUES(?%s)",
+		quoteIdent(table), strings.Join(columns, ", "), strings.Repeat(", ?", len(columns)))
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]any, len(columns)+1)
+	return func(rowid int64, values []any) error {
+		args[0] = rowid
+		for i := range columns {
+			args[i+1] = nil
+			if i < len(values) {
+				args[i+1] = values[i]
+			}
+		}
+		if ipk >= 0 && args[ipk+1] == nil {
+			args[ipk+1] = rowid
+		}
+		_, err := stmt.Exec(args...)
+		return err
+	}, nil
+}
+
+// salvageOrphans decodes the table leaf pages that the walk didn't reach and
+// stores their rows into a lost_and_found table.
+func salvageOrphans(tx *sql.Tx, w *pageWalker) (int64, error) {
+	type orphan struct {
+		page   uint32
+		rowid  int64
+		values []any
+	}
+
+	var orphans []orphan
+	fields := 0
+	for n := uint32(1); n <= w.p.pageCount; n++ {
+		if w.kinds[n] != pageUnused {
+			continue
+		}
+		data, err := w.p.page(n)
+		if err != nil {
+			continue
+		}
+		bp, err := parseBtreePage(n, data)
+		if err != nil || bp.Type != pageTableLeaf {
+			continue
+		}
+		for _, offset := range bp.Cells {
+			c, err := parseCell(data, offset, bp.Type, w.p.usableSize())
+			if err != nil {
+				continue
+			}
+			values, err := decodeRow(w.p, c)
+			if err != nil {
+				continue
+			}
+			orphans = append(orphans, orphan{page: n, rowid: c.Rowid, values: values})
+			fields = max(fields, len(values))
+		}
+	}
+	if len(orphans) == 0 {
+		return 0, nil
+	}
+
+	columns := make([]string, fields)
+	for i := range columns {
+		columns[i] = fmt.Sprintf("c%d", i)
+	}
+	create := "CREATE TABLE lost_and_found(pgno, id, nfield, " + strings.Join(columns, ", ") + ")"
+	if _, err := tx.Exec(create); err != nil {
+		return 0, err
+	}
+	stmt, err := tx.Prepare("INSERT INTO lost_and_found VALUES(?, ?, ?" + strings.Repeat(", ?", fields) + ")")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	args := make([]any, fields+3)
+	for _, o := range orphans {
+		args[0], args[1], args[2] = o.page, o.rowid, len(o.values)
+		for i := range fields {
+			args[i+3] = nil
+			if i < len(o.values) {
+				args[i+3] = o.values[i]
+			}
+		}
+		if _, err := stmt.Exec(args...); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(orphans)), nil
+}
+
+func printSalvageReport(dst string, report *salvageReport) {
+	fmt.Printf("Recovered data written to %s:\n", dst)
+	for _, t := range report.Tables {
+		if t.Failed > 0 {
+			fmt.Printf("  %s: %d rows recovered, %d rows lost\n", t.Name, t.Rows, t.Failed)
+		} else {
+			fmt.Printf("  %s: %d rows recovered\n", t.Name, t.Rows)
+		}
+	}
+	if report.Orphans > 0 {
+		fmt.Printf("  lost_and_found: %d rows from orphaned pages\n", report.Orphans)
+	}
+	if len(report.Problems) > 0 {
+		fmt.Printf("Problems found while recovering:\n")
+		for _, problem := range report.Problems {
+			fmt.Printf("  %s\n", problem)
+		}
+	}
+	fmt.Println()
+}
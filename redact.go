@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Actions of a redaction rule.
+const (
+	redactKeep = "keep"
+	redactHash = "hash"
+	redactMask = "mask"
+	redactDrop = "drop"
+)
+
+// redactionRule applies an action to the columns matching a pattern, as
+// table.column, where either side can hold wildcards.
+type redactionRule struct {
+	table, column string
+	action        string
+	line          int
+	used          bool
+}
+
+// redactionRules are the rules of a --redact file, the first one matching
+// a column applying to it. Columns no rule matches are anonymized with
+// --anonymize, and kept otherwise.
+type redactionRules struct {
+	path  string
+	rules []*redactionRule
+}
+
+// readRedactionRules reads a rules file: a table.column pattern and an
+// action per line, blank lines and those starting with # being ignored.
+func readRedactionRules(file string) (*redactionRules, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &redactionRules{path: file}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected table.column and an action", file, n)
+		}
+		table, column, ok := strings.Cut(fields[0], ".")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: %q isn't a table.column pattern", file, n, fields[0])
+		}
+		for _, pattern := range []string{table, column} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid pattern %q", file, n, pattern)
+			}
+		}
+		switch fields[1] {
+		case redactKeep, redactHash, redactMask, redactDrop:
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown action %q, expected keep, hash, mask or drop", file, n, fields[1])
+		}
+		r.rules = append(r.rules, &redactionRule{table: table, column: column, action: fields[1], line: n})
+	}
+	return r, scanner.Err()
+}
+
+// action returns what to do with column of table.
+func (r *redactionRules) action(table, column string) string {
+	if r != nil {
+		for _, rule := range r.rules {
+			tableOK, _ := path.Match(rule.table, table)
+			columnOK, _ := path.Match(rule.column, column)
+			if tableOK && columnOK {
+				rule.used = true
+				return rule.action
+			}
+		}
+	}
+	if anonymize {
+		return redactHash
+	}
+	return redactKeep
+}
+
+// warnUnused warns about the rules that matched no column, most likely
+// misspelled.
+func (r *redactionRules) warnUnused() {
+	if r == nil {
+		return
+	}
+	for _, rule := range r.rules {
+		if !rule.used {
+			warn("%s:%d: %s.%s matches no column", r.path, rule.line, rule.table, rule.column)
+		}
+	}
+}
+
+// redactExpr returns the expression column is copied through, given the
+// action applying to it. Empty values go through unchanged, as the driver
+// would turn them into NULLs, and so do NULLs but for dropped columns that
+// can hold them.
+func redactExpr(column, action string, notNull bool) string {
+	switch action {
+	case redactHash:
+		return fmt.Sprintf("CASE WHEN typeof(%[1]s) IN ('text', 'blob') AND length(%[1]s) > 0 THEN anonymize(%[1]s) ELSE %[1]s END", column)
+	case redactMask:
+		return fmt.Sprintf("CASE typeof(%[1]s) WHEN 'text' THEN replace(hex(zeroblob(length(%[1]s))), '00', '*') "+
+			"WHEN 'blob' THEN zeroblob(length(%[1]s)) WHEN 'null' THEN NULL ELSE 0 END", column)
+	case redactDrop:
+		if !notNull {
+			return "NULL"
+		}
+		return fmt.Sprintf("CASE typeof(%[1]s) WHEN 'text' THEN '' WHEN 'blob' THEN x'' ELSE 0 END", column)
+	}
+	return column
+}
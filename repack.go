@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var repackCmd = &cobra.Command{
+	Use:   "repack <dir|manifest> <outfile>",
+	Short: "Put an unpacked snapshot back together",
+	Long: `Reconstructs a snapshot from a directory produced by unpack, using the
+manifest written there to restore the order and the compression of the
+databases. Left untouched, the files give back a byte-compatible snapshot;
+databases edited in the meantime (e.g. with the sqlite3 cli) are packed as
+they are now. Extractions made with --tag-names are repacked by naming their
+MANIFEST.<term>-<index>.json instead of the directory.`,
+	Args: cobra.ExactArgs(2),
+	RunE: repack,
+
+	SilenceUsage: true,
+}
+
+var repackCompression string
+
+func init() {
+	repackCmd.Flags().StringVar(&repackCompression, "compression", "", "compression of the snapshot, none or lz4 (default as in the manifest)")
+	repackCmd.Flags().BoolVar(&checkpointOnPack, "checkpoint-on-pack", false, "apply the WAL to the main file, packing an empty WAL")
+	repackCmd.Flags().BoolVar(&verifyRoundtrip, "verify-roundtrip", false, "read the snapshot back and compare the hashes of its databases")
+	rootCmd.AddCommand(repackCmd)
+}
+
+func repack(cmd *cobra.Command, args []string) error {
+	dir := args[0]
+	var m *manifest
+	info, err := os.Stat(dir)
+	if err == nil && !info.IsDir() {
+		m, err = readManifestFile(dir)
+		dir = filepath.Dir(dir)
+	} else {
+		m, err = readManifest(dir)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't read the manifest of %s: %w", args[0], err)
+	}
+
+	compress, err := outputCompression(m.Compression, repackCompression)
+	if err != nil {
+		return err
+	}
+
+	var sources []*packSource
+	for _, db := range m.Databases {
+		source, err := unpackedSource(dir, db)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source)
+	}
+
+	if err := writeSnapshot(args[1], sources, compress); err != nil {
+		return fmt.Errorf("couldn't write %s: %w", args[1], err)
+	}
+	fmt.Printf("Repacked %d databases into %s\n", len(sources), args[1])
+	return nil
+}
+
+// unpackedSource locates the files of the database db in dir. A missing WAL,
+// e.g. because the sqlite3 cli checkpointed and removed it, is packed empty.
+func unpackedSource(dir string, db manifestEntry) (*packSource, error) {
+	source := &packSource{entry: dbEntry{name: db.Name}, main: filepath.Join(dir, db.Name)}
+	if len(db.Files) > 0 {
+		// Duplicate databases are extracted under another name.
+		source.main = filepath.Join(dir, db.Files[0].Path)
+	}
+
+	info, err := os.Stat(source.main)
+	if err != nil {
+		return nil, err
+	}
+	source.entry.mainSize = uint64(info.Size())
+
+	info, err = os.Stat(source.main + "-wal")
+	if err == nil {
+		source.wal = source.main + "-wal"
+		source.entry.walSize = uint64(info.Size())
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if source.entry.mainSize != db.MainSize || source.entry.walSize != db.WALSize {
+		fmt.Printf("Database %s changed since it was unpacked\n", db.Name)
+	}
+	return source, nil
+}
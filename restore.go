@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <snapshot> <new-datadir>",
+	Short: "Bootstrap a single-node data directory from a snapshot",
+	Long: `Unpacks a snapshot and writes a new dqlite data directory holding it, with
+its .meta file, metadata1 and metadata2, and a cluster configuration made of a
+single voter at --address, along with the cluster.yaml and info.yaml of
+go-dqlite describing it, so that a dqlite-based service can be brought back
+up from just a snapshot. Other nodes then join it as in a new cluster.
+
+The term and index of the snapshot are kept from its name, when it follows
+dqlite's snapshot-<term>-<index>-<timestamp> naming.`,
+	Example: `  dqlite-snapshot-unpack restore snapshot-3-1000-1700000000 ./backend --address 10.0.0.1:19001`,
+	Args:    cobra.ExactArgs(2),
+	RunE:    restore,
+
+	SilenceUsage: true,
+}
+
+// bootstrapID is the ID go-dqlite gives the node bootstrapping a cluster.
+const bootstrapID = 0x2dc171858c3155be
+
+var (
+	restoreAddress     string
+	restoreID          uint64
+	restoreCompression string
+)
+
+func init() {
+	restoreCmd.Flags().StringVar(&restoreAddress, "address", "", "address of the node, as host:port")
+	restoreCmd.Flags().Uint64Var(&restoreID, "id", bootstrapID, "raft ID of the node")
+	restoreCmd.Flags().StringVar(&restoreCompression, "compression", "", "compression of the snapshot, none or lz4 (default as the original)")
+	restoreCmd.MarkFlagRequired("address")
+	rootCmd.AddCommand(restoreCmd)
+}
+
+func restore(cmd *cobra.Command, args []string) error {
+	path, dir := args[0], args[1]
+	if restoreID == 0 {
+		return fmt.Errorf("--id can't be 0")
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s already exists and isn't empty", dir)
+	}
+
+	term, index := uint64(1), uint64(1)
+	if t, i, _, ok := parseSnapshotName(filepath.Base(path)); ok && i > 0 {
+		term, index = max(t, 1), i
+	} else {
+		warn("%s isn't named after its term and index, restoring it at term 1, index 1", path)
+	}
+
+	unpacked, _, cleanup, err := extractTemp(path, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't extract %s: %w", path, err)
+	}
+	defer cleanup()
+	m, err := readManifest(unpacked)
+	if err != nil {
+		return err
+	}
+	compress, err := outputCompression(m.Compression, restoreCompression)
+	if err != nil {
+		return err
+	}
+	var sources []*packSource
+	for _, db := range m.Databases {
+		source, err := unpackedSource(unpacked, db)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("snapshot-%d-%d-%d", term, index, time.Now().UnixMilli())
+	snapshot := filepath.Join(dir, name)
+	if err := writeSnapshot(snapshot, sources, compress); err != nil {
+		return fmt.Errorf("couldn't write %s: %w", snapshot, err)
+	}
+	meta := &snapshotMeta{
+		ConfigurationIndex: index,
+		Servers:            []raftServer{{ID: restoreID, Address: restoreAddress, Role: raftVoter}},
+	}
+	if err := writeSnapshotMeta(snapshot+".meta", meta); err != nil {
+		return fmt.Errorf("couldn't write %s.meta: %w", snapshot, err)
+	}
+	if err := writeRecoveryFiles(dir, meta.Servers, restoreID); err != nil {
+		return fmt.Errorf("couldn't write the recovery files: %w", err)
+	}
+	// Raft goes with the one of higher version, and writes the other next.
+	for i, file := range []string{"metadata1", "metadata2"} {
+		if err := writeRaftMetadata(filepath.Join(dir, file), &raftMetadata{Version: uint64(i + 1), Term: term}); err != nil {
+			return fmt.Errorf("couldn't write %s: %w", file, err)
+		}
+	}
+
+	fmt.Printf("Restored %d databases into %s, as %s with node %d at %s the only voter\n",
+		len(sources), dir, name, restoreID, restoreAddress)
+	return nil
+}
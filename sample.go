@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// sampleInto creates a new database at dst with the schema of the database
+// at src and the first --sample rows of each of its tables, returning the
+// number of rows copied. Tables being filled after those they reference,
+// rows are picked among those whose foreign keys lead to rows already
+// copied, or are NULL, so that the sample stays consistent as far as
+// possible: references to tables not filled yet, in cycles, are ignored.
+func sampleInto(src, dst string) (int64, error) {
+	filled := make(map[string]bool)
+	return rebuildInto(src, dst, func(tx *sql.Tx, table string, columns []tableColumn) (int64, error) {
+		where, err := sampleFilter(tx, table, filled)
+		if err != nil {
+			return 0, err
+		}
+		names := make([]string, len(columns))
+		for i, column := range columns {
+			names[i] = quoteIdent(column.Name)
+		}
+		list := strings.Join(names, ", ")
+		result, err := tx.Exec(fmt.Sprintf("INSERT INTO main.%s(%s) SELECT %s FROM src.%s AS s%s LIMIT %d",
+			quoteIdent(table), list, list, quoteIdent(table), where, sampleSize))
+		if err != nil {
+			return 0, err
+		}
+		filled[table] = true
+		return result.RowsAffected()
+	})
+}
+
+// sampleFilter returns the WHERE clause keeping the rows of table, as s,
+// whose foreign keys to the tables filled already lead to a row copied.
+func sampleFilter(tx *sql.Tx, table string, filled map[string]bool) (string, error) {
+	keys, err := foreignKeys(tx, table)
+	if err != nil {
+		return "", err
+	}
+	var conditions []string
+	for _, key := range keys {
+		if !filled[key.parent] || key.parent == table {
+			continue
+		}
+		if key.to == nil {
+			// The key references the primary key of the parent.
+			if key.to, err = primaryKey(tx, key.parent); err != nil {
+				return "", err
+			}
+			if len(key.to) != len(key.from) {
+				continue
+			}
+		}
+		var null, match []string
+		for i, from := range key.from {
+			null = append(null, fmt.Sprintf("s.%s IS NULL", quoteIdent(from)))
+			match = append(match, fmt.Sprintf("p.%s = s.%s", quoteIdent(key.to[i]), quoteIdent(from)))
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s OR EXISTS (SELECT 1 FROM main.%s AS p WHERE %s))",
+			strings.Join(null, " OR "), quoteIdent(key.parent), strings.Join(match, " AND ")))
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), nil
+}
+
+// foreignKey is a foreign key of a table, from some of its columns to those
+// of parent, or to its primary key if to is nil.
+type foreignKey struct {
+	parent   string
+	from, to []string
+}
+
+// foreignKeys returns the foreign keys of table in the main database.
+func foreignKeys(tx *sql.Tx, table string) ([]foreignKey, error) {
+	rows, err := tx.Query(`SELECT id, "table", "from", "to" FROM pragma_foreign_key_list(?) ORDER BY id, seq`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []foreignKey
+	last := -1
+	for rows.Next() {
+		var id int
+		var parent, from string
+		var to sql.NullString
+		if err := rows.Scan(&id, &parent, &from, &to); err != nil {
+			return nil, err
+		}
+		if id != last {
+			keys = append(keys, foreignKey{parent: parent})
+			last = id
+		}
+		key := &keys[len(keys)-1]
+		key.from = append(key.from, from)
+		if to.Valid {
+			key.to = append(key.to, to.String)
+		}
+	}
+	return keys, rows.Err()
+}
+
+// primaryKey returns the columns of the primary key of table in the main
+// database, in order.
+func primaryKey(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// foreignKeyOrder sorts tables so that each one comes after those it
+// references, as far as cycles allow.
+func foreignKeyOrder(tx *sql.Tx, tables []string) ([]string, error) {
+	parents := make(map[string][]string)
+	for _, table := range tables {
+		keys, err := foreignKeys(tx, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			parents[table] = append(parents[table], key.parent)
+		}
+	}
+
+	var order []string
+	visited := make(map[string]bool)
+	var visit func(table string)
+	visit = func(table string) {
+		if visited[table] {
+			return
+		}
+		visited[table] = true
+		for _, parent := range parents[table] {
+			if slices.Contains(tables, parent) {
+				visit(parent)
+			}
+		}
+		order = append(order, table)
+	}
+	for _, table := range tables {
+		visit(table)
+	}
+	return order, nil
+}
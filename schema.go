@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// extractSchemas writes the schema of every database in the snapshot at path
+// to <name>.sql in the current directory. The schema table is read straight
+// out of the snapshot, the data files are never written anywhere.
+func extractSchemas(path string) error {
+	snapshot, err := openSnapshot(path)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	fmt.Printf("Database count: %d\n", snapshot.count)
+	var total uint64
+	for {
+		entry, err := snapshot.next()
+		if err != nil {
+			return err
+		} else if entry == nil {
+			break
+		}
+		if !validName(entry.name) {
+			return fmt.Errorf("database name %q can't be used as a file name", entry.name)
+		}
+		if err := checkSize(entry, &total); err != nil {
+			return err
+		}
+
+		fmt.Printf("Writing schema of database %s to %s.sql...\n", entry.name, entry.name)
+		if err := writeSchemaFile(snapshot, entry, entry.name+".sql"); err != nil {
+			return fmt.Errorf("couldn't write schema of %s: %w", entry.name, err)
+		}
+	}
+	return snapshot.checkEOF()
+}
+
+// writeSchemaFile reads the database of entry out of snapshot and writes its
+// schema to path.
+func writeSchemaFile(snapshot *snapshotReader, entry *dbEntry, path string) error {
+	// An empty file is an empty database, as far as SQLite is concerned.
+	var objects []schemaObject
+	if entry.mainSize > 0 || entry.walSize > 0 {
+		p, err := readPager(snapshot, entry)
+		if err != nil {
+			return err
+		}
+		if objects, err = readSchemaPages(p); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := dumpSchema(w, objects); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// readSchemaPages reads the schema table of the database of p, returning the
+// same objects as readSchema, in the same order, without going through
+// SQLite.
+func readSchemaPages(p *pager) ([]schemaObject, error) {
+	type row struct {
+		rowid int64
+		schemaObject
+	}
+	var rows []row
+
+	w := newPageWalker(p)
+	w.walkBtree(&pageObject{Name: "sqlite_schema", Root: 1}, func(c btreeCell) {
+		payload, err := p.readPayload(c, nil)
+		if err != nil {
+			w.suspicious("couldn't read schema row %d: %v", c.Rowid, err)
+			return
+		}
+		values, err := parseRecord(payload)
+		if err != nil || len(values) < 5 {
+			w.suspicious("schema row %d is malformed", c.Rowid)
+			return
+		}
+		if values[4] == nil {
+			return
+		}
+		rows = append(rows, row{c.Rowid, schemaObject{
+			Type:    p.text(values[0]),
+			Name:    p.text(values[1]),
+			TblName: p.text(values[2]),
+			SQL:     p.text(values[4]),
+		}})
+	})
+	if len(w.layout.Suspicious) > 0 {
+		return nil, errors.New(w.layout.Suspicious[0])
+	}
+
+	// ORDER BY type != 'table', tbl_name = 'sqlite_sequence', rowid
+	rank := func(r row) int {
+		rank := 0
+		if r.Type != "table" {
+			rank += 2
+		}
+		if r.TblName == "sqlite_sequence" {
+			rank++
+		}
+		return rank
+	}
+	slices.SortFunc(rows, func(a, b row) int {
+		if d := rank(a) - rank(b); d != 0 {
+			return d
+		}
+		return cmp.Compare(a.rowid, b.rowid)
+	})
+
+	objects := make([]schemaObject, len(rows))
+	for i, r := range rows {
+		objects[i] = r.schemaObject
+	}
+	return objects, nil
+}
+
+// dumpSchema writes the CREATE statements of the user objects to w.
+func dumpSchema(w io.Writer, objects []schemaObject) error {
+	for _, o := range objects {
+		if strings.HasPrefix(o.Name, "sqlite_") {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s;\n", o.SQL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var schemaDiffCmd = &cobra.Command{
+	Use:   "schema-diff <snapshot-a> <snapshot-b>",
+	Short: "Compare the schemas of the databases of two snapshots",
+	Long: `Compares the schema of each database found in both snapshots, without
+looking at their rows, and lists the statements turning the schema of the
+first into the one of the second, in the order they'd have to run: DROPs
+first, then CREATEs and ALTERs. Tables that only gained or lost columns are
+altered, any other changed table, index, view or trigger is dropped and
+created anew.`,
+	Args: cobra.ExactArgs(2),
+	RunE: schemaDiff,
+
+	SilenceUsage: true,
+}
+
+var schemaDiffJSON bool
+
+func init() {
+	schemaDiffCmd.Flags().BoolVar(&schemaDiffJSON, "json", false, "print the differences as JSON")
+	rootCmd.AddCommand(schemaDiffCmd)
+}
+
+// snapshotSchemaDiff holds the schema differences between two snapshots.
+type snapshotSchemaDiff struct {
+	Added     []string             `json:"added_databases"`
+	Removed   []string             `json:"removed_databases"`
+	Databases []databaseSchemaDiff `json:"databases"`
+}
+
+// databaseSchemaDiff holds the statements migrating the schema of a database
+// found in both snapshots.
+type databaseSchemaDiff struct {
+	Name       string            `json:"name"`
+	Statements []schemaStatement `json:"statements"`
+}
+
+// schemaStatement creates, alters or drops an object of the schema.
+type schemaStatement struct {
+	Action string `json:"action"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	SQL    string `json:"sql"`
+}
+
+func schemaDiff(cmd *cobra.Command, args []string) error {
+	dirA, namesA, cleanupA, err := extractTemp(args[0], nil)
+	if err != nil {
+		return fmt.Errorf("couldn't extract %s: %w", args[0], err)
+	}
+	defer cleanupA()
+
+	dirB, namesB, cleanupB, err := extractTemp(args[1], nil)
+	if err != nil {
+		return fmt.Errorf("couldn't extract %s: %w", args[1], err)
+	}
+	defer cleanupB()
+
+	result := snapshotSchemaDiff{Databases: []databaseSchemaDiff{}}
+	for _, name := range namesA {
+		if !slices.Contains(namesB, name) {
+			result.Removed = append(result.Removed, name)
+		}
+	}
+	for _, name := range namesB {
+		if !slices.Contains(namesA, name) {
+			result.Added = append(result.Added, name)
+			continue
+		}
+		statements, err := diffDatabaseSchemas(filepath.Join(dirA, name), filepath.Join(dirB, name))
+		if err != nil {
+			return fmt.Errorf("couldn't compare %s: %w", name, err)
+		}
+		result.Databases = append(result.Databases, databaseSchemaDiff{Name: name, Statements: statements})
+	}
+
+	if schemaDiffJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	printSnapshotSchemaDiff(&result)
+	return nil
+}
+
+// diffDatabaseSchemas returns the statements turning the schema of the
+// database at pathA into the one of the database at pathB.
+func diffDatabaseSchemas(pathA, pathB string) ([]schemaStatement, error) {
+	statements := []schemaStatement{}
+	err := withDatabase(pathA, func(db *sql.DB) error {
+		uri := "file:" + (&url.URL{Path: pathB}).EscapedPath() + "?mode=ro"
+		if _, err := db.Exec("ATTACH DATABASE ? AS b", uri); err != nil {
+			return err
+		}
+		defer db.Exec("DETACH DATABASE b")
+
+		schemaA, err := readSchemaOf(db, "main")
+		if err != nil {
+			return err
+		}
+		schemaB, err := readSchemaOf(db, "b")
+		if err != nil {
+			return err
+		}
+		schemaA, schemaB = userObjects(schemaA), userObjects(schemaB)
+
+		// Tables that can't be altered are rebuilt, taking their indexes and
+		// triggers with them.
+		alters := map[string][]string{}
+		rebuilt := map[string]bool{}
+		for _, c := range diffSchemas(schemaA, schemaB) {
+			if c.Type != "table" || c.Change != "changed" {
+				continue
+			}
+			if !isVirtual(schemaObject{SQL: c.Old}) && !isVirtual(schemaObject{SQL: c.New}) {
+				alters[c.Name], err = alterTable(db, c.Name, c.Old, c.New)
+				if err != nil {
+					return fmt.Errorf("table %s: %w", c.Name, err)
+				}
+			}
+			if alters[c.Name] == nil {
+				rebuilt[c.Name] = true
+			}
+		}
+		changed := func(o schemaObject, other []schemaObject) bool {
+			i := slices.IndexFunc(other, func(x schemaObject) bool { return x.Type == o.Type && x.Name == o.Name })
+			switch {
+			case i < 0:
+				return true
+			case o.Type == "table":
+				return rebuilt[o.Name]
+			default:
+				return other[i].SQL != o.SQL || rebuilt[o.TblName]
+			}
+		}
+
+		// Objects are dropped in the reverse order they were created in, so
+		// that views go before the views they select from.
+		for _, o := range slices.Backward(schemaA) {
+			if changed(o, schemaB) {
+				drop := "DROP " + strings.ToUpper(o.Type) + " " + quoteIdent(o.Name) + ";"
+				statements = append(statements, schemaStatement{Action: "drop", Type: o.Type, Name: o.Name, SQL: drop})
+			}
+		}
+		for _, o := range schemaB {
+			for _, alter := range alters[o.Name] {
+				statements = append(statements, schemaStatement{Action: "alter", Type: o.Type, Name: o.Name, SQL: alter})
+			}
+			if changed(o, schemaA) {
+				statements = append(statements, schemaStatement{Action: "create", Type: o.Type, Name: o.Name, SQL: o.SQL + ";"})
+			}
+		}
+		return nil
+	})
+	return statements, err
+}
+
+// userObjects leaves out the tables SQLite manages itself, and the shadow
+// tables modules keep the contents of virtual tables in.
+func userObjects(objects []schemaObject) []schemaObject {
+	var virtual []string
+	for _, o := range objects {
+		if o.Type == "table" && isVirtual(o) {
+			virtual = append(virtual, o.Name)
+		}
+	}
+	return slices.DeleteFunc(objects, func(o schemaObject) bool {
+		return strings.HasPrefix(o.Name, "sqlite_") || isShadowTable(o, virtual)
+	})
+}
+
+// plainIdent matches the identifiers that may go without quotes.
+var plainIdent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// alterTable returns the ALTER TABLE statements turning table, as created by
+// the old statement, into the one created by the new one, or nil if it didn't
+// just gain or lose columns. The statements are replayed on an in-memory
+// database and must give back the new statement, which rules out any other
+// change to the table, such as a new constraint.
+func alterTable(db *sql.DB, table, oldSQL, newSQL string) ([]string, error) {
+	type column struct {
+		Name, Type, Default string
+		NotNull, PK         bool
+	}
+	columnsOf := func(schema string) ([]column, error) {
+		rows, err := db.Query(`SELECT name, type, "notnull", ifnull(dflt_value, ''), pk FROM pragma_table_info(?, ?)`, table, schema)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var columns []column
+		for rows.Next() {
+			var c column
+			if err := rows.Scan(&c.Name, &c.Type, &c.NotNull, &c.Default, &c.PK); err != nil {
+				return nil, err
+			}
+			columns = append(columns, c)
+		}
+		return columns, rows.Err()
+	}
+	columnsA, err := columnsOf("main")
+	if err != nil {
+		return nil, err
+	}
+	columnsB, err := columnsOf("b")
+	if err != nil {
+		return nil, err
+	}
+
+	// Columns dropped from the table, then those added at its end.
+	var dropped []string
+	var kept []column
+	for _, c := range columnsA {
+		if slices.ContainsFunc(columnsB, func(x column) bool { return x.Name == c.Name }) {
+			kept = append(kept, c)
+		} else {
+			dropped = append(dropped, c.Name)
+		}
+	}
+	if len(kept) > len(columnsB) || !slices.Equal(kept, columnsB[:len(kept)]) {
+		return nil, nil
+	}
+	added := columnsB[len(kept):]
+	if len(dropped) == 0 && len(added) == 0 {
+		return nil, nil
+	}
+
+	var statements []string
+	for _, name := range dropped {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", quoteIdent(table), quoteIdent(name)))
+	}
+	for _, c := range added {
+		// The new statement holds the definition as spelled in the ALTER
+		// statement that added the column, its name bare or quoted.
+		definition := quoteIdent(c.Name)
+		if plainIdent.MatchString(c.Name) && !strings.Contains(newSQL, definition) {
+			definition = c.Name
+		}
+		if c.Type != "" {
+			definition += " " + c.Type
+		}
+		if c.NotNull {
+			definition += " NOT NULL"
+		}
+		if c.Default != "" {
+			definition += " DEFAULT " + c.Default
+		}
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", quoteIdent(table), definition))
+	}
+	if !replaysTo(oldSQL, statements, table, newSQL) {
+		return nil, nil
+	}
+	return statements, nil
+}
+
+// replaysTo tells whether running statements after the create statement
+// leaves table defined by want.
+func replaysTo(create string, statements []string, table, want string) bool {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return false
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, statement := range append([]string{create}, statements...) {
+		if _, err := db.Exec(statement); err != nil {
+			return false
+		}
+	}
+	var got string
+	err = db.QueryRow("SELECT sql FROM sqlite_schema WHERE type = 'table' AND name = ?", table).Scan(&got)
+	return err == nil && got == want
+}
+
+func printSnapshotSchemaDiff(d *snapshotSchemaDiff) {
+	for _, name := range d.Removed {
+		fmt.Printf("- database %s\n", name)
+	}
+	for _, name := range d.Added {
+		fmt.Printf("+ database %s\n", name)
+	}
+
+	for _, db := range d.Databases {
+		if len(db.Statements) == 0 {
+			fmt.Printf("= database %s\n", db.Name)
+			continue
+		}
+		fmt.Printf("~ database %s\n", db.Name)
+		for _, s := range db.Statements {
+			fmt.Printf("    %s\n", strings.ReplaceAll(s.SQL, "\n", "\n    "))
+		}
+	}
+}
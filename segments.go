@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var segmentsCmd = &cobra.Command{
+	Use:   "segments <datadir>",
+	Short: "List the segments of the raft log of a data directory",
+	Long: `Lists every closed and open segment of the raft log in a dqlite data
+directory, with the first and last index of its entries, their count, its
+size and whether its batches pass their checksums, along with how it relates
+to the last index included in the newest snapshot: entries covered by the
+snapshot are only kept for followers catching up, those after it hold the
+changes since.`,
+	Args: cobra.ExactArgs(1),
+	RunE: segments,
+
+	SilenceUsage: true,
+}
+
+var segmentsJSON bool
+
+func init() {
+	segmentsCmd.Flags().BoolVar(&segmentsJSON, "json", false, "print the segments as JSON")
+	rootCmd.AddCommand(segmentsCmd)
+}
+
+// segmentInfo is a segment as listed by segments.
+type segmentInfo struct {
+	raftSegment
+	Entries  uint64 `json:"entries"`
+	Status   string `json:"status"`
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// segmentInventory is what segments lists.
+type segmentInventory struct {
+	Snapshot      string        `json:"snapshot,omitempty"`
+	SnapshotIndex uint64        `json:"snapshot_index,omitempty"`
+	Segments      []segmentInfo `json:"segments"`
+}
+
+func segments(cmd *cobra.Command, args []string) error {
+	dir := args[0]
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	list, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	inventory := segmentInventory{Segments: []segmentInfo{}}
+	if len(snapshots) > 0 {
+		inventory.Snapshot, inventory.SnapshotIndex = filepath.Base(snapshots[0].Path), snapshots[0].Index
+	}
+	// Open segments follow on from the closed ones or, without any, from
+	// the snapshot.
+	next := inventory.SnapshotIndex + 1
+	for _, segment := range list {
+		info := segmentInfo{raftSegment: segment, Status: "ok"}
+		err := readSegment(filepath.Join(dir, segment.Name), func(_ int64, entries []raftEntry) error {
+			info.Entries += uint64(len(entries))
+			return nil
+		})
+		if err != nil {
+			first := segment.First
+			if segment.Open {
+				first = next
+			}
+			info.Status = err.Error() + ", " + lastGood(first, info.Entries)
+		}
+		switch {
+		case segment.Open && info.Entries > 0:
+			info.First, info.Last = next, next+info.Entries-1
+		case !segment.Open:
+			if want := segment.Last - segment.First + 1; err == nil && info.Entries != want {
+				info.Status = fmt.Sprintf("%d entries, %d going by its name", info.Entries, want)
+			}
+		}
+		if info.Last > 0 {
+			next = info.Last + 1
+			info.Snapshot = snapshotRelation(info.First, info.Last, inventory)
+		}
+		inventory.Segments = append(inventory.Segments, info)
+	}
+
+	if segmentsJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(inventory)
+	}
+	printSegments(&inventory)
+	return nil
+}
+
+// snapshotRelation tells how the entries from first to last relate to the
+// newest snapshot.
+func snapshotRelation(first, last uint64, inventory segmentInventory) string {
+	switch {
+	case inventory.Snapshot == "":
+		return ""
+	case last <= inventory.SnapshotIndex:
+		return "included"
+	case first > inventory.SnapshotIndex:
+		return "after"
+	default:
+		return "spans"
+	}
+}
+
+func printSegments(inventory *segmentInventory) {
+	if inventory.Snapshot != "" {
+		fmt.Printf("Snapshot: %s, up to index %d\n\n", inventory.Snapshot, inventory.SnapshotIndex)
+	} else {
+		fmt.Printf("Snapshot: none\n\n")
+	}
+	if len(inventory.Segments) == 0 {
+		fmt.Println("No segments")
+		return
+	}
+
+	var first, last, after uint64
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEGMENT\tFIRST\tLAST\tENTRIES\tSIZE\tSNAPSHOT\tSTATUS")
+	for _, s := range inventory.Segments {
+		if s.Last == 0 {
+			fmt.Fprintf(tw, "%s\t-\t-\t0\t%s\t\t%s\n", s.Name, formatBytes(s.Size), s.Status)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t%s\n", s.Name, s.First, s.Last, s.Entries, formatBytes(s.Size), s.Snapshot, s.Status)
+		if first == 0 {
+			first = s.First
+		}
+		last = max(last, s.Last)
+		if s.Last > inventory.SnapshotIndex {
+			after += s.Last - max(s.First, inventory.SnapshotIndex+1) + 1
+		}
+	}
+	tw.Flush()
+
+	if first > 0 {
+		fmt.Printf("\nLog: %s, %d after the snapshot\n", entryRange(first, last), after)
+	}
+}
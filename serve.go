@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Unpack snapshots as a service, over a REST API",
+	Long: `Serves a REST API unpacking snapshots, so that they can be inspected from a
+central place:
+
+  POST   /snapshots                   unpack the snapshot in the request body
+  POST   /snapshots?source=<url>      unpack the snapshot at an http(s), s3, gs
+                                      or azblob URL
+  GET    /snapshots                   list the unpacked snapshots
+  GET    /snapshots/{id}              manifest of an unpacked snapshot
+  GET    /snapshots/{id}/files/{file} an extracted file, such as a database
+  GET    /snapshots/{id}/tar          all the extracted files, as a tarball
+  DELETE /snapshots/{id}              remove an unpacked snapshot
+  GET    /metrics                     Prometheus metrics
+
+With --grpc-listen, the same is served over gRPC as well, as defined in
+snapshot.proto, streaming uploads and query results.
+
+Unpacked snapshots are kept in --dir, by default a temporary directory
+removed on exit.`,
+	Args: cobra.NoArgs,
+	RunE: serve,
+
+	Annotations:  map[string]string{ownSignals: "yes"},
+	SilenceUsage: true,
+}
+
+var (
+	serveListen     string
+	serveGRPCListen string
+	serveDir        string
+	serveMaxUpload  int64 = 64 << 30
+)
+
+func init() {
+	serveCmd.Flags().StringVar(&serveListen, "listen", "localhost:8080", "address to listen on")
+	serveCmd.Flags().StringVar(&serveGRPCListen, "grpc-listen", "", "address to serve the gRPC API on too, as defined in snapshot.proto")
+	serveCmd.Flags().StringVar(&serveDir, "dir", "", "directory to keep the unpacked snapshots in (default a temporary one)")
+	serveCmd.Flags().Var((*byteSize64)(&serveMaxUpload), "max-upload", "refuse uploaded snapshots larger than this, 0 for no limit")
+	rootCmd.AddCommand(serveCmd)
+}
+
+func serve(cmd *cobra.Command, args []string) error {
+	dir := serveDir
+	if dir == "" {
+		var cleanup func()
+		var err error
+		if dir, cleanup, err = makeTempDir(); err != nil {
+			return err
+		}
+		defer cleanup()
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	snapshots := &snapshotServer{dir: dir}
+	server := &http.Server{Addr: serveListen, Handler: snapshots.handler()}
+	var grpcServer *grpc.Server
+	if serveGRPCListen != "" {
+		listener, err := net.Listen("tcp", serveGRPCListen)
+		if err != nil {
+			return err
+		}
+		grpcServer = newGRPCServer(snapshots)
+		go grpcServer.Serve(listener)
+		defer grpcServer.Stop()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		// gRPC calls get as long as REST requests to finish, after which
+		// the deferred Stop cuts them short.
+		if grpcServer != nil {
+			go grpcServer.GracefulStop()
+		}
+		server.Shutdown(shutdown)
+	}()
+
+	if grpcServer != nil {
+		logger.Info("Serving gRPC on " + serveGRPCListen)
+	}
+	logger.Info(fmt.Sprintf("Serving on %s, unpacking into %s", serveListen, dir))
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-stopped
+	return nil
+}
+
+// snapshotServer serves the REST API of serve. Each snapshot is unpacked
+// into a directory of its own, named by its id; nothing is kept in memory.
+type snapshotServer struct {
+	dir string
+}
+
+var snapshotID = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// servedSnapshot is how the API describes an unpacked snapshot.
+type servedSnapshot struct {
+	ID string `json:"id"`
+	*manifest
+}
+
+func (s *snapshotServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /snapshots", s.create)
+	mux.HandleFunc("GET /snapshots", s.list)
+	mux.HandleFunc("GET /snapshots/{id}", s.get)
+	mux.HandleFunc("GET /snapshots/{id}/files/{file}", s.file)
+	mux.HandleFunc("GET /snapshots/{id}/tar", s.tarball)
+	mux.HandleFunc("DELETE /snapshots/{id}", s.delete)
+	mux.Handle("GET /metrics", metricsHandler())
+	return mux
+}
+
+func (s *snapshotServer) create(w http.ResponseWriter, r *http.Request) {
+	source := r.URL.Query().Get("source")
+	if source != "" && !isRemoteURL(source) {
+		respondError(w, http.StatusBadRequest, fmt.Errorf("source must be an http(s), s3, gs or azblob URL"))
+		return
+	}
+	var body io.Reader = r.Body
+	if serveMaxUpload > 0 {
+		body = http.MaxBytesReader(w, r.Body, serveMaxUpload)
+	}
+	id, status, err := s.unpack(source, body, r.ContentLength)
+	if err != nil {
+		respondError(w, status, err)
+		return
+	}
+	s.respond(w, http.StatusCreated, id)
+}
+
+// unpack unpacks the snapshot at source, a remote URL, or else the one read
+// from upload, of size bytes (-1 if unknown), into a new directory, returning
+// its id. If it fails, it also returns the HTTP status telling whether the
+// snapshot is at fault.
+func (s *snapshotServer) unpack(source string, upload io.Reader, size int64) (string, int, error) {
+	var random [8]byte
+	rand.Read(random[:])
+	id := hex.EncodeToString(random[:])
+	dir := filepath.Join(s.dir, id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	p := startProcessing("unpack")
+
+	path := source
+	if path == "" {
+		// Uploads are saved first, so that uncompressed ones get mapped.
+		path = dir + ".upload"
+		defer os.Remove(path)
+		if err := saveUpload(path, upload, size); err != nil {
+			os.Remove(dir)
+			status := http.StatusBadRequest
+			if errors.Is(err, errUploadTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			} else if errors.Is(err, errNoSpace) {
+				status = http.StatusInsufficientStorage
+			}
+			err = fmt.Errorf("couldn't receive the snapshot: %w", err)
+			p.done(0, 0, err)
+			return "", status, err
+		}
+	}
+
+	if _, err := extract(path, dir, io.Discard, nil); err != nil {
+		os.RemoveAll(dir)
+		p.done(0, 0, err)
+		return "", http.StatusUnprocessableEntity, err
+	}
+	m, err := readManifest(dir)
+	if err == nil && source == "" {
+		// Uploads have no name of their own; the path they were saved to
+		// is the server's business.
+		m.Snapshot = id
+		err = writeManifest(dir, m)
+	}
+	if err != nil {
+		p.done(0, 0, err)
+		return "", http.StatusInternalServerError, err
+	}
+	var decompressed int64
+	for _, db := range m.Databases {
+		decompressed += int64(db.MainSize + db.WALSize)
+	}
+	p.done(m.SnapshotSize, decompressed, nil)
+	return id, 0, nil
+}
+
+var (
+	errUploadTooLarge = errors.New("the snapshot is larger than --max-upload")
+	errNoSpace        = errors.New("not enough disk space")
+)
+
+// saveUpload saves body, of size bytes (-1 if unknown), to path, within
+// --max-upload and the free disk space.
+func saveUpload(path string, body io.Reader, size int64) error {
+	if size >= 0 {
+		if serveMaxUpload > 0 && size > serveMaxUpload {
+			return errUploadTooLarge
+		}
+		if err := checkSpace(filepath.Dir(path), uint64(size), "the snapshot"); err != nil {
+			return fmt.Errorf("%w: %w", errNoSpace, err)
+		}
+	}
+	if serveMaxUpload > 0 {
+		// One byte more, to tell a snapshot of exactly the limit from a
+		// larger one.
+		body = io.LimitReader(body, serveMaxUpload+1)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || err == nil && serveMaxUpload > 0 && n > serveMaxUpload {
+		err = errUploadTooLarge
+	}
+	return err
+}
+
+func (s *snapshotServer) list(w http.ResponseWriter, r *http.Request) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	snapshots := []servedSnapshot{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !snapshotID.MatchString(entry.Name()) {
+			continue
+		}
+		// Snapshots whose unpacking just started have no manifest yet.
+		if m, err := readManifest(filepath.Join(s.dir, entry.Name())); err == nil {
+			snapshots = append(snapshots, servedSnapshot{entry.Name(), m})
+		}
+	}
+	respondJSON(w, http.StatusOK, snapshots)
+}
+
+func (s *snapshotServer) get(w http.ResponseWriter, r *http.Request) {
+	s.respond(w, http.StatusOK, r.PathValue("id"))
+}
+
+// respond writes the description of snapshot id.
+func (s *snapshotServer) respond(w http.ResponseWriter, status int, id string) {
+	m, ok := s.manifest(w, id)
+	if ok {
+		respondJSON(w, status, servedSnapshot{id, m})
+	}
+}
+
+// manifest returns the manifest of snapshot id or, if it can't, writes the
+// error.
+func (s *snapshotServer) manifest(w http.ResponseWriter, id string) (*manifest, bool) {
+	m, status, err := s.lookup(id)
+	if err != nil {
+		respondError(w, status, err)
+		return nil, false
+	}
+	return m, true
+}
+
+// lookup returns the manifest of snapshot id or, if it can't, the HTTP
+// status of the error.
+func (s *snapshotServer) lookup(id string) (*manifest, int, error) {
+	if !snapshotID.MatchString(id) {
+		return nil, http.StatusNotFound, fmt.Errorf("no snapshot %s", id)
+	}
+	m, err := readManifest(filepath.Join(s.dir, id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, http.StatusNotFound, fmt.Errorf("no snapshot %s", id)
+	} else if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return m, 0, nil
+}
+
+func (s *snapshotServer) file(w http.ResponseWriter, r *http.Request) {
+	id, name := r.PathValue("id"), r.PathValue("file")
+	m, ok := s.manifest(w, id)
+	if !ok {
+		return
+	}
+	// Only the files of the manifest are served, whatever the name.
+	for _, file := range servedFiles(m) {
+		if file == name {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			http.ServeFile(w, r, filepath.Join(s.dir, id, file))
+			return
+		}
+	}
+	respondError(w, http.StatusNotFound, fmt.Errorf("no file %s in snapshot %s", name, id))
+}
+
+func (s *snapshotServer) tarball(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	m, ok := s.manifest(w, id)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar"`, id))
+	sink := newTarStream(archiveStream(w))
+	var err error
+	for _, name := range servedFiles(m) {
+		if err = addFile(sink, filepath.Join(s.dir, id, name), name); err != nil {
+			break
+		}
+	}
+	// Once the response started, errors can only cut it short.
+	sink.finish(err)
+}
+
+func (s *snapshotServer) delete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.manifest(w, id); !ok {
+		return
+	}
+	if err := os.RemoveAll(filepath.Join(s.dir, id)); err != nil {
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// servedFiles returns the files of an unpacked snapshot, the manifest last.
+func servedFiles(m *manifest) []string {
+	var files []string
+	for _, db := range m.Databases {
+		for _, file := range db.Files {
+			files = append(files, file.Path)
+		}
+	}
+	return append(files, manifestName)
+}
+
+func respondJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func respondError(w http.ResponseWriter, status int, err error) {
+	countError(status)
+	respondJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// isRemoteURL tells whether path is a URL of a source reachable without
+// any local account, as opposed to local files and SSH.
+func isRemoteURL(path string) bool {
+	for _, scheme := range []string{"http://", "https://", "s3://", "gs://", "azblob://"} {
+		if strings.HasPrefix(path, scheme) {
+			return true
+		}
+	}
+	return false
+}
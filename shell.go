@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+var shellCmd = &cobra.Command{
+	Use:   "shell <snapshot>",
+	Short: "Open a sqlite3 shell on a database in a snapshot",
+	Long: `Extracts the snapshot to a temporary directory and runs the sqlite3 cli on the
+chosen database. The temporary files are removed when the shell exits.`,
+	Args: snapshotArg,
+	RunE: shell,
+
+	Annotations:  map[string]string{ownSignals: "yes"},
+	SilenceUsage: true,
+}
+
+var (
+	shellDB      string
+	shellSqlite3 string
+)
+
+func init() {
+	shellCmd.Flags().StringVar(&shellDB, "db", "", "database to open (required if the snapshot has more than one)")
+	shellCmd.Flags().StringVar(&shellSqlite3, "sqlite3", "sqlite3", "sqlite3 binary to run")
+	rootCmd.AddCommand(shellCmd)
+}
+
+func shell(cmd *cobra.Command, args []string) error {
+	binary, err := exec.LookPath(shellSqlite3)
+	if err != nil {
+		return err
+	}
+
+	path, err := snapshotPath(args)
+	if err != nil {
+		return err
+	}
+	dir, names, cleanup, err := extractTemp(path, onlyDatabase(shellDB))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	name, err := pickDatabase(names, shellDB)
+	if err != nil {
+		return err
+	}
+
+	// Interrupts are meant for the shell (to cancel a running statement): we
+	// must not die on them, or the temporary files would be left behind.
+	// SIGTERM is passed on, for the shell to exit and the cleanup to run.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	sqlite := exec.Command(binary, filepath.Join(dir, name))
+	sqlite.Stdin = os.Stdin
+	sqlite.Stdout = os.Stdout
+	sqlite.Stderr = os.Stderr
+	if err := sqlite.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGTERM {
+					sqlite.Process.Signal(sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return sqlite.Wait()
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ulikunitz/xz"
+)
+
+// snapshotReader decodes the stream of a dqlite snapshot: a header holding
+// the format number and the database count, followed by each database as a
+// header (name, main and WAL sizes) and the content of the two files.
+//
+// After next returns an entry, reading from the snapshotReader yields the
+// content of its main file and then of its WAL.
+type snapshotReader struct {
+	io.Reader
+	file   *os.File
+	remote io.Closer // instead of file, for snapshots read over the network
+	count  uint64
+	read   uint64
+
+	input   *countingReader // counts the (compressed) bytes read from file
+	decoded int64
+
+	// mapped is the content of file, for uncompressed snapshots read
+	// through mmap. The Reader is then a bytes.Reader over it, found at
+	// origin in the file at path.
+	mapped []byte
+	path   string
+	origin int64
+}
+
+// Limits on what a snapshot can declare, well beyond anything dqlite
+// produces, so that garbage input can't make the parser run wild.
+const (
+	maxDatabases  = 1 << 16
+	maxNameLength = 4096
+)
+
+// dbEntry is the header of a database in a snapshot.
+type dbEntry struct {
+	name     string
+	mainSize uint64
+	walSize  uint64
+
+	sum []byte // SHA-256 of the content, when written with --verify-roundtrip
+}
+
+// openSnapshot opens the snapshot at path, which may be an http(s):// URL,
+// decompressing it if needed, and reads its header.
+func openSnapshot(path string) (*snapshotReader, error) {
+	if remote, err := openRemote(path); err != nil {
+		return nil, err
+	} else if remote != nil {
+		s, err := newSnapshotReader(remote)
+		if err != nil {
+			remote.Close()
+			return nil, err
+		}
+		s.remote = remote
+		return s, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newSnapshotReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.file, s.path = file, path
+	// Going through the mapping, reads couldn't be held to --bwlimit.
+	if !noMmap && bwLimit == 0 && s.compression() == nil {
+		s.mapFile()
+	}
+	return s, nil
+}
+
+// mapFile switches an uncompressed snapshot to reading the file through
+// mmap, which spares copying its content around. Failing that, it is read
+// as usual.
+func (s *snapshotReader) mapFile() {
+	info, err := s.file.Stat()
+	if err != nil || !info.Mode().IsRegular() || int64(int(info.Size())) != info.Size() {
+		return
+	}
+	data, err := mmapFile(s.file, int(info.Size()))
+	if err != nil {
+		return
+	}
+
+	if closer, ok := s.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+	reader := bytes.NewReader(data)
+	reader.Seek(s.decoded, io.SeekStart)
+	s.Reader = reader
+	s.mapped = data
+}
+
+// section consumes the next n bytes of a mapped snapshot, returning a
+// snapshotReader of their own over them, to be read concurrently. Sections
+// must not be closed.
+func (s *snapshotReader) section(n int64) *snapshotReader {
+	origin := s.origin + s.decoded
+	data := s.take(n)
+	return &snapshotReader{Reader: bytes.NewReader(data), mapped: data, path: s.path, origin: origin}
+}
+
+// take consumes up to n bytes of a mapped snapshot, returning them without
+// copying.
+func (s *snapshotReader) take(n int64) []byte {
+	data := s.mapped[s.decoded:]
+	data = data[:min(n, int64(len(data)))]
+	s.Reader.(*bytes.Reader).Seek(int64(len(data)), io.SeekCurrent)
+	s.decoded += int64(len(data))
+	return data
+}
+
+// newSnapshotReader decodes the snapshot read from r, reading its header.
+func newSnapshotReader(r io.Reader) (*snapshotReader, error) {
+	input := &countingReader{r: limitReader(r)}
+	reader, err := createReader(input)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &snapshotReader{Reader: reader, input: input}
+	if layers := s.compression(); layers != nil {
+		trace(0, "compressed", nil, strings.Join(layers, ", ")+", offsets are in the decompressed content")
+	}
+	if err := s.readHeader(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *snapshotReader) readHeader() error {
+	if format, err := s.readField("format"); err != nil {
+		return fmt.Errorf("couldn't read format number: %w", err)
+	} else if format != 1 {
+		return fmt.Errorf("unexpected format number: %d", format)
+	}
+
+	databases, err := s.readField("count")
+	if err != nil {
+		return fmt.Errorf("couldn't read database count: %w", err)
+	}
+	if databases > maxDatabases {
+		return fmt.Errorf("implausible database count: %d", databases)
+	}
+	s.count = databases
+	return nil
+}
+
+// next reads the header of the next database, returning nil once all of
+// them have been read. The content of the previous database must have been
+// consumed already.
+func (s *snapshotReader) next() (*dbEntry, error) {
+	if s.read == s.count {
+		return nil, nil
+	}
+
+	offset := s.decoded
+	var raw bytes.Buffer
+	name, err := readPaddedString(io.TeeReader(s, &raw))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the database name: %w", err)
+	}
+	traceName(offset, raw.Bytes(), name)
+
+	mainSize, err := s.readField("main size")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read main size: %w", err)
+	}
+	walSize, err := s.readField("wal size")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read wal size: %w", err)
+	}
+	// Sizes are used as int64 and added together down the line.
+	if mainSize > math.MaxInt64 || walSize > math.MaxInt64-mainSize {
+		return nil, fmt.Errorf("database %s declares an impossible size", name)
+	}
+
+	trace(s.decoded, "main", nil, fmt.Sprintf("%d bytes of content of %s", mainSize, name))
+	trace(s.decoded+int64(mainSize), "wal", nil, fmt.Sprintf("%d bytes of content of %s", walSize, name))
+	s.read++
+	return &dbEntry{name: name, mainSize: mainSize, walSize: walSize}, nil
+}
+
+// readField reads a number of the snapshot headers, tracing it as field.
+func (s *snapshotReader) readField(field string) (uint64, error) {
+	offset := s.decoded
+	var raw [8]byte
+	if _, err := io.ReadFull(s, raw[:]); err != nil {
+		return 0, err
+	}
+	value := binary.LittleEndian.Uint64(raw[:])
+	trace(offset, field, raw[:], strconv.FormatUint(value, 10))
+	return value, nil
+}
+
+// skip consumes the content of entry without looking at it.
+func (s *snapshotReader) skip(entry *dbEntry) error {
+	if s.mapped != nil {
+		if size := int64(entry.mainSize + entry.walSize); int64(len(s.take(size))) < size {
+			return io.EOF
+		}
+		return nil
+	}
+	_, err := io.CopyN(io.Discard, s, int64(entry.mainSize+entry.walSize))
+	return err
+}
+
+// checkEOF makes sure nothing follows the last database.
+func (s *snapshotReader) checkEOF() error {
+	var extra [1]byte
+	offset := s.decoded
+	_, err := s.Read(extra[:])
+	if err == io.EOF {
+		trace(offset, "end", nil, "end of the snapshot")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("checking for EOF: %w", err)
+	} else {
+		trace(offset, "extra", nil, "data after the last database")
+		return fmt.Errorf("expected EOF but found extra data")
+	}
+}
+
+func (s *snapshotReader) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	s.decoded += int64(n)
+	return n, err
+}
+
+// compressedRead returns how many bytes were read from the snapshot file so
+// far. As the input is read ahead, it runs in front of the decoded data.
+func (s *snapshotReader) compressedRead() int64 {
+	if s.mapped != nil {
+		return s.decoded
+	}
+	return s.input.n.Load()
+}
+
+// compression returns the compression layers of the snapshot, outermost
+// first, or nil if it isn't compressed.
+func (s *snapshotReader) compression() []string {
+	if layered, ok := s.Reader.(*layeredReader); ok {
+		return layered.names
+	}
+	return nil
+}
+
+func (s *snapshotReader) Close() error {
+	if closer, ok := s.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+	if s.mapped != nil {
+		munmapFile(s.mapped)
+	}
+	if s.remote != nil {
+		return s.remote.Close()
+	}
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+func readUint64(r io.Reader) (uint64, error) {
+	var buf [8]byte
+	_, err := io.ReadFull(r, buf[:])
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// readPaddedString reads a null-terminated string from r,
+// consuming 8-byte blocks, stopping at the first null, and discarding remaining padding.
+// Strings longer than maxNameLength are refused rather than buffered.
+func readPaddedString(r io.Reader) (string, error) {
+	var buf bytes.Buffer
+	block := make([]byte, 8)
+
+	for {
+		if buf.Len() >= maxNameLength {
+			return "", fmt.Errorf("no terminator within %d bytes", maxNameLength)
+		}
+		_, err := io.ReadFull(r, block)
+		if err != nil {
+			return "", fmt.Errorf("reading block: %w", err)
+		}
+
+		// Efficient null scan
+		i := bytes.IndexByte(block, 0)
+		if i >= 0 {
+			// Null found: write up to it and stop
+			buf.Write(block[:i])
+			break
+		}
+
+		// No null: write whole block
+		buf.Write(block)
+	}
+
+	return buf.String(), nil
+}
+
+// snapshotWriter encodes a dqlite snapshot, the counterpart of
+// snapshotReader: after the header, each database is written as its header
+// with writeEntry followed by the content of its main file and of its WAL.
+type snapshotWriter struct {
+	io.Writer
+}
+
+// writeHeader writes the format number and the database count.
+func (s *snapshotWriter) writeHeader(count uint64) error {
+	if err := writeUint64(s, 1); err != nil {
+		return err
+	}
+	return writeUint64(s, count)
+}
+
+// writeEntry writes the header of a database.
+func (s *snapshotWriter) writeEntry(entry *dbEntry) error {
+	if err := writePaddedString(s, entry.name); err != nil {
+		return err
+	}
+	if err := writeUint64(s, entry.mainSize); err != nil {
+		return err
+	}
+	return writeUint64(s, entry.walSize)
+}
+
+// snapshotSize returns the size of the (uncompressed) snapshot of entries.
+func snapshotSize(entries []*dbEntry) int64 {
+	size := int64(16)
+	for _, entry := range entries {
+		size += int64(paddedStringSize(entry.name)) + 16
+		size += int64(entry.mainSize + entry.walSize)
+	}
+	return size
+}
+
+func writeUint64(w io.Writer, v uint64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// writePaddedString writes s null-terminated, padded with nulls to a
+// multiple of 8 bytes, as readPaddedString expects it.
+func writePaddedString(w io.Writer, s string) error {
+	buf := make([]byte, paddedStringSize(s))
+	copy(buf, s)
+	_, err := w.Write(buf)
+	return err
+}
+
+func paddedStringSize(s string) int {
+	return (len(s) + 1 + 7) / 8 * 8
+}
+
+// compression identifies the compression of a snapshot stream.
+type compression int
+
+const (
+	compressionNone compression = iota
+	compressionLZ4
+	compressionZstd
+	compressionGzip
+	compressionXz
+)
+
+var compressionNames = []string{
+	compressionNone: "none",
+	compressionLZ4:  "lz4",
+	compressionZstd: "zstd",
+	compressionGzip: "gzip",
+	compressionXz:   "xz",
+}
+
+func (c compression) String() string {
+	return compressionNames[c]
+}
+
+// lz4SkippableMagic is the first of the 16 magic numbers (up to 0x184D2A5F)
+// starting skippable LZ4 frames.
+const lz4SkippableMagic = 0x184D2A50
+
+// maxCompressionLayers bounds how many compression wrappers createReader
+// peels off, e.g. an LZ4 snapshot that was gzipped before being copied around.
+const maxCompressionLayers = 4
+
+// layeredReader reads through a stack of decompressors, closing all of them
+// on Close.
+type layeredReader struct {
+	io.Reader
+	closers []io.Closer
+	names   []string // the compression of each layer, outermost first
+}
+
+func (l *layeredReader) Close() error {
+	for i := len(l.closers) - 1; i >= 0; i-- {
+		l.closers[i].Close()
+	}
+	return nil
+}
+
+// createReader returns a reader decompressing the content of file. Reading
+// the input and each layer of decompression run in their own goroutine.
+func createReader(file io.Reader) (io.Reader, error) {
+	input := newAheadReader(file, bufferSize)
+	layered := &layeredReader{closers: []io.Closer{input}}
+	reader := bufio.NewReader(input)
+
+	// Raw LZ4 blocks have no magic number to detect them by.
+	if lz4Block {
+		block, err := newLZ4BlockReader(reader, lz4BlockSize)
+		if err != nil {
+			layered.Close()
+			return nil, err
+		}
+		reader = bufio.NewReader(block)
+		layered.names = append(layered.names, "lz4 block")
+	}
+	for range maxCompressionLayers {
+		kind, err := detectCompression(reader)
+		if err != nil {
+			layered.Close()
+			return nil, err
+		}
+
+		// The time spent decompressing is what the layer takes, minus the
+		// time it waits for its input.
+		input := &timedReader{r: reader, phase: &timings.decompress, subtract: true}
+		var next io.Reader
+		switch kind {
+		case compressionLZ4:
+			var lz4 *LZ4Reader
+			if lz4, err = NewLZ4Reader(input); err == nil {
+				lz4.verify = verifyCompression
+			}
+			next = lz4
+		case compressionZstd:
+			next, err = newZstdReader(input)
+		case compressionGzip:
+			next, err = gzip.NewReader(input)
+		case compressionXz:
+			next, err = xz.NewReader(input)
+		default:
+			layered.Reader = reader
+			return layered, nil
+		}
+		if err != nil {
+			layered.Close()
+			return nil, err
+		}
+
+		if closer, ok := next.(io.Closer); ok {
+			layered.closers = append(layered.closers, closer)
+		}
+		layered.names = append(layered.names, kind.String())
+		ahead := newAheadReader(&timedReader{r: next, phase: &timings.decompress}, bufferSize)
+		layered.closers = append(layered.closers, ahead)
+		reader = bufio.NewReader(ahead)
+	}
+
+	layered.Close()
+	return nil, fmt.Errorf("more than %d nested compression layers", maxCompressionLayers)
+}
+
+// detectCompression tells how the stream is compressed from its magic
+// number, without consuming it.
+func detectCompression(reader *bufio.Reader) (compression, error) {
+	const lz4magic = 0x184D2204
+	header, err := reader.Peek(6)
+	if len(header) < 4 {
+		return compressionNone, err
+	}
+
+	switch {
+	case binary.LittleEndian.Uint32(header) == lz4magic:
+		return compressionLZ4, nil
+	case binary.LittleEndian.Uint32(header)&0xfffffff0 == lz4SkippableMagic:
+		// zstd shares the range of skippable frames, but dqlite only
+		// produces LZ4.
+		return compressionLZ4, nil
+	case binary.LittleEndian.Uint32(header) == zstdMagic:
+		return compressionZstd, nil
+	case header[0] == 0x1f && header[1] == 0x8b:
+		return compressionGzip, nil
+	case bytes.HasPrefix(header, []byte("\xfd7zXZ\x00")):
+		return compressionXz, nil
+	}
+	return compressionNone, nil
+}
+
+// countingReader counts the bytes read through it, possibly from another
+// goroutine than the one looking at the count.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := c.r.Read(p)
+	timings.read.Add(int64(time.Since(start)))
+	c.n.Add(int64(n))
+	return n, err
+}
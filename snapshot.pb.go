@@ -0,0 +1,1065 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: snapshot.proto
+
+package main
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// SnapshotSource gives the snapshot to work on: either the http(s), s3, gs or
+// azblob URL of one, in a single message, or its content, uploaded in chunks
+// across as many messages as needed.
+type SnapshotSource struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Source:
+	//
+	//	*SnapshotSource_Url
+	//	*SnapshotSource_Chunk
+	Source        isSnapshotSource_Source `protobuf_oneof:"source"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SnapshotSource) Reset() {
+	*x = SnapshotSource{}
+	mi := &file_snapshot_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SnapshotSource) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SnapshotSource) ProtoMessage() {}
+
+func (x *SnapshotSource) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SnapshotSource.ProtoReflect.Descriptor instead.
+func (*SnapshotSource) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SnapshotSource) GetSource() isSnapshotSource_Source {
+	if x != nil {
+		return x.Source
+	}
+	return nil
+}
+
+func (x *SnapshotSource) GetUrl() string {
+	if x != nil {
+		if x, ok := x.Source.(*SnapshotSource_Url); ok {
+			return x.Url
+		}
+	}
+	return ""
+}
+
+func (x *SnapshotSource) GetChunk() []byte {
+	if x != nil {
+		if x, ok := x.Source.(*SnapshotSource_Chunk); ok {
+			return x.Chunk
+		}
+	}
+	return nil
+}
+
+type isSnapshotSource_Source interface {
+	isSnapshotSource_Source()
+}
+
+type SnapshotSource_Url struct {
+	Url string `protobuf:"bytes,1,opt,name=url,proto3,oneof"`
+}
+
+type SnapshotSource_Chunk struct {
+	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
+}
+
+func (*SnapshotSource_Url) isSnapshotSource_Source() {}
+
+func (*SnapshotSource_Chunk) isSnapshotSource_Source() {}
+
+type InspectResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Compression   []string               `protobuf:"bytes,1,rep,name=compression,proto3" json:"compression,omitempty"`
+	Databases     []*DatabaseReport      `protobuf:"bytes,2,rep,name=databases,proto3" json:"databases,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InspectResponse) Reset() {
+	*x = InspectResponse{}
+	mi := &file_snapshot_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InspectResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InspectResponse) ProtoMessage() {}
+
+func (x *InspectResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InspectResponse.ProtoReflect.Descriptor instead.
+func (*InspectResponse) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *InspectResponse) GetCompression() []string {
+	if x != nil {
+		return x.Compression
+	}
+	return nil
+}
+
+func (x *InspectResponse) GetDatabases() []*DatabaseReport {
+	if x != nil {
+		return x.Databases
+	}
+	return nil
+}
+
+// DatabaseReport is what the headers of a database tell, as with the inspect
+// command.
+type DatabaseReport struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Name     string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	MainSize uint64                 `protobuf:"varint,2,opt,name=main_size,json=mainSize,proto3" json:"main_size,omitempty"`
+	WalSize  uint64                 `protobuf:"varint,3,opt,name=wal_size,json=walSize,proto3" json:"wal_size,omitempty"`
+	// Unset if the main file is empty or its header invalid.
+	Header *DatabaseHeader `protobuf:"bytes,4,opt,name=header,proto3" json:"header,omitempty"`
+	// Unset if there is no WAL or its header is invalid.
+	Wal           *WALHeader `protobuf:"bytes,5,opt,name=wal,proto3" json:"wal,omitempty"`
+	Warnings      []string   `protobuf:"bytes,6,rep,name=warnings,proto3" json:"warnings,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DatabaseReport) Reset() {
+	*x = DatabaseReport{}
+	mi := &file_snapshot_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DatabaseReport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DatabaseReport) ProtoMessage() {}
+
+func (x *DatabaseReport) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DatabaseReport.ProtoReflect.Descriptor instead.
+func (*DatabaseReport) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *DatabaseReport) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *DatabaseReport) GetMainSize() uint64 {
+	if x != nil {
+		return x.MainSize
+	}
+	return 0
+}
+
+func (x *DatabaseReport) GetWalSize() uint64 {
+	if x != nil {
+		return x.WalSize
+	}
+	return 0
+}
+
+func (x *DatabaseReport) GetHeader() *DatabaseHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *DatabaseReport) GetWal() *WALHeader {
+	if x != nil {
+		return x.Wal
+	}
+	return nil
+}
+
+func (x *DatabaseReport) GetWarnings() []string {
+	if x != nil {
+		return x.Warnings
+	}
+	return nil
+}
+
+type DatabaseHeader struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PageSize      uint32                 `protobuf:"varint,1,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageCount     uint32                 `protobuf:"varint,2,opt,name=page_count,json=pageCount,proto3" json:"page_count,omitempty"`
+	TextEncoding  uint32                 `protobuf:"varint,3,opt,name=text_encoding,json=textEncoding,proto3" json:"text_encoding,omitempty"`
+	SchemaCookie  uint32                 `protobuf:"varint,4,opt,name=schema_cookie,json=schemaCookie,proto3" json:"schema_cookie,omitempty"`
+	ChangeCounter uint32                 `protobuf:"varint,5,opt,name=change_counter,json=changeCounter,proto3" json:"change_counter,omitempty"`
+	ApplicationId uint32                 `protobuf:"varint,6,opt,name=application_id,json=applicationId,proto3" json:"application_id,omitempty"`
+	UserVersion   uint32                 `protobuf:"varint,7,opt,name=user_version,json=userVersion,proto3" json:"user_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DatabaseHeader) Reset() {
+	*x = DatabaseHeader{}
+	mi := &file_snapshot_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DatabaseHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DatabaseHeader) ProtoMessage() {}
+
+func (x *DatabaseHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DatabaseHeader.ProtoReflect.Descriptor instead.
+func (*DatabaseHeader) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *DatabaseHeader) GetPageSize() uint32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *DatabaseHeader) GetPageCount() uint32 {
+	if x != nil {
+		return x.PageCount
+	}
+	return 0
+}
+
+func (x *DatabaseHeader) GetTextEncoding() uint32 {
+	if x != nil {
+		return x.TextEncoding
+	}
+	return 0
+}
+
+func (x *DatabaseHeader) GetSchemaCookie() uint32 {
+	if x != nil {
+		return x.SchemaCookie
+	}
+	return 0
+}
+
+func (x *DatabaseHeader) GetChangeCounter() uint32 {
+	if x != nil {
+		return x.ChangeCounter
+	}
+	return 0
+}
+
+func (x *DatabaseHeader) GetApplicationId() uint32 {
+	if x != nil {
+		return x.ApplicationId
+	}
+	return 0
+}
+
+func (x *DatabaseHeader) GetUserVersion() uint32 {
+	if x != nil {
+		return x.UserVersion
+	}
+	return 0
+}
+
+type WALHeader struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PageSize      uint32                 `protobuf:"varint,1,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	Frames        uint64                 `protobuf:"varint,2,opt,name=frames,proto3" json:"frames,omitempty"`
+	CheckpointSeq uint32                 `protobuf:"varint,3,opt,name=checkpoint_seq,json=checkpointSeq,proto3" json:"checkpoint_seq,omitempty"`
+	Salt1         uint32                 `protobuf:"varint,4,opt,name=salt1,proto3" json:"salt1,omitempty"`
+	Salt2         uint32                 `protobuf:"varint,5,opt,name=salt2,proto3" json:"salt2,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WALHeader) Reset() {
+	*x = WALHeader{}
+	mi := &file_snapshot_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WALHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WALHeader) ProtoMessage() {}
+
+func (x *WALHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WALHeader.ProtoReflect.Descriptor instead.
+func (*WALHeader) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *WALHeader) GetPageSize() uint32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *WALHeader) GetFrames() uint64 {
+	if x != nil {
+		return x.Frames
+	}
+	return 0
+}
+
+func (x *WALHeader) GetCheckpointSeq() uint32 {
+	if x != nil {
+		return x.CheckpointSeq
+	}
+	return 0
+}
+
+func (x *WALHeader) GetSalt1() uint32 {
+	if x != nil {
+		return x.Salt1
+	}
+	return 0
+}
+
+func (x *WALHeader) GetSalt2() uint32 {
+	if x != nil {
+		return x.Salt2
+	}
+	return 0
+}
+
+// Snapshot is an unpacked snapshot, described by its manifest.
+type Snapshot struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Snapshot      string                 `protobuf:"bytes,2,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	SnapshotSize  int64                  `protobuf:"varint,3,opt,name=snapshot_size,json=snapshotSize,proto3" json:"snapshot_size,omitempty"`
+	Complete      bool                   `protobuf:"varint,4,opt,name=complete,proto3" json:"complete,omitempty"`
+	Compression   []string               `protobuf:"bytes,5,rep,name=compression,proto3" json:"compression,omitempty"`
+	Databases     []*Database            `protobuf:"bytes,6,rep,name=databases,proto3" json:"databases,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Snapshot) Reset() {
+	*x = Snapshot{}
+	mi := &file_snapshot_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Snapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Snapshot) ProtoMessage() {}
+
+func (x *Snapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Snapshot.ProtoReflect.Descriptor instead.
+func (*Snapshot) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Snapshot) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Snapshot) GetSnapshot() string {
+	if x != nil {
+		return x.Snapshot
+	}
+	return ""
+}
+
+func (x *Snapshot) GetSnapshotSize() int64 {
+	if x != nil {
+		return x.SnapshotSize
+	}
+	return 0
+}
+
+func (x *Snapshot) GetComplete() bool {
+	if x != nil {
+		return x.Complete
+	}
+	return false
+}
+
+func (x *Snapshot) GetCompression() []string {
+	if x != nil {
+		return x.Compression
+	}
+	return nil
+}
+
+func (x *Snapshot) GetDatabases() []*Database {
+	if x != nil {
+		return x.Databases
+	}
+	return nil
+}
+
+type Database struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	MainSize      uint64                 `protobuf:"varint,2,opt,name=main_size,json=mainSize,proto3" json:"main_size,omitempty"`
+	WalSize       uint64                 `protobuf:"varint,3,opt,name=wal_size,json=walSize,proto3" json:"wal_size,omitempty"`
+	Files         []*File                `protobuf:"bytes,4,rep,name=files,proto3" json:"files,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Database) Reset() {
+	*x = Database{}
+	mi := &file_snapshot_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Database) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Database) ProtoMessage() {}
+
+func (x *Database) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Database.ProtoReflect.Descriptor instead.
+func (*Database) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Database) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Database) GetMainSize() uint64 {
+	if x != nil {
+		return x.MainSize
+	}
+	return 0
+}
+
+func (x *Database) GetWalSize() uint64 {
+	if x != nil {
+		return x.WalSize
+	}
+	return 0
+}
+
+func (x *Database) GetFiles() []*File {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
+// File is an extracted file, found at offset in the decompressed snapshot.
+type File struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Size          int64                  `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	Sha256        string                 `protobuf:"bytes,4,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *File) Reset() {
+	*x = File{}
+	mi := &file_snapshot_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *File) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*File) ProtoMessage() {}
+
+func (x *File) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use File.ProtoReflect.Descriptor instead.
+func (*File) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *File) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *File) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *File) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *File) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+type QueryRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The id of a snapshot unpacked with Unpack, or through the REST API.
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Required if the snapshot has more than one database.
+	Db            string `protobuf:"bytes,2,opt,name=db,proto3" json:"db,omitempty"`
+	Sql           string `protobuf:"bytes,3,opt,name=sql,proto3" json:"sql,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryRequest) Reset() {
+	*x = QueryRequest{}
+	mi := &file_snapshot_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryRequest) ProtoMessage() {}
+
+func (x *QueryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryRequest.ProtoReflect.Descriptor instead.
+func (*QueryRequest) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *QueryRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *QueryRequest) GetDb() string {
+	if x != nil {
+		return x.Db
+	}
+	return ""
+}
+
+func (x *QueryRequest) GetSql() string {
+	if x != nil {
+		return x.Sql
+	}
+	return ""
+}
+
+// QueryResponse carries a batch of rows. The first one also has the names of
+// the columns.
+type QueryResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Columns       []string               `protobuf:"bytes,1,rep,name=columns,proto3" json:"columns,omitempty"`
+	Rows          []*Row                 `protobuf:"bytes,2,rep,name=rows,proto3" json:"rows,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryResponse) Reset() {
+	*x = QueryResponse{}
+	mi := &file_snapshot_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryResponse) ProtoMessage() {}
+
+func (x *QueryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryResponse.ProtoReflect.Descriptor instead.
+func (*QueryResponse) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *QueryResponse) GetColumns() []string {
+	if x != nil {
+		return x.Columns
+	}
+	return nil
+}
+
+func (x *QueryResponse) GetRows() []*Row {
+	if x != nil {
+		return x.Rows
+	}
+	return nil
+}
+
+type Row struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Values        []*Value               `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Row) Reset() {
+	*x = Row{}
+	mi := &file_snapshot_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Row) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Row) ProtoMessage() {}
+
+func (x *Row) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Row.ProtoReflect.Descriptor instead.
+func (*Row) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Row) GetValues() []*Value {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+// Value is an SQLite value, NULL when none is set.
+type Value struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Value:
+	//
+	//	*Value_Integer
+	//	*Value_Real
+	//	*Value_Text
+	//	*Value_Blob
+	Value         isValue_Value `protobuf_oneof:"value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Value) Reset() {
+	*x = Value{}
+	mi := &file_snapshot_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Value) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Value) ProtoMessage() {}
+
+func (x *Value) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Value.ProtoReflect.Descriptor instead.
+func (*Value) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Value) GetValue() isValue_Value {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *Value) GetInteger() int64 {
+	if x != nil {
+		if x, ok := x.Value.(*Value_Integer); ok {
+			return x.Integer
+		}
+	}
+	return 0
+}
+
+func (x *Value) GetReal() float64 {
+	if x != nil {
+		if x, ok := x.Value.(*Value_Real); ok {
+			return x.Real
+		}
+	}
+	return 0
+}
+
+func (x *Value) GetText() string {
+	if x != nil {
+		if x, ok := x.Value.(*Value_Text); ok {
+			return x.Text
+		}
+	}
+	return ""
+}
+
+func (x *Value) GetBlob() []byte {
+	if x != nil {
+		if x, ok := x.Value.(*Value_Blob); ok {
+			return x.Blob
+		}
+	}
+	return nil
+}
+
+type isValue_Value interface {
+	isValue_Value()
+}
+
+type Value_Integer struct {
+	Integer int64 `protobuf:"varint,1,opt,name=integer,proto3,oneof"`
+}
+
+type Value_Real struct {
+	Real float64 `protobuf:"fixed64,2,opt,name=real,proto3,oneof"`
+}
+
+type Value_Text struct {
+	Text string `protobuf:"bytes,3,opt,name=text,proto3,oneof"`
+}
+
+type Value_Blob struct {
+	Blob []byte `protobuf:"bytes,4,opt,name=blob,proto3,oneof"`
+}
+
+func (*Value_Integer) isValue_Value() {}
+
+func (*Value_Real) isValue_Value() {}
+
+func (*Value_Text) isValue_Value() {}
+
+func (*Value_Blob) isValue_Value() {}
+
+var File_snapshot_proto protoreflect.FileDescriptor
+
+const file_snapshot_proto_rawDesc = "" +
+	"\n" +
+	"\x0esnapshot.proto\x12\x11dqlitesnapshot.v1\"F\n" +
+	"\x0eSnapshotSource\x12\x12\n" +
+	"\x03url\x18\x01 \x01(\tH\x00R\x03url\x12\x16\n" +
+	"\x05chunk\x18\x02 \x01(\fH\x00R\x05chunkB\b\n" +
+	"\x06source\"t\n" +
+	"\x0fInspectResponse\x12 \n" +
+	"\vcompression\x18\x01 \x03(\tR\vcompression\x12?\n" +
+	"\tdatabases\x18\x02 \x03(\v2!.dqlitesnapshot.v1.DatabaseReportR\tdatabases\"\xe3\x01\n" +
+	"\x0eDatabaseReport\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1b\n" +
+	"\tmain_size\x18\x02 \x01(\x04R\bmainSize\x12\x19\n" +
+	"\bwal_size\x18\x03 \x01(\x04R\awalSize\x129\n" +
+	"\x06header\x18\x04 \x01(\v2!.dqlitesnapshot.v1.DatabaseHeaderR\x06header\x12.\n" +
+	"\x03wal\x18\x05 \x01(\v2\x1c.dqlitesnapshot.v1.WALHeaderR\x03wal\x12\x1a\n" +
+	"\bwarnings\x18\x06 \x03(\tR\bwarnings\"\x87\x02\n" +
+	"\x0eDatabaseHeader\x12\x1b\n" +
+	"\tpage_size\x18\x01 \x01(\rR\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_count\x18\x02 \x01(\rR\tpageCount\x12#\n" +
+	"\rtext_encoding\x18\x03 \x01(\rR\ftextEncoding\x12#\n" +
+	"\rschema_cookie\x18\x04 \x01(\rR\fschemaCookie\x12%\n" +
+	"\x0echange_counter\x18\x05 \x01(\rR\rchangeCounter\x12%\n" +
+	"\x0eapplication_id\x18\x06 \x01(\rR\rapplicationId\x12!\n" +
+	"\fuser_version\x18\a \x01(\rR\vuserVersion\"\x93\x01\n" +
+	"\tWALHeader\x12\x1b\n" +
+	"\tpage_size\x18\x01 \x01(\rR\bpageSize\x12\x16\n" +
+	"\x06frames\x18\x02 \x01(\x04R\x06frames\x12%\n" +
+	"\x0echeckpoint_seq\x18\x03 \x01(\rR\rcheckpointSeq\x12\x14\n" +
+	"\x05salt1\x18\x04 \x01(\rR\x05salt1\x12\x14\n" +
+	"\x05salt2\x18\x05 \x01(\rR\x05salt2\"\xd4\x01\n" +
+	"\bSnapshot\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1a\n" +
+	"\bsnapshot\x18\x02 \x01(\tR\bsnapshot\x12#\n" +
+	"\rsnapshot_size\x18\x03 \x01(\x03R\fsnapshotSize\x12\x1a\n" +
+	"\bcomplete\x18\x04 \x01(\bR\bcomplete\x12 \n" +
+	"\vcompression\x18\x05 \x03(\tR\vcompression\x129\n" +
+	"\tdatabases\x18\x06 \x03(\v2\x1b.dqlitesnapshot.v1.DatabaseR\tdatabases\"\x85\x01\n" +
+	"\bDatabase\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1b\n" +
+	"\tmain_size\x18\x02 \x01(\x04R\bmainSize\x12\x19\n" +
+	"\bwal_size\x18\x03 \x01(\x04R\awalSize\x12-\n" +
+	"\x05files\x18\x04 \x03(\v2\x17.dqlitesnapshot.v1.FileR\x05files\"^\n" +
+	"\x04File\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x12\n" +
+	"\x04size\x18\x03 \x01(\x03R\x04size\x12\x16\n" +
+	"\x06sha256\x18\x04 \x01(\tR\x06sha256\"@\n" +
+	"\fQueryRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x0e\n" +
+	"\x02db\x18\x02 \x01(\tR\x02db\x12\x10\n" +
+	"\x03sql\x18\x03 \x01(\tR\x03sql\"U\n" +
+	"\rQueryResponse\x12\x18\n" +
+	"\acolumns\x18\x01 \x03(\tR\acolumns\x12*\n" +
+	"\x04rows\x18\x02 \x03(\v2\x16.dqlitesnapshot.v1.RowR\x04rows\"7\n" +
+	"\x03Row\x120\n" +
+	"\x06values\x18\x01 \x03(\v2\x18.dqlitesnapshot.v1.ValueR\x06values\"n\n" +
+	"\x05Value\x12\x1a\n" +
+	"\ainteger\x18\x01 \x01(\x03H\x00R\ainteger\x12\x14\n" +
+	"\x04real\x18\x02 \x01(\x01H\x00R\x04real\x12\x14\n" +
+	"\x04text\x18\x03 \x01(\tH\x00R\x04text\x12\x14\n" +
+	"\x04blob\x18\x04 \x01(\fH\x00R\x04blobB\a\n" +
+	"\x05value2\xff\x01\n" +
+	"\x0fSnapshotService\x12R\n" +
+	"\aInspect\x12!.dqlitesnapshot.v1.SnapshotSource\x1a\".dqlitesnapshot.v1.InspectResponse(\x01\x12J\n" +
+	"\x06Unpack\x12!.dqlitesnapshot.v1.SnapshotSource\x1a\x1b.dqlitesnapshot.v1.Snapshot(\x01\x12L\n" +
+	"\x05Query\x12\x1f.dqlitesnapshot.v1.QueryRequest\x1a .dqlitesnapshot.v1.QueryResponse0\x01B/Z-github.com/marco6/dqlite-snapshot-unpack;mainb\x06proto3"
+
+var (
+	file_snapshot_proto_rawDescOnce sync.Once
+	file_snapshot_proto_rawDescData []byte
+)
+
+func file_snapshot_proto_rawDescGZIP() []byte {
+	file_snapshot_proto_rawDescOnce.Do(func() {
+		file_snapshot_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_snapshot_proto_rawDesc), len(file_snapshot_proto_rawDesc)))
+	})
+	return file_snapshot_proto_rawDescData
+}
+
+var file_snapshot_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_snapshot_proto_goTypes = []any{
+	(*SnapshotSource)(nil),  // 0: dqlitesnapshot.v1.SnapshotSource
+	(*InspectResponse)(nil), // 1: dqlitesnapshot.v1.InspectResponse
+	(*DatabaseReport)(nil),  // 2: dqlitesnapshot.v1.DatabaseReport
+	(*DatabaseHeader)(nil),  // 3: dqlitesnapshot.v1.DatabaseHeader
+	(*WALHeader)(nil),       // 4: dqlitesnapshot.v1.WALHeader
+	(*Snapshot)(nil),        // 5: dqlitesnapshot.v1.Snapshot
+	(*Database)(nil),        // 6: dqlitesnapshot.v1.Database
+	(*File)(nil),            // 7: dqlitesnapshot.v1.File
+	(*QueryRequest)(nil),    // 8: dqlitesnapshot.v1.QueryRequest
+	(*QueryResponse)(nil),   // 9: dqlitesnapshot.v1.QueryResponse
+	(*Row)(nil),             // 10: dqlitesnapshot.v1.Row
+	(*Value)(nil),           // 11: dqlitesnapshot.v1.Value
+}
+var file_snapshot_proto_depIdxs = []int32{
+	2,  // 0: dqlitesnapshot.v1.InspectResponse.databases:type_name -> dqlitesnapshot.v1.DatabaseReport
+	3,  // 1: dqlitesnapshot.v1.DatabaseReport.header:type_name -> dqlitesnapshot.v1.DatabaseHeader
+	4,  // 2: dqlitesnapshot.v1.DatabaseReport.wal:type_name -> dqlitesnapshot.v1.WALHeader
+	6,  // 3: dqlitesnapshot.v1.Snapshot.databases:type_name -> dqlitesnapshot.v1.Database
+	7,  // 4: dqlitesnapshot.v1.Database.files:type_name -> dqlitesnapshot.v1.File
+	10, // 5: dqlitesnapshot.v1.QueryResponse.rows:type_name -> dqlitesnapshot.v1.Row
+	11, // 6: dqlitesnapshot.v1.Row.values:type_name -> dqlitesnapshot.v1.Value
+	0,  // 7: dqlitesnapshot.v1.SnapshotService.Inspect:input_type -> dqlitesnapshot.v1.SnapshotSource
+	0,  // 8: dqlitesnapshot.v1.SnapshotService.Unpack:input_type -> dqlitesnapshot.v1.SnapshotSource
+	8,  // 9: dqlitesnapshot.v1.SnapshotService.Query:input_type -> dqlitesnapshot.v1.QueryRequest
+	1,  // 10: dqlitesnapshot.v1.SnapshotService.Inspect:output_type -> dqlitesnapshot.v1.InspectResponse
+	5,  // 11: dqlitesnapshot.v1.SnapshotService.Unpack:output_type -> dqlitesnapshot.v1.Snapshot
+	9,  // 12: dqlitesnapshot.v1.SnapshotService.Query:output_type -> dqlitesnapshot.v1.QueryResponse
+	10, // [10:13] is the sub-list for method output_type
+	7,  // [7:10] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_snapshot_proto_init() }
+func file_snapshot_proto_init() {
+	if File_snapshot_proto != nil {
+		return
+	}
+	file_snapshot_proto_msgTypes[0].OneofWrappers = []any{
+		(*SnapshotSource_Url)(nil),
+		(*SnapshotSource_Chunk)(nil),
+	}
+	file_snapshot_proto_msgTypes[11].OneofWrappers = []any{
+		(*Value_Integer)(nil),
+		(*Value_Real)(nil),
+		(*Value_Text)(nil),
+		(*Value_Blob)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_snapshot_proto_rawDesc), len(file_snapshot_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_snapshot_proto_goTypes,
+		DependencyIndexes: file_snapshot_proto_depIdxs,
+		MessageInfos:      file_snapshot_proto_msgTypes,
+	}.Build()
+	File_snapshot_proto = out.File
+	file_snapshot_proto_goTypes = nil
+	file_snapshot_proto_depIdxs = nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// FuzzSnapshot feeds arbitrary input to the snapshot parser, compression
+// detection included, which must fail cleanly rather than panic or blow up.
+func FuzzSnapshot(f *testing.F) {
+	entries := []*dbEntry{{name: "db", mainSize: 8, walSize: 4}, {name: "k8s", mainSize: 3}}
+	var raw bytes.Buffer
+	w := &snapshotWriter{&raw}
+	w.writeHeader(uint64(len(entries)))
+	for _, entry := range entries {
+		w.writeEntry(entry)
+		raw.Write(make([]byte, entry.mainSize+entry.walSize))
+	}
+	f.Add(raw.Bytes())
+
+	var compressed bytes.Buffer
+	lz4, err := newLZ4Writer(&compressed, int64(raw.Len()))
+	if err != nil {
+		f.Fatal(err)
+	}
+	lz4.Write(raw.Bytes())
+	if err := lz4.Close(); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(compressed.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		snapshot, err := newSnapshotReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		defer snapshot.Close()
+
+		for {
+			entry, err := snapshot.next()
+			if err != nil || entry == nil {
+				break
+			}
+			if _, err := io.CopyN(io.Discard, snapshot, int64(entry.mainSize+entry.walSize)); err != nil {
+				break
+			}
+		}
+		snapshot.checkEOF()
+	})
+}
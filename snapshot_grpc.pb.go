@@ -0,0 +1,200 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: snapshot.proto
+
+package main
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	SnapshotService_Inspect_FullMethodName = "/dqlitesnapshot.v1.SnapshotService/Inspect"
+	SnapshotService_Unpack_FullMethodName  = "/dqlitesnapshot.v1.SnapshotService/Unpack"
+	SnapshotService_Query_FullMethodName   = "/dqlitesnapshot.v1.SnapshotService/Query"
+)
+
+// SnapshotServiceClient is the client API for SnapshotService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// SnapshotService is the gRPC API of serve, next to its REST API and sharing
+// its unpacked snapshots.
+type SnapshotServiceClient interface {
+	// Inspect reports the content of a snapshot without unpacking it.
+	Inspect(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[SnapshotSource, InspectResponse], error)
+	// Unpack unpacks a snapshot on the server, where it can then be queried.
+	Unpack(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[SnapshotSource, Snapshot], error)
+	// Query runs a read-only SQL statement against a database of an unpacked
+	// snapshot, streaming the rows back.
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[QueryResponse], error)
+}
+
+type snapshotServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSnapshotServiceClient(cc grpc.ClientConnInterface) SnapshotServiceClient {
+	return &snapshotServiceClient{cc}
+}
+
+func (c *snapshotServiceClient) Inspect(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[SnapshotSource, InspectResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SnapshotService_ServiceDesc.Streams[0], SnapshotService_Inspect_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SnapshotSource, InspectResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SnapshotService_InspectClient = grpc.ClientStreamingClient[SnapshotSource, InspectResponse]
+
+func (c *snapshotServiceClient) Unpack(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[SnapshotSource, Snapshot], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SnapshotService_ServiceDesc.Streams[1], SnapshotService_Unpack_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SnapshotSource, Snapshot]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SnapshotService_UnpackClient = grpc.ClientStreamingClient[SnapshotSource, Snapshot]
+
+func (c *snapshotServiceClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[QueryResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SnapshotService_ServiceDesc.Streams[2], SnapshotService_Query_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[QueryRequest, QueryResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SnapshotService_QueryClient = grpc.ServerStreamingClient[QueryResponse]
+
+// SnapshotServiceServer is the server API for SnapshotService service.
+// All implementations must embed UnimplementedSnapshotServiceServer
+// for forward compatibility.
+//
+// SnapshotService is the gRPC API of serve, next to its REST API and sharing
+// its unpacked snapshots.
+type SnapshotServiceServer interface {
+	// Inspect reports the content of a snapshot without unpacking it.
+	Inspect(grpc.ClientStreamingServer[SnapshotSource, InspectResponse]) error
+	// Unpack unpacks a snapshot on the server, where it can then be queried.
+	Unpack(grpc.ClientStreamingServer[SnapshotSource, Snapshot]) error
+	// Query runs a read-only SQL statement against a database of an unpacked
+	// snapshot, streaming the rows back.
+	Query(*QueryRequest, grpc.ServerStreamingServer[QueryResponse]) error
+	mustEmbedUnimplementedSnapshotServiceServer()
+}
+
+// UnimplementedSnapshotServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSnapshotServiceServer struct{}
+
+func (UnimplementedSnapshotServiceServer) Inspect(grpc.ClientStreamingServer[SnapshotSource, InspectResponse]) error {
+	return status.Error(codes.Unimplemented, "method Inspect not implemented")
+}
+func (UnimplementedSnapshotServiceServer) Unpack(grpc.ClientStreamingServer[SnapshotSource, Snapshot]) error {
+	return status.Error(codes.Unimplemented, "method Unpack not implemented")
+}
+func (UnimplementedSnapshotServiceServer) Query(*QueryRequest, grpc.ServerStreamingServer[QueryResponse]) error {
+	return status.Error(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedSnapshotServiceServer) mustEmbedUnimplementedSnapshotServiceServer() {}
+func (UnimplementedSnapshotServiceServer) testEmbeddedByValue()                         {}
+
+// UnsafeSnapshotServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SnapshotServiceServer will
+// result in compilation errors.
+type UnsafeSnapshotServiceServer interface {
+	mustEmbedUnimplementedSnapshotServiceServer()
+}
+
+func RegisterSnapshotServiceServer(s grpc.ServiceRegistrar, srv SnapshotServiceServer) {
+	// If the following call panics, it indicates UnimplementedSnapshotServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SnapshotService_ServiceDesc, srv)
+}
+
+func _SnapshotService_Inspect_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SnapshotServiceServer).Inspect(&grpc.GenericServerStream[SnapshotSource, InspectResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SnapshotService_InspectServer = grpc.ClientStreamingServer[SnapshotSource, InspectResponse]
+
+func _SnapshotService_Unpack_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SnapshotServiceServer).Unpack(&grpc.GenericServerStream[SnapshotSource, Snapshot]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SnapshotService_UnpackServer = grpc.ClientStreamingServer[SnapshotSource, Snapshot]
+
+func _SnapshotService_Query_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(QueryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SnapshotServiceServer).Query(m, &grpc.GenericServerStream[QueryRequest, QueryResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SnapshotService_QueryServer = grpc.ServerStreamingServer[QueryResponse]
+
+// SnapshotService_ServiceDesc is the grpc.ServiceDesc for SnapshotService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SnapshotService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dqlitesnapshot.v1.SnapshotService",
+	HandlerType: (*SnapshotServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Inspect",
+			Handler:       _SnapshotService_Inspect_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Unpack",
+			Handler:       _SnapshotService_Unpack_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Query",
+			Handler:       _SnapshotService_Query_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "snapshot.proto",
+}
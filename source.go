@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openRemote opens the snapshot at path if it is a URL, returning nil for
+// local paths.
+func openRemote(path string) (io.ReadCloser, error) {
+	switch {
+	case strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://"):
+		h, err := openHTTP(path)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	case strings.HasPrefix(path, "s3://") || strings.HasPrefix(path, "gs://") || strings.HasPrefix(path, "azblob://"):
+		return openBlob(path)
+	case strings.HasPrefix(path, "ssh://"):
+		u, err := url.Parse(path)
+		if err != nil {
+			return nil, err
+		}
+		host := u.Hostname()
+		if u.User != nil {
+			host = u.User.Username() + "@" + host
+		}
+		return openSSH(host, u.Port(), u.Path)
+	}
+
+	// Like with scp, host:path and user@host:path are remote, unless a local
+	// file is named so.
+	if host, file, ok := scpPath(path); ok {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return openSSH(host, "", file)
+		}
+	}
+	return nil, nil
+}
+
+// scpPath splits path if it reads as host:path or user@host:path. Local paths
+// with a colon, such as dir/a:b or the C:\x and C:x of Windows, don't; nor
+// does host: alone.
+func scpPath(path string) (host, file string, ok bool) {
+	host, file, ok = strings.Cut(path, ":")
+	if !ok || file == "" || strings.ContainsAny(host, `/\`) || isDriveLetter(host) {
+		return "", "", false
+	}
+	// Neither the host nor, if given, the user can be empty.
+	if user, name, found := strings.Cut(host, "@"); user == "" || found && name == "" {
+		return "", "", false
+	}
+	return host, file, true
+}
+
+func isDriveLetter(s string) bool {
+	return len(s) == 1 && ('a' <= s[0] && s[0] <= 'z' || 'A' <= s[0] && s[0] <= 'Z')
+}
+
+// sshReader streams a file of another machine through the ssh command, so
+// that the usual configuration, keys and agent apply.
+type sshReader struct {
+	io.ReadCloser
+	cmd  *exec.Cmd
+	host string
+}
+
+func openSSH(host, port, path string) (*sshReader, error) {
+	args := []string{"-e", "none"}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, "--", host, "cat -- "+shellQuote(path))
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("couldn't run ssh: %w", err)
+	}
+	return &sshReader{ReadCloser: stdout, cmd: cmd, host: host}, nil
+}
+
+// Read turns the end of the output into an error if ssh, or cat on the other
+// side, failed: the file may have been cut short.
+func (s *sshReader) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	if err == io.EOF {
+		if waitErr := s.wait(); waitErr != nil {
+			return n, fmt.Errorf("couldn't read from %s: %w", s.host, waitErr)
+		}
+	}
+	return n, err
+}
+
+func (s *sshReader) wait() error {
+	if s.cmd.ProcessState != nil {
+		if !s.cmd.ProcessState.Success() {
+			return fmt.Errorf("ssh %s", s.cmd.ProcessState)
+		}
+		return nil
+	}
+	if err := s.cmd.Wait(); err != nil {
+		return fmt.Errorf("ssh %w", err)
+	}
+	return nil
+}
+
+// Close stops the transfer if it is still going.
+func (s *sshReader) Close() error {
+	if s.cmd.ProcessState == nil {
+		s.cmd.Process.Kill()
+		s.cmd.Wait()
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// httpRetries is how many times in a row a download is resumed after the
+// connection breaks, before giving up.
+const httpRetries = 5
+
+// httpReader streams a snapshot served over HTTP(S). When the connection
+// breaks, it picks up where it stopped with a Range request, as long as the
+// server still has the same content.
+type httpReader struct {
+	url    string
+	body   io.ReadCloser
+	offset int64
+	size   int64  // -1 if unknown
+	check  string // ETag or Last-Modified of the content, for If-Range
+	failed int    // retries since the last successful read
+}
+
+func openHTTP(url string) (*httpReader, error) {
+	h := &httpReader{url: url}
+	resp, err := h.get()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("couldn't fetch %s: %s", url, resp.Status)
+	}
+	h.body, h.size = resp.Body, resp.ContentLength
+	// A weak ETag can't be used with If-Range.
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.check = etag
+	} else {
+		h.check = resp.Header.Get("Last-Modified")
+	}
+	return h, nil
+}
+
+func (h *httpReader) get() (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", h.offset))
+		req.Header.Set("If-Range", h.check)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch %s: %w", h.url, err)
+	}
+	return resp, nil
+}
+
+func (h *httpReader) Read(p []byte) (int, error) {
+	for {
+		n, err := h.body.Read(p)
+		h.offset += int64(n)
+		if n > 0 {
+			h.failed = 0
+		}
+		if err == nil || err == io.EOF && (h.size < 0 || h.offset == h.size) {
+			return n, err
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err := h.resume(err); err != nil || n > 0 {
+			return n, err
+		}
+	}
+}
+
+// resume reopens the download at the current offset after it failed with
+// err.
+func (h *httpReader) resume(err error) error {
+	h.body.Close()
+	h.body = http.NoBody
+	if h.failed == httpRetries || h.check == "" {
+		return fmt.Errorf("couldn't download %s: %w", h.url, err)
+	}
+	h.failed++
+	logger.Info(fmt.Sprintf("Download of %s interrupted after %d bytes (%v), resuming", h.url, h.offset, err))
+	time.Sleep(time.Duration(h.failed) * time.Second)
+
+	resp, rerr := h.get()
+	if rerr != nil {
+		return h.resume(rerr)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return fmt.Errorf("couldn't resume downloading %s: it changed or the server doesn't support ranges", h.url)
+		}
+		return h.resume(fmt.Errorf("%s", resp.Status))
+	}
+	if start, ok := rangeStart(resp.Header.Get("Content-Range")); !ok || start != h.offset {
+		resp.Body.Close()
+		return fmt.Errorf("couldn't resume downloading %s: asked for the content from byte %d, got range %q",
+			h.url, h.offset, resp.Header.Get("Content-Range"))
+	}
+	h.body = resp.Body
+	return nil
+}
+
+// rangeStart returns the first byte of a Content-Range header, bytes
+// <first>-<last>/<size>.
+func rangeStart(header string) (int64, bool) {
+	first, _, ok := strings.Cut(strings.TrimPrefix(header, "bytes "), "-")
+	if !ok || !strings.HasPrefix(header, "bytes ") {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil && start >= 0
+}
+
+func (h *httpReader) Close() error {
+	return h.body.Close()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testRangeServer serves content, breaking the first connection halfway
+// through. Range requests are answered with the Content-Range given by
+// contentRange, from the first byte asked for.
+func testRangeServer(content string, contentRange func(first int) string) *httptest.Server {
+	broken := false
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		var first int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &first); err != nil {
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			w.WriteHeader(http.StatusOK)
+			if !broken {
+				broken = true
+				io.WriteString(w, content[:len(content)/2])
+				panic(http.ErrAbortHandler)
+			}
+			io.WriteString(w, content)
+			return
+		}
+		w.Header().Set("Content-Range", contentRange(first))
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)-first))
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, content[first:])
+	}))
+}
+
+func TestHTTPReaderResume(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+
+	t.Run("resumed", func(t *testing.T) {
+		server := testRangeServer(content, func(first int) string {
+			return fmt.Sprintf("bytes %d-%d/%d", first, len(content)-1, len(content))
+		})
+		defer server.Close()
+		h, err := openHTTP(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+		data, err := io.ReadAll(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("downloaded %d bytes, not the content served", len(data))
+		}
+	})
+
+	t.Run("wrong range", func(t *testing.T) {
+		server := testRangeServer(content, func(int) string {
+			return fmt.Sprintf("bytes 0-%d/%d", len(content)-1, len(content))
+		})
+		defer server.Close()
+		h, err := openHTTP(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+		if _, err := io.ReadAll(h); err == nil || !strings.Contains(err.Error(), "couldn't resume") {
+			t.Errorf("got error %v, want the resumed range refused", err)
+		}
+	})
+}
+
+func TestRangeStart(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		want   int64
+		ok     bool
+	}{
+		{"bytes 100-199/200", 100, true},
+		{"bytes 0-99/*", 0, true},
+		{"bytes */200", 0, false},
+		{"bytes -1-99/200", 0, false},
+		{"items 100-199/200", 0, false},
+		{"", 0, false},
+	} {
+		if start, ok := rangeStart(tc.header); start != tc.want || ok != tc.ok {
+			t.Errorf("rangeStart(%q) = %d, %t, want %d, %t", tc.header, start, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestSCPPath(t *testing.T) {
+	for _, tc := range []struct {
+		path, host, file string
+	}{
+		{"node1:/var/snapshot", "node1", "/var/snapshot"},
+		{"ubuntu@10.0.0.1:snapshot", "ubuntu@10.0.0.1", "snapshot"},
+		{"node1:", "", ""},
+		{":snapshot", "", ""},
+		{"@node1:snapshot", "", ""},
+		{"ubuntu@:snapshot", "", ""},
+		{"dir/a:b", "", ""},
+		{`dir\a:b`, "", ""},
+		{`C:\snapshot`, "", ""},
+		{"c:snapshot", "", ""},
+		{"snapshot", "", ""},
+	} {
+		host, file, ok := scpPath(tc.path)
+		if host != tc.host || file != tc.file || ok != (tc.host != "") {
+			t.Errorf("scpPath(%q) = %q, %q, %t, want %q, %q", tc.path, host, file, ok, tc.host, tc.file)
+		}
+	}
+}
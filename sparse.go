@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// sparseBlockSize is the granularity at which sparseWriter looks for zeros,
+// the usual page and filesystem block size.
+const sparseBlockSize = 4096
+
+var zeroBlock = make([]byte, sparseBlockSize)
+
+// sparseWriter writes to a file, seeking over blocks made of zeros instead
+// of writing them, which leaves holes taking no disk space. finish must be
+// called once done, to give the file its full size should it end with a
+// hole.
+type sparseWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (s *sparseWriter) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		block := p[written:min(len(p), written+sparseBlockSize)]
+		if bytes.Equal(block, zeroBlock[:len(block)]) {
+			if _, err := s.file.Seek(int64(len(block)), io.SeekCurrent); err != nil {
+				return written, err
+			}
+		} else if _, err := s.file.Write(block); err != nil {
+			return written, err
+		}
+		written += len(block)
+		s.offset += int64(len(block))
+	}
+	return len(p), nil
+}
+
+func (s *sparseWriter) finish() error {
+	return s.file.Truncate(s.offset)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// openDatabase opens the extracted database at path through the embedded
+// SQLite driver. Read-only handles never checkpoint the WAL, so the extracted
+// files are left exactly as they were found in the snapshot.
+func openDatabase(path string, readOnly bool) (*sql.DB, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath()
+	if readOnly {
+		dsn += "?mode=ro"
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// A single connection keeps the shared-memory index (and any temporary
+	// state) private to this handle.
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// withDatabase runs fn against a read-only handle on the database at path,
+// removing the -shm file SQLite leaves behind unless it was already there.
+func withDatabase(path string, fn func(db *sql.DB) error) error {
+	_, err := os.Stat(path + "-shm")
+	hadShm := !errors.Is(err, fs.ErrNotExist)
+
+	db, err := openDatabase(path, true)
+	if err != nil {
+		return err
+	}
+	err = fn(db)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+
+	if !hadShm {
+		os.Remove(path + "-shm")
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+//go:build cgo
+
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// openImage opens a read-only, in-memory database holding image, the whole
+// content of a database file, without writing anything to disk.
+func openImage(image []byte) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	// Every connection to :memory: is a database of its own: the one the
+	// image goes into must be the only one, and stay open.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	err = conn.Raw(func(c any) error {
+		return c.(*sqlite3.SQLiteConn).Deserialize(image, "main")
+	})
+	if err == nil {
+		_, err = conn.ExecContext(context.Background(), "PRAGMA query_only = ON")
+	}
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
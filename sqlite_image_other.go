@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// openImage needs sqlite3_deserialize, out of reach without cgo.
+func openImage(image []byte) (*sql.DB, error) {
+	return nil, errors.New("in-memory databases require cgo")
+}
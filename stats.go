@@ -0,0 +1,280 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// tableStats summarizes a table of an extracted database.
+type tableStats struct {
+	Name    string
+	Rows    int64
+	Bytes   int64 // pages used by the table and its indexes
+	Indexes int
+}
+
+// printTableStats reports, for every table of the databases extracted into
+// dir, its row count, approximate size and number of indexes.
+func printTableStats(dir string, names []string) error {
+	for _, name := range names {
+		tables, err := collectTableStats(filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Errorf("couldn't collect statistics of %s: %w", name, err)
+		}
+
+		fmt.Printf("Database %s:\n", name)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "  TABLE\tROWS\tSIZE\tINDEXES")
+		for _, t := range tables {
+			fmt.Fprintf(tw, "  %s\t%d\t%s\t%d\n", t.Name, t.Rows, formatBytes(t.Bytes), t.Indexes)
+		}
+		tw.Flush()
+		fmt.Println()
+	}
+	return nil
+}
+
+func collectTableStats(path string) ([]tableStats, error) {
+	layout, err := analyzePages(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []tableStats
+	err = withDatabase(path, func(db *sql.DB) error {
+		objects, err := readSchema(db)
+		if err != nil {
+			return err
+		}
+
+		for _, o := range objects {
+			if o.Type != "table" || strings.HasPrefix(o.Name, "sqlite_") {
+				continue
+			}
+			t := tableStats{Name: o.Name}
+			if err := db.QueryRow("SELECT count(*) FROM " + quoteIdent(o.Name)).Scan(&t.Rows); err != nil {
+				return fmt.Errorf("table %s: %w", o.Name, err)
+			}
+			// The page layout knows about automatic indexes too, which
+			// aren't part of the schema as they have no SQL.
+			for _, po := range layout.Objects {
+				if po.TblName != o.Name {
+					continue
+				}
+				t.Bytes += int64(po.Pages) * int64(layout.PageSize)
+				if po.Type == "index" {
+					t.Indexes++
+				}
+			}
+			tables = append(tables, t)
+		}
+		return nil
+	})
+	return tables, err
+}
+
+// formatBytes renders a byte count with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// compressionStats tracks how much of the snapshot input each database was
+// decoded from, and how long it took, for the summary at the end of an
+// extraction.
+type compressionStats struct {
+	start     time.Time
+	phases    phaseDurations
+	databases []databaseCompression
+
+	// Of the database being extracted.
+	dbStart  time.Time
+	dbPhases phaseDurations
+}
+
+type databaseCompression struct {
+	name       string
+	size       int64
+	compressed int64
+
+	// Unknown (zero) for databases extracted in parallel.
+	elapsed time.Duration
+	phases  phaseDurations
+}
+
+func newCompressionStats() *compressionStats {
+	return &compressionStats{start: time.Now(), phases: timings.durations()}
+}
+
+// begin marks the start of the extraction of a database.
+func (c *compressionStats) begin() {
+	c.dbStart, c.dbPhases = time.Now(), timings.durations()
+}
+
+// add records that database name, of the given size, was decoded from
+// compressed bytes of input, since begin was called.
+func (c *compressionStats) add(name string, size, compressed int64) {
+	c.databases = append(c.databases, databaseCompression{
+		name:       name,
+		size:       size,
+		compressed: compressed,
+		elapsed:    time.Since(c.dbStart),
+		phases:     timings.durations().sub(c.dbPhases),
+	})
+}
+
+// addParallel is add for a database extracted in parallel with others, whose
+// timings can't be told apart.
+func (c *compressionStats) addParallel(name string, size, compressed int64) {
+	c.databases = append(c.databases, databaseCompression{name: name, size: size, compressed: compressed})
+}
+
+// print reports the compressed and decompressed sizes of snapshot, whose
+// content has been read entirely, and the throughput of the extraction.
+// Per-database figures are approximate, as the input is read ahead.
+func (c *compressionStats) print(out io.Writer, snapshot *snapshotReader) {
+	elapsed := time.Since(c.start)
+	compressed, decoded := snapshot.compressedRead(), snapshot.decoded
+	throughput := formatThroughput(decoded, elapsed)
+	defer c.printTimings(out)
+
+	kind := strings.Join(snapshot.compression(), ", ")
+	if kind == "" {
+		fmt.Fprintf(out, "Read %s (not compressed) in %s, %s\n",
+			formatBytes(decoded), elapsed.Round(time.Millisecond), throughput)
+		return
+	}
+
+	fmt.Fprintf(out, "Decompressed %s (%s) into %s, ratio %s, in %s, %s\n",
+		formatBytes(compressed), kind, formatBytes(decoded), formatRatio(decoded, compressed),
+		elapsed.Round(time.Millisecond), throughput)
+	for _, db := range c.databases {
+		// Below what the pipeline buffers, the figures mean nothing.
+		if db.compressed < int64(pipelineBuffers*bufferSize) {
+			fmt.Fprintf(out, "  %s: %s, too small to tell its compressed size\n", db.name, formatBytes(db.size))
+			continue
+		}
+		fmt.Fprintf(out, "  %s: %s from ~%s, ratio %s\n",
+			db.name, formatBytes(db.size), formatBytes(db.compressed), formatRatio(db.size, db.compressed))
+	}
+	fmt.Fprintln(out)
+}
+
+// printTimings reports, with --timings, the time spent in each phase of the
+// extraction, overall and per database. Like the compressed sizes, the
+// per-database figures are approximate, as the input is read ahead.
+func (c *compressionStats) printTimings(out io.Writer) {
+	if !printPhases {
+		return
+	}
+	fmt.Fprintf(out, "Timings (phases overlap, as they run concurrently):\n")
+	fmt.Fprintf(out, "  total: %s\n", timings.durations().sub(c.phases))
+	for _, db := range c.databases {
+		if db.elapsed == 0 {
+			fmt.Fprintf(out, "  %s: %s, extracted in parallel\n", db.name, formatBytes(db.size))
+			continue
+		}
+		fmt.Fprintf(out, "  %s: %s in %s, %s: %s\n", db.name, formatBytes(db.size),
+			formatDuration(db.elapsed), formatThroughput(db.size, db.elapsed), db.phases)
+	}
+	fmt.Fprintln(out)
+}
+
+func formatThroughput(size int64, elapsed time.Duration) string {
+	return formatBytes(int64(float64(size)/max(elapsed.Seconds(), 1e-9))) + "/s"
+}
+
+func formatRatio(decompressed, compressed int64) string {
+	if compressed <= 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1fx", float64(decompressed)/float64(compressed))
+}
+
+// phaseTimes accumulates the time the stages of extractions spend working,
+// whichever goroutine they run on.
+type phaseTimes struct {
+	read, decompress, write, hash atomic.Int64
+}
+
+var timings phaseTimes
+
+type phaseDurations struct {
+	read, decompress, write, hash time.Duration
+}
+
+func (p *phaseTimes) durations() phaseDurations {
+	return phaseDurations{
+		read:       time.Duration(p.read.Load()),
+		decompress: time.Duration(p.decompress.Load()),
+		write:      time.Duration(p.write.Load()),
+		hash:       time.Duration(p.hash.Load()),
+	}
+}
+
+func (d phaseDurations) sub(o phaseDurations) phaseDurations {
+	return phaseDurations{d.read - o.read, d.decompress - o.decompress, d.write - o.write, d.hash - o.hash}
+}
+
+func (d phaseDurations) String() string {
+	return fmt.Sprintf("reading %s, decompressing %s, writing %s, hashing %s",
+		formatDuration(d.read), formatDuration(d.decompress), formatDuration(d.write), formatDuration(d.hash))
+}
+
+// formatDuration rounds d to milliseconds, or microseconds below that.
+// Decompressors reading ahead on their own can make the time they're
+// credited with briefly negative, which shows as 0.
+func formatDuration(d time.Duration) string {
+	d = max(d, 0)
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// timedReader adds the time spent reading from r to phase or, with subtract
+// set, takes it off: wrapped around the input of a stage, it leaves out the
+// time the stage waits for the previous one.
+type timedReader struct {
+	r        io.Reader
+	phase    *atomic.Int64
+	subtract bool
+}
+
+func (t *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	if elapsed := int64(time.Since(start)); t.subtract {
+		t.phase.Add(-elapsed)
+	} else {
+		t.phase.Add(elapsed)
+	}
+	return n, err
+}
+
+// timedWriter adds the time spent writing to w to phase.
+type timedWriter struct {
+	w     io.Writer
+	phase *atomic.Int64
+}
+
+func (t *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	defer func() { t.phase.Add(int64(time.Since(start))) }()
+	return t.w.Write(p)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// summaryPath, set with --summary, is where unpack writes its summary as
+// JSON, to attach to an incident ticket.
+var summaryPath string
+
+// runSummary is what unpack reports at the end of the run, once the
+// progress has scrolled away.
+type runSummary struct {
+	Snapshot         string            `json:"snapshot"`
+	Compression      []string          `json:"compression,omitempty"`
+	CompressedSize   int64             `json:"compressed_size,omitempty"`
+	DecompressedSize int64             `json:"decompressed_size,omitempty"`
+	Databases        []summaryDatabase `json:"databases"`
+	Warnings         []string          `json:"warnings"`
+	Duration         float64           `json:"duration_seconds"`
+	Error            string            `json:"error,omitempty"`
+}
+
+// summaryDatabase is a database extracted by unpack, along with the outcome
+// of the checks run on it, if any.
+type summaryDatabase struct {
+	Name         string `json:"name"`
+	File         string `json:"file"`
+	MainSize     uint64 `json:"main_size"`
+	WALSize      uint64 `json:"wal_size"`
+	Verification string `json:"verification,omitempty"`
+	WALCheck     string `json:"wal_check,omitempty"`
+	FKCheck      string `json:"fk_check,omitempty"`
+}
+
+// summary collects the summary of the run, from the moment unpack starts it.
+var summary struct {
+	sync.Mutex
+	runSummary
+	active bool
+	start  time.Time
+}
+
+func startSummary() {
+	summary.Lock()
+	defer summary.Unlock()
+	summary.active, summary.start = true, time.Now()
+	summary.Warnings = []string{}
+}
+
+// summarize calls fn to update the summary, if the run has one.
+func summarize(fn func(s *runSummary)) {
+	summary.Lock()
+	defer summary.Unlock()
+	if summary.active {
+		fn(&summary.runSummary)
+	}
+}
+
+// quietly runs fn without reporting what it does to the summary or as
+// events, for snapshots read along the way, not the one the run is about.
+func quietly(fn func() error) error {
+	summary.Lock()
+	active := summary.active
+	summary.active = false
+	summary.Unlock()
+	out := events.out
+	events.out = nil
+	defer func() {
+		summary.Lock()
+		summary.active = active
+		summary.Unlock()
+		events.out = out
+	}()
+	return fn()
+}
+
+func summarizeSnapshot(path string, snapshot *snapshotReader) {
+	summarize(func(s *runSummary) {
+		s.Snapshot, s.Compression = path, snapshot.compression()
+	})
+}
+
+func summarizeSizes(snapshot *snapshotReader) {
+	summarize(func(s *runSummary) {
+		s.CompressedSize, s.DecompressedSize = snapshot.compressedRead(), snapshot.decoded
+	})
+}
+
+func summarizeDatabase(db manifestEntry) {
+	summarize(func(s *runSummary) {
+		s.Databases = append(s.Databases, summaryDatabase{Name: db.Name, File: db.Files[0].Path, MainSize: db.MainSize, WALSize: db.WALSize})
+	})
+}
+
+// summarizeCheck records the outcome of a check of the database extracted
+// as file, setting it through field.
+func summarizeCheck(file string, outcome string, field func(db *summaryDatabase) *string) {
+	summarize(func(s *runSummary) {
+		for i := range s.Databases {
+			if s.Databases[i].File == file {
+				*field(&s.Databases[i]) = outcome
+				return
+			}
+		}
+		// Such as the local database of --flavor, outside of the snapshot.
+		db := summaryDatabase{Name: file, File: file}
+		*field(&db) = outcome
+		s.Databases = append(s.Databases, db)
+	})
+}
+
+// finishSummary prints the summary of a run that got as far as opening the
+// snapshot, ending with err, and writes it to --summary. It returns err, or
+// the error writing the summary.
+func finishSummary(err error) error {
+	summary.Lock()
+	s := summary.runSummary
+	s.Duration = time.Since(summary.start).Seconds()
+	summary.active = false
+	summary.Unlock()
+	if s.Snapshot == "" {
+		return err
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	printSummary(progressOutput(), &s)
+	if summaryPath != "" {
+		data, jsonErr := json.MarshalIndent(&s, "", "  ")
+		if jsonErr == nil {
+			jsonErr = os.WriteFile(summaryPath, append(data, '\n'), 0644)
+		}
+		if jsonErr != nil && err == nil {
+			err = fmt.Errorf("couldn't write the summary: %w", jsonErr)
+		}
+	}
+	return err
+}
+
+func printSummary(out io.Writer, s *runSummary) {
+	fmt.Fprintf(out, "Summary:\n")
+	fmt.Fprintf(out, "  Snapshot:  %s\n", s.Snapshot)
+	if s.DecompressedSize > 0 {
+		if len(s.Compression) > 0 {
+			fmt.Fprintf(out, "  Size:      %s (%s) into %s, ratio %s\n", formatBytes(s.CompressedSize),
+				strings.Join(s.Compression, ", "), formatBytes(s.DecompressedSize), formatRatio(s.DecompressedSize, s.CompressedSize))
+		} else {
+			fmt.Fprintf(out, "  Size:      %s (not compressed)\n", formatBytes(s.DecompressedSize))
+		}
+	}
+	fmt.Fprintf(out, "  Databases: %d\n", len(s.Databases))
+	for _, db := range s.Databases {
+		line := fmt.Sprintf("    %s: main %s, WAL %s", db.File, formatBytes(int64(db.MainSize)), formatBytes(int64(db.WALSize)))
+		if db.Verification != "" {
+			line += ", verification " + db.Verification
+		}
+		if db.WALCheck != "" {
+			line += ", WAL check " + db.WALCheck
+		}
+		if db.FKCheck != "" {
+			line += ", foreign key check " + db.FKCheck
+		}
+		fmt.Fprintln(out, line)
+	}
+	fmt.Fprintf(out, "  Warnings:  %d\n", len(s.Warnings))
+	for _, warning := range s.Warnings {
+		fmt.Fprintf(out, "    %s\n", warning)
+	}
+	fmt.Fprintf(out, "  Duration:  %s\n", formatDuration(time.Duration(s.Duration*float64(time.Second))))
+	if s.Error != "" {
+		fmt.Fprintf(out, "  Failed:    %s\n", s.Error)
+	}
+}
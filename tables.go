@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// extractTables produces, in the current directory, a database named after
+// name (with --ext) holding only the given tables of the database with the
+// same name in the snapshot at path, along with their indexes and triggers.
+func extractTables(path, name string, tables []string) ([]string, error) {
+	dir, names, cleanup, err := extractTemp(path, onlyDatabase(name))
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("database %q not found in snapshot", name)
+	}
+
+	fmt.Printf("Extracting tables %s of database %s...\n", strings.Join(tables, ", "), name)
+	if err := copyTables(filepath.Join(dir, names[0]), names[0], tables); err != nil {
+		return nil, fmt.Errorf("couldn't extract tables of %s: %w", name, err)
+	}
+	fmt.Printf("Done!\n\n")
+	return names, nil
+}
+
+// copyTables creates a new database at dst with the given tables of the
+// database at src.
+func copyTables(src, dst string, tables []string) error {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if err := os.Remove(dst + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	db, err := openDatabase(dst, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	uri := "file:" + (&url.URL{Path: src}).EscapedPath() + "?mode=ro"
+	if _, err := db.Exec("ATTACH DATABASE ? AS src", uri); err != nil {
+		return err
+	}
+
+	objects, err := readSchemaOf(db, "src")
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if !slices.ContainsFunc(objects, func(o schemaObject) bool { return o.Type == "table" && o.Name == table }) {
+			return fmt.Errorf("table %q not found", table)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Tables (and their rows) go first, so that indexes and triggers find
+	// them in place.
+	for _, o := range objects {
+		if o.Type != "table" || !slices.Contains(tables, o.Name) {
+			continue
+		}
+		if _, err := tx.Exec(o.SQL); err != nil {
+			return fmt.Errorf("couldn't create table %s: %w", o.Name, err)
+		}
+		if err := copyRows(tx, o.Name); err != nil {
+			return fmt.Errorf("couldn't copy table %s: %w", o.Name, err)
+		}
+	}
+	for _, o := range objects {
+		if o.Type == "table" || o.Type == "view" || !slices.Contains(tables, o.TblName) {
+			continue
+		}
+		if _, err := tx.Exec(o.SQL); err != nil {
+			fmt.Printf("Skipping %s %s: %v\n", o.Type, o.Name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DETACH DATABASE src"); err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// copyRows copies the stored columns of table from the src database into
+// the main one.
+func copyRows(tx *sql.Tx, table string) error {
+	columns, _, err := tableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+	for i, column := range columns {
+		columns[i] = quoteIdent(column)
+	}
+	list := strings.Join(columns, ", ")
+
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO main.%s(%s) SELECT %s FROM src.%s",
+		quoteIdent(table), list, list, quoteIdent(table)))
+	return err
+}
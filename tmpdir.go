@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// tmpDir, set with --tmpdir, is where scratch files go: the databases query,
+// shell or diff extract, the log compact replays... Empty means os.TempDir,
+// which honors TMPDIR.
+var tmpDir string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&tmpDir, "tmpdir", "", "directory for scratch files (default $TMPDIR or /tmp)")
+}
+
+// scratch holds the scratch files and directories not removed yet, for an
+// interrupted run to remove on its way out.
+var scratch struct {
+	sync.Mutex
+	paths map[string]bool
+}
+
+// makeTempDir creates a scratch directory, returning the function removing
+// it again.
+func makeTempDir() (string, func(), error) {
+	dir, err := os.MkdirTemp(tmpDir, "dqlite-snapshot-unpack-")
+	if err != nil {
+		return "", nil, err
+	}
+	return dir, trackScratch(dir), nil
+}
+
+// makeTempFile creates an empty scratch file, returning the function
+// removing it again.
+func makeTempFile() (string, func(), error) {
+	file, err := os.CreateTemp(tmpDir, "dqlite-snapshot-")
+	if err != nil {
+		return "", nil, err
+	}
+	file.Close()
+	return file.Name(), trackScratch(file.Name()), nil
+}
+
+func trackScratch(path string) func() {
+	scratch.Lock()
+	defer scratch.Unlock()
+	if scratch.paths == nil {
+		scratch.paths = make(map[string]bool)
+	}
+	scratch.paths[path] = true
+	return func() {
+		scratch.Lock()
+		delete(scratch.paths, path)
+		scratch.Unlock()
+		os.RemoveAll(path)
+	}
+}
+
+// removeScratchOnSignal has SIGINT and SIGTERM remove the scratch files
+// before exiting, with the status a shell gives processes killed by them.
+// Commands handling signals themselves opt out with the ownSignals
+// annotation.
+func removeScratchOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		// Left locked: nothing new gets tracked on the way out.
+		scratch.Lock()
+		for path := range scratch.paths {
+			os.RemoveAll(path)
+		}
+		if sig == syscall.SIGTERM {
+			os.Exit(128 + int(syscall.SIGTERM))
+		}
+		os.Exit(128 + int(syscall.SIGINT))
+	}()
+}
+
+// ownSignals is the annotation of the commands handling SIGINT and SIGTERM
+// themselves, removing their scratch files as they shut down.
+const ownSignals = "own-signals"
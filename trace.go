@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// traceParsing, set with --trace, logs every field of the snapshot as it is
+// parsed, to make sense of subtly corrupt ones.
+var traceParsing bool
+
+// traceRawLimit is how many raw bytes of a field trace shows at most.
+const traceRawLimit = 32
+
+// trace logs, with --trace, what was found at offset in the decompressed
+// snapshot: a field, its raw bytes and the value decoded from them or, with
+// raw nil, a description of a span of content.
+func trace(offset int64, field string, raw []byte, value string) {
+	if !traceParsing {
+		return
+	}
+	if raw == nil {
+		fmt.Fprintf(os.Stderr, "TRACE 0x%08x %-10s %s\n", offset, field, value)
+		return
+	}
+	hex := fmt.Sprintf("% x", raw[:min(len(raw), traceRawLimit)])
+	if len(raw) > traceRawLimit {
+		hex += fmt.Sprintf(" ... (%d bytes)", len(raw))
+	}
+	fmt.Fprintf(os.Stderr, "TRACE 0x%08x %-10s %s = %s\n", offset, field, hex, value)
+}
+
+// traceName logs the padded name of a database, raw holding the blocks it
+// was read from, calling out padding bytes that aren't zero.
+func traceName(offset int64, raw []byte, name string) {
+	if !traceParsing {
+		return
+	}
+	padding := raw[len(name)+1:]
+	value := fmt.Sprintf("%q, %d bytes of padding", name, len(padding))
+	if strings.Trim(string(padding), "\x00") != "" {
+		value += " (not zeroed)"
+	}
+	trace(offset, "name", raw, value)
+}
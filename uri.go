@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// attachScriptName is the sqlite3 script unpack writes next to the
+// databases, attaching all of them to a single session, tagged like them
+// with --tag-names.
+const attachScriptName = "open-all.sql"
+
+// maxAttached is how many databases sqlite attaches at most, unless built
+// with a larger SQLITE_MAX_ATTACHED.
+const maxAttached = 10
+
+// readOnlyURI returns the URI opening the database at path read-only.
+//
+// immutable=1 spares sqlite any locking and the -shm file, but also makes it
+// ignore the WAL: databases with WAL frames get a plain mode=ro URI, lest
+// they look older than they are.
+func readOnlyURI(path string, walFrames bool) string {
+	uri := "file:" + (&url.URL{Path: path}).EscapedPath()
+	if walFrames {
+		return uri + "?mode=ro"
+	}
+	return uri + "?immutable=1&mode=ro"
+}
+
+// hasWALFrames tells whether the database at path has a non-empty WAL.
+func hasWALFrames(path string) bool {
+	info, err := os.Stat(path + "-wal")
+	return err == nil && info.Size() > 0
+}
+
+// printOpenCommands prints, for each database in dir, the URI opening it
+// read-only and the sqlite3 invocation using it, to copy and paste.
+func printOpenCommands(dir string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nTo open the databases read-only:\n")
+	for _, name := range names {
+		path := filepath.Join(abs, name)
+		uri := readOnlyURI(path, hasWALFrames(path))
+		fmt.Printf("  %s: %s\n  %*s  sqlite3 %s\n", name, uri, len(name), "", shellQuote(uri))
+	}
+	fmt.Printf("All of them at once, attached under their names:\n  cd %s && sqlite3 -init %s\n\n", shellQuote(abs), taggedName(attachScriptName))
+	return nil
+}
+
+// writeAttachScript writes into dir the script attaching every database
+// there read-only, under its name in the snapshot. The paths in it are
+// relative to dir, for the script to keep working wherever the directory
+// goes.
+func writeAttachScript(dir string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	scriptName := taggedName(attachScriptName)
+	var script strings.Builder
+	fmt.Fprintf(&script, "-- Attaches the databases extracted here, read-only; run from this\n-- directory with: sqlite3 -init %s\n", scriptName)
+	attached := 0
+	for _, file := range names {
+		name := strings.TrimSuffix(strings.TrimSuffix(file, outputExt), nameTag)
+		if strings.EqualFold(name, "main") || strings.EqualFold(name, "temp") {
+			warn("database %s can't be attached under its own name, leaving it out of %s", name, scriptName)
+			continue
+		}
+		uri := readOnlyURI(file, hasWALFrames(filepath.Join(dir, file)))
+		fmt.Fprintf(&script, "ATTACH DATABASE %s AS %s;\n", quoteString(uri), quoteIdent(name))
+		attached++
+	}
+	if attached > maxAttached {
+		warn("%s attaches %d databases, more than the %d sqlite allows unless built with a larger SQLITE_MAX_ATTACHED", scriptName, attached, maxAttached)
+	}
+	return os.WriteFile(filepath.Join(dir, scriptName), []byte(script.String()), 0644)
+}
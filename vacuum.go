@@ -0,0 +1,45 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// extractVacuumed unpacks the snapshot at path to a temporary directory and
+// produces each database in the current directory through VACUUM INTO, so
+// that the results are compact single files without free pages or a WAL.
+func extractVacuumed(path string, want func(name string) bool) ([]string, error) {
+	dir, names, cleanup, err := extractTemp(path, want)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	fmt.Printf("Database count: %d\n", len(names))
+	for _, name := range names {
+		fmt.Printf("Vacuuming database %s...\n", name)
+		if err := vacuumInto(filepath.Join(dir, name), name); err != nil {
+			return nil, fmt.Errorf("couldn't vacuum %s: %w", name, err)
+		}
+	}
+	return names, nil
+}
+
+// vacuumInto writes a vacuumed copy of the database at src to dst, replacing
+// any previous file there.
+func vacuumInto(src, dst string) error {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(dst + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return withDatabase(src, func(db *sql.DB) error {
+		_, err := db.Exec("VACUUM INTO ?", dst)
+		return err
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"runtime"
+)
+
+// verifyResult is the outcome of checking a database.
+type verifyResult struct {
+	problems []string
+	err      error
+}
+
+// verifyDatabases runs PRAGMA integrity_check, or quick_check with mode
+// quick, on each of the databases extracted into dir, several at a time,
+// along with the page checksums of those written through the checksum VFS,
+// reporting the outcome of each one in order. A check running for longer
+// than verifyTimeout, unless 0, is interrupted and fails. It fails if any of
+// them is corrupt or cannot be opened at all. With recoverDB set, a salvage
+// pass writes whatever can be retrieved from failed databases to
+// <name>.recovered.
+func verifyDatabases(dir string, names []string, mode string) error {
+	results := make([]verifyResult, len(names))
+	pool := newWorkerPool(runtime.NumCPU())
+	for i, name := range names {
+		pool.run(func() error {
+			ctx, cancel := context.Background(), func() {}
+			if verifyTimeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, verifyTimeout)
+			}
+			defer cancel()
+			problems, err := integrityCheck(ctx, filepath.Join(dir, name), mode == "quick")
+			if err != nil && ctx.Err() != nil {
+				err = fmt.Errorf("interrupted after %s", verifyTimeout)
+			}
+			// SQLite itself knows nothing of the checksums of the
+			// checksum VFS.
+			if err == nil {
+				var failed []string
+				failed, err = checksumProblems(filepath.Join(dir, name))
+				problems = append(problems, failed...)
+			}
+			results[i] = verifyResult{problems, err}
+			return nil
+		})
+	}
+	pool.wait()
+
+	failed := 0
+	for i, name := range names {
+		fmt.Printf("Verifying database %s (%s check)...\n", name, mode)
+
+		path := filepath.Join(dir, name)
+		problems, err := results[i].problems, results[i].err
+		switch {
+		case err != nil:
+			fmt.Printf("FAILED: %v\n\n", err)
+			summarizeCheck(name, fmt.Sprintf("FAILED: %v", err), func(db *summaryDatabase) *string { return &db.Verification })
+		case len(problems) > 0:
+			for _, problem := range problems {
+				fmt.Printf("  %s\n", problem)
+			}
+			fmt.Printf("FAILED: %d problems found\n\n", len(problems))
+			summarizeCheck(name, fmt.Sprintf("FAILED: %d problems found", len(problems)), func(db *summaryDatabase) *string { return &db.Verification })
+		default:
+			fmt.Printf("OK\n\n")
+			summarizeCheck(name, "OK", func(db *summaryDatabase) *string { return &db.Verification })
+			continue
+		}
+		failed++
+
+		if recoverDB {
+			report, err := salvage(path, path+".recovered")
+			if err != nil {
+				fmt.Printf("Couldn't recover %s: %v\n\n", name, err)
+				continue
+			}
+			printSalvageReport(path+".recovered", report)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d databases failed verification", failed, len(names))
+	}
+	return nil
+}
+
+// integrityCheck returns the problems reported by PRAGMA integrity_check,
+// or by the faster quick_check that skips matching indexes against their
+// tables, on the database at path, or nil if the database is sound. The
+// check is interrupted once ctx is done.
+func integrityCheck(ctx context.Context, path string, quick bool) ([]string, error) {
+	pragma := "PRAGMA integrity_check"
+	if quick {
+		pragma = "PRAGMA quick_check"
+	}
+	var problems []string
+	err := withDatabase(path, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, pragma)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var line string
+			if err := rows.Scan(&line); err != nil {
+				return err
+			}
+			if line != "ok" {
+				problems = append(problems, line)
+			}
+		}
+		return rows.Err()
+	})
+	return problems, err
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+	walMagicLE         = 0x377f0682
+	walMagicBE         = 0x377f0683
+)
+
+// walHeader is the 32 bytes header of a WAL file.
+type walHeader struct {
+	Magic         uint32
+	Version       uint32
+	PageSize      uint32
+	CheckpointSeq uint32
+	Salt1         uint32
+	Salt2         uint32
+	Checksum1     uint32
+	Checksum2     uint32
+}
+
+// walFrame describes a frame of a WAL file whose salts and checksum are valid.
+type walFrame struct {
+	Page   uint32 // page number the frame holds
+	Commit uint32 // database size in pages for commit frames, 0 otherwise
+	Offset int64  // offset of the page content in the WAL file
+}
+
+// walInfo is the result of scanning a WAL file.
+type walInfo struct {
+	Header walHeader
+	// Frames holds the valid frames, in order. The checksum chain makes
+	// every frame after the first invalid one invalid as well.
+	Frames []walFrame
+	// Committed is the number of frames up to, and including, the last
+	// commit frame: only those are seen by readers.
+	Committed int
+	// Trailing is the number of bytes following the last valid frame.
+	Trailing int64
+}
+
+// uncommitted returns the number of frames following the last commit frame,
+// and of distinct pages they hold: a transaction that never committed,
+// discarded by SQLite when it recovers the WAL.
+func (w *walInfo) uncommitted() (frames, pages int) {
+	seen := make(map[uint32]bool)
+	for _, frame := range w.Frames[w.Committed:] {
+		seen[frame.Page] = true
+	}
+	return len(w.Frames) - w.Committed, len(seen)
+}
+
+// walChecksum extends the running checksum (s0, s1) over b, as described in
+// the WAL file format. The length of b must be a multiple of 8.
+func walChecksum(order binary.ByteOrder, s0, s1 uint32, b []byte) (uint32, uint32) {
+	for i := 0; i+8 <= len(b); i += 8 {
+		s0 += order.Uint32(b[i:]) + s1
+		s1 += order.Uint32(b[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+// readWAL scans the WAL file at path, validating its header and the checksum
+// chain of its frames. An empty WAL yields a nil result and no error.
+func readWAL(path string) (*walInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() == 0 {
+		return nil, nil
+	}
+	return scanWAL(bufio.NewReader(file), stat.Size())
+}
+
+// parseWALHeader decodes and validates a WAL header, also returning the byte
+// order its checksums use.
+func parseWALHeader(raw []byte) (walHeader, binary.ByteOrder, error) {
+	if len(raw) < walHeaderSize {
+		return walHeader{}, nil, errors.New("WAL header too short")
+	}
+
+	h := walHeader{
+		Magic:         binary.BigEndian.Uint32(raw[0:]),
+		Version:       binary.BigEndian.Uint32(raw[4:]),
+		PageSize:      binary.BigEndian.Uint32(raw[8:]),
+		CheckpointSeq: binary.BigEndian.Uint32(raw[12:]),
+		Salt1:         binary.BigEndian.Uint32(raw[16:]),
+		Salt2:         binary.BigEndian.Uint32(raw[20:]),
+		Checksum1:     binary.BigEndian.Uint32(raw[24:]),
+		Checksum2:     binary.BigEndian.Uint32(raw[28:]),
+	}
+
+	var order binary.ByteOrder
+	switch h.Magic {
+	case walMagicLE:
+		order = binary.LittleEndian
+	case walMagicBE:
+		order = binary.BigEndian
+	default:
+		return h, nil, fmt.Errorf("bad WAL magic number: %#x", h.Magic)
+	}
+	if h.PageSize < 512 || h.PageSize > 65536 || h.PageSize&(h.PageSize-1) != 0 {
+		return h, nil, fmt.Errorf("bad WAL page size: %d", h.PageSize)
+	}
+
+	s0, s1 := walChecksum(order, 0, 0, raw[:24])
+	if s0 != h.Checksum1 || s1 != h.Checksum2 {
+		return h, nil, errors.New("WAL header checksum mismatch")
+	}
+	return h, order, nil
+}
+
+func scanWAL(r io.Reader, size int64) (*walInfo, error) {
+	var raw [walHeaderSize]byte
+	if _, err := io.ReadFull(r, raw[:]); err != nil {
+		return nil, fmt.Errorf("couldn't read WAL header: %w", err)
+	}
+
+	h, order, err := parseWALHeader(raw[:])
+	if err != nil {
+		return nil, err
+	}
+	info := walInfo{Header: h}
+	s0, s1 := h.Checksum1, h.Checksum2
+
+	offset := int64(walHeaderSize)
+	frame := make([]byte, walFrameHeaderSize+int(h.PageSize))
+	for offset+int64(len(frame)) <= size {
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, fmt.Errorf("couldn't read WAL frame at offset %d: %w", offset, err)
+		}
+
+		page := binary.BigEndian.Uint32(frame[0:])
+		commit := binary.BigEndian.Uint32(frame[4:])
+		salt1 := binary.BigEndian.Uint32(frame[8:])
+		salt2 := binary.BigEndian.Uint32(frame[12:])
+		if page == 0 || salt1 != h.Salt1 || salt2 != h.Salt2 {
+			break
+		}
+
+		c0, c1 := walChecksum(order, s0, s1, frame[:8])
+		c0, c1 = walChecksum(order, c0, c1, frame[walFrameHeaderSize:])
+		if c0 != binary.BigEndian.Uint32(frame[16:]) || c1 != binary.BigEndian.Uint32(frame[20:]) {
+			break
+		}
+		s0, s1 = c0, c1
+
+		info.Frames = append(info.Frames, walFrame{
+			Page:   page,
+			Commit: commit,
+			Offset: offset + walFrameHeaderSize,
+		})
+		if commit != 0 {
+			info.Committed = len(info.Frames)
+		}
+		offset += int64(len(frame))
+	}
+	info.Trailing = size - offset
+
+	return &info, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// checkWALs makes sure the WAL of each of the databases extracted into dir
+// belongs with its main file, reporting the outcome of each one. It fails if
+// any of them doesn't, which points to a snapshot assembled from mismatched
+// pieces.
+func checkWALs(dir string, names []string) error {
+	failed := 0
+	for _, name := range names {
+		fmt.Printf("Checking the WAL of database %s...\n", name)
+
+		problems, note, err := walProblems(filepath.Join(dir, name))
+		if note != "" {
+			fmt.Printf("  %s\n", note)
+		}
+		switch {
+		case err != nil:
+			fmt.Printf("FAILED: %v\n\n", err)
+			summarizeCheck(name, fmt.Sprintf("FAILED: %v", err), func(db *summaryDatabase) *string { return &db.WALCheck })
+		case len(problems) > 0:
+			for _, problem := range problems {
+				fmt.Printf("  %s\n", problem)
+			}
+			fmt.Printf("FAILED: %d problems found\n\n", len(problems))
+			summarizeCheck(name, fmt.Sprintf("FAILED: %d problems found", len(problems)), func(db *summaryDatabase) *string { return &db.WALCheck })
+		default:
+			fmt.Printf("OK\n\n")
+			outcome := "OK"
+			if note != "" {
+				outcome += ", " + note
+			}
+			summarizeCheck(name, outcome, func(db *summaryDatabase) *string { return &db.WALCheck })
+			continue
+		}
+		failed++
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d databases have a WAL not matching their main file", failed, len(names))
+	}
+	return nil
+}
+
+// walProblems returns the ways in which the WAL of the database at path
+// contradicts its main file or itself, or nil if they fit together, along
+// with a note on the uncommitted transaction the WAL ends with, if any.
+func walProblems(path string) ([]string, string, error) {
+	info, err := readWAL(path + "-wal")
+	if errors.Is(err, os.ErrNotExist) || info == nil && err == nil {
+		return nil, "", nil
+	} else if err != nil {
+		return []string{err.Error()}, "", nil
+	}
+
+	// Frames past the last commit are a write that never made it: not a
+	// mismatch, but what incident analysis is usually after.
+	var note string
+	if frames, pages := info.uncommitted(); frames > 0 && info.Committed > 0 {
+		note = fmt.Sprintf("the WAL ends with %d uncommitted frames, %d pages discarded on recovery", frames, pages)
+	}
+
+	main, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer main.Close()
+
+	// An empty main file is fine, everything being in the WAL still.
+	var header *dbHeader
+	var raw [dbHeaderSize]byte
+	if _, err := main.ReadAt(raw[:], 0); err == nil {
+		h, err := parseDBHeader(raw[:])
+		if err != nil {
+			return []string{fmt.Sprintf("invalid database header: %v", err)}, note, nil
+		}
+		header = &h
+	} else if err != io.EOF {
+		return nil, "", err
+	}
+
+	wal, err := os.Open(path + "-wal")
+	if err != nil {
+		return nil, "", err
+	}
+	defer wal.Close()
+
+	var problems []string
+	if header != nil && info.Header.PageSize != header.PageSize {
+		problems = append(problems, fmt.Sprintf("WAL page size %d differs from the database page size %d",
+			info.Header.PageSize, header.PageSize))
+	}
+
+	// Frames left over from before the WAL was last reset carry other salts
+	// and are ignored by SQLite too, but a frame with the current salts
+	// breaking the checksum chain means the WAL was tampered with.
+	frameSize := int64(walFrameHeaderSize + info.Header.PageSize)
+	if info.Trailing >= frameSize {
+		var frame [walFrameHeaderSize]byte
+		offset := walHeaderSize + int64(len(info.Frames))*frameSize
+		if _, err := wal.ReadAt(frame[:], offset); err != nil {
+			return nil, "", err
+		}
+		if binary.BigEndian.Uint32(frame[8:]) == info.Header.Salt1 && binary.BigEndian.Uint32(frame[12:]) == info.Header.Salt2 {
+			problems = append(problems, fmt.Sprintf("the checksum chain breaks at frame %d, %d frames are ignored",
+				len(info.Frames)+1, info.Trailing/frameSize))
+		}
+	}
+	if len(info.Frames) > 0 && info.Committed == 0 {
+		problems = append(problems, fmt.Sprintf("none of the %d frames of the WAL is committed", len(info.Frames)))
+	}
+
+	// The last committed copy of page 1 must come from the same database as
+	// the main file, at a later point in its history.
+	if header == nil {
+		return problems, note, nil
+	}
+	for i := info.Committed - 1; i >= 0; i-- {
+		frame := info.Frames[i]
+		if frame.Page != 1 {
+			continue
+		}
+		if _, err := wal.ReadAt(raw[:], frame.Offset); err != nil {
+			return nil, "", err
+		}
+		h, err := parseDBHeader(raw[:])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid database header in the WAL: %v", err))
+		} else if h.PageSize != header.PageSize {
+			problems = append(problems, fmt.Sprintf("the WAL holds a database with pages of %d bytes, the main file of %d",
+				h.PageSize, header.PageSize))
+		} else if h.ChangeCounter < header.ChangeCounter {
+			problems = append(problems, fmt.Sprintf("the change counter in the WAL (%d) is behind the one of the main file (%d)",
+				h.ChangeCounter, header.ChangeCounter))
+		}
+		break
+	}
+	return problems, note, nil
+}
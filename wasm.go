@@ -0,0 +1,129 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"syscall/js"
+)
+
+// The WebAssembly build exposes the snapshot reader to JavaScript instead of
+// running the command line: once loaded, the dqliteSnapshot global holds
+//
+//	inspect(snapshot)       the headers of each database, as a JSON string
+//	extract(snapshot, name) {main, wal}, the files of the named database
+//
+// where snapshot is a Uint8Array holding a whole snapshot, compressed or
+// not. Failures come back as Error values rather than being thrown.
+func main() {
+	js.Global().Set("dqliteSnapshot", js.ValueOf(map[string]any{
+		"inspect": js.FuncOf(wasmInspect),
+		"extract": js.FuncOf(wasmExtract),
+	}))
+	select {}
+}
+
+// wasmDatabase is what inspect reports about a database.
+type wasmDatabase struct {
+	Name     string     `json:"name"`
+	MainSize uint64     `json:"main_size"`
+	WALSize  uint64     `json:"wal_size"`
+	Header   *dbHeader  `json:"header,omitempty"`
+	WAL      *walHeader `json:"wal,omitempty"`
+	Warnings []string   `json:"warnings,omitempty"`
+}
+
+func wasmInspect(this js.Value, args []js.Value) any {
+	snapshot, err := wasmSnapshot(args)
+	if err != nil {
+		return jsError(err)
+	}
+	databases := []wasmDatabase{}
+	for {
+		entry, err := snapshot.next()
+		if err != nil {
+			return jsError(err)
+		}
+		if entry == nil {
+			break
+		}
+		r, err := inspectDatabase(snapshot, entry)
+		if err != nil {
+			return jsError(fmt.Errorf("couldn't inspect %s: %w", entry.name, err))
+		}
+		databases = append(databases, wasmDatabase{
+			Name:     entry.name,
+			MainSize: entry.mainSize,
+			WALSize:  entry.walSize,
+			Header:   r.header,
+			WAL:      r.wal,
+			Warnings: r.warnings,
+		})
+	}
+	if err := snapshot.checkEOF(); err != nil {
+		return jsError(err)
+	}
+	data, err := json.Marshal(databases)
+	if err != nil {
+		return jsError(err)
+	}
+	return string(data)
+}
+
+func wasmExtract(this js.Value, args []js.Value) any {
+	if len(args) != 2 || args[1].Type() != js.TypeString {
+		return jsError(fmt.Errorf("expected a snapshot and a database name"))
+	}
+	snapshot, err := wasmSnapshot(args[:1])
+	if err != nil {
+		return jsError(err)
+	}
+	name := args[1].String()
+	for {
+		entry, err := snapshot.next()
+		if err != nil {
+			return jsError(err)
+		}
+		if entry == nil {
+			return jsError(fmt.Errorf("database %q not found in snapshot", name))
+		}
+		if entry.name != name {
+			if err := snapshot.skip(entry); err != nil {
+				return jsError(fmt.Errorf("couldn't skip database %s: %w", entry.name, err))
+			}
+			continue
+		}
+
+		files := make(map[string]any)
+		for _, f := range []struct {
+			key  string
+			size uint64
+		}{{"main", entry.mainSize}, {"wal", entry.walSize}} {
+			data := make([]byte, f.size)
+			if _, err := io.ReadFull(snapshot, data); err != nil {
+				return jsError(fmt.Errorf("couldn't unpack %s: %w", name, err))
+			}
+			array := js.Global().Get("Uint8Array").New(len(data))
+			js.CopyBytesToJS(array, data)
+			files[f.key] = array
+		}
+		return js.ValueOf(files)
+	}
+}
+
+// wasmSnapshot opens the snapshot held by the Uint8Array in args.
+func wasmSnapshot(args []js.Value) (*snapshotReader, error) {
+	if len(args) != 1 || !args[0].InstanceOf(js.Global().Get("Uint8Array")) {
+		return nil, fmt.Errorf("expected the snapshot as a Uint8Array")
+	}
+	data := make([]byte, args[0].Length())
+	js.CopyBytesToGo(data, args[0])
+	return newSnapshotReader(bytes.NewReader(data))
+}
+
+func jsError(err error) js.Value {
+	return js.Global().Get("Error").New(err.Error())
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	xxhPrime1 uint32 = 2654435761
+	xxhPrime2 uint32 = 2246822519
+	xxhPrime3 uint32 = 3266489917
+	xxhPrime4 uint32 = 668265263
+	xxhPrime5 uint32 = 374761393
+)
+
+// xxh32 is a streaming implementation of the 32 bits xxHash, which LZ4 frames
+// use for their header, block and content checksums.
+type xxh32 struct {
+	seed  uint32
+	v     [4]uint32
+	buf   [16]byte
+	n     int // bytes buffered in buf
+	total uint64
+}
+
+func newXXH32(seed uint32) *xxh32 {
+	h := &xxh32{seed: seed}
+	h.Reset()
+	return h
+}
+
+func (h *xxh32) Reset() {
+	h.v = [4]uint32{h.seed + xxhPrime1 + xxhPrime2, h.seed + xxhPrime2, h.seed, h.seed - xxhPrime1}
+	h.n = 0
+	h.total = 0
+}
+
+func xxhRound(acc, input uint32) uint32 {
+	acc += input * xxhPrime2
+	acc = bits.RotateLeft32(acc, 13)
+	return acc * xxhPrime1
+}
+
+func (h *xxh32) Write(p []byte) (int, error) {
+	written := len(p)
+	h.total += uint64(len(p))
+
+	if h.n > 0 {
+		c := copy(h.buf[h.n:], p)
+		h.n += c
+		p = p[c:]
+		if h.n < len(h.buf) {
+			return written, nil
+		}
+		h.stripe(h.buf[:])
+		h.n = 0
+	}
+	for len(p) >= 16 {
+		h.stripe(p[:16])
+		p = p[16:]
+	}
+	h.n = copy(h.buf[:], p)
+	return written, nil
+}
+
+func (h *xxh32) stripe(b []byte) {
+	h.v[0] = xxhRound(h.v[0], binary.LittleEndian.Uint32(b[0:]))
+	h.v[1] = xxhRound(h.v[1], binary.LittleEndian.Uint32(b[4:]))
+	h.v[2] = xxhRound(h.v[2], binary.LittleEndian.Uint32(b[8:]))
+	h.v[3] = xxhRound(h.v[3], binary.LittleEndian.Uint32(b[12:]))
+}
+
+func (h *xxh32) Sum32() uint32 {
+	var acc uint32
+	if h.total >= 16 {
+		acc = bits.RotateLeft32(h.v[0], 1) + bits.RotateLeft32(h.v[1], 7) +
+			bits.RotateLeft32(h.v[2], 12) + bits.RotateLeft32(h.v[3], 18)
+	} else {
+		acc = h.seed + xxhPrime5
+	}
+	acc += uint32(h.total)
+
+	b := h.buf[:h.n]
+	for ; len(b) >= 4; b = b[4:] {
+		acc += binary.LittleEndian.Uint32(b) * xxhPrime3
+		acc = bits.RotateLeft32(acc, 17) * xxhPrime4
+	}
+	for _, c := range b {
+		acc += uint32(c) * xxhPrime5
+		acc = bits.RotateLeft32(acc, 11) * xxhPrime1
+	}
+
+	acc ^= acc >> 15
+	acc *= xxhPrime2
+	acc ^= acc >> 13
+	acc *= xxhPrime3
+	acc ^= acc >> 16
+	return acc
+}
+
+// xxh32Sum returns the 32 bits xxHash of b with a zero seed.
+func xxh32Sum(b []byte) uint32 {
+	h := newXXH32(0)
+	h.Write(b)
+	return h.Sum32()
+}
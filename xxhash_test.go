@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestXXH32(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  uint32
+	}{
+		{"", 0x02cc5d05},
+		{"a", 0x550d7456},
+		{"abc", 0x32d153ff},
+		{"Nobody inspects the spammish repetition", 0xe2293b2f},
+		{"The quick brown fox jumps over the lazy dog", 0xe85ea4de},
+		{string(bytes.Repeat([]byte("x"), 100)), 0x4bd30d3a},
+	} {
+		if got := xxh32Sum([]byte(tc.input)); got != tc.want {
+			t.Errorf("xxh32Sum(%.20q) = %#08x, want %#08x", tc.input, got, tc.want)
+		}
+
+		// Streamed a byte at a time, going through the buffering of
+		// partial stripes.
+		h := newXXH32(0)
+		for i := range len(tc.input) {
+			h.Write([]byte{tc.input[i]})
+		}
+		if got := h.Sum32(); got != tc.want {
+			t.Errorf("streamed xxh32 of %.20q = %#08x, want %#08x", tc.input, got, tc.want)
+		}
+	}
+}
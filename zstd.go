@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const zstdMagic = 0xFD2FB528
+
+// zstdReader adapts a zstd decoder to io.ReadCloser.
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+// newZstdReader wraps an io.Reader that provides zstd-compressed data.
+func newZstdReader(r io.Reader) (*zstdReader, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdReader{decoder}, nil
+}
+
+func (z *zstdReader) Close() error {
+	z.Decoder.Close()
+	return nil
+}